@@ -0,0 +1,299 @@
+package auth
+
+import (
+	"akita/db"
+	akerrors "akita/errors"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Permission is a set of operations a grant allows on keys.
+type Permission uint8
+
+const (
+	// PermRead allows reading values
+	PermRead Permission = 1 << iota
+	// PermWrite allows writing values
+	PermWrite
+	// PermList allows listing keys and watching their changes
+	PermList
+	// PermDelete allows deleting keys
+	PermDelete
+)
+
+const (
+	// aclsNamespace holds the grants of namespace ns as key _acls/ns
+	aclsNamespace = "_acls"
+	// aclAuditNamespace holds every change of grants as key _acl_audit/<unix nano time>
+	aclAuditNamespace = "_acl_audit"
+	// AnyPrincipal is granted to every principal
+	AnyPrincipal = "*"
+)
+
+var permissionNames = []struct {
+	perm Permission
+	name string
+}{
+	{PermRead, "read"},
+	{PermWrite, "write"},
+	{PermList, "list"},
+	{PermDelete, "delete"},
+}
+
+// ParsePermission parse a comma separated list of permission names.
+func ParsePermission(s string) (Permission, error) {
+	var perm Permission
+	for _, name := range strings.Split(s, ",") {
+		found := false
+		for _, pn := range permissionNames {
+			if strings.TrimSpace(name) == pn.name {
+				perm |= pn.perm
+				found = true
+			}
+		}
+		if !found {
+			return 0, akerrors.ErrPermissionUnknown
+		}
+	}
+	return perm, nil
+}
+
+func (p Permission) String() string {
+	var names []string
+	for _, pn := range permissionNames {
+		if p&pn.perm != 0 {
+			names = append(names, pn.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// MarshalJSON write permission as the comma separated list of its names.
+func (p Permission) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.String())
+}
+
+// UnmarshalJSON read permission from the comma separated list of its names.
+func (p *Permission) UnmarshalJSON(data []byte) error {
+	var names string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return err
+	}
+	perm, err := ParsePermission(names)
+	if err != nil {
+		return err
+	}
+	*p = perm
+	return nil
+}
+
+// Grant gives a principal, the name of a token, permissions on the keys of a namespace starting with prefix.
+type Grant struct {
+	Principal   string     `json:"principal"`
+	Namespace   string     `json:"namespace"`
+	Prefix      string     `json:"prefix"` // all keys of the namespace if empty
+	Permissions Permission `json:"permissions"`
+}
+
+// matches report whether grant gives principal perm on key.
+func (g *Grant) matches(principal string, key string, perm Permission) bool {
+	return (g.Principal == principal || g.Principal == AnyPrincipal) &&
+		strings.HasPrefix(key, g.Prefix) && g.Permissions&perm == perm
+}
+
+// AuditEntry records a change of grants, Action is grant or revoke.
+type AuditEntry struct {
+	Time   int64  `json:"time"` // unix time in nanoseconds
+	Actor  string `json:"actor"`
+	Action string `json:"action"`
+	Grant  Grant  `json:"grant"`
+}
+
+// ACLs restrict the keys of namespaces to the principals they are granted to.
+// A namespace without grants is open to every token with the scope of a request, tokens with the admin scope
+// are never restricted. Grants and their audit trail are stored in akita, so they replicate to slaves.
+type ACLs struct {
+	sync.Mutex // serializes changes of grants
+	engine     *db.Engine
+}
+
+// NewACLs create access control lists stored in engine.
+func NewACLs(engine *db.Engine) *ACLs {
+	return &ACLs{engine: engine}
+}
+
+func aclKey(namespace string) string {
+	return aclsNamespace + db.NamespaceSeparator + namespace
+}
+
+// validPrefix report whether prefix selects keys of namespace only, an empty prefix selects all of them.
+func validPrefix(namespace string, prefix string) bool {
+	return prefix == "" || (strings.Contains(prefix, db.NamespaceSeparator) && db.Namespace(prefix) == namespace)
+}
+
+// Grants return the grants of namespace.
+func (a *ACLs) Grants(namespace string) ([]*Grant, error) {
+	value, err := a.engine.Seek(aclKey(namespace))
+	if err != nil || value == nil {
+		return nil, err
+	}
+	var grants []*Grant
+	if err := json.Unmarshal(value, &grants); err != nil {
+		return nil, err
+	}
+	return grants, nil
+}
+
+// All return the grants of every namespace, ordered by namespace.
+func (a *ACLs) All() ([]*Grant, error) {
+	var all []*Grant
+	prefix := aclsNamespace + db.NamespaceSeparator
+	for more, after := true, ""; more; {
+		var keys []string
+		keys, more = a.engine.List(prefix, after, 256)
+		for _, key := range keys {
+			grants, err := a.Grants(strings.TrimPrefix(key, prefix))
+			if err != nil {
+				return nil, err
+			}
+			all = append(all, grants...)
+			after = key
+		}
+	}
+	return all, nil
+}
+
+// Grant add g, permissions of an existing grant of the principal and prefix are replaced. Actor is audited.
+func (a *ACLs) Grant(actor string, g *Grant) error {
+	if g.Principal == "" || g.Namespace == "" || g.Permissions == 0 {
+		return akerrors.ErrGrantInvalid
+	}
+	if !validPrefix(g.Namespace, g.Prefix) {
+		return akerrors.ErrGrantPrefix
+	}
+	a.Lock()
+	defer a.Unlock()
+	grants, err := a.Grants(g.Namespace)
+	if err != nil {
+		return err
+	}
+	replaced := false
+	for i, old := range grants {
+		if old.Principal == g.Principal && old.Prefix == g.Prefix {
+			grants[i] = g
+			replaced = true
+		}
+	}
+	if !replaced {
+		grants = append(grants, g)
+	}
+	if err := a.save(g.Namespace, grants); err != nil {
+		return err
+	}
+	return a.audit(actor, "grant", g)
+}
+
+// Revoke remove the grant of principal on prefix of namespace, return false if there is no such grant.
+// Actor is audited.
+func (a *ACLs) Revoke(actor string, principal string, namespace string, prefix string) (bool, error) {
+	a.Lock()
+	defer a.Unlock()
+	grants, err := a.Grants(namespace)
+	if err != nil {
+		return false, err
+	}
+	for i, g := range grants {
+		if g.Principal == principal && g.Prefix == prefix {
+			if err := a.save(namespace, append(grants[:i], grants[i+1:]...)); err != nil {
+				return false, err
+			}
+			return true, a.audit(actor, "revoke", g)
+		}
+	}
+	return false, nil
+}
+
+// save store grants of namespace, the key is deleted when no grant is left.
+func (a *ACLs) save(namespace string, grants []*Grant) error {
+	if len(grants) == 0 {
+		_, _, _, err := a.engine.Delete(aclKey(namespace))
+		return err
+	}
+	value, err := json.Marshal(grants)
+	if err != nil {
+		return err
+	}
+	return a.engine.Put(aclKey(namespace), value)
+}
+
+func (a *ACLs) audit(actor string, action string, g *Grant) error {
+	entry := &AuditEntry{
+		Time:   time.Now().UnixNano(),
+		Actor:  actor,
+		Action: action,
+		Grant:  *g,
+	}
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	// zero padded, so entries are listed in time order
+	return a.engine.Put(fmt.Sprintf("%s%s%020d", aclAuditNamespace, db.NamespaceSeparator, entry.Time), value)
+}
+
+// Audit return at most limit audit entries in time order, after the entry at time after unless it is 0,
+// and whether more entries follow.
+func (a *ACLs) Audit(after int64, limit int) ([]*AuditEntry, bool, error) {
+	prefix := aclAuditNamespace + db.NamespaceSeparator
+	startAfter := ""
+	if after > 0 {
+		startAfter = fmt.Sprintf("%s%020d", prefix, after)
+	}
+	keys, more := a.engine.List(prefix, startAfter, limit)
+	values, errs := a.engine.BatchSeek(keys)
+	entries := make([]*AuditEntry, 0, len(keys))
+	for i := range keys {
+		if errs[i] != nil {
+			return nil, false, errs[i]
+		}
+		entry := &AuditEntry{}
+		if err := json.Unmarshal(values[i], entry); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, more, nil
+}
+
+// Allowed report whether the token of info has perm on key. Keys of namespaces used by akita itself,
+// those starting with an underscore, are only allowed to the admin scope.
+func (a *ACLs) Allowed(info *TokenInfo, key string, perm Permission) (bool, error) {
+	if a == nil {
+		return true, nil
+	}
+	if info == nil {
+		return false, nil
+	}
+	if info.Scope&ScopeAdmin != 0 {
+		return true, nil
+	}
+	namespace := db.Namespace(key)
+	if strings.HasPrefix(namespace, "_") {
+		return false, nil
+	}
+	grants, err := a.Grants(namespace)
+	if err != nil {
+		return false, err
+	}
+	if len(grants) == 0 {
+		return true, nil
+	}
+	for _, g := range grants {
+		if g.matches(info.Name, key, perm) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"akita/db"
+	akerrors "akita/errors"
+	"testing"
+)
+
+func Test_ACLs(t *testing.T) {
+	openTestTokens(t, "")
+	acls := NewACLs(db.GetEngine())
+	reader := &TokenInfo{Name: "reporting job", Scope: ScopeRead}
+	writer := &TokenInfo{Name: "uploader", Scope: ScopeRead | ScopeWrite}
+	admin := &TokenInfo{Name: "ops", Scope: ScopeAdmin}
+
+	check := func(info *TokenInfo, key string, perm Permission, want bool) {
+		t.Helper()
+		ok, err := acls.Allowed(info, key, perm)
+		if err != nil {
+			t.Fatalf("allowed error: %s.\n", err)
+		}
+		if ok != want {
+			t.Fatalf("allowed %v of %v on %v is %v, want %v.\n", perm, info, key, ok, want)
+		}
+	}
+
+	// namespaces without grants are open, internal ones are not
+	check(reader, "photos/a.jpg", PermRead, true)
+	check(reader, "_tokens/x", PermRead, false)
+	check(nil, "photos/a.jpg", PermRead, false)
+
+	perm, err := ParsePermission("read, list")
+	if err != nil || perm != PermRead|PermList {
+		t.Fatalf("parse permission got %v, %v.\n", perm, err)
+	}
+	if _, err := ParsePermission("read,execute"); err != akerrors.ErrPermissionUnknown {
+		t.Fatalf("parse unknown permission error %v.\n", err)
+	}
+	if err := acls.Grant("ops", &Grant{Principal: "uploader", Namespace: "photos", Prefix: "videos/", Permissions: PermWrite}); err != akerrors.ErrGrantPrefix {
+		t.Fatalf("grant prefix of another namespace error %v.\n", err)
+	}
+	if err := acls.Grant("ops", &Grant{Principal: "uploader", Namespace: "photos", Prefix: "photos/2020/", Permissions: PermRead | PermWrite}); err != nil {
+		t.Fatalf("grant error: %s.\n", err)
+	}
+	if err := acls.Grant("ops", &Grant{Principal: AnyPrincipal, Namespace: "photos", Permissions: PermList}); err != nil {
+		t.Fatalf("grant error: %s.\n", err)
+	}
+
+	check(writer, "photos/2020/a.jpg", PermWrite, true)
+	check(writer, "photos/2021/a.jpg", PermWrite, false)
+	check(writer, "photos/2020/a.jpg", PermDelete, false)
+	check(reader, "photos/2020/a.jpg", PermRead, false)
+	check(reader, "photos/2021/a.jpg", PermList, true)
+	check(admin, "photos/2021/a.jpg", PermDelete, true)
+	check(reader, "videos/a.mp4", PermRead, true)
+
+	// granting the same principal and prefix again replaces the permissions
+	if err := acls.Grant("ops", &Grant{Principal: "uploader", Namespace: "photos", Prefix: "photos/2020/", Permissions: PermRead}); err != nil {
+		t.Fatalf("grant error: %s.\n", err)
+	}
+	check(writer, "photos/2020/a.jpg", PermWrite, false)
+	if grants, err := acls.Grants("photos"); err != nil || len(grants) != 2 {
+		t.Fatalf("grants of photos got %v, %v.\n", grants, err)
+	}
+
+	if revoked, err := acls.Revoke("ops", "uploader", "photos", "photos/2020/"); err != nil || !revoked {
+		t.Fatalf("revoke got %v, %v.\n", revoked, err)
+	}
+	if revoked, err := acls.Revoke("ops", "uploader", "photos", "photos/2020/"); err != nil || revoked {
+		t.Fatalf("revoke twice got %v, %v.\n", revoked, err)
+	}
+	if revoked, err := acls.Revoke("ops", AnyPrincipal, "photos", ""); err != nil || !revoked {
+		t.Fatalf("revoke got %v, %v.\n", revoked, err)
+	}
+	if all, err := acls.All(); err != nil || len(all) != 0 {
+		t.Fatalf("grants left after revoke %v, %v.\n", all, err)
+	}
+	check(writer, "photos/2021/a.jpg", PermWrite, true)
+
+	entries, more, err := acls.Audit(0, 3)
+	if err != nil || len(entries) != 3 || !more {
+		t.Fatalf("audit got %d entries, more %v, error %v.\n", len(entries), more, err)
+	}
+	if entries[0].Action != "grant" || entries[0].Actor != "ops" || entries[0].Grant.Prefix != "photos/2020/" {
+		t.Fatalf("first audit entry is %+v.\n", entries[0])
+	}
+	rest, more, err := acls.Audit(entries[2].Time, 10)
+	if err != nil || len(rest) != 2 || more || rest[1].Action != "revoke" {
+		t.Fatalf("audit after %d got %d entries, more %v, error %v.\n", entries[2].Time, len(rest), more, err)
+	}
+}
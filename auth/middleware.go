@@ -0,0 +1,82 @@
+package auth
+
+import (
+	akerrors "akita/errors"
+	akhttp "akita/http"
+	"akita/logger"
+	"context"
+	"net/http"
+)
+
+// Require return a handler serving h only to requests whose token has scope.
+// If t is nil authentication is turned off and h is returned.
+func (t *Tokens) Require(scope Scope, h http.HandlerFunc) http.HandlerFunc {
+	return t.RequireFunc(func(*http.Request) Scope { return scope }, h)
+}
+
+// RequireFunc is like Require, the scope needed depends on the request.
+func (t *Tokens) RequireFunc(scopeOf func(*http.Request) Scope, h http.HandlerFunc) http.HandlerFunc {
+	if t == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, req *http.Request) {
+		if info, ok := t.authorize(w, req, scopeOf(req)); ok {
+			h(w, req.WithContext(WithTokenInfo(req.Context(), info)))
+		}
+	}
+}
+
+// RequireOrSigned is like Require but presigned requests are served without token, h must verify their signature.
+func (t *Tokens) RequireOrSigned(scope Scope, h http.HandlerFunc) http.HandlerFunc {
+	if t == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, req *http.Request) {
+		if IsSigned(req) {
+			h(w, req)
+			return
+		}
+		if info, ok := t.authorize(w, req, scope); ok {
+			h(w, req.WithContext(WithTokenInfo(req.Context(), info)))
+		}
+	}
+}
+
+type tokenInfoKey struct{}
+
+// WithTokenInfo return a copy of ctx carrying the token info of an authorized request.
+func WithTokenInfo(ctx context.Context, info *TokenInfo) context.Context {
+	return context.WithValue(ctx, tokenInfoKey{}, info)
+}
+
+// TokenInfoFrom return the token info of the authorized request of ctx, nil if it has none.
+func TokenInfoFrom(ctx context.Context) *TokenInfo {
+	info, _ := ctx.Value(tokenInfoKey{}).(*TokenInfo)
+	return info
+}
+
+// ScopeByMethod return the scope of a request of a key api, read for GET and HEAD, write otherwise.
+func ScopeByMethod(req *http.Request) Scope {
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return ScopeRead
+	}
+	return ScopeWrite
+}
+
+// authorize check the token of req has scope, write the error response and return false if not.
+func (t *Tokens) authorize(w http.ResponseWriter, req *http.Request, scope Scope) (*TokenInfo, bool) {
+	info, err := t.Authorize(RequestToken(req), scope)
+	switch err {
+	case nil:
+		return info, true
+	case akerrors.ErrTokenMissing, akerrors.ErrTokenInvalid:
+		w.Header().Set("WWW-Authenticate", `Bearer realm="akita"`)
+		akhttp.WriteError(w, http.StatusUnauthorized, akhttp.ErrCodeUnauthorized, err.Error())
+	case akerrors.ErrTokenScope:
+		akhttp.WriteError(w, http.StatusForbidden, akhttp.ErrCodeForbidden, err.Error())
+	default:
+		logger.Errorf("Authorize request error %v", err)
+		akhttp.WriteError(w, http.StatusInternalServerError, akhttp.ErrCodeInternal, err.Error())
+	}
+	return nil, false
+}
@@ -0,0 +1,92 @@
+package auth
+
+import (
+	akerrors "akita/errors"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// MaxPresignExpiry is the longest time a signed url is valid.
+	MaxPresignExpiry = 7 * 24 * time.Hour
+
+	minSecretSize = 16
+)
+
+// Signer signs and verifies urls granting one method on one key until they expire.
+type Signer struct {
+	secret []byte
+}
+
+// NewSigner create a signer with the hmac secret.
+func NewSigner(secret []byte) *Signer {
+	return &Signer{secret: secret}
+}
+
+// LoadSigner load the hex encoded secret of at least 16 bytes from the file path.
+func LoadSigner(path string) (*Signer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("presign secret file %s: %v", path, err)
+	}
+	if len(secret) < minSecretSize {
+		return nil, fmt.Errorf("presign secret file %s: secret is shorter than %d bytes", path, minSecretSize)
+	}
+	return NewSigner(secret), nil
+}
+
+// Sign return the query granting method on key at path until expires.
+func (s *Signer) Sign(method string, path string, key string, expires time.Time) url.Values {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return url.Values{
+		"key":       {key},
+		"expires":   {exp},
+		"signature": {s.signature(method, path, key, exp)},
+	}
+}
+
+func (s *Signer) signature(method string, path string, key string, expires string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(method + "\n" + path + "\n" + key + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// IsSigned report whether req carries a signature.
+func IsSigned(req *http.Request) bool {
+	return req.URL.Query().Get("signature") != ""
+}
+
+// Verify check that the signature of req grants its method on the key of its query and has not expired,
+// a url signed for GET also grants HEAD.
+func (s *Signer) Verify(req *http.Request) error {
+	query := req.URL.Query()
+	key, exp, sig := query.Get("key"), query.Get("expires"), query.Get("signature")
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || key == "" || sig == "" {
+		return akerrors.ErrSignatureInvalid
+	}
+	method := req.Method
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	want := s.signature(method, req.URL.Path, key, exp)
+	if !hmac.Equal([]byte(want), []byte(sig)) {
+		return akerrors.ErrSignatureInvalid
+	}
+	if time.Now().Unix() > expires {
+		return akerrors.ErrSignatureExpired
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package auth
+
+import (
+	akerrors "akita/errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_Presign(t *testing.T) {
+	s := NewSigner([]byte("0123456789abcdef"))
+
+	query := s.Sign("GET", "/akita/search/", "photos/1.jpg", time.Now().Add(time.Minute))
+	url := "/akita/search/?" + query.Encode()
+	if err := s.Verify(httptest.NewRequest("GET", url, nil)); err != nil {
+		t.Fatalf("verify signed url: %v", err)
+	}
+	if err := s.Verify(httptest.NewRequest("HEAD", url, nil)); err != nil {
+		t.Fatalf("verify head of signed get url: %v", err)
+	}
+	if err := s.Verify(httptest.NewRequest("POST", "/akita/save/?"+query.Encode(), nil)); err != akerrors.ErrSignatureInvalid {
+		t.Fatalf("verify other method and path: %v, want ErrSignatureInvalid", err)
+	}
+
+	query.Set("key", "photos/2.jpg")
+	if err := s.Verify(httptest.NewRequest("GET", "/akita/search/?"+query.Encode(), nil)); err != akerrors.ErrSignatureInvalid {
+		t.Fatalf("verify tampered key: %v, want ErrSignatureInvalid", err)
+	}
+	if err := NewSigner([]byte("another secret..")).Verify(httptest.NewRequest("GET", url, nil)); err != akerrors.ErrSignatureInvalid {
+		t.Fatalf("verify with other secret: %v, want ErrSignatureInvalid", err)
+	}
+
+	expired := s.Sign("GET", "/akita/search/", "photos/1.jpg", time.Now().Add(-time.Second))
+	if err := s.Verify(httptest.NewRequest("GET", "/akita/search/?"+expired.Encode(), nil)); err != akerrors.ErrSignatureExpired {
+		t.Fatalf("verify expired url: %v, want ErrSignatureExpired", err)
+	}
+}
@@ -0,0 +1,252 @@
+package auth
+
+import (
+	"akita/db"
+	akerrors "akita/errors"
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Scope is a set of permissions of a token.
+type Scope uint8
+
+const (
+	// ScopeRead allows reading values
+	ScopeRead Scope = 1 << iota
+	// ScopeWrite allows writing and deleting values
+	ScopeWrite
+	// ScopeAdmin allows managing tokens
+	ScopeAdmin
+	// ScopeReplication allows slaves to pull the data file
+	ScopeReplication
+)
+
+const (
+	// tokensNamespace holds tokens created through the admin api, a token whose sha256 is h is stored as
+	// _tokens/h, so tokens are never stored in clear.
+	tokensNamespace = "_tokens"
+	tokenPrefix     = "ak_"
+	lengthToken     = 32
+)
+
+var scopeNames = []struct {
+	scope Scope
+	name  string
+}{
+	{ScopeRead, "read"},
+	{ScopeWrite, "write"},
+	{ScopeAdmin, "admin"},
+	{ScopeReplication, "replication"},
+}
+
+// ParseScope parse a comma separated list of scope names.
+func ParseScope(s string) (Scope, error) {
+	var scope Scope
+	for _, name := range strings.Split(s, ",") {
+		found := false
+		for _, sn := range scopeNames {
+			if strings.TrimSpace(name) == sn.name {
+				scope |= sn.scope
+				found = true
+			}
+		}
+		if !found {
+			return 0, akerrors.ErrScopeUnknown
+		}
+	}
+	return scope, nil
+}
+
+func (s Scope) String() string {
+	var names []string
+	for _, sn := range scopeNames {
+		if s&sn.scope != 0 {
+			names = append(names, sn.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// MarshalJSON write scope as the comma separated list of its names.
+func (s Scope) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// UnmarshalJSON read scope from the comma separated list of its names.
+func (s *Scope) UnmarshalJSON(data []byte) error {
+	var names string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return err
+	}
+	scope, err := ParseScope(names)
+	if err != nil {
+		return err
+	}
+	*s = scope
+	return nil
+}
+
+// TokenInfo describes a token, ID is the hex encoded sha256 of the token.
+type TokenInfo struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Scope  Scope  `json:"scope"`
+	Static bool   `json:"static"`
+}
+
+// Tokens authenticates requests by api tokens, static ones of the token file and ones created through
+// the admin api, which are stored in akita and so replicated to slaves.
+type Tokens struct {
+	engine *db.Engine
+	static map[string]*TokenInfo // by id
+}
+
+// NewTokens create tokens stored in engine, with the static tokens of the token file path if it is not empty.
+// Each line of the file is "<token> <comma separated scopes> [name]", lines starting with # are ignored.
+func NewTokens(engine *db.Engine, path string) (*Tokens, error) {
+	t := &Tokens{
+		engine: engine,
+		static: make(map[string]*TokenInfo),
+	}
+	if path == "" {
+		return t, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		fields := strings.Fields(s)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("token file %s line %d: want token and scopes", path, line)
+		}
+		scope, err := ParseScope(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("token file %s line %d: %v", path, line, err)
+		}
+		info := &TokenInfo{ID: tokenID(fields[0]), Scope: scope, Static: true}
+		if len(fields) > 2 {
+			info.Name = strings.Join(fields[2:], " ")
+		}
+		t.static[info.ID] = info
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func tokenID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func tokenKey(id string) string {
+	return tokensNamespace + db.NamespaceSeparator + id
+}
+
+// Create create a token with scope, the token is returned once and only its sha256 is stored.
+func (t *Tokens) Create(name string, scope Scope) (string, *TokenInfo, error) {
+	buf := make([]byte, lengthToken)
+	if _, err := rand.Read(buf); err != nil {
+		return "", nil, err
+	}
+	token := tokenPrefix + hex.EncodeToString(buf)
+	info := &TokenInfo{ID: tokenID(token), Name: name, Scope: scope}
+	value, err := json.Marshal(info)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := t.engine.Put(tokenKey(info.ID), value); err != nil {
+		return "", nil, err
+	}
+	return token, info, nil
+}
+
+// Revoke delete the created token id, return false if there is no such token. Static tokens can not be revoked.
+func (t *Tokens) Revoke(id string) (bool, error) {
+	deleted, _, _, err := t.engine.Delete(tokenKey(id))
+	return deleted, err
+}
+
+// List return every token, ordered by name.
+func (t *Tokens) List() ([]*TokenInfo, error) {
+	var infos []*TokenInfo
+	for _, info := range t.static {
+		infos = append(infos, info)
+	}
+	after := ""
+	for {
+		keys, more := t.engine.List(tokensNamespace+db.NamespaceSeparator, after, 256)
+		values, errs := t.engine.BatchSeek(keys)
+		for i := range keys {
+			if errs[i] != nil {
+				return nil, errs[i]
+			}
+			info := &TokenInfo{}
+			if err := json.Unmarshal(values[i], info); err == nil {
+				infos = append(infos, info)
+			}
+		}
+		if !more {
+			break
+		}
+		after = keys[len(keys)-1]
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+// Lookup return the token info of token.
+func (t *Tokens) Lookup(token string) (*TokenInfo, error) {
+	id := tokenID(token)
+	if info, ok := t.static[id]; ok {
+		return info, nil
+	}
+	value, err := t.engine.Seek(tokenKey(id))
+	if err != nil {
+		return nil, err
+	}
+	info := &TokenInfo{}
+	if value == nil || json.Unmarshal(value, info) != nil {
+		return nil, akerrors.ErrTokenInvalid
+	}
+	return info, nil
+}
+
+// RequestToken return the token of req, sent as "Authorization: Bearer <token>" or in the X-Akita-Token header.
+func RequestToken(req *http.Request) string {
+	if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return req.Header.Get("X-Akita-Token")
+}
+
+// Authorize check that token has scope.
+func (t *Tokens) Authorize(token string, scope Scope) (*TokenInfo, error) {
+	if token == "" {
+		return nil, akerrors.ErrTokenMissing
+	}
+	info, err := t.Lookup(token)
+	if err != nil {
+		return nil, err
+	}
+	if info.Scope&scope != scope {
+		return nil, akerrors.ErrTokenScope
+	}
+	return info, nil
+}
@@ -0,0 +1,113 @@
+package auth
+
+import (
+	"akita/common"
+	"akita/db"
+	akerrors "akita/errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestTokens(t *testing.T, file string) *Tokens {
+	dir := t.TempDir()
+	fPath := filepath.Join(dir, "akita.dat")
+	f, err := os.Create(fPath)
+	if err != nil {
+		t.Fatalf("create data file error: %s.\n", err)
+	}
+	f.Close()
+	masterAddr, err := common.GetIntranetIP()
+	if err != nil {
+		t.Fatalf("get intranet ip error: %s.\n", err)
+	}
+	db.InitializeEngine(masterAddr, nil, "0", fPath, true, 100, false)
+	e := db.GetEngine()
+	e.StartBackground(1000, int64(time.Hour/time.Millisecond))
+	t.Cleanup(func() { e.GetDB().Close() })
+
+	path := ""
+	if file != "" {
+		path = filepath.Join(dir, "tokens")
+		if err := ioutil.WriteFile(path, []byte(file), 0600); err != nil {
+			t.Fatalf("write token file error: %s.\n", err)
+		}
+	}
+	tokens, err := NewTokens(e, path)
+	if err != nil {
+		t.Fatalf("new tokens error: %s.\n", err)
+	}
+	return tokens
+}
+
+func Test_Tokens(t *testing.T) {
+	tokens := openTestTokens(t, "# static tokens\nsecret-reader read reporting job\nsecret-slave replication\n")
+
+	if info, err := tokens.Authorize("secret-reader", ScopeRead); err != nil || info.Name != "reporting job" {
+		t.Fatalf("authorize static token: %v, %v", info, err)
+	}
+	if _, err := tokens.Authorize("secret-reader", ScopeWrite); err != akerrors.ErrTokenScope {
+		t.Fatalf("authorize static token beyond scope: %v, want ErrTokenScope", err)
+	}
+	if _, err := tokens.Authorize("", ScopeRead); err != akerrors.ErrTokenMissing {
+		t.Fatalf("authorize without token: %v, want ErrTokenMissing", err)
+	}
+
+	token, info, err := tokens.Create("uploader", ScopeRead|ScopeWrite)
+	if err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+	if _, err := tokens.Authorize(token, ScopeWrite); err != nil {
+		t.Fatalf("authorize created token: %v", err)
+	}
+	// only the sha256 of the token is stored
+	if keys, _ := db.GetEngine().List("_tokens/", "", 10); len(keys) != 1 || keys[0] != "_tokens/"+info.ID {
+		t.Fatalf("stored tokens: %v", keys)
+	}
+	infos, err := tokens.List()
+	if err != nil || len(infos) != 3 || infos[2].Name != "uploader" || infos[2].Scope.String() != "read,write" {
+		t.Fatalf("list tokens: %v, %v", infos, err)
+	}
+
+	if revoked, err := tokens.Revoke(info.ID); !revoked || err != nil {
+		t.Fatalf("revoke token: %v, %v", revoked, err)
+	}
+	if _, err := tokens.Authorize(token, ScopeRead); err != akerrors.ErrTokenInvalid {
+		t.Fatalf("authorize revoked token: %v, want ErrTokenInvalid", err)
+	}
+}
+
+func Test_Require(t *testing.T) {
+	tokens := openTestTokens(t, "secret-reader read\n")
+	ok := func(w http.ResponseWriter, req *http.Request) { w.WriteHeader(http.StatusNoContent) }
+
+	for _, c := range []struct {
+		h      http.HandlerFunc
+		method string
+		url    string
+		token  string
+		status int
+	}{
+		{tokens.Require(ScopeRead, ok), "GET", "/akita/search/?key=k", "secret-reader", http.StatusNoContent},
+		{tokens.Require(ScopeRead, ok), "GET", "/akita/search/?key=k", "", http.StatusUnauthorized},
+		{tokens.Require(ScopeRead, ok), "GET", "/akita/search/?key=k", "wrong", http.StatusUnauthorized},
+		{tokens.Require(ScopeWrite, ok), "POST", "/akita/save/", "secret-reader", http.StatusForbidden},
+		{tokens.RequireOrSigned(ScopeRead, ok), "GET", "/akita/search/?key=k&signature=x", "", http.StatusNoContent},
+		{tokens.RequireFunc(ScopeByMethod, ok), "PUT", "/v2/keys/k", "secret-reader", http.StatusForbidden},
+		{(*Tokens)(nil).Require(ScopeAdmin, ok), "GET", "/akita/tokens/", "", http.StatusNoContent},
+	} {
+		req := httptest.NewRequest(c.method, c.url, nil)
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		rec := httptest.NewRecorder()
+		c.h(rec, req)
+		if rec.Code != c.status {
+			t.Fatalf("%s %s with token %q: status %d, want %d", c.method, c.url, c.token, rec.Code, c.status)
+		}
+	}
+}
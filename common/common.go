@@ -6,6 +6,7 @@ import (
 	"hash/crc32"
 	"net"
 	"reflect"
+	"strings"
 	"unsafe"
 )
 
@@ -126,3 +127,77 @@ func HashCode(s string) int {
 	}
 	return h
 }
+
+// GlobMatch report whether s matches the redis style glob pattern,
+// which supports *, ?, [abc], [^abc], [a-z] and \ escaping.
+// Every other token matches one byte, so only the last * is retried when the rest fails and
+// matching takes at most len(pattern)*len(s) steps.
+func GlobMatch(pattern string, s string) bool {
+	p, i := 0, 0
+	star, starI := -1, 0 // pattern after the last *, and the byte of s it is retried from
+	for i < len(s) {
+		if p < len(pattern) && pattern[p] == '*' {
+			for p < len(pattern) && pattern[p] == '*' {
+				p++
+			}
+			if p == len(pattern) {
+				return true
+			}
+			star, starI = p, i
+			continue
+		}
+		if p < len(pattern) {
+			if n, ok := globMatchByte(pattern[p:], s[i]); ok {
+				p += n
+				i++
+				continue
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		// the last * takes one more byte
+		starI++
+		p, i = star, starI
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// globMatchByte report whether c matches the first token of pattern, which is not *, and return the length of the token.
+func globMatchByte(pattern string, c byte) (int, bool) {
+	switch pattern[0] {
+	case '?':
+		return 1, true
+	case '[':
+		end := strings.IndexByte(pattern[1:], ']')
+		if end < 0 {
+			// no closing bracket, match [ literally
+			return 1, c == '['
+		}
+		class := pattern[1:(end + 1)]
+		negate := len(class) > 0 && class[0] == '^'
+		if negate {
+			class = class[1:]
+		}
+		matched := false
+		for i := 0; i < len(class); i++ {
+			if i+2 < len(class) && class[i+1] == '-' {
+				if class[i] <= c && c <= class[i+2] {
+					matched = true
+				}
+				i += 2
+			} else if class[i] == c {
+				matched = true
+			}
+		}
+		return end + 2, matched != negate
+	case '\\':
+		if len(pattern) > 1 {
+			return 2, pattern[1] == c
+		}
+	}
+	return 1, pattern[0] == c
+}
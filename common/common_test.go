@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestIntToByteSlice(t *testing.T) {
@@ -83,3 +84,47 @@ func TestGetIntranetIp(t *testing.T) {
 	}
 	fmt.Println(intranet)
 }
+
+func TestGlobMatch(t *testing.T) {
+	cases := []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"*", "", true},
+		{"img/*", "img/1.jpg", true},
+		{"img/*", "log/1", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"*.jpg", "a/b.jpg", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{"*[0-9]", "img/7", true},
+		{"**?", "", false},
+		{"[abc", "[abc", true},
+		{"\\", "\\", true},
+		{"*a*a*a*a*a*a*a*a*b", strings.Repeat("a", 60), false},
+	}
+	for _, c := range cases {
+		if GlobMatch(c.pattern, c.s) != c.match {
+			t.Errorf("glob match pattern: %s, s: %s, want %v", c.pattern, c.s, c.match)
+		}
+	}
+}
+
+func TestGlobMatchPathological(t *testing.T) {
+	s := strings.Repeat("a", 60)
+	start := time.Now()
+	for i := 0; i < 1000; i++ {
+		if GlobMatch("*a*a*a*a*a*a*a*a*b", s) {
+			t.Fatalf("glob match should fail")
+		}
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("1000 pathological glob matches took %v", elapsed)
+	}
+}
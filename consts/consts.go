@@ -3,17 +3,28 @@ package consts
 const (
 	K = 1 << 10
 	M = 1 << 20
+
+	MaxValueSize = 64 * M // largest value a key can be saved with
 )
 
 const (
 	FlagWrite          = 1
 	FlagDelete         = 2
 	FlagExpire         = 3
+	FlagBlob           = 4 // content-addressed value, key is the sha256 digest of value
+	FlagRef            = 5 // key references a blob, value is the sha256 digest
+	FlagCheckpoint     = 6 // written by compaction, the records before it hold the state up to its lsn
+	FlagTypeMask       = 0xff
+	FlagCompress       = 1 << 8  // value is gzip compressed
+	FlagEncrypt        = 1 << 9  // value is AES-GCM encrypted, after compression
+	FlagLSN            = 1 << 10 // header is followed by the log sequence number of the record
 	LengthKs           = 4
 	LengthVs           = 4
 	LengthFlag         = 4
 	LengthExpireAt     = 8
 	LengthCrc32        = 4
+	LengthLSN          = 8
+	LengthDigest       = 32
 	LengthKVs          = LengthKs + LengthVs
 	LengthRecordHeader = LengthKs + LengthVs + LengthFlag + LengthExpireAt
 )
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"akita/common"
+	"encoding/json"
+)
+
+const (
+	// attrsNamespace holds the attributes of values, the attributes of key k are stored as key _attrs/k
+	attrsNamespace = "_attrs"
+)
+
+// Attrs are attributes of a value, such as its content type. They are written and deleted in the same
+// data file write as the value, so they never outlive it, whichever api writes the key next.
+type Attrs map[string]string
+
+func attrsKey(key string) string {
+	return attrsNamespace + NamespaceSeparator + key
+}
+
+// addAttrs add the write of attrs of key to b, or the delete of the attributes key has now if attrs is nil.
+// Keys of reserved namespaces have no attributes.
+func (e *Engine) addAttrs(b *Batch, key string, attrs Attrs, expireAt int64) error {
+	if reserved(Namespace(key)) {
+		return nil
+	}
+	ak := attrsKey(key)
+	if attrs == nil {
+		if e.db.iTable.get(ak) != nil {
+			b.Delete(common.StringToByteSlice(ak))
+		}
+		return nil
+	}
+	value, err := json.Marshal(attrs)
+	if err != nil {
+		return err
+	}
+	b.Put(common.StringToByteSlice(ak), value, expireAt, false)
+	return nil
+}
+
+// Attrs return the attributes of the value of key, nil if it has none or key is not stored.
+func (e *Engine) Attrs(key string) (Attrs, error) {
+	if e.db.liveIndex(key) == nil {
+		return nil, nil
+	}
+	ri := e.db.liveIndex(attrsKey(key))
+	if ri == nil {
+		return nil, nil
+	}
+	value, err := e.db.ReadRecord(ri.offset, ri.size)
+	if err != nil {
+		return nil, err
+	}
+	attrs := make(Attrs)
+	if err := json.Unmarshal(value, &attrs); err != nil {
+		return nil, err
+	}
+	return attrs, nil
+}
@@ -58,7 +58,11 @@ func newHashTableLRUCache(limit int) *hashTableLRUCache {
 }
 
 func (l *hashTableLRUCache) seekBucket(key string) int {
-	return common.HashCode(key) % len(l.bucket)
+	bi := common.HashCode(key) % len(l.bucket)
+	if bi < 0 {
+		bi += len(l.bucket)
+	}
+	return bi
 }
 
 func (l *hashTableLRUCache) isEmpty() bool {
@@ -104,29 +108,18 @@ func (l *hashTableLRUCache) insert(key string, data []byte) {
 	}
 	l.Lock()
 	defer l.Unlock()
+	l.unlink(n.key)
+	if l.isFull() && l.tail.pre != l.head {
+		l.unlink(l.tail.pre.key)
+	}
+
 	bi := l.seekBucket(n.key)
 	cn := l.bucket[bi]
 	for cn.hNext != nil {
-		if cn.hNext.key == n.key {
-			l.usage -= hashTableLRUNodeSize + len(n.key) + len(cn.hNext.data)
-			cn.hNext = cn.hNext.hNext
-			prn := cn.pre
-			nxn := n.next
-			prn.next = nxn
-			nxn.pre = prn
-		}
 		cn = cn.hNext
 	}
 	cn.hNext = n
 
-	if l.isFull() {
-		tn := l.tail.pre
-		pn := tn.pre
-		pn.next = l.tail
-		l.tail.pre = pn
-		l.usage -= hashTableLRUNodeSize + len(pn.data) + len(pn.key)
-	}
-
 	hn := l.head.next
 	hn.pre = n
 	n.next = hn
@@ -139,6 +132,11 @@ func (l *hashTableLRUCache) insert(key string, data []byte) {
 func (l *hashTableLRUCache) remove(key string) {
 	l.Lock()
 	defer l.Unlock()
+	l.unlink(key)
+}
+
+// unlink remove node of key from bucket and list, caller must hold the lock.
+func (l *hashTableLRUCache) unlink(key string) {
 	bi := l.seekBucket(key)
 	cn := l.bucket[bi]
 	for cn.hNext != nil {
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"akita/common"
+	"akita/consts"
+	"akita/logger"
+	"os"
+	"time"
+)
+
+// Compact rewrite the data file with live records only, values encrypted with an old key
+// or not encrypted at all are encrypted with the active key if encryption is turned on.
+// Records keep their lsns and are followed by a checkpoint of the last lsn, slaves behind it can not
+// get the records they miss one by one anymore.
+// It must be called after Reload and before the write gr is started.
+func (db *DB) Compact() error {
+	src, err := os.OpenFile(db.dfPath, os.O_RDONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmpPath := db.dfPath + ".compact"
+	dst, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+	defer dst.Close()
+
+	var size int64
+	write := func(ri *recordIndex) error {
+		recordBuf, err := db.compactRecord(src, ri)
+		if err != nil {
+			return err
+		}
+		n, err := common.WriteBufToFile(dst, size, recordBuf)
+		size += n
+		return err
+	}
+	// blobs go first, so every reference follows its blob
+	for _, bi := range db.blobs.table {
+		if err := write(&bi.recordIndex); err != nil {
+			return err
+		}
+	}
+	now := time.Now().Unix()
+	for _, ri := range db.iTable.table {
+		if ri.expired(now) {
+			continue
+		}
+		if err := write(ri); err != nil {
+			return err
+		}
+	}
+	if db.lsn > 0 {
+		checkpointBuf, err := db.genRecordBuf(&DataRecord{
+			header: &DataHeader{Flag: consts.FlagCheckpoint | consts.FlagLSN, lsn: db.lsn},
+		}, true)
+		if err != nil {
+			return err
+		}
+		n, err := common.WriteBufToFile(dst, size, checkpointBuf)
+		db.recordBuffPool.Put(checkpointBuf)
+		if err != nil {
+			return err
+		}
+		size += n
+	}
+	if err := dst.Sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, db.dfPath); err != nil {
+		return err
+	}
+	logger.Infof("compact data file %s from %d to %d bytes", db.dfPath, db.size, size)
+
+	db.size = size
+	db.iTable = newIndexTable()
+	db.blobs = newBlobTable()
+	db.expire = newKeyExpireHeap(1000)
+	db.lsn, db.baseLSN, db.lsnIndex = 0, 0, nil
+	return db.Reload()
+}
+
+// compactRecord return the record at ri as it is, or re-encrypted with the active key.
+func (db *DB) compactRecord(src *os.File, ri *recordIndex) ([]byte, error) {
+	recordBuf, err := common.ReadFileToBytes(src, ri.offset, ri.size)
+	if err != nil {
+		return nil, err
+	}
+	flag, err := common.ByteSliceToInt32(recordBuf[consts.LengthKVs:(consts.LengthKVs + consts.LengthFlag)])
+	if err != nil {
+		return nil, err
+	}
+	if db.keys == nil || (flag&consts.FlagTypeMask != consts.FlagWrite && flag&consts.FlagTypeMask != consts.FlagBlob) {
+		return recordBuf, nil
+	}
+
+	key, value, _, err := db.readRawRecord(src, ri.offset, ri.size)
+	if err != nil {
+		return nil, err
+	}
+	if flag&consts.FlagEncrypt != 0 {
+		if id, err := db.keys.keyID(value); err != nil || id == db.keys.active {
+			return recordBuf, err
+		}
+		if value, err = db.keys.open(key, value); err != nil {
+			return nil, err
+		}
+	}
+	header, _, err := parseHeader(recordBuf)
+	if err != nil {
+		return nil, err
+	}
+	record, err := db.encryptRecord(&DataRecord{
+		header: &DataHeader{
+			Ks:       int32(len(key)),
+			Vs:       int32(len(value)),
+			Flag:     flag &^ consts.FlagEncrypt,
+			expireAt: header.expireAt,
+			lsn:      header.lsn,
+		},
+		key:   key,
+		value: value,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return db.genRecordBuf(record, true)
+}
@@ -0,0 +1,101 @@
+package db
+
+import (
+	"akita/consts"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	// values smaller than compressMinSize are not worth compressing
+	compressMinSize = 256
+)
+
+// compressPolicy decides which values are gzip compressed on write.
+type compressPolicy struct {
+	namespaces map[string]bool // namespaces to compress, every namespace if empty
+}
+
+func newCompressPolicy(namespaces []string) *compressPolicy {
+	p := &compressPolicy{
+		namespaces: make(map[string]bool),
+	}
+	for _, ns := range namespaces {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			p.namespaces[ns] = true
+		}
+	}
+	return p
+}
+
+// shouldCompress report whether value of key should be compressed,
+// values whose content type is already compressed such as jpeg are skipped.
+func (p *compressPolicy) shouldCompress(key string, value []byte) bool {
+	if len(value) < compressMinSize {
+		return false
+	}
+	if len(p.namespaces) != 0 && !p.namespaces[Namespace(key)] {
+		return false
+	}
+	return !isCompressedContent(value)
+}
+
+func isCompressedContent(value []byte) bool {
+	ct := http.DetectContentType(value)
+	if strings.HasPrefix(ct, "image/") {
+		// bitmaps and svg compress well, every other image format is compressed already
+		return ct != "image/bmp" && ct != "image/svg+xml"
+	}
+	if strings.HasPrefix(ct, "video/") || strings.HasPrefix(ct, "audio/") {
+		return true
+	}
+	switch ct {
+	case "application/x-gzip", "application/zip", "application/x-rar-compressed", "application/pdf", "font/woff", "font/woff2":
+		return true
+	}
+	return false
+}
+
+// compressRecord compress value of record if the policy of db says so, key decides the namespace.
+// The record is returned unchanged if compression does not make value smaller.
+func (db *DB) compressRecord(key string, record *DataRecord) (*DataRecord, error) {
+	if db.compress == nil || !db.compress.shouldCompress(key, record.value) {
+		return record, nil
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(record.value); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	if buf.Len() >= len(record.value) {
+		return record, nil
+	}
+	header := *record.header
+	header.Vs = int32(buf.Len())
+	header.Flag |= consts.FlagCompress
+	return &DataRecord{
+		header: &header,
+		key:    record.key,
+		value:  buf.Bytes(),
+	}, nil
+}
+
+func decompressValue(value []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(value))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return ioutil.ReadAll(zr)
+}
+
+// UseCompression turn on gzip compression of values of namespaces, every namespace if namespaces is empty.
+func (db *DB) UseCompression(namespaces []string) {
+	db.compress = newCompressPolicy(namespaces)
+}
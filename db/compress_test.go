@@ -0,0 +1,59 @@
+package db
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_Namespace(t *testing.T) {
+	for key, want := range map[string]string{"logs/a": "logs", "a": DefaultNamespace, "/a": DefaultNamespace, "a/b/c": "a"} {
+		if ns := Namespace(key); ns != want {
+			t.Errorf("namespace of %s: %s, want %s", key, ns, want)
+		}
+	}
+}
+
+func Test_CompressRecord(t *testing.T) {
+	d := openTestDB(t)
+	defer d.Close()
+	d.UseCompression([]string{"logs"})
+
+	text := bytes.Repeat([]byte("akita compress test "), 100)
+	jpeg := append([]byte{0xff, 0xd8, 0xff, 0xe0}, text...)
+	for _, c := range []struct {
+		key        string
+		value      []byte
+		compressed bool
+	}{
+		{"logs/text", text, true},
+		{"logs/jpeg", jpeg, false},
+		{"images/text", text, false},
+	} {
+		record := &DataRecord{
+			header: &DataHeader{Ks: int32(len(c.key)), Vs: int32(len(c.value)), Flag: 1},
+			key:    []byte(c.key),
+			value:  c.value,
+		}
+		if err := d.WriteRecord(record); err != nil {
+			t.Fatalf("write record error: %s.\n", err)
+		}
+		ri := d.iTable.get(c.key)
+		_, compressed, err := d.ReadRecordEncoded(ri.offset, ri.size)
+		if err != nil || compressed != c.compressed {
+			t.Fatalf("key %s compressed: %v, %v, want %v", c.key, compressed, err, c.compressed)
+		}
+		value, err := d.ReadRecord(ri.offset, ri.size)
+		if err != nil || !bytes.Equal(value, c.value) {
+			t.Fatalf("read key %s error: %v", c.key, err)
+		}
+	}
+
+	rd := OpenDB(d.dfPath)
+	if err := rd.Reload(); err != nil {
+		t.Fatalf("reload db error: %s.\n", err)
+	}
+	ri := rd.iTable.get("logs/text")
+	if value, err := rd.ReadRecord(ri.offset, ri.size); err != nil || !bytes.Equal(value, text) {
+		t.Fatalf("read compressed key after reload error: %v", err)
+	}
+}
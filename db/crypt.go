@@ -0,0 +1,165 @@
+package db
+
+import (
+	"akita/common"
+	"akita/consts"
+	akerrors "akita/errors"
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	lengthKeyID = 4
+	lengthNonce = 12
+)
+
+// keyRing holds the AES-GCM keys of the data file by key id.
+// Every record is encrypted with the active key, records of older keys stay readable.
+type keyRing struct {
+	aeads  map[uint32]cipher.AEAD
+	active uint32
+}
+
+// loadKeyRing load keys from key file, each line is "<key id> <hex encoded 16, 24 or 32 bytes key>".
+// Lines starting with # are ignored, the key of the last line is the active key.
+func loadKeyRing(path string) (*keyRing, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	kr := &keyRing{
+		aeads: make(map[uint32]cipher.AEAD),
+	}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		fields := strings.Fields(s)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("key file %s line %d: want key id and key", path, line)
+		}
+		id, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("key file %s line %d: %v", path, line, err)
+		}
+		key, err := hex.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("key file %s line %d: %v", path, line, err)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("key file %s line %d: %v", path, line, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		kr.aeads[uint32(id)] = aead
+		kr.active = uint32(id)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(kr.aeads) == 0 {
+		return nil, fmt.Errorf("key file %s has no key", path)
+	}
+	return kr, nil
+}
+
+// seal encrypt value with the active key, key of record is authenticated with it.
+// Sealed value is key id, nonce, then cipher text.
+func (kr *keyRing) seal(key []byte, value []byte) ([]byte, error) {
+	aead := kr.aeads[kr.active]
+	idBuf, err := common.UintToByteSlice(kr.active)
+	if err != nil {
+		return nil, err
+	}
+	sealed := make([]byte, lengthKeyID+lengthNonce, lengthKeyID+lengthNonce+len(value)+aead.Overhead())
+	copy(sealed, idBuf)
+	if _, err := io.ReadFull(rand.Reader, sealed[lengthKeyID:]); err != nil {
+		return nil, err
+	}
+	return aead.Seal(sealed, sealed[lengthKeyID:], value, key), nil
+}
+
+// open decrypt sealed value with the key it was sealed with.
+func (kr *keyRing) open(key []byte, sealed []byte) ([]byte, error) {
+	if len(sealed) < lengthKeyID+lengthNonce {
+		return nil, akerrors.ErrDataHasBeenModified
+	}
+	id, err := kr.keyID(sealed)
+	if err != nil {
+		return nil, err
+	}
+	aead, ok := kr.aeads[id]
+	if !ok {
+		return nil, fmt.Errorf("encryption key %d is not in key file", id)
+	}
+	value, err := aead.Open(nil, sealed[lengthKeyID:(lengthKeyID+lengthNonce)], sealed[(lengthKeyID+lengthNonce):], key)
+	if err != nil {
+		return nil, akerrors.ErrDataHasBeenModified
+	}
+	return value, nil
+}
+
+func (kr *keyRing) keyID(sealed []byte) (uint32, error) {
+	return common.ByteSliceToUint(sealed[:lengthKeyID])
+}
+
+// encryptRecord encrypt value of record with the active key if encryption is turned on.
+func (db *DB) encryptRecord(record *DataRecord) (*DataRecord, error) {
+	if db.keys == nil {
+		return record, nil
+	}
+	sealed, err := db.keys.seal(record.key, record.value)
+	if err != nil {
+		return nil, err
+	}
+	header := *record.header
+	header.Vs = int32(len(sealed))
+	header.Flag |= consts.FlagEncrypt
+	return &DataRecord{
+		header: &header,
+		key:    record.key,
+		value:  sealed,
+	}, nil
+}
+
+// decryptValue decrypt value stored with key.
+func (db *DB) decryptValue(key []byte, value []byte) ([]byte, error) {
+	if db.keys == nil {
+		return nil, akerrors.ErrEncryptTurnOff
+	}
+	return db.keys.open(key, value)
+}
+
+// encodeRecord compress then encrypt value of record as db is configured, key decides the namespace.
+func (db *DB) encodeRecord(key string, record *DataRecord) (*DataRecord, error) {
+	record, err := db.compressRecord(key, record)
+	if err != nil {
+		return nil, err
+	}
+	return db.encryptRecord(record)
+}
+
+// UseEncryption turn on AES-GCM encryption of values with keys of key file.
+func (db *DB) UseEncryption(keyFile string) error {
+	kr, err := loadKeyRing(keyFile)
+	if err != nil {
+		return err
+	}
+	db.keys = kr
+	return nil
+}
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	testKey1 = "1 000102030405060708090a0b0c0d0e0f000102030405060708090a0b0c0d0e0f\n"
+	testKey2 = "2 0f0e0d0c0b0a09080706050403020100\n"
+)
+
+func writeTestKeyFile(t *testing.T, keys ...string) string {
+	path := filepath.Join(t.TempDir(), "akita.key")
+	if err := ioutil.WriteFile(path, []byte("# test keys\n"+strings.Join(keys, "")), 0600); err != nil {
+		t.Fatalf("write key file error: %s.\n", err)
+	}
+	return path
+}
+
+func Test_EncryptRecord(t *testing.T) {
+	d := openTestDB(t)
+	if err := d.UseEncryption(writeTestKeyFile(t, testKey1)); err != nil {
+		t.Fatalf("use encryption error: %s.\n", err)
+	}
+	value := []byte("akita plaintext value")
+	for _, key := range []string{"secret0", "secret1"} {
+		record := &DataRecord{
+			header: &DataHeader{Ks: int32(len(key)), Vs: int32(len(value)), Flag: 1},
+			key:    []byte(key),
+			value:  value,
+		}
+		if err := d.WriteRecord(record); err != nil {
+			t.Fatalf("write record error: %s.\n", err)
+		}
+	}
+	d.Close()
+	data, err := ioutil.ReadFile(d.dfPath)
+	if err != nil || bytes.Contains(data, value) {
+		t.Fatalf("data file should not contain plaintext value: %v", err)
+	}
+
+	// rotate key, old records stay readable and are re-encrypted by compaction
+	rd := OpenDB(d.dfPath)
+	if err := rd.UseEncryption(writeTestKeyFile(t, testKey1, testKey2)); err != nil {
+		t.Fatalf("use encryption error: %s.\n", err)
+	}
+	if err := rd.Reload(); err != nil {
+		t.Fatalf("reload db error: %s.\n", err)
+	}
+	if err := rd.Compact(); err != nil {
+		t.Fatalf("compact db error: %s.\n", err)
+	}
+	for _, key := range []string{"secret0", "secret1"} {
+		ri := rd.iTable.get(key)
+		read, err := rd.ReadRecord(ri.offset, ri.size)
+		if err != nil || !bytes.Equal(read, value) {
+			t.Fatalf("read key %s after compaction: %q, %v", key, read, err)
+		}
+		f, err := os.Open(rd.dfPath)
+		if err != nil {
+			t.Fatalf("open data file error: %s.\n", err)
+		}
+		_, sealed, _, err := rd.readRawRecord(f, ri.offset, ri.size)
+		f.Close()
+		if id, _ := rd.keys.keyID(sealed); err != nil || id != 2 {
+			t.Fatalf("key %s should be encrypted with key 2, got %d, %v", key, id, err)
+		}
+	}
+
+	// without the old key records can not be read
+	od := OpenDB(d.dfPath)
+	if err := od.UseEncryption(writeTestKeyFile(t, testKey1)); err != nil {
+		t.Fatalf("use encryption error: %s.\n", err)
+	}
+	if err := od.Reload(); err != nil {
+		t.Fatalf("reload db error: %s.\n", err)
+	}
+	ri := od.iTable.get("secret0")
+	if _, err := od.ReadRecord(ri.offset, ri.size); err == nil {
+		t.Fatalf("read record encrypted with unknown key should fail")
+	}
+}
@@ -6,7 +6,7 @@ import (
 	"akita/consts"
 	akerrors "akita/errors"
 	"akita/logger"
-	"errors"
+	"crypto/sha256"
 	"os"
 	"path/filepath"
 	"sync"
@@ -22,13 +22,20 @@ type DB struct {
 	size   int64 // next insert offset
 	iTable *indexTable
 
+	// blobs indexes deduplicated values by their sha256 digest
+	blobs *blobTable
+
+	// compress decides which values are compressed, nil if compression is turned off
+	compress *compressPolicy
+
+	// keys encrypt values, nil if encryption is turned off
+	keys *keyRing
+
 	// uses a buffered channel to pass write data,
 	// a gr that writes data specifically reads recordBuffQueue and writes data to the db file.
 	// this is design is to avoid using locks in I/O, "use communication to share data"
-	recordBuffQueue chan []byte
-
-	// write data errors are passed through recordBuffWriteErrs in current gr and write data gr
-	recordBuffWriteErrs map[uint32]chan error
+	// write data errors are passed back through the err channel of each recordBuff.
+	recordBuffQueue chan *recordBuff
 
 	// recordBuffPool reduces the consumption caused by GC recycling byte slices.
 	// Get byte slice from recordBuffPool and write it into data file, and put it back to recordBuffPool after success
@@ -36,6 +43,24 @@ type DB struct {
 
 	// expire uses small top heap to save expired keys
 	expire *keyExpireHeap
+
+	// seqLock serializes assigning log sequence numbers and pushing records to recordBuffQueue,
+	// nextLSN is the last assigned lsn
+	seqLock sync.Mutex
+	nextLSN int64
+
+	// lsn is the lsn of the last record written, baseLSN the lsn of the last compaction, guarded by the db lock.
+	// lsnIndex holds the offset of an lsn every lsnIndexInterval lsns, so records after an lsn are found quickly.
+	lsn      int64
+	baseLSN  int64
+	lsnIndex []lsnPosition
+}
+
+// recordBuff is a pending write of recordBuffQueue.
+type recordBuff struct {
+	buf    []byte
+	offset int64 // data file offset buf was written at
+	err    chan error
 }
 
 // OpenDB create a db object with data file path.
@@ -64,13 +89,13 @@ func OpenDB(fPath string) *DB {
 	}
 
 	db := &DB{
-		dfPath:              fPath,
-		size:                fi.Size(),
-		iTable:              newIndexTable(),
-		recordBuffQueue:     make(chan []byte, 100),
-		recordBuffWriteErrs: make(map[uint32]chan error),
-		recordBuffPool:      bytepool.NewBytePool(100, 2*consts.M),
-		expire:              newKeyExpireHeap(1000),
+		dfPath:          fPath,
+		size:            fi.Size(),
+		iTable:          newIndexTable(),
+		blobs:           newBlobTable(),
+		recordBuffQueue: make(chan *recordBuff, 100),
+		recordBuffPool:  bytepool.NewBytePool(100, 2*consts.M),
+		expire:          newKeyExpireHeap(1000),
 	}
 
 	return db
@@ -96,7 +121,12 @@ func (db *DB) Reload() error {
 		complete <- db.UpdateTable(0, length)
 	}()
 
-	return <-complete
+	if err := <-complete; err != nil {
+		return err
+	}
+	// blobs are released as their keys go, but the keys of a blob may be gone before it is read again
+	db.blobs.prune()
+	return nil
 }
 
 // UpdateTable update db index table from data file.
@@ -112,72 +142,134 @@ func (db *DB) UpdateTable(offset int64, length int64) error {
 	if err != nil {
 		return err
 	}
-	return db.UpdateTableWithData(offset, dataBuff)
+	if err := db.UpdateTableWithData(offset, dataBuff); err != nil {
+		return err
+	}
+	db.Lock()
+	defer db.Unlock()
+	return db.noteLSNs(offset, dataBuff)
 }
 
 // UpdateTableWithData update db index table with data buf.
 func (db *DB) UpdateTableWithData(offset int64, dataBuff []byte) error {
+	return db.indexRecords(offset, dataBuff, nil)
+}
+
+// indexRecords update db index table with the records of data buf written at offset, and append the changes
+// of keys to events if it is not nil.
+func (db *DB) indexRecords(offset int64, dataBuff []byte, events *[]*Event) error {
 	buffOffset, length := int64(0), int64(len(dataBuff))
 	for buffOffset < length {
-		ksBuff := dataBuff[buffOffset:(buffOffset + consts.LengthKs)]
-		vsBuff := dataBuff[(buffOffset + consts.LengthKs):(buffOffset + consts.LengthKVs)]
-		flagBuff := dataBuff[(buffOffset + consts.LengthKVs):(buffOffset + consts.LengthKVs + consts.LengthFlag)]
-		expireAtBuff := dataBuff[(buffOffset + consts.LengthKVs + consts.LengthFlag):(buffOffset + consts.LengthRecordHeader)]
-
-		ks, err := common.ByteSliceToInt32(ksBuff)
-		if err != nil {
-			logger.Errorf("turn byte slice to int32 error: %s", err)
-			return err
-		}
-		vs, err := common.ByteSliceToInt32(vsBuff)
-		if err != nil {
-			logger.Errorf("turn byte slice to int32 error: %s", err)
-			return err
+		header, rs, err := parseHeader(dataBuff[buffOffset:])
+		if err == nil && buffOffset+rs > length {
+			err = akerrors.ErrRecordTruncated
 		}
-		keyBuf := dataBuff[(buffOffset + consts.LengthRecordHeader):(buffOffset + consts.LengthRecordHeader + int64(ks))]
-		key := common.ByteSliceToString(keyBuf)
-		flag, err := common.ByteSliceToInt32(flagBuff)
 		if err != nil {
-			logger.Errorf("turn byte slice to int32 error: %s", err)
+			logger.Errorf("parse record header at offset %d error: %s", offset+buffOffset, err)
 			return err
 		}
+		keyStart := buffOffset + headerSize(header.Flag)
+		key := string(dataBuff[keyStart:(keyStart + int64(header.Ks))])
+		flag := header.Flag & consts.FlagTypeMask
 
 		if flag == consts.FlagDelete {
-			db.iTable.remove(key)
-			buffOffset += consts.LengthRecordHeader + int64(ks) + int64(vs)
+			if ri := db.removeIndex(key); ri != nil {
+				// the master deletes keys when they expire, the slave may have expired the key itself already
+				if ri.expired(time.Now().Unix()) {
+					noteEvent(events, EventExpire, key)
+				} else {
+					noteEvent(events, EventDelete, key)
+				}
+			}
+			buffOffset += rs
+			continue
+		}
+		if flag == consts.FlagCheckpoint {
+			buffOffset += rs
 			continue
 		}
 
-		expireAt, err := common.ByteSliceToInt64(expireAtBuff)
-		if err != nil {
-			logger.Errorf("turn byte slice to int64 error: %s", err)
-			return err
+		ri := recordIndex{
+			offset: offset + buffOffset,
+			size:   rs,
+			lsn:    header.lsn,
+		}
+
+		if flag == consts.FlagBlob {
+			db.blobs.put(key, &ri)
+			buffOffset += rs
+			continue
 		}
 
+		expireAt := header.expireAt
 		if expireAt != 0 && time.Unix(expireAt, 0).Before(time.Now()) {
-			db.iTable.remove(key)
-			buffOffset += consts.LengthRecordHeader + int64(ks) + int64(vs) + consts.LengthCrc32
+			if db.removeIndex(key) != nil {
+				noteEvent(events, EventExpire, key)
+			}
+			buffOffset += rs
 			continue
 		} else if expireAt != 0 {
-			ke := &keyExpire{
-				key:     key,
-				seconds: int64(time.Unix(expireAt, 0).Sub(time.Now()).Seconds()),
-			}
-			db.expire.push(ke)
+			ri.expireAt = expireAt
+			db.expire.push(&keyExpire{key: key, seconds: expireAt})
 		}
 
-		rs := consts.LengthRecordHeader + int(ks) + int(vs) + consts.LengthCrc32
-		ri := recordIndex{
-			offset: offset + buffOffset,
-			size:   int64(rs),
+		if flag == consts.FlagRef {
+			valueStart := keyStart + int64(header.Ks)
+			ri.digest = string(dataBuff[valueStart:(valueStart + int64(header.Vs))])
+			if !db.blobs.retain(ri.digest) {
+				logger.Errorf("key %v references a missing blob, skip it", key)
+				buffOffset += rs
+				continue
+			}
 		}
-		db.iTable.put(key, &ri)
-		buffOffset += int64(rs)
+		db.putIndex(key, &ri)
+		noteEvent(events, EventPut, key)
+		buffOffset += rs
 
 	}
 	return nil
 }
 
+// noteEvent append the change of key to events, if they are collected. Attributes change with their key
+// and are not watched themselves.
+func noteEvent(events *[]*Event, eventType EventType, key string) {
+	if events == nil || Namespace(key) == attrsNamespace {
+		return
+	}
+	*events = append(*events, &Event{Type: eventType, Key: key})
+}
+
+// putIndex insert record index and release the blob the old record referenced.
+// Blob referenced by newIndex must already be retained.
+func (db *DB) putIndex(key string, newIndex *recordIndex) {
+	if oldIndex := db.iTable.put(key, newIndex); oldIndex != nil && oldIndex.digest != "" {
+		db.blobs.release(oldIndex.digest)
+	}
+}
+
+// removeIndex remove record index and release the blob it referenced.
+func (db *DB) removeIndex(key string) *recordIndex {
+	ri := db.iTable.remove(key)
+	if ri != nil && ri.digest != "" {
+		db.blobs.release(ri.digest)
+	}
+	return ri
+}
+
+// liveIndex return index of key, nil if key is not stored or expired.
+func (db *DB) liveIndex(key string) *recordIndex {
+	ri := db.iTable.get(key)
+	if ri == nil || ri.expired(time.Now().Unix()) {
+		return nil
+	}
+	return ri
+}
+
+// HasBlob report whether a value with the sha256 digest is stored.
+func (db *DB) HasBlob(digest []byte) bool {
+	return db.blobs.get(string(digest)) != nil
+}
+
 // ReadRecord read data to memery.
 func (db *DB) ReadRecord(offset int64, length int64) ([]byte, error) {
 	dbFile, err := os.OpenFile(db.dfPath, os.O_RDONLY, 0644)
@@ -185,86 +277,343 @@ func (db *DB) ReadRecord(offset int64, length int64) ([]byte, error) {
 		return nil, err
 	}
 	defer dbFile.Close()
+	return db.readRecord(dbFile, offset, length)
+}
+
+// ReadRecordEncoded read data to memery as it is compressed, compressed report whether it is gzip compressed.
+func (db *DB) ReadRecordEncoded(offset int64, length int64) (value []byte, compressed bool, err error) {
+	dbFile, err := os.OpenFile(db.dfPath, os.O_RDONLY, 0644)
+	if err != nil {
+		return nil, false, err
+	}
+	defer dbFile.Close()
+	key, value, flag, err := db.readRawRecord(dbFile, offset, length)
+	if err != nil {
+		return nil, false, err
+	}
+	if flag&consts.FlagEncrypt != 0 {
+		if value, err = db.decryptValue(key, value); err != nil {
+			logger.Errorf("decrypt value error: %s", err)
+			return nil, false, err
+		}
+	}
+	return value, flag&consts.FlagCompress != 0, nil
+}
+
+// ReadRecords read several records to memery with one open data file.
+func (db *DB) ReadRecords(indexes []*recordIndex) ([][]byte, []error) {
+	values, errs := make([][]byte, len(indexes)), make([]error, len(indexes))
+	dbFile, err := os.OpenFile(db.dfPath, os.O_RDONLY, 0644)
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return values, errs
+	}
+	defer dbFile.Close()
+	for i, ri := range indexes {
+		if ri == nil {
+			continue
+		}
+		values[i], errs[i] = db.readRecord(dbFile, ri.offset, ri.size)
+	}
+	return values, errs
+}
+
+func (db *DB) readRecord(dbFile *os.File, offset int64, length int64) ([]byte, error) {
+	key, value, flag, err := db.readRawRecord(dbFile, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	if flag&consts.FlagEncrypt != 0 {
+		if value, err = db.decryptValue(key, value); err != nil {
+			logger.Errorf("decrypt value error: %s", err)
+			return nil, err
+		}
+	}
+	if flag&consts.FlagCompress != 0 {
+		if value, err = decompressValue(value); err != nil {
+			logger.Errorf("decompress value error: %s", err)
+			return nil, err
+		}
+	}
+	return value, nil
+}
 
+// readRawRecord read key, value of record as it is stored and the flag of record.
+func (db *DB) readRawRecord(dbFile *os.File, offset int64, length int64) ([]byte, []byte, int32, error) {
 	recordBuf, err := common.ReadFileToBytes(dbFile, offset, length)
 	if err != nil {
 		logger.Errorf("read data from file error: %s", err)
-		return nil, err
+		return nil, nil, 0, err
 	}
 
 	ksBuf := recordBuf[0:consts.LengthKs]
 	ks, err := common.ByteSliceToInt32(ksBuf)
 	if err != nil {
 		logger.Errorf("turn byte slice to int32 error: %s", err)
-		return nil, err
+		return nil, nil, 0, err
+	}
+	flag, err := common.ByteSliceToInt32(recordBuf[consts.LengthKVs:(consts.LengthKVs + consts.LengthFlag)])
+	if err != nil {
+		logger.Errorf("turn byte slice to int32 error: %s", err)
+		return nil, nil, 0, err
 	}
+	hs := headerSize(flag)
 
-	valueBuf := recordBuf[(consts.LengthRecordHeader + int64(ks)):(length - consts.LengthCrc32)]
+	valueBuf := recordBuf[(hs + int64(ks)):(length - consts.LengthCrc32)]
 	recordCrcBuf := recordBuf[(length - consts.LengthCrc32):length]
 	recordCrc32, err := common.ByteSliceToUint(recordCrcBuf)
 	if err != nil {
 		logger.Errorf("turn byte slice to uint error: %s", err)
-		return nil, err
+		return nil, nil, 0, err
 	}
 
 	crcSrcBuf := recordBuf[0:(length - consts.LengthCrc32)]
 	crc32 := common.CreateCrc32(crcSrcBuf)
 	if crc32 != recordCrc32 {
 		logger.Warningf("the data which offset: %v, length: %v has been modified, not safe. ", offset, length)
-		return nil, akerrors.ErrDataHasBeenModified
+		return nil, nil, 0, akerrors.ErrDataHasBeenModified
 	}
-	return valueBuf, nil
+	return recordBuf[hs:(hs + int64(ks))], valueBuf, flag, nil
 }
 
 // WriteRecord write byte stream record to data file.
 func (db *DB) WriteRecord(record *DataRecord) error {
-	recordBuf, err := db.genRecordBuf(record, true)
+	record, err := db.encodeRecord(string(record.key), record)
 	if err != nil {
 		return err
 	}
-	offsize := db.GetSyncSize()
-	db.PushRecordToQueue(recordBuf)
-	if err = db.GetWriteRecordResult(recordBuf); err != nil {
+	offset, sizes, err := db.writeRecords(true, record)
+	if err != nil {
 		logger.Errorf("write record error: %v", err)
 		return err
 	}
-	it := db.iTable
-	ri := &recordIndex{offset: offsize, size: int64(len(recordBuf))}
-	it.put(common.ByteSliceToString(record.key), ri)
+	ri := &recordIndex{offset: offset, size: sizes[0], expireAt: record.header.expireAt, lsn: record.header.lsn}
+	db.putIndex(string(record.key), ri)
+	if ri.expireAt != 0 {
+		db.expire.push(&keyExpire{key: string(record.key), seconds: ri.expireAt})
+	}
 	return nil
 }
 
 // WriteRecordNoCrc32 write byte stream record but no crc32 to data file.
 func (db *DB) WriteRecordNoCrc32(record *DataRecord) error {
-	rf, err := db.genRecordBuf(record, false)
-	if err != nil {
-		return err
-	}
-	db.PushRecordToQueue(rf)
-	if err = db.GetWriteRecordResult(rf); err != nil {
+	if _, _, err := db.writeRecords(false, record); err != nil {
 		logger.Errorf("write record error: %v", err)
 		return err
 	}
 	return nil
 }
 
-// GetDataByOffset get byte stream from data file at offset.
-func (db *DB) GetDataByOffset(offset int64) ([]byte, error) {
-	length := db.GetSyncSize() - offset
-	if length <= 0 {
-		return nil, akerrors.ErrNoDataUpdate
+// writeRecords assign the next log sequence numbers to records and write them with a single data file write,
+// return the offset they were written at and their sizes. Lsns are assigned and records pushed under seqLock,
+// so lsns increase in data file order.
+func (db *DB) writeRecords(checkCrc32 bool, records ...*DataRecord) (int64, []int64, error) {
+	db.seqLock.Lock()
+	if lsn := db.LSN(); db.nextLSN < lsn {
+		// written by the master this node synced from, or reloaded
+		db.nextLSN = lsn
+	}
+	bufs := make([][]byte, 0, len(records))
+	defer func() {
+		for _, buf := range bufs {
+			db.recordBuffPool.Put(buf)
+		}
+	}()
+	for i, record := range records {
+		record.header.Flag |= consts.FlagLSN
+		record.header.lsn = db.nextLSN + int64(i) + 1
+		buf, err := db.genRecordBuf(record, checkCrc32 && record.header.Flag&consts.FlagTypeMask != consts.FlagDelete)
+		if err != nil {
+			db.seqLock.Unlock()
+			return 0, nil, err
+		}
+		bufs = append(bufs, buf)
 	}
-	dbFile, err := os.OpenFile(db.dfPath, os.O_RDONLY, 0644)
+	db.nextLSN += int64(len(records))
+	buf := bufs[0]
+	if len(bufs) > 1 {
+		buf = common.AppendByteSlice(bufs...)
+	}
+	rb := db.PushRecordToQueue(buf)
+	db.seqLock.Unlock()
+
+	offset, err := db.GetWriteRecordResult(rb)
 	if err != nil {
-		return nil, err
+		return 0, nil, err
 	}
-	defer dbFile.Close()
+	sizes := make([]int64, len(bufs))
+	for i, buf := range bufs {
+		sizes[i] = int64(len(buf))
+	}
+	return offset, sizes, nil
+}
 
-	data, err := common.ReadFileToBytes(dbFile, offset, length)
+// WriteDedupRecord write a record referencing the blob of value, the blob is written first if not stored yet.
+func (db *DB) WriteDedupRecord(key []byte, value []byte, expireAt int64) error {
+	return db.WriteBatch([][]byte{key}, [][]byte{value}, expireAt, true)
+}
+
+// WriteBatch write records of keys and values with a single data file write, then index them in order.
+// Keys expire at expireAt unless it is 0. If dedup is true values are stored as blobs referenced by keys.
+func (db *DB) WriteBatch(keys [][]byte, values [][]byte, expireAt int64, dedup bool) error {
+	b := &Batch{}
+	for i, key := range keys {
+		b.Put(key, values[i], expireAt, dedup)
+	}
+	_, err := db.Write(b)
+	return err
+}
+
+type (
+	// batchOp is a put, delete or link of a batch.
+	batchOp struct {
+		flag     int32 // FlagWrite, FlagDelete or FlagRef
+		key      []byte
+		value    []byte // digest of the blob if flag is FlagRef
+		expireAt int64
+		dedup    bool
+	}
+
+	// Batch holds puts and deletes which are written with a single data file write, in order.
+	Batch struct {
+		ops []batchOp
+	}
+)
+
+// Put add a write of value to key, which expires at expireAt unless it is 0.
+// If dedup is true value is stored as a blob referenced by key.
+func (b *Batch) Put(key []byte, value []byte, expireAt int64, dedup bool) {
+	b.ops = append(b.ops, batchOp{flag: consts.FlagWrite, key: key, value: value, expireAt: expireAt, dedup: dedup})
+}
+
+// Link add a write of key as a reference to the blob of digest, which the caller has retained.
+// The reference is released if the batch is not written.
+func (b *Batch) Link(key []byte, digest []byte) {
+	b.ops = append(b.ops, batchOp{flag: consts.FlagRef, key: key, value: digest})
+}
+
+// Delete add a delete of key.
+func (b *Batch) Delete(key []byte) {
+	b.ops = append(b.ops, batchOp{flag: consts.FlagDelete, key: key})
+}
+
+// Write write the records of b with a single data file write, then index them in order.
+// It returns the lsn of the last record.
+func (db *DB) Write(b *Batch) (int64, error) {
+	if len(b.ops) == 0 {
+		return db.LSN(), nil
+	}
+	records := make([]*DataRecord, 0, len(b.ops))
+	held := make(map[int]bool)       // references which take over a reference retained before writing
+	written := make(map[string]bool) // blobs written by this batch
+	release := func() {
+		for i := range held {
+			db.blobs.release(string(records[i].value))
+		}
+	}
+	for _, op := range b.ops {
+		switch {
+		case op.flag == consts.FlagDelete:
+			records = append(records, &DataRecord{
+				header: &DataHeader{Ks: int32(len(op.key)), Flag: consts.FlagDelete},
+				key:    op.key,
+			})
+			continue
+		case op.flag == consts.FlagRef:
+			held[len(records)] = true
+			records = append(records, newRefRecord(op.key, op.value))
+			continue
+		case !op.dedup:
+			record, err := db.encodeRecord(string(op.key), &DataRecord{
+				header: &DataHeader{
+					Ks:       int32(len(op.key)),
+					Vs:       int32(len(op.value)),
+					Flag:     consts.FlagWrite,
+					expireAt: op.expireAt,
+				},
+				key:   op.key,
+				value: op.value,
+			})
+			if err != nil {
+				release()
+				return 0, err
+			}
+			records = append(records, record)
+			continue
+		}
+		sum := sha256.Sum256(op.value)
+		digest := sum[:]
+		hold := false
+		switch {
+		case written[string(digest)]:
+			// blob is already written by this batch
+		case db.blobs.retain(string(digest)):
+			hold = true
+		default:
+			// the blob is compressed by the policy of the key which stores it first
+			record, err := db.encodeRecord(string(op.key), &DataRecord{
+				header: &DataHeader{
+					Ks:   int32(len(digest)),
+					Vs:   int32(len(op.value)),
+					Flag: consts.FlagBlob,
+				},
+				key:   digest,
+				value: op.value,
+			})
+			if err != nil {
+				release()
+				return 0, err
+			}
+			records = append(records, record)
+			written[string(digest)] = true
+		}
+		if hold {
+			held[len(records)] = true
+		}
+		ref := newRefRecord(op.key, digest)
+		ref.header.expireAt = op.expireAt
+		records = append(records, ref)
+	}
+
+	// records are written together, so a reader of the data file never sees a reference without its blob
+	offset, sizes, err := db.writeRecords(true, records...)
 	if err != nil {
-		return nil, err
+		release()
+		logger.Errorf("write batch error: %v", err)
+		return 0, err
+	}
+
+	for i, record := range records {
+		ri := &recordIndex{offset: offset, size: sizes[i], expireAt: record.header.expireAt, lsn: record.header.lsn}
+		offset += ri.size
+		key := string(record.key)
+		switch record.header.Flag & consts.FlagTypeMask {
+		case consts.FlagBlob:
+			ri.expireAt = 0
+			db.blobs.put(key, ri)
+			continue
+		case consts.FlagDelete:
+			db.removeIndex(key)
+			continue
+		case consts.FlagRef:
+			ri.digest = string(record.value)
+			if !held[i] {
+				db.blobs.retain(ri.digest)
+			}
+		}
+		if ri.expireAt != 0 {
+			db.expire.push(&keyExpire{key: key, seconds: ri.expireAt})
+		}
+		db.putIndex(key, ri)
 	}
-	return data, nil
+	return records[len(records)-1].header.lsn, nil
+}
+
+// RetainBlob add a reference to the blob of digest, return false if it is not stored.
+func (db *DB) RetainBlob(digest []byte) bool {
+	return db.blobs.retain(string(digest))
 }
 
 func (db *DB) genRecordBuf(record *DataRecord, checkCrc32 bool) ([]byte, error) {
@@ -294,6 +643,14 @@ func (db *DB) genRecordBuf(record *DataRecord, checkCrc32 bool) ([]byte, error)
 	recordBuff = append(recordBuff, vsBuff...)
 	recordBuff = append(recordBuff, flagBuff...)
 	recordBuff = append(recordBuff, expireAtBuff...)
+	if record.header.Flag&consts.FlagLSN != 0 {
+		lsnBuff, err := common.Int64ByteSlice(record.header.lsn)
+		if err != nil {
+			logger.Errorf("turn int64 to byte slice error: %s", err)
+			return nil, err
+		}
+		recordBuff = append(recordBuff, lsnBuff...)
+	}
 	recordBuff = append(recordBuff, record.key...)
 	recordBuff = append(recordBuff, record.value...)
 
@@ -312,75 +669,74 @@ func (db *DB) genRecordBuf(record *DataRecord, checkCrc32 bool) ([]byte, error)
 
 // Close recycle some resource.
 func (db *DB) Close() error {
-	for key := range db.recordBuffWriteErrs {
-		close(db.recordBuffWriteErrs[key])
-	}
 	close(db.recordBuffQueue)
 	return nil
 }
 
-// WriteSyncData write byte stream data to data file.
-func (db *DB) WriteSyncData(dataBuff []byte) error {
-	offset := db.GetSyncSize()
-	db.PushRecordToQueue(dataBuff)
-	if err := db.GetWriteRecordResult(dataBuff); err != nil {
+// WriteSyncData write byte stream data to data file, and return the changes of keys it made.
+func (db *DB) WriteSyncData(dataBuff []byte) ([]*Event, error) {
+	offset, err := db.GetWriteRecordResult(db.PushRecordToQueue(dataBuff))
+	if err != nil {
 		logger.Errorf("write sync data error: %v", err)
-		return err
+		return nil, err
 	}
 
-	err := db.UpdateTableWithData(offset, dataBuff)
+	var events []*Event
+	err = db.indexRecords(offset, dataBuff, &events)
 	if err != nil {
 		logger.Errorf("update index table error: %v", err)
-		return err
+		return nil, err
 	}
-	return nil
+	return events, nil
 }
 
 // WriteRecordBuffQueueData write the data to data file with channel.
 func (db *DB) WriteRecordBuffQueueData() {
-	for {
-		select {
-		case r := <-db.recordBuffQueue:
-			k := common.CreateCrc32(r)
-			db.recordBuffWriteErrs[k] = make(chan error)
-			dbFile, err := os.OpenFile(db.dfPath, os.O_WRONLY|os.O_APPEND, 0644)
-			if err != nil {
-				db.recordBuffWriteErrs[k] <- err
-				db.recordBuffPool.Put(r)
-				continue
-			}
-			_, err = dbFile.Write(r)
-			if err != nil {
-				dbFile.Close()
-				db.recordBuffWriteErrs[k] <- err
-				db.recordBuffPool.Put(r)
-				continue
-			}
+	for r := range db.recordBuffQueue {
+		dbFile, err := os.OpenFile(db.dfPath, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			r.err <- err
+			continue
+		}
+		_, err = dbFile.Write(r.buf)
+		if err != nil {
 			dbFile.Close()
-			db.Lock()
-			db.size += int64(len(r))
-			db.Unlock()
-			db.recordBuffWriteErrs[k] <- nil
-			db.recordBuffPool.Put(r)
+			r.err <- err
+			continue
 		}
+		dbFile.Close()
+		db.Lock()
+		r.offset = db.size
+		db.size += int64(len(r.buf))
+		if err := db.noteLSNs(r.offset, r.buf); err != nil {
+			logger.Errorf("note lsns of records at offset %d error: %v", r.offset, err)
+		}
+		db.Unlock()
+		r.err <- nil
 	}
 }
 
 // PushRecordToQueue send records to recordQueue
-func (db *DB) PushRecordToQueue(r []byte) {
-	db.recordBuffQueue <- r
+func (db *DB) PushRecordToQueue(r []byte) *recordBuff {
+	rb := &recordBuff{
+		buf: r,
+		err: make(chan error, 1),
+	}
+	db.recordBuffQueue <- rb
+	return rb
 }
 
-// GetWriteRecordResult get write record error
-func (db *DB) GetWriteRecordResult(r []byte) error {
-	k := common.CreateCrc32(r)
-	errCh, ok := db.recordBuffWriteErrs[k]
-	if !ok {
-		return errors.New("data has some problem")
+// WriteQueueLoad return the fraction of recordBuffQueue holding pending writes.
+func (db *DB) WriteQueueLoad() float64 {
+	return float64(len(db.recordBuffQueue)) / float64(cap(db.recordBuffQueue))
+}
+
+// GetWriteRecordResult wait for the record to be written, return the offset it was written at
+func (db *DB) GetWriteRecordResult(rb *recordBuff) (int64, error) {
+	if err := <-rb.err; err != nil {
+		return 0, err
 	}
-	defer delete(db.recordBuffWriteErrs, k)
-	defer close(errCh)
-	return <-errCh
+	return rb.offset, nil
 }
 
 // DataFileSync flush system buffer data to the data file.
@@ -115,19 +115,19 @@ func Test_UpdateTable(t *testing.T) {
 	}
 }
 
-func Test_GetDataByOffset(t *testing.T) {
-	t.Log("test get data by offset.")
+func Test_GetDataAfter(t *testing.T) {
+	t.Log("test get data after lsn.")
 	d := OpenDB("/usr/local/akdata/akita.dat")
 
-	t.Logf("test get data by offset =====> size: %d. \n", d.size)
+	t.Logf("test get data after lsn =====> size: %d. \n", d.size)
 
-	data, err := d.GetDataByOffset(0)
+	data, _, err := d.GetDataAfter(0, 0, 4*1024*1024)
 	if err != nil {
-		t.Errorf("test get data by offset error: %s. \n", err)
+		t.Errorf("test get data after lsn error: %s. \n", err)
 		return
 	}
 
-	t.Logf("test get data by offset =====> data lenth :%d. \n", len(data))
+	t.Logf("test get data after lsn =====> data lenth :%d. \n", len(data))
 }
 
 func Test_WriteSyncData(t *testing.T) {
@@ -202,7 +202,7 @@ func Test_WriteSyncData(t *testing.T) {
 
 	t.Logf("test write sync data =====> sync record bytes len: %d. \n", len(recordBuf))
 
-	if err := d.WriteSyncData(recordBuf); err != nil {
+	if _, err := d.WriteSyncData(recordBuf); err != nil {
 		t.Errorf("write sync data error: %s.\n", err)
 	}
 
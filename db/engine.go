@@ -3,14 +3,22 @@ package db
 import (
 	"akita/common"
 	"akita/consts"
+	akerrors "akita/errors"
 	akhttp "akita/http"
 	"akita/logger"
 	"akita/pb"
 	"bytes"
 	"context"
+	"crypto/tls"
 	"fmt"
+	"io"
+	"math"
 	"mime/multipart"
 	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -20,16 +28,42 @@ import (
 // Engine kv database engine.
 type Engine struct {
 	sync.RWMutex
-	master    string   // master ip
-	slaves    []string // slaves ips
-	port      string
-	db        *DB
-	notifiers map[string]chan struct{} // notifiers notify slaves can get data from
-	useCache  bool
-	cache     *hashTableLRUCache
-	stop      chan struct{}
+	master     string   // master ip
+	slaves     []string // slaves ips
+	port       string
+	db         *DB
+	notifiers  map[string]chan struct{} // notifiers notify slaves can get data from
+	useCache   bool
+	cache      *hashTableLRUCache
+	useDedup   bool // store identical values once, referenced by sha256 digest
+	keyLocks   keyLocks
+	watchers   *watchers
+	syncToken  string // token slaves authenticate to the master sync endpoint with
+	syncClient *akhttp.HttpClient
+	syncScheme string // https if slaves sync with the master over tls
+	// syncBatchSize caps the bytes of records a slave gets at once
+	syncBatchSize int64
+	syncStream    bool // slaves keep a sync stream open instead of polling the master
+	streamClient  *akhttp.HttpClient
+	nodeID        string           // id a slave syncs with, the master keeps track of slaves by it
+	replicas      *replicaRegistry // slaves of the master
+	acks          chan struct{}    // a slave acknowledges the records it wrote when this is signaled
+	// writes wait until quorum replicas acknowledge them, for at most quorumTimeout;
+	// when the time is up they fail, or succeed anyway if quorumAsync
+	quorum        int
+	quorumTimeout time.Duration
+	quorumAsync   bool
+	stop          chan struct{}
 }
 
+const (
+	expireCheckInterval  = 500 * time.Millisecond
+	defaultSyncBatchSize = 4 * consts.M
+
+	// MaxScanCount is the largest number of keys a scan returns at once.
+	MaxScanCount = 1000
+)
+
 var (
 	engine *Engine
 )
@@ -40,7 +74,7 @@ func GetEngine() *Engine {
 }
 
 // InitializeEngine init engine.
-func InitializeEngine(master string, slaves []string, port string, dataFilePath string, useCache bool, cacheLimit int) {
+func InitializeEngine(master string, slaves []string, port string, dataFilePath string, useCache bool, cacheLimit int, useDedup bool) {
 	engine = &Engine{
 		master:    master,
 		slaves:    slaves,
@@ -48,8 +82,17 @@ func InitializeEngine(master string, slaves []string, port string, dataFilePath
 		db:        OpenDB(dataFilePath),
 		notifiers: make(map[string]chan struct{}),
 		useCache:  useCache,
+		useDedup:  useDedup,
+		watchers:  newWatchers(),
+		replicas:  newReplicaRegistry(slaves),
+		acks:      make(chan struct{}, 1),
 		stop:      make(chan struct{}),
 	}
+	engine.nodeID, _ = os.Hostname()
+	engine.syncClient = akhttp.NewHttpClient(2000 * time.Millisecond)
+	engine.streamClient = akhttp.NewHttpClient(0)
+	engine.syncScheme = "http"
+	engine.syncBatchSize = defaultSyncBatchSize
 	if useCache {
 		engine.cache = newHashTableLRUCache(cacheLimit)
 	}
@@ -62,48 +105,270 @@ func (e *Engine) GetDB() *DB {
 
 // Insert insert binary data to databae.
 func (e *Engine) Insert(key string, src multipart.File, length int64) (bool, error) {
-	keyBuf := common.StringToByteSlice(key)
 	valueBuf := make([]byte, length)
-	_, err := src.Read(valueBuf)
+	_, err := io.ReadFull(src, valueBuf)
 	if err != nil {
 		logger.Errorf("Insert key %v failed:  %v", key, err)
 		return false, err
 	}
-	ks := len(keyBuf)
-	dr := &DataRecord{
-		header: &DataHeader{
-			Ks:   int32(ks),
-			Vs:   int32(length),
-			Flag: consts.FlagWrite,
-		},
-		key:   keyBuf,
-		value: valueBuf,
+	e.keyLocks.lock(key)
+	defer e.keyLocks.unlock(key)
+	if _, err := e.put(key, valueBuf, 0, nil); err != nil {
+		return false, err
 	}
-	db := e.db
-	if err := db.WriteRecord(dr); err != nil {
+	return true, nil
+}
+
+// Put insert value of key.
+func (e *Engine) Put(key string, value []byte) error {
+	e.keyLocks.lock(key)
+	defer e.keyLocks.unlock(key)
+	_, err := e.put(key, value, 0, nil)
+	return err
+}
+
+// PutWithExpire insert value of key which expires at expireAt, unix time in seconds.
+// Return the lsn of the write, see WaitQuorum.
+func (e *Engine) PutWithExpire(key string, value []byte, expireAt int64) (int64, error) {
+	e.keyLocks.lock(key)
+	defer e.keyLocks.unlock(key)
+	return e.put(key, value, expireAt, nil)
+}
+
+// PutWithAttrs insert value of key with its attributes, see Attrs. Writing key without attributes
+// removes the ones it had. Return the lsn of the write.
+func (e *Engine) PutWithAttrs(key string, value []byte, expireAt int64, attrs Attrs) (int64, error) {
+	e.keyLocks.lock(key)
+	defer e.keyLocks.unlock(key)
+	return e.put(key, value, expireAt, attrs)
+}
+
+// PutIf insert value of key with attrs, which expires at expireAt, if cond accepts the current version
+// of key, exists is false if key is not stored. Return whether value is written and the lsn of the write.
+func (e *Engine) PutIf(key string, value []byte, expireAt int64, attrs Attrs, cond func(version uint64, exists bool) bool) (bool, int64, error) {
+	e.keyLocks.lock(key)
+	defer e.keyLocks.unlock(key)
+	version, exists := e.Version(key)
+	if !cond(version, exists) {
+		return false, 0, nil
+	}
+	lsn, err := e.put(key, value, expireAt, attrs)
+	if err != nil {
+		return false, 0, err
+	}
+	return true, lsn, nil
+}
+
+// Version return the version of key, it changes on every write of key and is the same on master and slaves.
+func (e *Engine) Version(key string) (uint64, bool) {
+	ri := e.db.liveIndex(key)
+	if ri == nil {
+		return 0, false
+	}
+	return ri.version(), true
+}
+
+// put write value of key with attrs and return the lsn of the write, caller must hold the key lock.
+func (e *Engine) put(key string, valueBuf []byte, expireAt int64, attrs Attrs) (int64, error) {
+	if err := e.checkQuota([]string{key}, len(valueBuf)); err != nil {
+		return 0, err
+	}
+	b := &Batch{}
+	b.Put(common.StringToByteSlice(key), valueBuf, expireAt, e.useDedup)
+	if err := e.addAttrs(b, key, attrs, expireAt); err != nil {
+		return 0, err
+	}
+	lsn, err := e.db.Write(b)
+	if err != nil {
 		logger.Errorf("Insert key %v failed:  %v \n", key, err)
-		return false, err
+		return 0, err
 	}
 	e.notify()
 	if e.useCache {
 		e.cache.insert(key, valueBuf)
 	}
-	return true, nil
+	e.watchers.publish(&Event{Type: EventPut, Key: key})
+	return lsn, nil
+}
+
+// Append append data to the value of key atomically, a missing key is created.
+// The whole new value is written with the attributes of key, so replaying and replicating it needs nothing
+// but the record, it fails with ErrValueSize if the new value would be larger than a value can be saved with.
+// Return the length of the new value and the lsn of the write.
+func (e *Engine) Append(key string, data []byte) (int, int64, error) {
+	e.keyLocks.lock(key)
+	defer e.keyLocks.unlock(key)
+	value, err := e.Seek(key)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(value)+len(data) > consts.MaxValueSize {
+		return 0, 0, akerrors.ErrValueSize
+	}
+	newValue := make([]byte, 0, len(value)+len(data))
+	newValue = append(append(newValue, value...), data...)
+	attrs, err := e.Attrs(key)
+	if err != nil {
+		return 0, 0, err
+	}
+	lsn, err := e.put(key, newValue, e.expireAt(key), attrs)
+	if err != nil {
+		return 0, 0, err
+	}
+	return len(newValue), lsn, nil
+}
+
+// Incr add delta to the decimal integer value of key atomically, a missing key counts as 0.
+// The attributes of key are kept. Return the new value and the lsn of the write.
+func (e *Engine) Incr(key string, delta int64) (int64, int64, error) {
+	e.keyLocks.lock(key)
+	defer e.keyLocks.unlock(key)
+	value, err := e.Seek(key)
+	if err != nil {
+		return 0, 0, err
+	}
+	var n int64
+	if value != nil {
+		if n, err = strconv.ParseInt(string(value), 10, 64); err != nil {
+			return 0, 0, akerrors.ErrValueNotInteger
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, 0, akerrors.ErrIncrOverflow
+	}
+	n += delta
+	attrs, err := e.Attrs(key)
+	if err != nil {
+		return 0, 0, err
+	}
+	lsn, err := e.put(key, []byte(strconv.FormatInt(n, 10)), e.expireAt(key), attrs)
+	if err != nil {
+		return 0, 0, err
+	}
+	return n, lsn, nil
+}
+
+// expireAt return the time key expires at, 0 if it never expires or is not stored.
+func (e *Engine) expireAt(key string) int64 {
+	if ri := e.db.liveIndex(key); ri != nil {
+		return ri.expireAt
+	}
+	return 0
+}
+
+// Expire set key to expire at expireAt, unix time in seconds, 0 makes key persistent.
+// The value is written again with the new expiration and its attributes, return false if key is not stored,
+// and the lsn of the write.
+func (e *Engine) Expire(key string, expireAt int64) (bool, int64, error) {
+	e.keyLocks.lock(key)
+	defer e.keyLocks.unlock(key)
+	value, err := e.Seek(key)
+	if err != nil || value == nil {
+		return false, 0, err
+	}
+	if expireAt != 0 && expireAt <= time.Now().Unix() {
+		_, _, lsn, err := e.delete(key)
+		if err != nil {
+			return false, 0, err
+		}
+		e.watchers.publish(&Event{Type: EventExpire, Key: key})
+		return true, lsn, nil
+	}
+	attrs, err := e.Attrs(key)
+	if err != nil {
+		return false, 0, err
+	}
+	lsn, err := e.put(key, value, expireAt, attrs)
+	if err != nil {
+		return false, 0, err
+	}
+	return true, lsn, nil
+}
+
+// TTL return the seconds key lives, -1 if key never expires and -2 if key is not stored.
+func (e *Engine) TTL(key string) int64 {
+	ri := e.db.liveIndex(key)
+	if ri == nil {
+		return -2
+	}
+	if ri.expireAt == 0 {
+		return -1
+	}
+	return ri.expireAt - time.Now().Unix()
+}
+
+// Exists report whether key is stored.
+func (e *Engine) Exists(key string) bool {
+	return e.db.liveIndex(key) != nil
+}
+
+// Scan return at most count keys matching pattern from cursor on, and the cursor to continue with,
+// which is 0 when all keys have been scanned. Keys are scanned in order, pattern is a glob pattern.
+// count is kept between 1 and MaxScanCount.
+func (e *Engine) Scan(cursor uint64, pattern string, count int) (uint64, []string) {
+	if count < 1 {
+		count = 1
+	} else if count > MaxScanCount {
+		count = MaxScanCount
+	}
+	all := e.db.iTable.keys()
+	var keys []string
+	now := time.Now().Unix()
+	i := cursor
+	for ; i < uint64(len(all)) && len(keys) < count; i++ {
+		if pattern != "" && !common.GlobMatch(pattern, all[i]) {
+			continue
+		}
+		if ri := e.db.iTable.get(all[i]); ri == nil || ri.expired(now) {
+			continue
+		}
+		keys = append(keys, all[i])
+	}
+	if i >= uint64(len(all)) {
+		i = 0
+	}
+	return i, keys
+}
+
+// List return at most limit live keys starting with prefix in byte order, beginning after startAfter,
+// and whether more keys follow.
+func (e *Engine) List(prefix string, startAfter string, limit int) ([]string, bool) {
+	all := e.db.iTable.keys()
+	start := prefix
+	if startAfter >= start {
+		start = startAfter + "\x00"
+	}
+	keys := make([]string, 0, limit)
+	now := time.Now().Unix()
+	for i := sort.SearchStrings(all, start); i < len(all) && strings.HasPrefix(all[i], prefix); i++ {
+		if ri := e.db.iTable.get(all[i]); ri == nil || ri.expired(now) {
+			continue
+		}
+		if len(keys) == limit {
+			return keys, true
+		}
+		keys = append(keys, all[i])
+	}
+	return keys, false
 }
 
 // Seek get data from key.
 func (e *Engine) Seek(key string) ([]byte, error) {
+	db := e.db
+	ri := db.liveIndex(key)
+	if ri == nil {
+		return nil, nil
+	}
 	if e.useCache {
 		cn := e.cache.search(key)
 		if cn != nil {
 			return cn.data, nil
 		}
 	}
-	db := e.db
-	it := e.db.iTable
-	ri := it.get(key)
-	if ri == nil {
-		return nil, nil
+	if ri.digest != "" {
+		if ri = db.blobs.get(ri.digest); ri == nil {
+			return nil, nil
+		}
 	}
 
 	data := make(chan []byte)
@@ -126,76 +391,373 @@ func (e *Engine) Seek(key string) ([]byte, error) {
 	return value, nil
 }
 
-// Delete delete data from key.
-func (e *Engine) Delete(key string) (bool, int64, error) {
+// SeekWithVersion get data from key and the version of the data, see Version.
+func (e *Engine) SeekWithVersion(key string) ([]byte, uint64, error) {
+	ri := e.db.liveIndex(key)
+	if ri == nil {
+		return nil, 0, nil
+	}
+	version := ri.version()
+	if ri.digest != "" {
+		if ri = e.db.blobs.get(ri.digest); ri == nil {
+			return nil, 0, nil
+		}
+	}
+	value, err := e.db.ReadRecord(ri.offset, ri.size)
+	if err != nil {
+		logger.Errorf("seek key: %v failed. err: %v", key, err)
+		return nil, 0, err
+	}
+	return value, version, nil
+}
+
+// SeekEncoded get data from key as it is stored, compressed report whether it is gzip compressed.
+func (e *Engine) SeekEncoded(key string) ([]byte, bool, error) {
+	ri := e.db.liveIndex(key)
+	if ri == nil {
+		return nil, false, nil
+	}
 	if e.useCache {
-		e.cache.remove(key)
+		if cn := e.cache.search(key); cn != nil {
+			return cn.data, false, nil
+		}
+	}
+	if ri.digest != "" {
+		ri = e.db.blobs.get(ri.digest)
 	}
-	ri := e.db.iTable.remove(key)
 	if ri == nil {
-		return false, 0, nil
+		return nil, false, nil
 	}
-	keyBuf := common.StringToByteSlice(key)
-	ks := len(keyBuf)
-	dr := &DataRecord{
-		header: &DataHeader{
-			Ks:   int32(ks),
-			Vs:   int32(0),
-			Flag: consts.FlagDelete,
-		},
-		key:   keyBuf,
-		value: nil,
+	value, compressed, err := e.db.ReadRecordEncoded(ri.offset, ri.size)
+	if err != nil {
+		logger.Errorf("seek key: %v failed. err: %v", key, err)
+		return nil, false, err
 	}
+	return value, compressed, nil
+}
+
+// BatchInsert insert several values with one data file write, return the lsn of the last record.
+func (e *Engine) BatchInsert(keys []string, values [][]byte) (int64, error) {
+	return e.BatchInsertWithAttrs(keys, values, nil)
+}
 
-	err := e.db.WriteRecordNoCrc32(dr)
+// BatchInsertWithAttrs insert several values with one data file write, the i-th value with attrs[i]
+// if attrs is not nil, see PutWithAttrs. Return the lsn of the last record.
+func (e *Engine) BatchInsertWithAttrs(keys []string, values [][]byte, attrs []Attrs) (int64, error) {
+	unlock := e.keyLocks.lockAll(keys)
+	defer unlock()
+	sizes := make([]int, len(keys))
+	for i, value := range values {
+		sizes[i] = len(value)
+	}
+	if err := e.checkQuota(keys, sizes...); err != nil {
+		return 0, err
+	}
+	b := &Batch{}
+	for i, key := range keys {
+		b.Put(common.StringToByteSlice(key), values[i], 0, e.useDedup)
+		var a Attrs
+		if attrs != nil {
+			a = attrs[i]
+		}
+		if err := e.addAttrs(b, key, a, 0); err != nil {
+			return 0, err
+		}
+	}
+	lsn, err := e.db.Write(b)
+	if err != nil {
+		logger.Errorf("Batch insert %d keys failed: %v", len(keys), err)
+		return 0, err
+	}
+	e.notify()
+	for i, key := range keys {
+		if e.useCache {
+			e.cache.insert(key, values[i])
+		}
+		e.watchers.publish(&Event{Type: EventPut, Key: key})
+	}
+	return lsn, nil
+}
+
+// BatchSeek get data of several keys with one open data file, value of missing key is nil.
+func (e *Engine) BatchSeek(keys []string) ([][]byte, []error) {
+	values, errs := make([][]byte, len(keys)), make([]error, len(keys))
+	indexes := make([]*recordIndex, len(keys))
+	for i, key := range keys {
+		ri := e.db.liveIndex(key)
+		if ri == nil {
+			continue
+		}
+		if e.useCache {
+			if cn := e.cache.search(key); cn != nil {
+				values[i] = cn.data
+				continue
+			}
+		}
+		if ri.digest != "" {
+			ri = e.db.blobs.get(ri.digest)
+		}
+		indexes[i] = ri
+	}
+
+	read, readErrs := e.db.ReadRecords(indexes)
+	for i, ri := range indexes {
+		if ri == nil {
+			continue
+		}
+		if readErrs[i] != nil {
+			logger.Errorf("seek key: %v failed. err: %v", keys[i], readErrs[i])
+			errs[i] = readErrs[i]
+			continue
+		}
+		values[i] = read[i]
+		if e.useCache {
+			e.cache.insert(keys[i], read[i])
+		}
+	}
+	return values, errs
+}
+
+// Delete delete data from key, return whether key was stored, the offset of its record
+// and the lsn of the write, 0 if nothing is written.
+func (e *Engine) Delete(key string) (deleted bool, offset int64, lsn int64, err error) {
+	e.keyLocks.lock(key)
+	defer e.keyLocks.unlock(key)
+	deleted, offset, lsn, err = e.delete(key)
+	if deleted {
+		e.watchers.publish(&Event{Type: EventDelete, Key: key})
+	}
+	return deleted, offset, lsn, err
+}
+
+// delete write delete record of key, caller must hold the key lock.
+// An expired key which is not deleted yet is deleted but reported as missing.
+func (e *Engine) delete(key string) (bool, int64, int64, error) {
+	if e.useCache {
+		e.cache.remove(key)
+	}
+	ri := e.db.iTable.get(key)
+	if ri == nil {
+		return false, 0, 0, nil
+	}
+	b := &Batch{}
+	b.Delete(common.StringToByteSlice(key))
+	if err := e.addAttrs(b, key, nil, 0); err != nil {
+		return false, 0, 0, err
+	}
+	lsn, err := e.db.Write(b)
 	if err != nil {
 		logger.Errorf("Delete key: "+key+" failed: %v", err)
-		return false, 0, err
+		return false, 0, 0, err
 	}
 	e.notify()
-	return true, ri.offset, nil
+	return !ri.expired(time.Now().Unix()), ri.offset, lsn, nil
 }
 
-// DbSync slaves server update data.
+// Link save key as a reference to an already stored value, so the value need not be uploaded again.
+// Return the lsn of the write.
+func (e *Engine) Link(key string, digest []byte) (int64, error) {
+	if !e.useDedup {
+		return 0, akerrors.ErrDedupTurnOff
+	}
+	if len(digest) != consts.LengthDigest {
+		return 0, akerrors.ErrDigestSize
+	}
+	e.keyLocks.lock(key)
+	defer e.keyLocks.unlock(key)
+	if err := e.checkQuota([]string{key}, len(digest)); err != nil {
+		return 0, err
+	}
+	if !e.db.RetainBlob(digest) {
+		return 0, akerrors.ErrBlobNotFound
+	}
+	b := &Batch{}
+	b.Link(common.StringToByteSlice(key), digest)
+	if err := e.addAttrs(b, key, nil, 0); err != nil {
+		e.db.blobs.release(string(digest))
+		return 0, err
+	}
+	lsn, err := e.db.Write(b)
+	if err != nil {
+		logger.Errorf("Link key %v failed:  %v", key, err)
+		return 0, err
+	}
+	e.notify()
+	if e.useCache {
+		e.cache.remove(key)
+	}
+	e.watchers.publish(&Event{Type: EventPut, Key: key})
+	return lsn, nil
+}
+
+// HasDigest report whether a value with the sha256 digest is stored.
+func (e *Engine) HasDigest(digest []byte) bool {
+	return e.useDedup && e.db.HasBlob(digest)
+}
+
+// DbSync slaves server update data, batches are pulled one after another until the slave has caught up.
+// A slave the master has no records for anymore is bootstrapped from a snapshot of the master.
 func (e *Engine) DbSync() error {
 
 	if e.IsMaster() {
 		return nil
 	}
-
-	offset := e.db.GetSyncSize()
-	syncOffset := &pb.SyncOffset{
-		Offset: offset,
+	for {
+		more, err := e.syncBatch()
+		if err == akerrors.ErrSyncPositionLost {
+			return e.Bootstrap()
+		}
+		if err != nil || !more {
+			return err
+		}
+		select {
+		case <-e.stop:
+			return nil
+		default:
+		}
 	}
+}
+
+// syncBatch pull and write the next batch of records from master, and report whether more records follow.
+func (e *Engine) syncBatch() (bool, error) {
+	syncOffset := e.syncOffset()
 	protoData, err := proto.Marshal(syncOffset)
 	if err != nil {
 		logger.Errorf("marshal data to proto error: %v", err)
-		return err
+		return false, err
 	}
 	reader := bytes.NewReader(protoData)
-	hc := akhttp.NewHttpClient(2000 * time.Millisecond)
-	url := fmt.Sprintf("%v%v:%v%v", "http://", e.master, e.port, "/akita/syn/")
-	statusCode, data, err := hc.Post(url, "application/protobuf", reader)
+	url := fmt.Sprintf("%v://%v:%v%v", e.syncScheme, e.master, e.port, "/akita/sync/")
+	header := make(http.Header)
+	if e.syncToken != "" {
+		header.Set("Authorization", "Bearer "+e.syncToken)
+	}
+	statusCode, data, err := e.syncClient.PostWithHeader(url, "application/protobuf", header, reader)
 	if err != nil {
 		logger.Errorf("sync request fail: %v", err)
-		return err
+		return false, err
+	}
+	if statusCode == http.StatusGone {
+		logger.Errorf("sync from lsn %d fail: %v", syncOffset.Lsn, akerrors.ErrSyncPositionLost)
+		return false, akerrors.ErrSyncPositionLost
 	}
 	if statusCode != 200 {
-		logger.Infof("sync data from fail info : %v", err)
-		return err
+		logger.Infof("sync data from fail info : %s", data)
+		return false, err
 	}
 	syncData := &pb.SyncData{}
 	err = proto.Unmarshal(data, syncData)
 	if err != nil {
 		logger.Errorf("proto data unmarshal error: %v", err)
-		return err
+		return false, err
 	}
 	if syncData.Code != 0 {
-		return e.db.WriteSyncData(syncData.Data) // write sync data
+		// write sync data
+		if err := e.writeSyncData(syncData.Data); err != nil {
+			return false, err
+		}
+	}
+	return syncData.More, nil
+}
+
+// writeSyncData write the records the master sent, and publish the changes of keys they made to watchers.
+func (e *Engine) writeSyncData(data []byte) error {
+	events, err := e.db.WriteSyncData(data)
+	if err != nil {
+		return err
+	}
+	for _, ev := range events {
+		if e.useCache {
+			e.cache.remove(ev.Key)
+		}
+		e.watchers.publish(ev)
 	}
 	return nil
 }
 
+// SyncDataAfter get the next batch of records after lsn for a slave, and whether more records follow.
+// A slave at lsn 0 gets the records without lsns from legacyOffset on, see DB.GetDataAfter.
+func (e *Engine) SyncDataAfter(lsn int64, legacyOffset int64) ([]byte, bool, error) {
+	return e.db.GetDataAfter(lsn, legacyOffset, e.syncBatchSize)
+}
+
+// syncOffset return the position the slave syncs from.
+func (e *Engine) syncOffset() *pb.SyncOffset {
+	syncOffset := &pb.SyncOffset{Lsn: e.db.LSN(), NodeId: e.nodeID}
+	if syncOffset.Lsn == 0 {
+		// records are written as the master sent them, so the data file holds the records without lsns synced so far
+		syncOffset.LegacyOffset = e.db.GetSyncSize()
+	}
+	return syncOffset
+}
+
+// UseSyncBatchSize cap the records a slave gets at once at size bytes, a larger record is sent alone.
+func (e *Engine) UseSyncBatchSize(size int64) {
+	e.syncBatchSize = size
+}
+
+// UseSyncStream make slaves keep a sync stream open, the master pushes records through it as they are written.
+func (e *Engine) UseSyncStream(turnOn bool) {
+	e.syncStream = turnOn
+}
+
+// UseWriteQuorum make writes wait until n replicas acknowledge them, for at most timeout. When the time is up
+// WaitQuorum fails, or if async is true succeeds anyway, falling back to asynchronous replication.
+func (e *Engine) UseWriteQuorum(n int, timeout time.Duration, async bool) {
+	e.quorum, e.quorumTimeout, e.quorumAsync = n, timeout, async
+}
+
+// WaitQuorum wait until the write quorum of replicas acknowledged the write at lsn, and return how many did.
+// If too few do in time it fails with ErrQuorumTimeout unless writes fall back to asynchronous replication.
+// The write is stored on the master either way.
+func (e *Engine) WaitQuorum(lsn int64) (int, error) {
+	n := e.WaitReplicas(lsn, e.quorum, e.quorumTimeout)
+	if n >= e.quorum {
+		return n, nil
+	}
+	if e.quorumAsync {
+		logger.Infof("%d of %d replicas confirmed lsn %d in time, replicate it asynchronously", n, e.quorum, lsn)
+		return n, nil
+	}
+	return n, akerrors.ErrQuorumTimeout
+}
+
+// WaitReplicas wait until n replicas acknowledged the write at lsn, for at most timeout, and return how many did.
+func (e *Engine) WaitReplicas(lsn int64, n int, timeout time.Duration) int {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		confirmed, acked := e.replicas.confirmed(lsn)
+		if confirmed >= n {
+			return confirmed
+		}
+		select {
+		case <-acked:
+		case <-timer.C:
+			return confirmed
+		case <-e.stop:
+			return confirmed
+		}
+	}
+}
+
+// UseNodeID set the id a slave syncs with, the host name by default.
+func (e *Engine) UseNodeID(nodeID string) {
+	e.nodeID = nodeID
+}
+
+// UseSyncToken set the api token with replication scope a slave sends to the master.
+func (e *Engine) UseSyncToken(token string) {
+	e.syncToken = token
+}
+
+// UseTLS make slaves sync with the master over tls using config, which verifies the certificate of the master host.
+func (e *Engine) UseTLS(config *tls.Config) {
+	e.syncClient = akhttp.NewHttpClientWithTLS(2000*time.Millisecond, config)
+	e.streamClient = akhttp.NewHttpClientWithTLS(0, config)
+	e.syncScheme = "https"
+}
+
 // IsMaster judge server is master or not.
 func (e *Engine) IsMaster() bool {
 	intranet, err := common.GetIntranetIP()
@@ -206,29 +768,71 @@ func (e *Engine) IsMaster() bool {
 }
 
 func (e *Engine) notify() {
+	e.replicas.noteWrite(e.db.LSN())
 	e.Lock()
 	defer e.Unlock()
-	for host, notifier := range e.notifiers {
+	for nodeID, notifier := range e.notifiers {
 		close(notifier)
-		delete(e.notifiers, host)
+		delete(e.notifiers, nodeID)
 	}
 }
 
-// Register regist slaves to master.
-func (e *Engine) Register(slaveHost string, notifier chan struct{}) {
+// Register regist slaves to master, notifier is closed on the next write.
+func (e *Engine) Register(nodeID string, notifier chan struct{}) {
 	e.Lock()
 	defer e.Unlock()
-	e.notifiers[slaveHost] = notifier
+	e.notifiers[nodeID] = notifier
+}
+
+// Unregister remove the notifier of a slave which stopped syncing, unless it was registered again since.
+func (e *Engine) Unregister(nodeID string, notifier chan struct{}) {
+	e.Lock()
+	defer e.Unlock()
+	if e.notifiers[nodeID] == notifier {
+		delete(e.notifiers, nodeID)
+	}
+}
+
+// AckSync note the slave nodeID at addr has written the records up to lsn. It fails with ErrSyncPositionLost
+// if the master has not written the record at lsn.
+func (e *Engine) AckSync(nodeID string, addr string, lsn int64) error {
+	if lsn < 0 || lsn > e.db.LSN() {
+		return akerrors.ErrSyncPositionLost
+	}
+	e.replicas.ack(nodeID, addr, lsn)
+	return nil
+}
+
+// Replicas return the slaves which synced since the master started and the configured slaves which did not,
+// with their lag.
+func (e *Engine) Replicas() []*Replica {
+	return e.replicas.list(e.db.LSN(), e.db.bytesAfter)
 }
 
 // Start start akita server service.
 func (e *Engine) Start(server *http.Server, dfsInterval int64, dbsInterval int64) {
+	e.StartBackground(dfsInterval, dbsInterval)
+	logger.Infoln("akita server starting... ")
+	var err error
+	if server.TLSConfig != nil {
+		// certificates are provided by the tls config
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		logger.Fatalf("start http server error %v", err)
+	}
+}
+
+// StartBackground start the write, timing and expire key grs of engine.
+func (e *Engine) StartBackground(dfsInterval int64, dbsInterval int64) {
 	go e.db.WriteRecordBuffQueueData()
 	go e.TimeExecute(dfsInterval, dbsInterval, e.stop)
 	go e.ExpireKeyManagement()
-	logger.Infoln("akita server starting... ")
-	if err := server.ListenAndServe(); err != nil {
-		logger.Fatalf("start http server error %v", err)
+	if e.syncStream && !e.IsMaster() {
+		go e.StreamSync(dbsInterval)
+		go e.AckSyncs()
 	}
 }
 
@@ -237,17 +841,19 @@ func (e *Engine) Close(server *http.Server) {
 	logger.Infoln("akita server stopping... ")
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
+	// watch requests and sync streams never end by themselves
+	e.watchers.removeAll()
+	close(e.stop)
 	if err := server.Shutdown(ctx); err != nil {
 		logger.Errorf("shut down http server error %v", err)
 		return
 	}
 	e.db.Close()
-	close(e.stop)
 	logger.Infoln("akita server stopped. ")
 }
 
 // TimeExecute execute engine timing tasks, currently hard-coded
-// Currently DB.DataFileSync() and DbSync() are executed regularly
+// Currently DB.DataFileSync() and DbSync(), unless slaves keep a sync stream open, are executed regularly
 func (e *Engine) TimeExecute(dfsInterval int64, dbsInterval int64, stop chan struct{}) {
 	dfsTicker := time.NewTicker(time.Duration(dfsInterval) * time.Millisecond)
 	defer dfsTicker.Stop()
@@ -258,7 +864,9 @@ func (e *Engine) TimeExecute(dfsInterval int64, dbsInterval int64, stop chan str
 		case <-dfsTicker.C:
 			e.db.DataFileSync()
 		case <-dbsTicker.C:
-			e.DbSync()
+			if !e.syncStream {
+				e.DbSync()
+			}
 		case <-stop:
 			return
 		}
@@ -273,26 +881,65 @@ func (e *Engine) DbReload() error {
 // ExpireKeyManagement manage expired keys and delete them in cache, index, and data files
 func (e *Engine) ExpireKeyManagement() {
 	de := e.db.expire
+	ticker := time.NewTicker(expireCheckInterval)
+	defer ticker.Stop()
 	for {
-		ek := de.pop()
-		if ek == nil {
-			continue
+		select {
+		case <-ticker.C:
+		case <-e.stop:
+			return
 		}
-		<-time.After(time.Duration(ek.seconds))
-		e.cache.remove(ek.key)
-		e.db.iTable.remove(ek.key)
-		keyBuf := common.StringToByteSlice(ek.key)
-		ks := len(keyBuf)
-		dr := &DataRecord{
-			header: &DataHeader{
-				Ks:   int32(ks),
-				Vs:   int32(0),
-				Flag: consts.FlagDelete,
-			},
-			key:   keyBuf,
-			value: nil,
+		now := time.Now().Unix()
+		for ek := de.peek(); ek != nil && ek.seconds <= now; ek = de.peek() {
+			de.pop()
+			e.expireKey(ek)
 		}
-		e.db.WriteRecordNoCrc32(dr)
 	}
+}
+
+// expireKey delete key if it still expires at the time of ek, it may have been written again since.
+// Only master writes the delete record, slaves get it by sync.
+func (e *Engine) expireKey(ek *keyExpire) {
+	e.keyLocks.lock(ek.key)
+	defer e.keyLocks.unlock(ek.key)
+	ri := e.db.iTable.get(ek.key)
+	if ri == nil || ri.expireAt != ek.seconds {
+		return
+	}
+	if !e.IsMaster() {
+		if e.useCache {
+			e.cache.remove(ek.key)
+		}
+		e.db.removeIndex(ek.key)
+		e.watchers.publish(&Event{Type: EventExpire, Key: ek.key})
+		return
+	}
+	if _, _, _, err := e.delete(ek.key); err != nil {
+		logger.Errorf("delete expired key %v error: %v", ek.key, err)
+		return
+	}
+	e.watchers.publish(&Event{Type: EventExpire, Key: ek.key})
+}
+
+// Watch return the put, delete and expire events of keys starting with prefix from now on, and a function
+// which stops watching. Events are closed when watching stops or the watcher falls too far behind.
+func (e *Engine) Watch(prefix string) (<-chan *Event, func()) {
+	events, stop, _ := e.WatchFrom(prefix, 0)
+	return events, stop
+}
+
+// WatchFrom is like Watch but events begin after the event at position after, so a watcher resuming
+// with the Seq of the last event it got misses nothing. It fails with ErrWatchPositionLost if the
+// events after the position are no longer kept, position 0 watches from now on.
+func (e *Engine) WatchFrom(prefix string, after uint64) (<-chan *Event, func(), error) {
+	w, err := e.watchers.add(prefix, after)
+	if err != nil {
+		return nil, nil, err
+	}
+	return w.events, func() { e.watchers.remove(w) }, nil
+}
 
+// WatchPosition return the position of the latest event.
+func (e *Engine) WatchPosition() uint64 {
+	return e.watchers.position()
 }
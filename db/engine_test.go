@@ -0,0 +1,249 @@
+package db
+
+import (
+	"akita/consts"
+	akerrors "akita/errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openTestEngine(t *testing.T) *Engine {
+	e := &Engine{
+		db:            openTestDB(t),
+		notifiers:     make(map[string]chan struct{}),
+		useCache:      true,
+		cache:         newHashTableLRUCache(100),
+		watchers:      newWatchers(),
+		syncBatchSize: defaultSyncBatchSize,
+		replicas:      newReplicaRegistry(nil),
+		acks:          make(chan struct{}, 1),
+		stop:          make(chan struct{}),
+	}
+	return e
+}
+
+func Test_AppendAndIncr(t *testing.T) {
+	e := openTestEngine(t)
+	defer e.db.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := e.Incr("counter", 2); err != nil {
+				t.Errorf("incr error: %s.\n", err)
+			}
+			if _, _, err := e.Append("log", []byte("a")); err != nil {
+				t.Errorf("append error: %s.\n", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n, _, err := e.Incr("counter", -1); err != nil || n != 99 {
+		t.Fatalf("incr counter: %d, %v, want 99", n, err)
+	}
+	if _, _, err := e.Incr("log", 1); err == nil {
+		t.Fatalf("incr a non integer value should fail")
+	}
+	if _, _, err := e.Append("log", make([]byte, consts.MaxValueSize)); err != akerrors.ErrValueSize {
+		t.Fatalf("append beyond the value size: %v, want %v", err, akerrors.ErrValueSize)
+	}
+
+	// values must be the same after reload
+	rd := OpenDB(e.db.dfPath)
+	if err := rd.Reload(); err != nil {
+		t.Fatalf("reload db error: %s.\n", err)
+	}
+	for key, want := range map[string]string{"counter": strconv.Itoa(99), "log": string(make([]byte, 50))} {
+		ri := rd.iTable.get(key)
+		value, err := rd.ReadRecord(ri.offset, ri.size)
+		if err != nil || (key == "counter" && string(value) != want) || len(value) != len(want) {
+			t.Fatalf("reload key %s: %q, %v", key, value, err)
+		}
+	}
+}
+
+func Test_Watch(t *testing.T) {
+	e := openTestEngine(t)
+	defer e.db.Close()
+
+	events, stop := e.Watch("img/")
+	e.Put("doc/1", []byte("x"))
+	e.Put("img/1", []byte("x"))
+	e.Delete("img/1")
+	var last uint64
+	for _, want := range []Event{{Type: EventPut, Key: "img/1"}, {Type: EventDelete, Key: "img/1"}} {
+		ev := <-events
+		if ev.Type != want.Type || ev.Key != want.Key || ev.Seq <= last {
+			t.Fatalf("watch event: %v, want %v after %d", *ev, want, last)
+		}
+		last = ev.Seq
+	}
+	stop()
+	if _, ok := <-events; ok {
+		t.Fatalf("events are not closed after stop")
+	}
+	stop()
+
+	// resume after the put
+	e.Put("img/2", []byte("x"))
+	events, stop, err := e.WatchFrom("img/", last-1)
+	if err != nil {
+		t.Fatalf("watch from %d: %v", last-1, err)
+	}
+	defer stop()
+	for _, key := range []string{"img/1", "img/2"} {
+		if ev := <-events; ev.Key != key {
+			t.Fatalf("resumed watch event: %v, want key %s", *ev, key)
+		}
+	}
+	if _, _, err := e.WatchFrom("img/", 1); err != akerrors.ErrWatchPositionLost {
+		t.Fatalf("watch from lost position: %v, want ErrWatchPositionLost", err)
+	}
+}
+
+func Test_Quota(t *testing.T) {
+	e := openTestEngine(t)
+	defer e.db.Close()
+
+	value := make([]byte, 100)
+	recordSize := int64(consts.LengthRecordHeader + consts.LengthLSN + len("img/1") + len(value) + consts.LengthCrc32)
+	if err := e.SetQuota("img", Quota{MaxObjects: 2, MaxBytes: 3 * recordSize}); err != nil {
+		t.Fatalf("set quota error: %s.\n", err)
+	}
+	for _, key := range []string{"img/1", "img/2"} {
+		if err := e.Put(key, value); err != nil {
+			t.Fatalf("put %s error: %s.\n", key, err)
+		}
+	}
+	if err := e.Put("img/3", value); err != akerrors.ErrQuotaExceeded {
+		t.Fatalf("put over object quota: %v, want ErrQuotaExceeded", err)
+	}
+	if _, err := e.BatchInsert([]string{"doc/1", "img/3"}, [][]byte{value, value}); err != akerrors.ErrQuotaExceeded {
+		t.Fatalf("batch insert over object quota: %v, want ErrQuotaExceeded", err)
+	}
+	if err := e.Put("img/1", make([]byte, 300)); err != akerrors.ErrQuotaExceeded {
+		t.Fatalf("overwrite over byte quota: %v, want ErrQuotaExceeded", err)
+	}
+	if err := e.Put("img/1", value[:50]); err != nil {
+		t.Fatalf("overwrite within quota error: %s.\n", err)
+	}
+	if err := e.Put("doc/1", value); err != nil {
+		t.Fatalf("put to namespace without quota error: %s.\n", err)
+	}
+	if _, _, _, err := e.Delete("img/2"); err != nil {
+		t.Fatalf("delete error: %s.\n", err)
+	}
+	if err := e.Put("img/3", value); err != nil {
+		t.Fatalf("put after delete error: %s.\n", err)
+	}
+
+	want := Usage{Objects: 2, Bytes: 2*recordSize - 50}
+	if u := e.Usage("img"); u != want {
+		t.Fatalf("usage %+v, want %+v", u, want)
+	}
+	// usage is rebuilt from the data file
+	e.db.iTable = newIndexTable()
+	e.db.blobs = newBlobTable()
+	if err := e.db.Reload(); err != nil {
+		t.Fatalf("reload error: %s.\n", err)
+	}
+	if u := e.Usage("img"); u != want {
+		t.Fatalf("usage after reload %+v, want %+v", u, want)
+	}
+	if quotas, err := e.Quotas(); err != nil || len(quotas) != 1 || *quotas["img"] != (Quota{MaxObjects: 2, MaxBytes: 3 * recordSize}) {
+		t.Fatalf("quotas %v %v", quotas, err)
+	}
+}
+
+func Test_Attrs(t *testing.T) {
+	for _, dedup := range []bool{false, true} {
+		e := openTestEngine(t)
+		e.useDedup = dedup
+		if _, err := e.PutWithAttrs("img/1", []byte("x"), 0, Attrs{"type": "png"}); err != nil {
+			t.Fatalf("put with attrs error: %s.\n", err)
+		}
+		if attrs, err := e.Attrs("img/1"); err != nil || attrs["type"] != "png" {
+			t.Fatalf("dedup: %v, attrs: %v, %v", dedup, attrs, err)
+		}
+		if _, _, err := e.Expire("img/1", time.Now().Unix()+60); err != nil {
+			t.Fatalf("expire error: %s.\n", err)
+		}
+		if attrs, err := e.Attrs("img/1"); err != nil || attrs["type"] != "png" || e.TTL(attrsKey("img/1")) <= 0 {
+			t.Fatalf("dedup: %v, attrs after expire: %v, %v", dedup, attrs, err)
+		}
+		// a write without attributes drops them, and so does a delete
+		if err := e.Put("img/1", []byte("y")); err != nil {
+			t.Fatalf("put error: %s.\n", err)
+		}
+		if attrs, err := e.Attrs("img/1"); err != nil || attrs != nil || e.Exists(attrsKey("img/1")) {
+			t.Fatalf("dedup: %v, attrs after put: %v, %v", dedup, attrs, err)
+		}
+		if _, err := e.PutWithAttrs("img/2", []byte("x"), 0, Attrs{"type": "gif"}); err != nil {
+			t.Fatalf("put with attrs error: %s.\n", err)
+		}
+		if _, _, _, err := e.Delete("img/2"); err != nil {
+			t.Fatalf("delete error: %s.\n", err)
+		}
+		if e.Exists(attrsKey("img/2")) {
+			t.Fatalf("dedup: %v, attrs should be deleted with the key", dedup)
+		}
+
+		// the data file holds the same after reload
+		if _, err := e.PutWithAttrs("img/3", []byte("x"), 0, Attrs{"type": "jpeg"}); err != nil {
+			t.Fatalf("put with attrs error: %s.\n", err)
+		}
+		rd := OpenDB(e.db.dfPath)
+		if err := rd.Reload(); err != nil {
+			t.Fatalf("reload db error: %s.\n", err)
+		}
+		for key, live := range map[string]bool{attrsKey("img/1"): false, attrsKey("img/2"): false, attrsKey("img/3"): true} {
+			if (rd.iTable.get(key) != nil) != live {
+				t.Fatalf("dedup: %v, reloaded %s should be live: %v", dedup, key, live)
+			}
+		}
+		e.db.Close()
+	}
+}
+
+func Test_Version(t *testing.T) {
+	e := openTestEngine(t)
+	if err := e.Put("v/1", []byte("a")); err != nil {
+		t.Fatalf("put error: %s.\n", err)
+	}
+	if err := e.Put("v/2", []byte("b")); err != nil {
+		t.Fatalf("put error: %s.\n", err)
+	}
+	v1, ok := e.Version("v/1")
+	if !ok || v1 != uint64(e.db.LSN()-1) {
+		t.Fatalf("version of v/1 want lsn %d, got %d %v.\n", e.db.LSN()-1, v1, ok)
+	}
+	if _, _, _, err := e.Delete("v/2"); err != nil {
+		t.Fatalf("delete error: %s.\n", err)
+	}
+	e.db.Close()
+
+	// compaction moves records, their versions stay
+	d := OpenDB(e.db.dfPath)
+	if err := d.Reload(); err != nil {
+		t.Fatalf("reload error: %s.\n", err)
+	}
+	if err := d.Compact(); err != nil {
+		t.Fatalf("compact error: %s.\n", err)
+	}
+	go d.WriteRecordBuffQueueData()
+	defer d.Close()
+	e.db = d
+	if v, ok := e.Version("v/1"); !ok || v != v1 {
+		t.Fatalf("version of v/1 after compaction want %d, got %d %v.\n", v1, v, ok)
+	}
+	if err := e.Put("v/1", []byte("a")); err != nil {
+		t.Fatalf("put error: %s.\n", err)
+	}
+	if v, _ := e.Version("v/1"); v == v1 {
+		t.Fatalf("version of v/1 is not changed by a write.\n")
+	}
+}
@@ -7,7 +7,7 @@ import (
 type (
 	keyExpire struct {
 		key     string
-		seconds int64 // key expires after seconds
+		seconds int64 // unix time in seconds the key expires at
 	}
 	// keyExpireHeap represents the small top heap of expired keys
 	keyExpireHeap struct {
@@ -41,6 +41,8 @@ func (h *keyExpireHeap) pop() *keyExpire {
 	}
 	top := h.keyExpires[0]
 	h.size--
+	tail := h.keyExpires[h.size]
+	h.keyExpires[h.size] = nil
 
 	// dynamic expansion
 	if h.size <= len(h.keyExpires)/2 && h.size > h.cap {
@@ -51,7 +53,6 @@ func (h *keyExpireHeap) pop() *keyExpire {
 		h.keyExpires = nks
 	}
 
-	tail := h.keyExpires[h.size]
 	i := 0
 	for i*2+1 < h.size {
 		// child node index
@@ -69,6 +70,16 @@ func (h *keyExpireHeap) pop() *keyExpire {
 	return top
 }
 
+// peek return the top node without removing it
+func (h *keyExpireHeap) peek() *keyExpire {
+	h.Lock()
+	defer h.Unlock()
+	if h.size == 0 {
+		return nil
+	}
+	return h.keyExpires[0]
+}
+
 func (h *keyExpireHeap) push(k *keyExpire) {
 	h.Lock()
 	defer h.Unlock()
@@ -0,0 +1,51 @@
+package db
+
+import (
+	"akita/common"
+	"sort"
+	"sync"
+)
+
+const keyLockStripes = 64
+
+// keyLocks serializes writes of the same key, keys are striped over a fixed number of mutexes.
+type keyLocks struct {
+	stripes [keyLockStripes]sync.Mutex
+}
+
+func (kl *keyLocks) stripe(key string) int {
+	h := common.HashCode(key) % keyLockStripes
+	if h < 0 {
+		h += keyLockStripes
+	}
+	return h
+}
+
+func (kl *keyLocks) lock(key string) {
+	kl.stripes[kl.stripe(key)].Lock()
+}
+
+func (kl *keyLocks) unlock(key string) {
+	kl.stripes[kl.stripe(key)].Unlock()
+}
+
+// lockAll lock every key in stripe order to avoid deadlock, return the func unlocking them.
+func (kl *keyLocks) lockAll(keys []string) func() {
+	seen := make(map[int]bool)
+	stripes := make([]int, 0, len(keys))
+	for _, key := range keys {
+		if s := kl.stripe(key); !seen[s] {
+			seen[s] = true
+			stripes = append(stripes, s)
+		}
+	}
+	sort.Ints(stripes)
+	for _, s := range stripes {
+		kl.stripes[s].Lock()
+	}
+	return func() {
+		for _, s := range stripes {
+			kl.stripes[s].Unlock()
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package db
+
+import (
+	"akita/common"
+	"akita/consts"
+	akerrors "akita/errors"
+	"akita/logger"
+	"os"
+	"sort"
+)
+
+// lsnIndexInterval is the number of lsns between two positions of the lsn index.
+const lsnIndexInterval = 256
+
+// lsnPosition is the data file offset of the record of an lsn.
+type lsnPosition struct {
+	lsn    int64
+	offset int64
+}
+
+// LSN return the log sequence number of the last record written to the data file, a slave returns
+// the lsn of the last record it applied. Every record gets the next lsn, so replication does not
+// depend on where records are in the data file.
+func (db *DB) LSN() int64 {
+	db.Lock()
+	defer db.Unlock()
+	return db.lsn
+}
+
+// noteLSNs note the lsns of the records of buf written at offset, caller must hold the db lock.
+func (db *DB) noteLSNs(offset int64, buf []byte) error {
+	for pos := int64(0); pos < int64(len(buf)); {
+		header, rs, err := parseHeader(buf[pos:])
+		if err != nil {
+			return err
+		}
+		if header.lsn != 0 {
+			db.noteLSN(header.lsn, offset+pos, header.Flag&consts.FlagTypeMask == consts.FlagCheckpoint)
+		}
+		pos += rs
+	}
+	return nil
+}
+
+// noteLSN note the record of lsn is at offset, caller must hold the db lock.
+func (db *DB) noteLSN(lsn int64, offset int64, checkpoint bool) {
+	if checkpoint {
+		// records up to a checkpoint are compacted, slaves continue right after it
+		db.baseLSN = lsn
+		db.lsnIndex = append(db.lsnIndex, lsnPosition{lsn: lsn, offset: offset})
+	}
+	if lsn <= db.lsn {
+		return
+	}
+	if n := len(db.lsnIndex); n == 0 || lsn >= db.lsnIndex[n-1].lsn+lsnIndexInterval {
+		db.lsnIndex = append(db.lsnIndex, lsnPosition{lsn: lsn, offset: offset})
+	}
+	db.lsn = lsn
+}
+
+// GetDataAfter get the records after lsn from the data file, at most limit bytes of whole records unless the first
+// record is larger, and whether more records follow. It fails with ErrNoDataUpdate if there are none,
+// and with ErrSyncPositionLost if they are not kept one by one anymore, because the data file was compacted
+// after lsn, or lsn is ahead of the data file. Records written before lsns are sent to slaves at lsn 0 in batches
+// too, from legacyOffset, the bytes of them the slave has written already.
+func (db *DB) GetDataAfter(lsn int64, legacyOffset int64, limit int64) ([]byte, bool, error) {
+	db.Lock()
+	size, last, base := db.size, db.lsn, db.baseLSN
+	var start int64
+	if i := sort.Search(len(db.lsnIndex), func(i int) bool { return db.lsnIndex[i].lsn > lsn }); i > 0 {
+		start = db.lsnIndex[i-1].offset
+	}
+	first := db.firstLSNOffset()
+	db.Unlock()
+	if err := checkSyncPosition(lsn, base, last); err != nil {
+		return nil, false, err
+	}
+	if err := checkLegacyOffset(lsn, legacyOffset, first); err != nil {
+		return nil, false, err
+	}
+
+	dbFile, err := os.OpenFile(db.dfPath, os.O_RDONLY, 0644)
+	if err != nil {
+		return nil, false, err
+	}
+	defer dbFile.Close()
+	if lsn > 0 {
+		if start, err = seekLSN(dbFile, start, size, lsn); err != nil {
+			return nil, false, err
+		}
+	} else {
+		// a slave still at lsn 0 continues after the records without lsns it has written
+		start = legacyOffset
+	}
+	data, err := readRecords(dbFile, start, size, limit)
+	if err != nil {
+		return nil, false, err
+	}
+	return data, start+int64(len(data)) < size, nil
+}
+
+// bytesAfter return the size of the records after lsn, all of the data file if they are not kept one by one anymore.
+func (db *DB) bytesAfter(lsn int64) int64 {
+	db.Lock()
+	size, last, base := db.size, db.lsn, db.baseLSN
+	var start int64
+	if i := sort.Search(len(db.lsnIndex), func(i int) bool { return db.lsnIndex[i].lsn > lsn }); i > 0 {
+		start = db.lsnIndex[i-1].offset
+	}
+	db.Unlock()
+	switch checkSyncPosition(lsn, base, last) {
+	case akerrors.ErrNoDataUpdate:
+		return 0
+	case akerrors.ErrSyncPositionLost:
+		return size
+	}
+	if lsn == 0 {
+		return size
+	}
+	dbFile, err := os.OpenFile(db.dfPath, os.O_RDONLY, 0644)
+	if err != nil {
+		return size - start
+	}
+	defer dbFile.Close()
+	if start, err = seekLSN(dbFile, start, size, lsn); err != nil {
+		logger.Errorf("seek lsn %d error: %v", lsn, err)
+	}
+	return size - start
+}
+
+// readRecords read the whole records from offset of a data file of size bytes, at most limit bytes unless
+// the first record alone is larger.
+func readRecords(dbFile *os.File, offset int64, size int64, limit int64) ([]byte, error) {
+	n := size - offset
+	if n > limit {
+		n = limit
+	}
+	data, err := common.ReadFileToBytes(dbFile, offset, n)
+	if err != nil {
+		return nil, err
+	}
+	end := recordsEnd(data)
+	if end == 0 {
+		_, rs, err := readHeader(dbFile, offset, size)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = common.ReadFileToBytes(dbFile, offset, rs); err != nil {
+			return nil, err
+		}
+		end = rs
+	}
+	return data[:end], nil
+}
+
+// CheckSyncPosition check a slave at lsn, which has written legacyOffset bytes of the records without lsns if lsn
+// is 0, can get the records after it, it fails with ErrSyncPositionLost if they are not kept one by one anymore.
+func (db *DB) CheckSyncPosition(lsn int64, legacyOffset int64) error {
+	db.Lock()
+	base, last, first := db.baseLSN, db.lsn, db.firstLSNOffset()
+	db.Unlock()
+	if err := checkSyncPosition(lsn, base, last); err == akerrors.ErrSyncPositionLost {
+		return err
+	}
+	return checkLegacyOffset(lsn, legacyOffset, first)
+}
+
+// checkSyncPosition check the records after lsn are kept one by one in a data file of records from base to last.
+func checkSyncPosition(lsn int64, base int64, last int64) error {
+	switch {
+	case lsn < base || lsn > last:
+		return akerrors.ErrSyncPositionLost
+	case lsn == last:
+		return akerrors.ErrNoDataUpdate
+	}
+	return nil
+}
+
+// firstLSNOffset return the offset of the first record which has an lsn, the records before it have none.
+// Caller must hold the db lock.
+func (db *DB) firstLSNOffset() int64 {
+	if len(db.lsnIndex) == 0 {
+		return db.size
+	}
+	return db.lsnIndex[0].offset
+}
+
+// checkLegacyOffset check a slave at lsn 0 which has written legacyOffset bytes of the records without lsns,
+// which end at first, continues within them.
+func checkLegacyOffset(lsn int64, legacyOffset int64, first int64) error {
+	if lsn == 0 && (legacyOffset < 0 || legacyOffset > first) {
+		return akerrors.ErrSyncPositionLost
+	}
+	return nil
+}
+
+// lastLSN return the lsn of the last record of buf which has one.
+func lastLSN(buf []byte) int64 {
+	var lsn int64
+	for pos := int64(0); pos < int64(len(buf)); {
+		header, rs, err := parseHeader(buf[pos:])
+		if err != nil {
+			break
+		}
+		if header.lsn != 0 {
+			lsn = header.lsn
+		}
+		pos += rs
+	}
+	return lsn
+}
+
+// recordsEnd return the end of the last whole record of buf.
+func recordsEnd(buf []byte) int64 {
+	var end int64
+	for end < int64(len(buf)) {
+		_, rs, err := parseHeader(buf[end:])
+		if err != nil || end+rs > int64(len(buf)) {
+			break
+		}
+		end += rs
+	}
+	return end
+}
+
+// seekLSN return the offset of the first record after lsn, scanning the records from offset to size.
+func seekLSN(dbFile *os.File, offset int64, size int64, lsn int64) (int64, error) {
+	for offset < size {
+		header, rs, err := readHeader(dbFile, offset, size)
+		if err != nil {
+			return 0, err
+		}
+		if header.lsn > lsn {
+			break
+		}
+		offset += rs
+	}
+	return offset, nil
+}
+
+// readHeader read the header of the record at offset of a data file of size bytes, and return it
+// with the size of the record.
+func readHeader(dbFile *os.File, offset int64, size int64) (*DataHeader, int64, error) {
+	n := int64(consts.LengthRecordHeader + consts.LengthLSN)
+	if size-offset < n {
+		n = size - offset
+	}
+	headerBuf, err := common.ReadFileToBytes(dbFile, offset, n)
+	if err != nil {
+		return nil, 0, err
+	}
+	return parseHeader(headerBuf)
+}
@@ -0,0 +1,200 @@
+package db
+
+import (
+	"akita/consts"
+	akerrors "akita/errors"
+	"strconv"
+	"testing"
+)
+
+func Test_LSN(t *testing.T) {
+	master, slave := openTestDB(t), openTestDB(t)
+	defer slave.Close()
+
+	keys, values := make([][]byte, 300), make([][]byte, 300)
+	for i := range keys {
+		keys[i], values[i] = []byte("lsn"+strconv.Itoa(i)), []byte{byte(i)}
+	}
+	if err := master.WriteBatch(keys, values, 0, false); err != nil {
+		t.Fatalf("write batch error: %s.\n", err)
+	}
+	if err := master.WriteRecordNoCrc32(&DataRecord{header: &DataHeader{Ks: 4, Flag: consts.FlagDelete}, key: []byte("lsn0")}); err != nil {
+		t.Fatalf("write delete record error: %s.\n", err)
+	}
+	master.removeIndex("lsn0")
+	if lsn := master.LSN(); lsn != 301 {
+		t.Fatalf("lsn after 301 records is %d.\n", lsn)
+	}
+
+	sync := func() {
+		t.Helper()
+		data, more, err := master.GetDataAfter(slave.LSN(), 0, consts.M)
+		if err != nil || more {
+			t.Fatalf("get data after lsn %d error: %v, more %t.\n", slave.LSN(), err, more)
+		}
+		if _, err := slave.WriteSyncData(data); err != nil {
+			t.Fatalf("write sync data error: %s.\n", err)
+		}
+		if slave.LSN() != master.LSN() {
+			t.Fatalf("slave lsn %d, master lsn %d.\n", slave.LSN(), master.LSN())
+		}
+	}
+	sync()
+	if slave.iTable.get("lsn0") != nil || slave.iTable.get("lsn299") == nil {
+		t.Fatalf("slave index does not match the master one")
+	}
+	if err := master.WriteRecord(&DataRecord{header: &DataHeader{Ks: 4, Vs: 1, Flag: consts.FlagWrite}, key: []byte("lsn0"), value: []byte{1}}); err != nil {
+		t.Fatalf("write record error: %s.\n", err)
+	}
+	sync()
+	if slave.iTable.get("lsn0") == nil {
+		t.Fatalf("key written again is not synced")
+	}
+
+	// records after an lsn are found through the lsn index
+	data, _, err := master.GetDataAfter(257, 0, consts.M)
+	if err != nil {
+		t.Fatalf("get data after lsn 257 error: %s.\n", err)
+	}
+	if header, _, err := parseHeader(data); err != nil || header.lsn != 258 {
+		t.Fatalf("first record after lsn 257 is %+v, %v.\n", header, err)
+	}
+	if _, _, err := master.GetDataAfter(302, 0, consts.M); err != akerrors.ErrNoDataUpdate {
+		t.Fatalf("get data after the last lsn error %v.\n", err)
+	}
+	if _, _, err := master.GetDataAfter(400, 0, consts.M); err != akerrors.ErrSyncPositionLost {
+		t.Fatalf("get data after an lsn ahead of master error %v.\n", err)
+	}
+	master.Close()
+
+	// compaction keeps the lsn, but slaves behind it are lost
+	compacted := OpenDB(master.dfPath)
+	if err := compacted.Reload(); err != nil {
+		t.Fatalf("reload error: %s.\n", err)
+	}
+	if lsn := compacted.LSN(); lsn != 302 {
+		t.Fatalf("lsn after reload is %d.\n", lsn)
+	}
+	if err := compacted.Compact(); err != nil {
+		t.Fatalf("compact error: %s.\n", err)
+	}
+	go compacted.WriteRecordBuffQueueData()
+	defer compacted.Close()
+	if _, _, err := compacted.GetDataAfter(100, 0, consts.M); err != akerrors.ErrSyncPositionLost {
+		t.Fatalf("get data after a compacted lsn error %v.\n", err)
+	}
+	if err := compacted.WriteRecord(&DataRecord{header: &DataHeader{Ks: 4, Vs: 1, Flag: consts.FlagWrite}, key: []byte("lsn1"), value: []byte{2}}); err != nil {
+		t.Fatalf("write record error: %s.\n", err)
+	}
+	master = compacted
+	sync()
+	if lsn := slave.LSN(); lsn != 303 {
+		t.Fatalf("slave lsn after compaction is %d.\n", lsn)
+	}
+}
+
+func Test_LSNBatches(t *testing.T) {
+	master, slave := openTestDB(t), openTestDB(t)
+	defer master.Close()
+	defer slave.Close()
+
+	for i := 0; i < 10; i++ {
+		key := []byte("batch" + strconv.Itoa(i))
+		if err := master.WriteRecord(&DataRecord{header: &DataHeader{Ks: int32(len(key)), Vs: 16, Flag: consts.FlagWrite}, key: key, value: make([]byte, 16)}); err != nil {
+			t.Fatalf("write record error: %s.\n", err)
+		}
+	}
+	large := make([]byte, 1024)
+	if err := master.WriteRecord(&DataRecord{header: &DataHeader{Ks: 5, Vs: int32(len(large)), Flag: consts.FlagWrite}, key: []byte("large"), value: large}); err != nil {
+		t.Fatalf("write record error: %s.\n", err)
+	}
+
+	// batches hold whole records only, a record larger than the limit comes alone
+	for batches, more := 0, true; more; batches++ {
+		var data []byte
+		var err error
+		data, more, err = master.GetDataAfter(slave.LSN(), 0, 100)
+		if err != nil {
+			t.Fatalf("get data after lsn %d error: %s.\n", slave.LSN(), err)
+		}
+		if len(data) > 100 && (recordsEnd(data[:100]) != 0 || more) {
+			t.Fatalf("batch of %d bytes is over the limit.\n", len(data))
+		}
+		if _, err := slave.WriteSyncData(data); err != nil {
+			t.Fatalf("write sync data error: %s.\n", err)
+		}
+		if batches > 11 {
+			t.Fatalf("slave is not caught up after %d batches.\n", batches)
+		}
+	}
+	if slave.LSN() != master.LSN() || slave.iTable.get("large") == nil {
+		t.Fatalf("slave lsn %d, master lsn %d.\n", slave.LSN(), master.LSN())
+	}
+}
+
+func Test_LegacyBatches(t *testing.T) {
+	master, slave := openTestDB(t), openTestDB(t)
+	defer master.Close()
+	defer slave.Close()
+
+	// records written before lsns have none
+	for i := 0; i < 20; i++ {
+		key := []byte("legacy" + strconv.Itoa(i))
+		buf, err := master.genRecordBuf(&DataRecord{header: &DataHeader{Ks: int32(len(key)), Vs: 32, Flag: consts.FlagWrite}, key: key, value: make([]byte, 32)}, true)
+		if err != nil {
+			t.Fatalf("gen record error: %s.\n", err)
+		}
+		if _, err := master.WriteSyncData(append([]byte(nil), buf...)); err != nil {
+			t.Fatalf("write legacy record error: %s.\n", err)
+		}
+	}
+	legacySize := master.GetSyncSize()
+	if err := master.WriteRecord(&DataRecord{header: &DataHeader{Ks: 3, Vs: 1, Flag: consts.FlagWrite}, key: []byte("new"), value: []byte{1}}); err != nil {
+		t.Fatalf("write record error: %s.\n", err)
+	}
+
+	// the records without lsns are larger than a batch, they are sent in batches too
+	const limit = 200
+	if legacySize <= limit {
+		t.Fatalf("legacy records of %d bytes fit in a batch.\n", legacySize)
+	}
+	var batches int
+	for more := true; more; batches++ {
+		var legacyOffset int64
+		if slave.LSN() == 0 {
+			legacyOffset = slave.GetSyncSize()
+		}
+		data, m, err := master.GetDataAfter(slave.LSN(), legacyOffset, limit)
+		if err != nil {
+			t.Fatalf("get data after lsn %d offset %d error: %s.\n", slave.LSN(), legacyOffset, err)
+		}
+		if len(data) > limit {
+			t.Fatalf("batch of %d bytes is over the limit.\n", len(data))
+		}
+		if _, err := slave.WriteSyncData(data); err != nil {
+			t.Fatalf("write sync data error: %s.\n", err)
+		}
+		if more = m; batches > 20 {
+			t.Fatalf("slave is not caught up after %d batches.\n", batches)
+		}
+	}
+	if batches < 2 {
+		t.Fatalf("legacy records are sent in %d batch.\n", batches)
+	}
+	if slave.LSN() != master.LSN() || slave.GetSyncSize() != master.GetSyncSize() {
+		t.Fatalf("slave lsn %d size %d, master lsn %d size %d.\n", slave.LSN(), slave.GetSyncSize(), master.LSN(), master.GetSyncSize())
+	}
+	for i := 0; i < 20; i++ {
+		if slave.iTable.get("legacy"+strconv.Itoa(i)) == nil {
+			t.Fatalf("legacy%d is not synced.\n", i)
+		}
+	}
+
+	// a slave at lsn 0 with more records than the master has without lsns is not a copy of it
+	if _, _, err := master.GetDataAfter(0, legacySize+1, limit); err != akerrors.ErrSyncPositionLost {
+		t.Fatalf("get data after an offset beyond the legacy records error %v.\n", err)
+	}
+	if err := master.CheckSyncPosition(0, legacySize+1); err != akerrors.ErrSyncPositionLost {
+		t.Fatalf("check sync position beyond the legacy records error %v.\n", err)
+	}
+}
@@ -0,0 +1,18 @@
+package db
+
+import "strings"
+
+const (
+	// NamespaceSeparator separates the namespace of a key from the rest of it
+	NamespaceSeparator = "/"
+	// DefaultNamespace is the namespace of keys without separator
+	DefaultNamespace = "default"
+)
+
+// Namespace return the namespace of key, that is the part before the first separator.
+func Namespace(key string) string {
+	if i := strings.Index(key, NamespaceSeparator); i > 0 {
+		return key[:i]
+	}
+	return DefaultNamespace
+}
@@ -0,0 +1,151 @@
+package db
+
+import (
+	"akita/consts"
+	akerrors "akita/errors"
+	"encoding/json"
+	"strings"
+)
+
+const (
+	// QuotasNamespace stores the quota of namespace ns as key _quotas/ns, so quotas replicate to slaves
+	QuotasNamespace = "_quotas"
+)
+
+// Quota caps the usage of a namespace, a zero limit is unlimited.
+type Quota struct {
+	MaxBytes   int64 `json:"max_bytes"`
+	MaxObjects int64 `json:"max_objects"`
+}
+
+// Usage of a namespace, Bytes are the data file bytes of the records of its keys.
+// A deduplicated value counts as the reference of each key, not as its blob.
+type Usage struct {
+	Objects int64 `json:"objects"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// reserved report whether namespace is used by akita itself, such namespaces have no quota.
+func reserved(namespace string) bool {
+	return strings.HasPrefix(namespace, "_")
+}
+
+func quotaKey(namespace string) string {
+	return QuotasNamespace + NamespaceSeparator + namespace
+}
+
+// SetQuota set the quota of namespace, a zero quota removes it.
+func (e *Engine) SetQuota(namespace string, q Quota) error {
+	if q.MaxBytes <= 0 && q.MaxObjects <= 0 {
+		_, _, _, err := e.Delete(quotaKey(namespace))
+		return err
+	}
+	value, err := json.Marshal(&q)
+	if err != nil {
+		return err
+	}
+	return e.Put(quotaKey(namespace), value)
+}
+
+// Quota return the quota of namespace, nil if it has none.
+func (e *Engine) Quota(namespace string) (*Quota, error) {
+	if reserved(namespace) {
+		return nil, nil
+	}
+	value, err := e.Seek(quotaKey(namespace))
+	if err != nil || value == nil {
+		return nil, err
+	}
+	q := &Quota{}
+	if err := json.Unmarshal(value, q); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// Quotas return the quotas of all namespaces which have one.
+func (e *Engine) Quotas() (map[string]*Quota, error) {
+	prefix := QuotasNamespace + NamespaceSeparator
+	quotas := make(map[string]*Quota)
+	for more, after := true, ""; more; {
+		var keys []string
+		keys, more = e.List(prefix, after, 1000)
+		for _, key := range keys {
+			namespace := strings.TrimPrefix(key, prefix)
+			q, err := e.Quota(namespace)
+			if err != nil {
+				return nil, err
+			}
+			if q != nil {
+				quotas[namespace] = q
+			}
+			after = key
+		}
+	}
+	return quotas, nil
+}
+
+// Usage return the usage of namespace.
+func (e *Engine) Usage(namespace string) Usage {
+	return e.db.iTable.usageOf(namespace)
+}
+
+// Usages return the usage of every namespace which has keys.
+func (e *Engine) Usages() map[string]Usage {
+	return e.db.iTable.usages()
+}
+
+// checkQuota check that writing values of sizes to keys keeps their namespaces within quota,
+// deduplicated values are written as references.
+// Callers must hold the key locks, concurrent writes of other keys may still exceed a quota slightly.
+func (e *Engine) checkQuota(keys []string, sizes ...int) error {
+	type delta struct {
+		objects int64
+		bytes   int64
+	}
+	deltas := make(map[string]*delta)
+	for i, key := range keys {
+		namespace := Namespace(key)
+		if reserved(namespace) {
+			continue
+		}
+		d, ok := deltas[namespace]
+		if !ok {
+			d = &delta{}
+			deltas[namespace] = d
+		}
+		size := sizes[i]
+		if e.useDedup {
+			size = consts.LengthDigest
+		}
+		// values may be compressed, the uncompressed record size bounds the bytes written
+		d.bytes += int64(consts.LengthRecordHeader + consts.LengthLSN + len(key) + size + consts.LengthCrc32)
+		if ri := e.db.iTable.get(key); ri != nil {
+			d.bytes -= ri.size
+		} else {
+			d.objects++
+		}
+	}
+	for namespace, d := range deltas {
+		if err := e.CheckQuota(namespace, d.objects, d.bytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckQuota check that adding objects keys and bytes to namespace keeps it within quota, it fails with
+// ErrQuotaExceeded if not. Values staged outside the namespace they end up in, such as the parts of
+// multipart uploads, are charged to that namespace with it.
+func (e *Engine) CheckQuota(namespace string, objects int64, bytes int64) error {
+	q, err := e.Quota(namespace)
+	if err != nil || q == nil {
+		return err
+	}
+	usage := e.Usage(namespace)
+	if (q.MaxObjects > 0 && objects > 0 && usage.Objects+objects > q.MaxObjects) ||
+		(q.MaxBytes > 0 && bytes > 0 && usage.Bytes+bytes > q.MaxBytes) {
+		return akerrors.ErrQuotaExceeded
+	}
+	return nil
+}
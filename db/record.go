@@ -1,11 +1,18 @@
 package db
 
+import (
+	"akita/common"
+	"akita/consts"
+	akerrors "akita/errors"
+)
+
 type (
 	DataHeader struct {
 		Ks       int32 // key size
 		Vs       int32 // value size
 		Flag     int32 // flag of record type
 		expireAt int64 // mark expire time
+		lsn      int64 // log sequence number, 0 for records written before lsns
 	}
 
 	DataRecord struct {
@@ -14,3 +21,59 @@ type (
 		value  []byte // value bytes
 	}
 )
+
+func newRefRecord(key []byte, digest []byte) *DataRecord {
+	return &DataRecord{
+		header: &DataHeader{
+			Ks:   int32(len(key)),
+			Vs:   int32(len(digest)),
+			Flag: consts.FlagRef,
+		},
+		key:   key,
+		value: digest,
+	}
+}
+
+// headerSize return the size of the header of a record with flag, the lsn of a record follows the fixed header.
+func headerSize(flag int32) int64 {
+	if flag&consts.FlagLSN != 0 {
+		return consts.LengthRecordHeader + consts.LengthLSN
+	}
+	return consts.LengthRecordHeader
+}
+
+// parseHeader decode the header of the record at the start of buf, which must hold the header at least,
+// and return it with the size of the whole record. Delete records have no crc32.
+func parseHeader(buf []byte) (*DataHeader, int64, error) {
+	if len(buf) < consts.LengthRecordHeader {
+		return nil, 0, akerrors.ErrRecordTruncated
+	}
+	header := &DataHeader{}
+	var err error
+	if header.Ks, err = common.ByteSliceToInt32(buf[0:consts.LengthKs]); err != nil {
+		return nil, 0, err
+	}
+	if header.Vs, err = common.ByteSliceToInt32(buf[consts.LengthKs:consts.LengthKVs]); err != nil {
+		return nil, 0, err
+	}
+	if header.Flag, err = common.ByteSliceToInt32(buf[consts.LengthKVs:(consts.LengthKVs + consts.LengthFlag)]); err != nil {
+		return nil, 0, err
+	}
+	if header.expireAt, err = common.ByteSliceToInt64(buf[(consts.LengthKVs + consts.LengthFlag):consts.LengthRecordHeader]); err != nil {
+		return nil, 0, err
+	}
+	hs := headerSize(header.Flag)
+	if header.Flag&consts.FlagLSN != 0 {
+		if int64(len(buf)) < hs {
+			return nil, 0, akerrors.ErrRecordTruncated
+		}
+		if header.lsn, err = common.ByteSliceToInt64(buf[consts.LengthRecordHeader:hs]); err != nil {
+			return nil, 0, err
+		}
+	}
+	size := hs + int64(header.Ks) + int64(header.Vs)
+	if header.Flag&consts.FlagTypeMask != consts.FlagDelete {
+		size += consts.LengthCrc32
+	}
+	return header, size, nil
+}
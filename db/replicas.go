@@ -0,0 +1,180 @@
+package db
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// writeSampleInterval is the resolution of replica time lag, writes are sampled at most this often
+	writeSampleInterval = time.Second
+	// maxWriteSamples bounds the samples kept, a replica behind all of them lags at least the age of the oldest
+	maxWriteSamples = 3600
+)
+
+// Replica is a slave the master knows of.
+type Replica struct {
+	NodeID      string  `json:"node_id"`
+	Addr        string  `json:"addr"`         // ip the slave syncs from
+	AckLSN      int64   `json:"ack_lsn"`      // lsn of the last record the slave acknowledged to have written
+	LagRecords  int64   `json:"lag_records"`  // records written after AckLSN
+	LagBytes    int64   `json:"lag_bytes"`    // size of the records written after AckLSN
+	LagSeconds  float64 `json:"lag_seconds"`  // age of the oldest record the slave has not acknowledged
+	LastContact int64   `json:"last_contact"` // unix time in seconds of the last request of the slave, 0 if never
+	Streaming   bool    `json:"streaming"`    // the slave has a sync stream open
+	Configured  bool    `json:"configured"`   // the slave is one of the slaves the master is started with
+}
+
+type (
+	replicaState struct {
+		addr        string
+		ack         int64
+		lastContact time.Time
+		streams     int
+	}
+
+	// writeSample is the lsn of the last record written within writeSampleInterval from a time.
+	writeSample struct {
+		lsn int64
+		at  time.Time
+	}
+
+	// replicaRegistry keeps track of the slaves syncing from the master by their node ids.
+	replicaRegistry struct {
+		sync.Mutex
+		replicas   map[string]*replicaState
+		configured map[string]bool // ips of the slaves the master is started with
+		samples    []writeSample
+		acked      chan struct{} // closed and replaced on every acknowledgement
+	}
+)
+
+func newReplicaRegistry(slaves []string) *replicaRegistry {
+	rr := &replicaRegistry{
+		replicas:   make(map[string]*replicaState),
+		configured: make(map[string]bool),
+		acked:      make(chan struct{}),
+	}
+	for _, slave := range slaves {
+		if slave != "" {
+			rr.configured[slave] = true
+		}
+	}
+	return rr
+}
+
+// ack note the slave nodeID at addr has written the records up to lsn.
+func (rr *replicaRegistry) ack(nodeID string, addr string, lsn int64) {
+	rr.Lock()
+	defer rr.Unlock()
+	r, ok := rr.replicas[nodeID]
+	if !ok {
+		r = &replicaState{}
+		rr.replicas[nodeID] = r
+	}
+	r.addr, r.ack, r.lastContact = addr, lsn, time.Now()
+	close(rr.acked)
+	rr.acked = make(chan struct{})
+}
+
+// confirmed return the number of replicas which acknowledged the records up to lsn, and a channel
+// closed on the next acknowledgement.
+func (rr *replicaRegistry) confirmed(lsn int64) (int, <-chan struct{}) {
+	rr.Lock()
+	defer rr.Unlock()
+	n := 0
+	for _, r := range rr.replicas {
+		if r.ack >= lsn {
+			n++
+		}
+	}
+	return n, rr.acked
+}
+
+// streaming note a sync stream of the slave nodeID is opened, or closed if open is false.
+func (rr *replicaRegistry) streaming(nodeID string, open bool) {
+	rr.Lock()
+	defer rr.Unlock()
+	r, ok := rr.replicas[nodeID]
+	if !ok {
+		return
+	}
+	if open {
+		r.streams++
+	} else {
+		r.streams--
+	}
+}
+
+// noteWrite note the records up to lsn are written by now.
+func (rr *replicaRegistry) noteWrite(lsn int64) {
+	now := time.Now()
+	rr.Lock()
+	defer rr.Unlock()
+	if n := len(rr.samples); n > 0 && now.Sub(rr.samples[n-1].at) < writeSampleInterval {
+		// the records are counted as written at the time of the sample, lag is over by less than the interval
+		rr.samples[n-1].lsn = lsn
+		return
+	}
+	if len(rr.samples) == maxWriteSamples {
+		rr.samples = append(rr.samples[:0], rr.samples[1:]...)
+	}
+	rr.samples = append(rr.samples, writeSample{lsn: lsn, at: now})
+}
+
+// list return the replicas of nodes which synced since the master started and the configured slaves
+// which did not, with their lag behind the records up to lsn. bytesAfter returns the size of the records
+// after an lsn.
+func (rr *replicaRegistry) list(lsn int64, bytesAfter func(int64) int64) []*Replica {
+	rr.Lock()
+	now := time.Now()
+	seen := make(map[string]bool)
+	replicas := make([]*Replica, 0, len(rr.replicas)+len(rr.configured))
+	for nodeID, r := range rr.replicas {
+		replica := &Replica{
+			NodeID:      nodeID,
+			Addr:        r.addr,
+			AckLSN:      r.ack,
+			LastContact: r.lastContact.Unix(),
+			Streaming:   r.streams > 0,
+			Configured:  rr.configured[r.addr],
+		}
+		if r.ack < lsn {
+			replica.LagRecords = lsn - r.ack
+			replica.LagSeconds = now.Sub(rr.firstWriteAfter(r.ack)).Seconds()
+		}
+		seen[r.addr] = true
+		replicas = append(replicas, replica)
+	}
+	for addr := range rr.configured {
+		if !seen[addr] {
+			replicas = append(replicas, &Replica{Addr: addr, Configured: true})
+		}
+	}
+	rr.Unlock()
+
+	for _, replica := range replicas {
+		if replica.LagRecords > 0 {
+			replica.LagBytes = bytesAfter(replica.AckLSN)
+		}
+	}
+	sort.Slice(replicas, func(i, j int) bool {
+		if replicas[i].NodeID != replicas[j].NodeID {
+			return replicas[i].NodeID < replicas[j].NodeID
+		}
+		return replicas[i].Addr < replicas[j].Addr
+	})
+	return replicas
+}
+
+// firstWriteAfter return the time the first record after lsn was written at, to the sample interval,
+// caller must hold the lock.
+func (rr *replicaRegistry) firstWriteAfter(lsn int64) time.Time {
+	i := sort.Search(len(rr.samples), func(i int) bool { return rr.samples[i].lsn > lsn })
+	if i == len(rr.samples) {
+		// written after the last sample
+		return time.Now()
+	}
+	return rr.samples[i].at
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	akerrors "akita/errors"
+	"testing"
+	"time"
+)
+
+func Test_Replicas(t *testing.T) {
+	e := openTestEngine(t)
+	defer e.db.Close()
+	e.replicas = newReplicaRegistry([]string{"10.0.0.1", "10.0.0.2", ""})
+	putTestKeys(t, e, "replica", 0, 10)
+	e.AckSync("node1", "10.0.0.1", e.db.LSN())
+	e.AckSync("node3", "10.0.0.3", 4)
+	time.Sleep(10 * time.Millisecond)
+
+	replicas := e.Replicas()
+	if len(replicas) != 3 {
+		t.Fatalf("replicas are %d, want node1, node3 and the missing configured slave.\n", len(replicas))
+	}
+	missing, caughtUp, behind := replicas[0], replicas[1], replicas[2]
+	if missing.NodeID != "" || missing.Addr != "10.0.0.2" || !missing.Configured || missing.LastContact != 0 {
+		t.Fatalf("missing configured slave is %+v.\n", missing)
+	}
+	if caughtUp.NodeID != "node1" || !caughtUp.Configured || caughtUp.LagRecords != 0 || caughtUp.LagBytes != 0 || caughtUp.LagSeconds != 0 {
+		t.Fatalf("caught up replica is %+v.\n", caughtUp)
+	}
+	size := e.db.GetSyncSize()
+	if behind.NodeID != "node3" || behind.Configured || behind.LagRecords != 6 || behind.LagBytes != size*6/10 || behind.LagSeconds <= 0 {
+		t.Fatalf("replica behind is %+v, data file is %d bytes.\n", behind, size)
+	}
+
+	// acknowledging again catches up
+	e.AckSync("node3", "10.0.0.3", e.db.LSN())
+	if behind := e.Replicas()[2]; behind.LagRecords != 0 || behind.AckLSN != e.db.LSN() {
+		t.Fatalf("replica after ack is %+v.\n", behind)
+	}
+}
+
+func Test_WriteQuorum(t *testing.T) {
+	e := openTestEngine(t)
+	defer e.db.Close()
+	lsn, err := e.PutWithExpire("quorum0", []byte("0"), 0)
+	if err != nil {
+		t.Fatalf("put error: %s.\n", err)
+	}
+
+	e.UseWriteQuorum(1, 20*time.Millisecond, false)
+	if n, err := e.WaitQuorum(lsn); n != 0 || err != akerrors.ErrQuorumTimeout {
+		t.Fatalf("wait quorum without replicas: %d, %v.\n", n, err)
+	}
+	e.UseWriteQuorum(1, 20*time.Millisecond, true)
+	if n, err := e.WaitQuorum(lsn); n != 0 || err != nil {
+		t.Fatalf("wait quorum falling back to async: %d, %v.\n", n, err)
+	}
+
+	// a replica behind does not count until it acknowledges the write
+	e.AckSync("node1", "10.0.0.1", 0)
+	e.UseWriteQuorum(1, 5*time.Second, false)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		e.AckSync("node2", "10.0.0.2", lsn)
+	}()
+	if n, err := e.WaitQuorum(lsn); n != 1 || err != nil {
+		t.Fatalf("wait quorum with an acknowledging replica: %d, %v.\n", n, err)
+	}
+	// a write is confirmed by its own lsn, later writes are not waited for
+	putTestKeys(t, e, "quorum", 1, 3)
+	e.UseWriteQuorum(1, 20*time.Millisecond, false)
+	if n, err := e.WaitQuorum(lsn); n != 1 || err != nil {
+		t.Fatalf("wait quorum of an acknowledged write followed by others: %d, %v.\n", n, err)
+	}
+	if err := e.AckSync("node2", "10.0.0.2", e.db.LSN()+1); err != akerrors.ErrSyncPositionLost {
+		t.Fatalf("ack of a record the master did not write want ErrSyncPositionLost, got %v.\n", err)
+	}
+}
@@ -0,0 +1,226 @@
+package db
+
+import (
+	"akita/common"
+	"akita/consts"
+	"akita/logger"
+	"akita/pb"
+	"os"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// Snapshot send a point-in-time copy of the live records through send, in batches of whole records of at most
+// limit bytes unless a record alone is larger, more is false on the last one. The copy holds the records up to
+// the last record written when it starts and ends with a checkpoint of its lsn, so a slave which writes it to an
+// empty data file syncs the records after it from then on. While the data file is scanned an empty batch is sent
+// now and then to keep the connection alive.
+func (db *DB) Snapshot(limit int64, send func(data []byte, more bool) error) error {
+	db.Lock()
+	size, lsn := db.size, db.lsn
+	db.Unlock()
+	dbFile, err := os.OpenFile(db.dfPath, os.O_RDONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer dbFile.Close()
+
+	// the index of db may not have caught up with the records up to size yet, so they are indexed again
+	snap := newSnapshotDB(db.dfPath)
+	alive := time.Now()
+	for offset := int64(0); offset < size; {
+		data, err := readRecords(dbFile, offset, size, limit)
+		if err != nil {
+			return err
+		}
+		if err := snap.UpdateTableWithData(offset, data); err != nil {
+			return err
+		}
+		offset += int64(len(data))
+		if time.Since(alive) >= syncStreamHeartbeat {
+			if err := send(nil, true); err != nil {
+				return err
+			}
+			alive = time.Now()
+		}
+	}
+
+	snap.blobs.prune()
+
+	var batch []byte
+	add := func(recordBuf []byte) error {
+		if len(batch) > 0 && int64(len(batch)+len(recordBuf)) > limit {
+			if err := send(batch, true); err != nil {
+				return err
+			}
+			batch = nil
+		}
+		batch = append(batch, recordBuf...)
+		return nil
+	}
+	// blobs go first, so every reference follows its blob
+	for _, bi := range snap.blobs.table {
+		recordBuf, err := common.ReadFileToBytes(dbFile, bi.offset, bi.size)
+		if err != nil {
+			return err
+		}
+		if err := add(recordBuf); err != nil {
+			return err
+		}
+	}
+	now := time.Now().Unix()
+	for _, ri := range snap.iTable.table {
+		if ri.expired(now) {
+			continue
+		}
+		recordBuf, err := common.ReadFileToBytes(dbFile, ri.offset, ri.size)
+		if err != nil {
+			return err
+		}
+		if err := add(recordBuf); err != nil {
+			return err
+		}
+	}
+	if lsn > 0 {
+		checkpointBuf, err := db.genRecordBuf(&DataRecord{
+			header: &DataHeader{Flag: consts.FlagCheckpoint | consts.FlagLSN, lsn: lsn},
+		}, true)
+		if err != nil {
+			return err
+		}
+		err = add(checkpointBuf)
+		db.recordBuffPool.Put(checkpointBuf)
+		if err != nil {
+			return err
+		}
+	}
+	return send(batch, false)
+}
+
+// newSnapshotDB create a db indexing the data file at fPath on its own, it has no write gr.
+func newSnapshotDB(fPath string) *DB {
+	return &DB{
+		dfPath: fPath,
+		iTable: newIndexTable(),
+		blobs:  newBlobTable(),
+		expire: newKeyExpireHeap(1000),
+	}
+}
+
+// snapshotFile is a data file a snapshot of the master is written to before it replaces the data file of a slave.
+type snapshotFile struct {
+	file *os.File
+	snap *DB
+}
+
+// createSnapshotFile create an empty data file next to the data file of db.
+func (db *DB) createSnapshotFile() (*snapshotFile, error) {
+	fPath := db.dfPath + ".snapshot"
+	file, err := os.OpenFile(fPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &snapshotFile{file: file, snap: newSnapshotDB(fPath)}, nil
+}
+
+// write append records of the snapshot to the file and index them.
+func (sf *snapshotFile) write(data []byte) error {
+	offset := sf.snap.size
+	n, err := common.WriteBufToFile(sf.file, offset, data)
+	if err != nil {
+		return err
+	}
+	sf.snap.size += n
+	if err := sf.snap.UpdateTableWithData(offset, data); err != nil {
+		return err
+	}
+	return sf.snap.noteLSNs(offset, data)
+}
+
+// remove close and remove the file, unless it replaced a data file.
+func (sf *snapshotFile) remove() {
+	sf.file.Close()
+	os.Remove(sf.snap.dfPath)
+}
+
+// replaceWith make db serve the records of the snapshot file, which is moved over the data file of db.
+// Readers holding an index of the old data file may fail once, the records are checked before they are returned.
+func (db *DB) replaceWith(sf *snapshotFile) error {
+	if err := sf.file.Sync(); err != nil {
+		return err
+	}
+	snap := sf.snap
+	db.Lock()
+	defer db.Unlock()
+	if err := os.Rename(snap.dfPath, db.dfPath); err != nil {
+		return err
+	}
+	db.size, db.lsn, db.baseLSN, db.lsnIndex = snap.size, snap.lsn, snap.baseLSN, snap.lsnIndex
+	db.iTable.replace(snap.iTable)
+	db.blobs.replace(snap.blobs)
+	// expire entries of replaced keys are skipped when they are due, the index does not match them anymore
+	for key, ri := range snap.iTable.table {
+		if ri.expireAt != 0 {
+			db.expire.push(&keyExpire{key: key, seconds: ri.expireAt})
+		}
+	}
+	return nil
+}
+
+// SendSnapshot send a snapshot of the live records to a slave through send, see DB.Snapshot.
+// Empty SyncData are sent to keep the connection alive until the last one, whose More is false.
+func (e *Engine) SendSnapshot(send func(*pb.SyncData) error) error {
+	return e.db.Snapshot(e.syncBatchSize, func(data []byte, more bool) error {
+		if len(data) == 0 && more {
+			return send(&pb.SyncData{})
+		}
+		return send(&pb.SyncData{Code: 1, Data: data, More: more})
+	})
+}
+
+// Bootstrap replace the data of the slave with a snapshot of the master, the records after it are synced
+// from then on. Nothing changes if the snapshot does not come through whole.
+func (e *Engine) Bootstrap() error {
+	protoData, err := proto.Marshal(&pb.SyncOffset{NodeId: e.nodeID})
+	if err != nil {
+		return err
+	}
+	stream, err := e.openSyncStream("/akita/sync/snapshot/", protoData)
+	if err != nil {
+		return err
+	}
+	defer stream.close()
+	sf, err := e.db.createSnapshotFile()
+	if err != nil {
+		return err
+	}
+	defer sf.remove()
+	logger.Infof("bootstrap from a snapshot of master %v", e.master)
+	for {
+		syncData, err := stream.next()
+		if err != nil {
+			return err
+		}
+		if syncData.Code == 0 {
+			continue
+		}
+		if err := sf.write(syncData.Data); err != nil {
+			return err
+		}
+		if !syncData.More {
+			break
+		}
+	}
+	if err := e.db.replaceWith(sf); err != nil {
+		return err
+	}
+	if e.useCache {
+		e.cache.removeAll()
+	}
+	// the changes the snapshot made are not known, watchers are ended rather than missing them silently
+	e.watchers.removeAll()
+	e.ackSync()
+	logger.Infof("bootstrapped from a snapshot of master %v at lsn %d, %d bytes", e.master, e.db.LSN(), e.db.GetSyncSize())
+	return nil
+}
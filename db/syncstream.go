@@ -0,0 +1,290 @@
+package db
+
+import (
+	akerrors "akita/errors"
+	"akita/logger"
+	"akita/pb"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	// SyncStreamContentType is the content type of sync streams, frames of a length and a SyncData.
+	SyncStreamContentType = "application/x-akita-sync-stream"
+	syncStreamHeartbeat   = 5 * time.Second  // empty frame sent to a slave when there are no records
+	syncStreamIdleTimeout = 15 * time.Second // slaves reconnect when neither records nor heartbeats arrive
+	maxSyncStreamRetry    = time.Minute
+)
+
+// StreamSyncData send the records after lsn, or from legacyOffset on at lsn 0 as SyncDataAfter does, to the
+// slave nodeID through send as they are written, an empty
+// SyncData is sent as a heartbeat when there are none for a while. The next batch is read only after the
+// previous one is sent, so a slave which reads slowly holds back its own stream and nothing more.
+// It returns when done is closed, the engine stops or send fails.
+func (e *Engine) StreamSyncData(nodeID string, lsn int64, legacyOffset int64, done <-chan struct{}, send func(*pb.SyncData) error) error {
+	e.replicas.streaming(nodeID, true)
+	defer e.replicas.streaming(nodeID, false)
+	var notifier chan struct{}
+	defer func() { e.Unregister(nodeID, notifier) }()
+	heartbeat := time.NewTicker(syncStreamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		// registered before reading, so records written meanwhile are not missed
+		notifier = make(chan struct{})
+		e.Register(nodeID, notifier)
+		data, more, err := e.SyncDataAfter(lsn, legacyOffset)
+		switch err {
+		case nil:
+			if err := send(&pb.SyncData{Code: 1, Data: data, More: more}); err != nil {
+				return err
+			}
+			if last := lastLSN(data); last != 0 {
+				lsn = last
+			} else {
+				legacyOffset += int64(len(data))
+			}
+			continue
+		case akerrors.ErrNoDataUpdate:
+		default:
+			return err
+		}
+		select {
+		case <-notifier:
+		case <-heartbeat.C:
+			if err := send(&pb.SyncData{}); err != nil {
+				return err
+			}
+		case <-done:
+			return nil
+		case <-e.stop:
+			return nil
+		}
+	}
+}
+
+// CheckSyncPosition check a slave at lsn can get the records after it, see DB.CheckSyncPosition.
+func (e *Engine) CheckSyncPosition(lsn int64, legacyOffset int64) error {
+	return e.db.CheckSyncPosition(lsn, legacyOffset)
+}
+
+// StreamSync keep a sync stream from the master open and write the records it pushes. A broken stream is
+// opened again after retryInterval milliseconds, doubled while the master can not be reached, resuming
+// after the last record written. A slave with an empty data file, or one the master has no records for
+// anymore, is bootstrapped from a snapshot of the master first.
+func (e *Engine) StreamSync(retryInterval int64) {
+	retry := time.Duration(retryInterval) * time.Millisecond
+	wait := retry
+	bootstrap := e.db.GetSyncSize() == 0
+	for {
+		var connected bool
+		var err error
+		if bootstrap {
+			err = e.Bootstrap()
+			connected, bootstrap = err == nil, err != nil && err != akerrors.ErrSyncStreamOff
+		} else {
+			connected, err = e.streamSync()
+			bootstrap = err == akerrors.ErrSyncPositionLost
+		}
+		select {
+		case <-e.stop:
+			return
+		default:
+		}
+		if err != nil {
+			logger.Errorf("sync stream from lsn %d error: %v, open it again in %v", e.db.LSN(), err, wait)
+		}
+		select {
+		case <-time.After(wait):
+		case <-e.stop:
+			return
+		}
+		switch {
+		case connected:
+			wait = retry
+		case wait < maxSyncStreamRetry:
+			wait *= 2
+		}
+	}
+}
+
+// streamSync open a sync stream from the master and write the records it pushes until the stream breaks,
+// and report whether the master accepted the stream.
+func (e *Engine) streamSync() (bool, error) {
+	protoData, err := proto.Marshal(e.syncOffset())
+	if err != nil {
+		return false, err
+	}
+	stream, err := e.openSyncStream("/akita/sync/stream/", protoData)
+	if err != nil {
+		return false, err
+	}
+	defer stream.close()
+	logger.Infof("sync stream from master %v opened", e.master)
+	for {
+		syncData, err := stream.next()
+		if err != nil {
+			return true, err
+		}
+		if syncData.Code != 0 {
+			if err := e.writeSyncData(syncData.Data); err != nil {
+				return true, err
+			}
+			e.ackSync()
+		}
+	}
+}
+
+// ackSync make the slave acknowledge the records it wrote to the master, acknowledgements which are
+// not sent yet are merged.
+func (e *Engine) ackSync() {
+	select {
+	case e.acks <- struct{}{}:
+	default:
+	}
+}
+
+// AckSyncs send the lsn of the last record the slave wrote to the master whenever it is signaled by ackSync,
+// a slave with a sync stream open tells the master its position this way.
+func (e *Engine) AckSyncs() {
+	header := make(http.Header)
+	if e.syncToken != "" {
+		header.Set("Authorization", "Bearer "+e.syncToken)
+	}
+	for {
+		select {
+		case <-e.acks:
+		case <-e.stop:
+			return
+		}
+		protoData, err := proto.Marshal(&pb.SyncOffset{Lsn: e.db.LSN(), NodeId: e.nodeID})
+		if err != nil {
+			logger.Errorf("marshal data to proto error: %v", err)
+			continue
+		}
+		url := fmt.Sprintf("%v://%v:%v%v", e.syncScheme, e.master, e.port, "/akita/sync/ack/")
+		statusCode, data, err := e.syncClient.PostWithHeader(url, "application/protobuf", header, bytes.NewReader(protoData))
+		if err != nil {
+			logger.Errorf("ack sync request fail: %v", err)
+			continue
+		}
+		if statusCode != http.StatusOK {
+			logger.Errorf("ack sync fail info : %s", data)
+		}
+	}
+}
+
+// syncStream is a stream of SyncData from the master.
+type syncStream struct {
+	body   io.ReadCloser
+	ctx    context.Context
+	cancel context.CancelFunc
+	idle   *time.Timer
+	stop   chan struct{}
+}
+
+// openSyncStream post body to path of the master and return the stream of SyncData it answers with.
+// The stream is closed when the engine stops, or when nothing comes through it for a while.
+func (e *Engine) openSyncStream(path string, body []byte) (*syncStream, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &syncStream{ctx: ctx, cancel: cancel, stop: e.stop}
+	go func() {
+		select {
+		case <-e.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	// a master which went away without closing the connection is noticed by missing heartbeats
+	stream.idle = time.AfterFunc(syncStreamIdleTimeout, cancel)
+
+	url := fmt.Sprintf("%v://%v:%v%v", e.syncScheme, e.master, e.port, path)
+	header := make(http.Header)
+	if e.syncToken != "" {
+		header.Set("Authorization", "Bearer "+e.syncToken)
+	}
+	resp, err := e.streamClient.PostStream(ctx, url, "application/protobuf", header, bytes.NewReader(body))
+	if err != nil {
+		stream.close()
+		return nil, err
+	}
+	stream.body = resp.Body
+	switch {
+	case resp.StatusCode == http.StatusGone:
+		err = akerrors.ErrSyncPositionLost
+	case resp.StatusCode == http.StatusNotFound:
+		err = akerrors.ErrSyncStreamOff
+	case resp.StatusCode != http.StatusOK:
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		err = fmt.Errorf("sync stream status %d: %s", resp.StatusCode, msg)
+	case resp.Header.Get("Content-Type") != SyncStreamContentType:
+		err = akerrors.ErrSyncStreamOff
+	}
+	if err != nil {
+		stream.close()
+		return nil, err
+	}
+	return stream, nil
+}
+
+// next read the next SyncData of the stream, the time the caller takes to apply it does not count as idle.
+func (s *syncStream) next() (*pb.SyncData, error) {
+	s.idle.Reset(syncStreamIdleTimeout)
+	syncData, err := readSyncFrame(s.body)
+	s.idle.Stop()
+	if err != nil && s.ctx.Err() != nil {
+		select {
+		case <-s.stop:
+			return nil, s.ctx.Err()
+		default:
+			return nil, akerrors.ErrSyncStreamIdle
+		}
+	}
+	return syncData, err
+}
+
+// close close the stream.
+func (s *syncStream) close() {
+	s.idle.Stop()
+	s.cancel()
+	if s.body != nil {
+		s.body.Close()
+	}
+}
+
+// WriteSyncFrame write syncData to a sync stream, prefixed with its length.
+func WriteSyncFrame(w io.Writer, syncData *pb.SyncData) error {
+	data, err := proto.Marshal(syncData)
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+	_, err = w.Write(frame)
+	return err
+}
+
+// readSyncFrame read the next SyncData of a sync stream.
+func readSyncFrame(r io.Reader) (*pb.SyncData, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint32(length[:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	syncData := &pb.SyncData{}
+	if err := proto.Unmarshal(data, syncData); err != nil {
+		return nil, err
+	}
+	return syncData, nil
+}
@@ -0,0 +1,200 @@
+package db
+
+import (
+	akhttp "akita/http"
+	"akita/pb"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// startTestSync serve the sync stream and snapshot of master, and make slave sync from it.
+func startTestSync(t *testing.T, master *Engine, slave *Engine) *httptest.Server {
+	send := func(w http.ResponseWriter) func(*pb.SyncData) error {
+		w.Header().Set("Content-Type", SyncStreamContentType)
+		return func(syncData *pb.SyncData) error {
+			if err := WriteSyncFrame(w, syncData); err != nil {
+				return err
+			}
+			w.(http.Flusher).Flush()
+			return nil
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/akita/sync/stream/", func(w http.ResponseWriter, req *http.Request) {
+		offsetBuf, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			t.Errorf("read sync offset error: %s.\n", err)
+			return
+		}
+		syncOffset := &pb.SyncOffset{}
+		if err := proto.Unmarshal(offsetBuf, syncOffset); err != nil {
+			t.Errorf("unmarshal sync offset error: %s.\n", err)
+			return
+		}
+		if err := master.CheckSyncPosition(syncOffset.Lsn, syncOffset.LegacyOffset); err != nil {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		master.AckSync(syncOffset.NodeId, "127.0.0.1", syncOffset.Lsn)
+		master.StreamSyncData(syncOffset.NodeId, syncOffset.Lsn, syncOffset.LegacyOffset, req.Context().Done(), send(w))
+	})
+	mux.HandleFunc("/akita/sync/ack/", func(w http.ResponseWriter, req *http.Request) {
+		offsetBuf, _ := ioutil.ReadAll(req.Body)
+		syncOffset := &pb.SyncOffset{}
+		if err := proto.Unmarshal(offsetBuf, syncOffset); err != nil {
+			t.Errorf("unmarshal sync offset error: %s.\n", err)
+			return
+		}
+		master.AckSync(syncOffset.NodeId, "127.0.0.1", syncOffset.Lsn)
+	})
+	mux.HandleFunc("/akita/sync/snapshot/", func(w http.ResponseWriter, req *http.Request) {
+		if err := master.SendSnapshot(send(w)); err != nil {
+			t.Errorf("send snapshot error: %s.\n", err)
+		}
+	})
+	server := httptest.NewServer(mux)
+	host, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	slave.master, slave.port, slave.syncScheme = host, port, "http"
+	slave.nodeID = "slave"
+	slave.syncClient, slave.streamClient = akhttp.NewHttpClient(time.Second), akhttp.NewHttpClient(0)
+	go slave.StreamSync(10)
+	go slave.AckSyncs()
+	return server
+}
+
+// waitCaughtUp wait until slave has the lsn of master.
+func waitCaughtUp(t *testing.T, master *Engine, slave *Engine) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); slave.db.LSN() != master.db.LSN(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("slave lsn %d, master lsn %d.\n", slave.db.LSN(), master.db.LSN())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func putTestKeys(t *testing.T, e *Engine, prefix string, from int, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		if err := e.Put(prefix+strconv.Itoa(i), []byte{byte(i)}); err != nil {
+			t.Fatalf("put error: %s.\n", err)
+		}
+	}
+}
+
+func Test_SyncStream(t *testing.T) {
+	master, slave := openTestEngine(t), openTestEngine(t)
+	defer master.db.Close()
+	defer slave.db.Close()
+	// a slave with data does not bootstrap
+	putTestKeys(t, slave, "stream", 0, 1)
+	putTestKeys(t, master, "stream", 0, 10)
+	server := startTestSync(t, master, slave)
+	defer server.Close()
+	defer close(slave.stop)
+
+	waitCaughtUp(t, master, slave)
+	if _, err := slave.Seek("stream9"); err != nil {
+		t.Fatalf("seek synced key error: %s.\n", err)
+	}
+	mv, _ := master.Version("stream9")
+	if sv, ok := slave.Version("stream9"); !ok || sv != mv {
+		t.Fatalf("version of synced key want %d as on master, got %d %v.\n", mv, sv, ok)
+	}
+
+	// records are pushed as they are written, and after the stream breaks it resumes where it stopped
+	events, stop := slave.Watch("stream1")
+	defer stop()
+	server.CloseClientConnections()
+	putTestKeys(t, master, "stream", 10, 20)
+	waitCaughtUp(t, master, slave)
+	if _, err := slave.Seek("stream19"); err != nil {
+		t.Fatalf("seek synced key error: %s.\n", err)
+	}
+
+	// watchers of the slave get the changes of synced records
+	for i := 10; i < 20; i++ {
+		if ev := <-events; ev.Type != EventPut || ev.Key != "stream"+strconv.Itoa(i) {
+			t.Fatalf("slave watch event: %v, want put of stream%d", *ev, i)
+		}
+	}
+	if _, _, _, err := master.Delete("stream10"); err != nil {
+		t.Fatalf("delete error: %s.\n", err)
+	}
+	if ev := <-events; ev.Type != EventDelete || ev.Key != "stream10" {
+		t.Fatalf("slave watch event: %v, want delete of stream10", *ev)
+	}
+
+	// the slave acknowledges the records it wrote
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		replicas := master.Replicas()
+		if len(replicas) == 1 && replicas[0].NodeID == "slave" && replicas[0].AckLSN == master.db.LSN() && replicas[0].Streaming {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("replicas of the master are %+v.\n", replicas[0])
+		}
+	}
+
+	// a write waits until the slave acknowledges it
+	master.UseWriteQuorum(1, 5*time.Second, false)
+	lsn, err := master.PutWithExpire("quorum0", []byte("0"), 0)
+	if err != nil {
+		t.Fatalf("put error: %s.\n", err)
+	}
+	if n, err := master.WaitQuorum(lsn); n != 1 || err != nil {
+		t.Fatalf("wait quorum of the slave: %d, %v.\n", n, err)
+	}
+	if _, err := slave.Seek("quorum0"); err != nil {
+		t.Fatalf("seek confirmed key error: %s.\n", err)
+	}
+}
+
+func Test_Bootstrap(t *testing.T) {
+	master, slave := openTestEngine(t), openTestEngine(t)
+	defer master.db.Close()
+	defer slave.db.Close()
+	master.useDedup = true
+	// the snapshot comes in many batches
+	master.syncBatchSize = 256
+	putTestKeys(t, master, "boot", 0, 100)
+	putTestKeys(t, master, "boot", 0, 50)
+	for i := 50; i < 60; i++ {
+		if _, _, _, err := master.delete("boot" + strconv.Itoa(i)); err != nil {
+			t.Fatalf("delete error: %s.\n", err)
+		}
+	}
+	// the records of a slave ahead of its master are replaced by the snapshot
+	putTestKeys(t, slave, "ahead", 0, 500)
+	server := startTestSync(t, master, slave)
+	defer server.Close()
+	defer close(slave.stop)
+
+	waitCaughtUp(t, master, slave)
+	if n := len(slave.db.iTable.keys()); n != 90 {
+		t.Fatalf("slave has %d keys after bootstrap, want 90.\n", n)
+	}
+	if value, _ := slave.Seek("ahead0"); value != nil {
+		t.Fatalf("key of the slave is kept after bootstrap")
+	}
+	if value, err := slave.Seek("boot99"); err != nil || value[0] != 99 {
+		t.Fatalf("seek bootstrapped key %v, %v.\n", value, err)
+	}
+	if size := slave.db.GetSyncSize(); size >= master.db.GetSyncSize() {
+		t.Fatalf("snapshot of %d bytes is not smaller than the %d bytes of the master.\n", size, master.db.GetSyncSize())
+	}
+
+	// records after the snapshot are streamed
+	putTestKeys(t, master, "after", 0, 10)
+	waitCaughtUp(t, master, slave)
+	if _, err := slave.Seek("after9"); err != nil {
+		t.Fatalf("seek key written after bootstrap error: %s.\n", err)
+	}
+}
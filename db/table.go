@@ -1,20 +1,36 @@
 package db
 
 import (
+	"sort"
 	"sync"
 	"unsafe"
 )
 
 type (
 	recordIndex struct {
-		offset int64 // record begin offset
-		size   int64 // record size
+		offset   int64  // record begin offset
+		size     int64  // record size
+		digest   string // digest of the referenced blob, empty if the record holds its own value
+		expireAt int64  // unix time in seconds the record expires at, 0 if it never expires
+		lsn      int64  // log sequence number of the record, 0 for records written before lsns
 	}
 
 	indexTable struct {
-		table  map[string]*recordIndex
+		table      map[string]*recordIndex
+		rwLock     sync.RWMutex
+		usage      int               // memory size of database index table
+		namespaces map[string]*Usage // usage of namespaces by their keys
+	}
+
+	blobIndex struct {
+		recordIndex
+		refs int // number of keys referencing the blob
+	}
+
+	// blobTable indexes content-addressed values by digest
+	blobTable struct {
+		table  map[string]*blobIndex
 		rwLock sync.RWMutex
-		usage  int // memory size of database index table
 	}
 )
 
@@ -23,9 +39,24 @@ const (
 	recordIndexSize = int(unsafe.Sizeof(recordIndex{}))
 )
 
+// expired report whether the record is expired at now.
+func (ri *recordIndex) expired(now int64) bool {
+	return ri.expireAt != 0 && ri.expireAt <= now
+}
+
+// version identify the write of the record. Records keep their lsn through compaction, sync and bootstrap,
+// records written before lsns have none and are identified by their offset, which has the top bit set.
+func (ri *recordIndex) version() uint64 {
+	if ri.lsn > 0 {
+		return uint64(ri.lsn)
+	}
+	return uint64(ri.offset+1) | 1<<63
+}
+
 func newIndexTable() *indexTable {
 	return &indexTable{
-		table: make(map[string]*recordIndex, 1024),
+		table:      make(map[string]*recordIndex, 1024),
+		namespaces: make(map[string]*Usage),
 	}
 }
 
@@ -35,9 +66,14 @@ func (it *indexTable) put(key string, newIndex *recordIndex) (oldIndex *recordIn
 	defer it.rwLock.Unlock()
 	oldIndex = it.table[key]
 	it.table[key] = newIndex
+	u := it.namespaceUsage(key)
 	if oldIndex == nil {
 		it.usage += len(key) + recordIndexSize
+		u.Objects++
+	} else {
+		u.Bytes -= oldIndex.size
 	}
+	u.Bytes += newIndex.size
 	return
 }
 
@@ -56,7 +92,146 @@ func (it *indexTable) remove(key string) *recordIndex {
 	if index, exists := it.table[key]; exists {
 		it.usage -= len(key) + recordIndexSize
 		delete(it.table, key)
+		u := it.namespaceUsage(key)
+		u.Objects--
+		u.Bytes -= index.size
+		if u.Objects == 0 {
+			delete(it.namespaces, Namespace(key))
+		}
 		return index
 	}
 	return nil
 }
+
+// namespaceUsage return the usage of the namespace of key, caller must hold the write lock.
+func (it *indexTable) namespaceUsage(key string) *Usage {
+	namespace := Namespace(key)
+	u, ok := it.namespaces[namespace]
+	if !ok {
+		u = &Usage{}
+		it.namespaces[namespace] = u
+	}
+	return u
+}
+
+// usageOf return the usage of namespace.
+func (it *indexTable) usageOf(namespace string) Usage {
+	it.rwLock.RLock()
+	defer it.rwLock.RUnlock()
+	if u, ok := it.namespaces[namespace]; ok {
+		return *u
+	}
+	return Usage{}
+}
+
+// usages return the usage of every namespace which has keys.
+func (it *indexTable) usages() map[string]Usage {
+	it.rwLock.RLock()
+	defer it.rwLock.RUnlock()
+	usages := make(map[string]Usage, len(it.namespaces))
+	for namespace, u := range it.namespaces {
+		usages[namespace] = *u
+	}
+	return usages
+}
+
+// keys return all keys of index table in order.
+func (it *indexTable) keys() []string {
+	it.rwLock.RLock()
+	keys := make([]string, 0, len(it.table))
+	for key := range it.table {
+		keys = append(keys, key)
+	}
+	it.rwLock.RUnlock()
+	sort.Strings(keys)
+	return keys
+}
+
+// replace make the index table hold the records of other, other must not be used anymore.
+func (it *indexTable) replace(other *indexTable) {
+	it.rwLock.Lock()
+	defer it.rwLock.Unlock()
+	it.table, it.usage, it.namespaces = other.table, other.usage, other.namespaces
+}
+
+func newBlobTable() *blobTable {
+	return &blobTable{
+		table: make(map[string]*blobIndex),
+	}
+}
+
+// put record the location of a blob, references already counted are kept.
+func (bt *blobTable) put(digest string, ri *recordIndex) {
+	bt.rwLock.Lock()
+	defer bt.rwLock.Unlock()
+	bi, exists := bt.table[digest]
+	if !exists {
+		bi = &blobIndex{}
+		bt.table[digest] = bi
+	}
+	bi.recordIndex = *ri
+}
+
+// find blob from blob table.
+func (bt *blobTable) get(digest string) *recordIndex {
+	bt.rwLock.RLock()
+	defer bt.rwLock.RUnlock()
+	if bi, exists := bt.table[digest]; exists {
+		return &bi.recordIndex
+	}
+	return nil
+}
+
+// retain add a reference to blob, return false if blob is not stored.
+func (bt *blobTable) retain(digest string) bool {
+	bt.rwLock.Lock()
+	defer bt.rwLock.Unlock()
+	bi, exists := bt.table[digest]
+	if !exists {
+		return false
+	}
+	bi.refs++
+	return true
+}
+
+// release drop a reference to blob, the blob is forgotten when nothing references it.
+func (bt *blobTable) release(digest string) {
+	bt.rwLock.Lock()
+	defer bt.rwLock.Unlock()
+	bi, exists := bt.table[digest]
+	if !exists {
+		return
+	}
+	bi.refs--
+	if bi.refs <= 0 {
+		delete(bt.table, digest)
+	}
+}
+
+// prune forget the blobs nothing references, a data file read as a whole may hold blobs whose keys are gone.
+func (bt *blobTable) prune() {
+	bt.rwLock.Lock()
+	defer bt.rwLock.Unlock()
+	for digest, bi := range bt.table {
+		if bi.refs <= 0 {
+			delete(bt.table, digest)
+		}
+	}
+}
+
+// references return the number of keys referencing blob.
+func (bt *blobTable) references(digest string) int {
+	bt.rwLock.RLock()
+	defer bt.rwLock.RUnlock()
+	if bi, exists := bt.table[digest]; exists {
+		return bi.refs
+	}
+	return 0
+}
+
+// replace make the blob table hold the blobs of other, other must not be used anymore.
+func (bt *blobTable) replace(other *blobTable) {
+	bt.rwLock.Lock()
+	defer bt.rwLock.Unlock()
+	bt.table = other.table
+}
@@ -0,0 +1,120 @@
+package db
+
+import (
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestDB(t *testing.T) *DB {
+	fPath := filepath.Join(t.TempDir(), "akita.dat")
+	f, err := os.Create(fPath)
+	if err != nil {
+		t.Fatalf("create data file error: %s.\n", err)
+	}
+	f.Close()
+	d := OpenDB(fPath)
+	go d.WriteRecordBuffQueueData()
+	return d
+}
+
+func Test_DedupRecord(t *testing.T) {
+	d := openTestDB(t)
+	defer d.Close()
+	value := []byte{1, 2, 3, 4, 5}
+	for _, key := range []string{"dedup0", "dedup1", "dedup2"} {
+		if err := d.WriteDedupRecord([]byte(key), value, 0); err != nil {
+			t.Fatalf("write dedup record error: %s.\n", err)
+		}
+	}
+	ri0, ri1 := d.iTable.get("dedup0"), d.iTable.get("dedup1")
+	if ri0.digest == "" || ri0.digest != ri1.digest {
+		t.Fatalf("keys with the same value should reference the same blob")
+	}
+	if refs := d.blobs.references(ri0.digest); refs != 3 {
+		t.Fatalf("blob references: %d, want 3", refs)
+	}
+
+	// overwrite one key with another value, delete another one
+	if err := d.WriteDedupRecord([]byte("dedup1"), []byte{5, 4, 3}, 0); err != nil {
+		t.Fatalf("write dedup record error: %s.\n", err)
+	}
+	if err := d.WriteRecordNoCrc32(&DataRecord{
+		header: &DataHeader{Ks: 6, Flag: 2},
+		key:    []byte("dedup2"),
+	}); err != nil {
+		t.Fatalf("write delete record error: %s.\n", err)
+	}
+	d.removeIndex("dedup2")
+	if refs := d.blobs.references(ri0.digest); refs != 1 {
+		t.Fatalf("blob references: %d, want 1", refs)
+	}
+
+	blob := d.blobs.get(ri0.digest)
+	value0, err := d.ReadRecord(blob.offset, blob.size)
+	if err != nil || string(value0) != string(value) {
+		t.Fatalf("read blob: %v, %v", value0, err)
+	}
+
+	// references must be the same after reload
+	rd := OpenDB(d.dfPath)
+	if err := rd.Reload(); err != nil {
+		t.Fatalf("reload db error: %s.\n", err)
+	}
+	if refs := rd.blobs.references(ri0.digest); refs != 1 {
+		t.Fatalf("blob references after reload: %d, want 1", refs)
+	}
+	if ri := rd.iTable.get("dedup1"); ri == nil || rd.blobs.references(ri.digest) != 1 {
+		t.Fatalf("overwritten key should reference its own blob after reload")
+	}
+	if rd.iTable.get("dedup2") != nil {
+		t.Fatalf("deleted key should not be reloaded")
+	}
+
+	// a blob whose only key expired is forgotten on reload as it is when the key expires
+	expired := []byte{9, 9, 9}
+	if err := d.WriteDedupRecord([]byte("dedup3"), expired, time.Now().Unix()-1); err != nil {
+		t.Fatalf("write dedup record error: %s.\n", err)
+	}
+	rd = OpenDB(d.dfPath)
+	if err := rd.Reload(); err != nil {
+		t.Fatalf("reload db error: %s.\n", err)
+	}
+	digest := sha256.Sum256(expired)
+	if rd.HasBlob(digest[:]) {
+		t.Fatalf("blob without references should not be reloaded")
+	}
+}
+
+func Test_WriteBatch(t *testing.T) {
+	d := openTestDB(t)
+	defer d.Close()
+	keys := [][]byte{[]byte("batch0"), []byte("batch1"), []byte("batch2")}
+	values := [][]byte{{1, 2, 3}, {4, 5}, {1, 2, 3}}
+	for _, dedup := range []bool{false, true} {
+		if err := d.WriteBatch(keys, values, 0, dedup); err != nil {
+			t.Fatalf("write batch error: %s.\n", err)
+		}
+		indexes := make([]*recordIndex, len(keys)+1)
+		for i, key := range keys {
+			indexes[i] = d.iTable.get(string(key))
+			if indexes[i].digest != "" {
+				indexes[i] = d.blobs.get(indexes[i].digest)
+			}
+		}
+		read, errs := d.ReadRecords(indexes)
+		for i := range keys {
+			if errs[i] != nil || string(read[i]) != string(values[i]) {
+				t.Fatalf("dedup: %v, read key %s: %v, %v", dedup, keys[i], read[i], errs[i])
+			}
+		}
+		if read[len(keys)] != nil || errs[len(keys)] != nil {
+			t.Fatalf("missing index should read nothing")
+		}
+	}
+	if refs := d.blobs.references(d.iTable.get("batch0").digest); refs != 2 {
+		t.Fatalf("blob references: %d, want 2", refs)
+	}
+}
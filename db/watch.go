@@ -0,0 +1,132 @@
+package db
+
+import (
+	akerrors "akita/errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventType kind of a change of a key.
+type EventType int32
+
+const (
+	// EventPut key is written
+	EventPut EventType = iota + 1
+	// EventDelete key is deleted
+	EventDelete
+	// EventExpire key is removed because it expired
+	EventExpire
+)
+
+const (
+	watchEventBuffer = 256  // events queued for a watcher before it is dropped
+	watchHistory     = 4096 // latest events kept for watchers resuming from a position
+)
+
+// Event a change of a key, Seq is the position of the event in the feed.
+type Event struct {
+	Seq  uint64
+	Type EventType
+	Key  string
+}
+
+type watcher struct {
+	prefix string
+	events chan *Event
+}
+
+// watchers fan out events to the watchers of matching key prefixes, and keep the latest events
+// so that a watcher can resume after the position of the last event it got.
+type watchers struct {
+	sync.Mutex
+	set     map[*watcher]struct{}
+	history []*Event // ring of the latest events, event of sequence seq is history[seq%watchHistory]
+	kept    uint64   // number of events in history
+	next    uint64   // sequence of the next event
+}
+
+func newWatchers() *watchers {
+	return &watchers{
+		set:     make(map[*watcher]struct{}),
+		history: make([]*Event, watchHistory),
+		// positions of an earlier run are older than every event of this run, so they can not be resumed
+		next: uint64(time.Now().UnixNano()),
+	}
+}
+
+// position return the sequence of the latest event.
+func (ws *watchers) position() uint64 {
+	ws.Lock()
+	defer ws.Unlock()
+	return ws.next - 1
+}
+
+// add register a watcher of keys starting with prefix, its events begin with the kept events after position
+// after. Position 0 watches from now on.
+func (ws *watchers) add(prefix string, after uint64) (*watcher, error) {
+	ws.Lock()
+	defer ws.Unlock()
+	var backlog []*Event
+	if after != 0 {
+		oldest := ws.next - ws.kept
+		if after < oldest-1 || after >= ws.next {
+			return nil, akerrors.ErrWatchPositionLost
+		}
+		for seq := after + 1; seq < ws.next; seq++ {
+			if ev := ws.history[seq%watchHistory]; strings.HasPrefix(ev.Key, prefix) {
+				backlog = append(backlog, ev)
+			}
+		}
+	}
+	w := &watcher{prefix: prefix, events: make(chan *Event, watchEventBuffer+len(backlog))}
+	for _, ev := range backlog {
+		w.events <- ev
+	}
+	ws.set[w] = struct{}{}
+	return w, nil
+}
+
+// remove unregister w and close its events, it is safe to remove w more than once.
+func (ws *watchers) remove(w *watcher) {
+	ws.Lock()
+	defer ws.Unlock()
+	if _, ok := ws.set[w]; ok {
+		delete(ws.set, w)
+		close(w.events)
+	}
+}
+
+// removeAll unregister every watcher and close its events.
+func (ws *watchers) removeAll() {
+	ws.Lock()
+	defer ws.Unlock()
+	for w := range ws.set {
+		delete(ws.set, w)
+		close(w.events)
+	}
+}
+
+// publish number ev and send it to the watchers of its key, a watcher which does not keep up is dropped
+// and its events closed, so it never misses events silently.
+func (ws *watchers) publish(ev *Event) {
+	ws.Lock()
+	defer ws.Unlock()
+	ev.Seq = ws.next
+	if ws.kept < watchHistory {
+		ws.kept++
+	}
+	ws.history[ev.Seq%watchHistory] = ev
+	ws.next++
+	for w := range ws.set {
+		if !strings.HasPrefix(ev.Key, w.prefix) {
+			continue
+		}
+		select {
+		case w.events <- ev:
+		default:
+			delete(ws.set, w)
+			close(w.events)
+		}
+	}
+}
@@ -6,4 +6,35 @@ var (
 	ErrKeySize             = errors.New("key size is too large to save. ")
 	ErrDataHasBeenModified = errors.New("the data has been modified, not safe. ")
 	ErrNoDataUpdate        = errors.New("no data update. ")
+	ErrRecordTruncated     = errors.New("the record is truncated. ")
+	ErrSyncPositionLost    = errors.New("records after the sync position are no longer kept, the slave must be bootstrapped again. ")
+	ErrSyncStreamIdle      = errors.New("neither records nor heartbeats came through the sync stream. ")
+	ErrSyncStreamOff       = errors.New("master does not stream records, turn sync streams off on the slave. ")
+	ErrQuorumTimeout       = errors.New("the write is stored on the master but too few replicas confirmed it in time. ")
+	ErrDigestSize          = errors.New("digest must be a hex encoded sha256 sum. ")
+	ErrBlobNotFound        = errors.New("no value with this digest is stored. ")
+	ErrDedupTurnOff        = errors.New("deduplication is not turned on. ")
+	ErrValueNotInteger     = errors.New("value is not an integer. ")
+	ErrValueSize           = errors.New("value would be too large to save. ")
+	ErrIncrOverflow        = errors.New("increment would overflow. ")
+	ErrEncryptTurnOff      = errors.New("value is encrypted but encryption is not turned on. ")
+	ErrWatchPositionLost   = errors.New("events after the watch position are no longer kept. ")
+	ErrImageOptions        = errors.New("image transform parameters are not valid. ")
+	ErrImageTooLarge       = errors.New("image is larger than the transform limits. ")
+	ErrImageFormat         = errors.New("value is not a jpeg, png or gif image. ")
+	ErrSignatureExpired    = errors.New("the signed url has expired. ")
+	ErrSignatureInvalid    = errors.New("the signature of the url is not valid. ")
+	ErrTokenMissing        = errors.New("an api token is required. ")
+	ErrTokenInvalid        = errors.New("the api token is not valid. ")
+	ErrTokenScope          = errors.New("the api token lacks the scope of this request. ")
+	ErrScopeUnknown        = errors.New("scope must be read, write, admin or replication. ")
+	ErrPermissionUnknown   = errors.New("permission must be read, write, list or delete. ")
+	ErrGrantInvalid        = errors.New("a grant needs a principal, a namespace and permissions. ")
+	ErrGrantPrefix         = errors.New("prefix must be empty or select keys of the namespace only. ")
+	ErrAccessDenied        = errors.New("the acl of the namespace does not grant this request. ")
+	ErrQuotaExceeded       = errors.New("the quota of the namespace is exceeded. ")
+	ErrRateLimited         = errors.New("too many requests, retry later. ")
+	ErrOverloaded          = errors.New("server is overloaded, retry later. ")
+	ErrPeerCertificate     = errors.New("a client certificate signed by the cluster ca is required. ")
+	ErrPeerName            = errors.New("the host of a peer is required to verify its certificate. ")
 )
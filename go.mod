@@ -3,6 +3,10 @@ module akita
 go 1.15
 
 require (
-	github.com/golang/protobuf v1.4.1
+	github.com/aws/aws-sdk-go v1.36.31
+	github.com/bradfitz/gomemcache v0.0.0-20190913173617-a41fca850d0b
+	github.com/golang/protobuf v1.4.2
+	github.com/gomodule/redigo v1.8.5
+	google.golang.org/grpc v1.34.0
 	google.golang.org/protobuf v1.25.0
 )
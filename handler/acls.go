@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"akita/auth"
+	"akita/db"
+	"akita/errors"
+	akhttp "akita/http"
+	"akita/logger"
+	"net/http"
+	"strconv"
+)
+
+// acls restrict namespaces to the principals they are granted to, they are turned off if nil.
+var acls *auth.ACLs
+
+// UseACLs turn on namespace access control lists a, tokens must be turned on too.
+func UseACLs(a *auth.ACLs) {
+	acls = a
+}
+
+// allowed check the token of req has perm on key, write the error response and return false if not.
+func allowed(w http.ResponseWriter, req *http.Request, key string, perm auth.Permission) bool {
+	ok, err := acls.Allowed(auth.TokenInfoFrom(req.Context()), key, perm)
+	if err != nil {
+		logger.Errorf("Check acl of key %v error %v", key, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		akhttp.WriteResponse(w, http.StatusForbidden, "key "+key+": "+errors.ErrAccessDenied.Error())
+	}
+	return ok
+}
+
+// allowedV2 is like allowed, errors are written as json error envelope.
+func allowedV2(w http.ResponseWriter, req *http.Request, key string, perm auth.Permission) bool {
+	ok, err := acls.Allowed(auth.TokenInfoFrom(req.Context()), key, perm)
+	if err != nil {
+		logger.Errorf("Check acl of key %v error %v", key, err)
+		akhttp.WriteError(w, http.StatusInternalServerError, akhttp.ErrCodeInternal, err.Error())
+		return false
+	}
+	if !ok {
+		akhttp.WriteError(w, http.StatusForbidden, akhttp.ErrCodeForbidden, errors.ErrAccessDenied.Error())
+	}
+	return ok
+}
+
+// ACLs handle access control list requests: GET lists the grants of every namespace, or of the namespace
+// form value, POST grants the permissions form value to principal on the keys of namespace starting with prefix,
+// DELETE revokes the grant of principal on prefix of namespace. Changes are audited with the name of the token.
+func ACLs(w http.ResponseWriter, req *http.Request) {
+	if acls == nil {
+		akhttp.WriteResponse(w, http.StatusBadRequest, "authentication is not turned on! ")
+		return
+	}
+	namespace := req.FormValue("namespace")
+	if req.Method == http.MethodGet {
+		var grants []*auth.Grant
+		var err error
+		if namespace != "" {
+			grants, err = acls.Grants(namespace)
+		} else {
+			grants, err = acls.All()
+		}
+		if err != nil {
+			logger.Errorf("List grants fail: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if grants == nil {
+			grants = []*auth.Grant{}
+		}
+		akhttp.WriteResponse(w, http.StatusOK, grants)
+		return
+	}
+	if !db.GetEngine().IsMaster() {
+		akhttp.WriteResponse(w, http.StatusUnauthorized, "sorry this akita node isn't master node! ")
+		return
+	}
+	actor := ""
+	if info := auth.TokenInfoFrom(req.Context()); info != nil {
+		actor = info.Name
+	}
+	switch req.Method {
+	case http.MethodPost:
+		perm, err := auth.ParsePermission(req.FormValue("permissions"))
+		if err != nil {
+			akhttp.WriteResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		g := &auth.Grant{
+			Principal:   req.FormValue("principal"),
+			Namespace:   namespace,
+			Prefix:      req.FormValue("prefix"),
+			Permissions: perm,
+		}
+		err = acls.Grant(actor, g)
+		if err == errors.ErrGrantInvalid || err == errors.ErrGrantPrefix {
+			akhttp.WriteResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			logger.Errorf("Grant fail: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		akhttp.WriteResponse(w, http.StatusOK, g)
+	case http.MethodDelete:
+		revoked, err := acls.Revoke(actor, req.FormValue("principal"), namespace, req.FormValue("prefix"))
+		if err != nil {
+			logger.Errorf("Revoke grant fail: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !revoked {
+			akhttp.WriteResponse(w, http.StatusNotFound, "no grant of this principal and prefix! ")
+			return
+		}
+		akhttp.WriteResponse(w, http.StatusOK, "revoke grant success! ")
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		akhttp.WriteResponse(w, http.StatusMethodNotAllowed, "method "+req.Method+" is not allowed! ")
+	}
+}
+
+type auditPage struct {
+	Entries []*auth.AuditEntry `json:"entries"`
+	More    bool               `json:"more"`
+}
+
+// ACLAudit handle acl audit trail requests, at most limit entries, 100 by default, are listed in time order
+// after the entry whose time is the after form value.
+func ACLAudit(w http.ResponseWriter, req *http.Request) {
+	if acls == nil {
+		akhttp.WriteResponse(w, http.StatusBadRequest, "authentication is not turned on! ")
+		return
+	}
+	limit := 100
+	if s := req.FormValue("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > 1000 {
+			akhttp.WriteResponse(w, http.StatusBadRequest, "limit must be between 1 and 1000! ")
+			return
+		}
+		limit = n
+	}
+	var after int64
+	if s := req.FormValue("after"); s != "" {
+		var err error
+		if after, err = strconv.ParseInt(s, 10, 64); err != nil {
+			akhttp.WriteResponse(w, http.StatusBadRequest, "after must be the time of an entry! ")
+			return
+		}
+	}
+	entries, more, err := acls.Audit(after, limit)
+	if err != nil {
+		logger.Errorf("List acl audit fail: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	akhttp.WriteResponse(w, http.StatusOK, &auditPage{Entries: entries, More: more})
+}
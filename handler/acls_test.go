@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"akita/auth"
+	"akita/db"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// useTestACLs turn on the access control lists of e until the test ends, principal is granted perms
+// on the keys of namespace.
+func useTestACLs(t *testing.T, e *db.Engine, principal string, namespace string, perms auth.Permission) {
+	a := auth.NewACLs(e)
+	if err := a.Grant("admin", &auth.Grant{Principal: principal, Namespace: namespace, Permissions: perms}); err != nil {
+		t.Fatalf("grant error: %s.\n", err)
+	}
+	UseACLs(a)
+	t.Cleanup(func() { UseACLs(nil) })
+}
+
+// withToken return req sent with a token of name and scope.
+func withToken(req *http.Request, name string, scope auth.Scope) *http.Request {
+	return req.WithContext(auth.WithTokenInfo(req.Context(), &auth.TokenInfo{ID: name, Name: name, Scope: scope}))
+}
+
+func Test_DigestACL(t *testing.T) {
+	e := openTestEngineWithDedup(t, true)
+	if err := e.Put("photos/a", []byte("value")); err != nil {
+		t.Fatalf("put photos/a error: %s.\n", err)
+	}
+	useTestACLs(t, e, "reader", "photos", auth.PermRead)
+	sum := sha256.Sum256([]byte("value"))
+	digest := func(name string, namespace string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/akita/digest/?sha256="+hex.EncodeToString(sum[:])+"&namespace="+namespace, nil)
+		return serve(Digest, withToken(req, name, auth.ScopeRead))
+	}
+
+	if w := digest("reader", "photos"); w.Code != http.StatusOK || w.Body.String() != "true" {
+		t.Fatalf("digest of granted namespace want 200 true, got %d %s.\n", w.Code, w.Body)
+	}
+	if w := digest("other", "photos"); w.Code != http.StatusForbidden {
+		t.Fatalf("digest of namespace not granted want 403, got %d %s.\n", w.Code, w.Body)
+	}
+	if w := digest("reader", "_tokens"); w.Code != http.StatusForbidden {
+		t.Fatalf("digest of reserved namespace want 403, got %d %s.\n", w.Code, w.Body)
+	}
+	// a namespace without grants is open to every token
+	if w := digest("other", "docs"); w.Code != http.StatusOK {
+		t.Fatalf("digest of namespace without grants want 200, got %d %s.\n", w.Code, w.Body)
+	}
+}
+
+func Test_SaveSearchACL(t *testing.T) {
+	e := openTestEngine(t)
+	useTestACLs(t, e, "owner", "photos", auth.PermRead|auth.PermWrite)
+	save := func(name string, key string) *httptest.ResponseRecorder {
+		return serve(Save, withToken(saveRequest(t, "/akita/save/", key, []byte("value")), name, auth.ScopeWrite))
+	}
+	search := func(name string, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/akita/search/?key="+key, nil)
+		return serve(Search, withToken(req, name, auth.ScopeRead))
+	}
+
+	if w := save("other", "photos/a"); w.Code != http.StatusForbidden {
+		t.Fatalf("save to namespace not granted want 403, got %d %s.\n", w.Code, w.Body)
+	}
+	if value, _ := e.Seek("photos/a"); value != nil {
+		t.Fatalf("denied save want no value, got %s.\n", value)
+	}
+	if w := save("owner", "photos/a"); w.Code != http.StatusOK {
+		t.Fatalf("save to granted namespace want 200, got %d %s.\n", w.Code, w.Body)
+	}
+	if w := search("other", "photos/a"); w.Code != http.StatusForbidden {
+		t.Fatalf("search of namespace not granted want 403, got %d %s.\n", w.Code, w.Body)
+	}
+	if w := search("owner", "photos/a"); w.Code != http.StatusOK {
+		t.Fatalf("search of granted namespace want 200, got %d %s.\n", w.Code, w.Body)
+	}
+	// a namespace without grants is open to every token
+	if w := save("other", "docs/a"); w.Code != http.StatusOK {
+		t.Fatalf("save to namespace without grants want 200, got %d %s.\n", w.Code, w.Body)
+	}
+}
@@ -1,20 +1,32 @@
 package handler
 
 import (
+	"akita/auth"
 	"akita/common"
 	"akita/consts"
 	"akita/db"
 	"akita/errors"
 	akhttp "akita/http"
+	"akita/imaging"
 	"akita/logger"
 	"akita/pb"
+	"encoding/hex"
 	"io/ioutil"
+	"mime/multipart"
+	"net"
 	"net/http"
+	"net/textproto"
+	"strconv"
 	"time"
 
 	"google.golang.org/protobuf/proto"
 )
 
+const (
+	multiMaxKeys = 256           // maximum number of keys of a multi request
+	multiMaxSize = 64 * consts.M // maximum size of all files of a multi save request
+)
+
 // Save handle insert data request.
 func Save(w http.ResponseWriter, req *http.Request) {
 	if !db.GetEngine().IsMaster() {
@@ -22,6 +34,18 @@ func Save(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 	key := req.FormValue("key")
+	signed := auth.IsSigned(req)
+	if signed {
+		if !checkSignature(w, req) {
+			return
+		}
+		// the signed key can not be replaced by a form value
+		key = req.URL.Query().Get("key")
+		if formKey := req.PostFormValue("key"); formKey != "" && formKey != key {
+			akhttp.WriteResponse(w, http.StatusForbidden, "key is not the signed key! ")
+			return
+		}
+	}
 	if key == "" {
 		akhttp.WriteResponse(w, http.StatusBadRequest, "key can not be empty! ")
 		return
@@ -30,6 +54,33 @@ func Save(w http.ResponseWriter, req *http.Request) {
 		akhttp.WriteResponse(w, http.StatusBadRequest, errors.ErrKeySize)
 		return
 	}
+	// the signature grants the signed key
+	if !signed && !allowed(w, req, key, auth.PermWrite) {
+		return
+	}
+	if sum := req.FormValue("sha256"); sum != "" {
+		// the client already knows the value is stored, save key without upload
+		digest, err := hex.DecodeString(sum)
+		if err != nil {
+			akhttp.WriteResponse(w, http.StatusBadRequest, errors.ErrDigestSize.Error())
+			return
+		}
+		lsn, err := db.GetEngine().Link(key, digest)
+		if err == errors.ErrQuotaExceeded {
+			akhttp.WriteResponse(w, http.StatusInsufficientStorage, err.Error())
+			return
+		}
+		if err != nil {
+			logger.Errorf("Link key %v fail: %v", key, err)
+			akhttp.WriteResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !confirmWrite(w, lsn, false) {
+			return
+		}
+		akhttp.WriteResponse(w, http.StatusOK, "save  key: "+key+" success! ")
+		return
+	}
 	_, file, err := req.FormFile("file")
 	if file == nil {
 		akhttp.WriteResponse(w, http.StatusBadRequest, "file can not be empty! ")
@@ -54,22 +105,186 @@ func Save(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 	defer src.Close()
-	_, err = db.GetEngine().Insert(key, src, length)
+	value, err := ioutil.ReadAll(src)
+	if err != nil {
+		logger.Errorf("File read fail: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	value, meta, err := prepareUpload(key, value)
+	if err != nil {
+		akhttp.WriteResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	lsn, err := db.GetEngine().PutWithAttrs(key, value, 0, metadataAttrs(meta))
+	if err == errors.ErrQuotaExceeded {
+		akhttp.WriteResponse(w, http.StatusInsufficientStorage, err.Error())
+		return
+	}
 	if err != nil {
 		logger.Errorf("File save key %v fail: %v", key, err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if !confirmWrite(w, lsn, false) {
+		return
+	}
 	akhttp.WriteResponse(w, http.StatusOK, "save  key: "+key+" success! ")
 }
 
+// Append handle append data to value request.
+func Append(w http.ResponseWriter, req *http.Request) {
+	if !db.GetEngine().IsMaster() {
+		akhttp.WriteResponse(w, http.StatusUnauthorized, "sorry this akita node isn't master node! ")
+		return
+	}
+	key := req.FormValue("key")
+	if key == "" {
+		akhttp.WriteResponse(w, http.StatusBadRequest, "key can not be empty! ")
+		return
+	}
+	if len(common.StringToByteSlice(key)) > 10*consts.K {
+		akhttp.WriteResponse(w, http.StatusBadRequest, errors.ErrKeySize)
+		return
+	}
+	if !allowed(w, req, key, auth.PermWrite) {
+		return
+	}
+	_, file, err := req.FormFile("file")
+	if file == nil {
+		akhttp.WriteResponse(w, http.StatusBadRequest, "file can not be empty! ")
+		return
+	}
+	if err != nil {
+		logger.Errorf("Get form file fail: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if file.Size > 64*consts.M {
+		logger.Errorf("Upload file too large: %v", file.Size)
+		akhttp.WriteResponse(w, http.StatusBadRequest, "file is too large to save. ")
+		return
+	}
+	src, err := file.Open()
+	if err != nil {
+		logger.Errorf("File open fail: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer src.Close()
+	data, err := ioutil.ReadAll(src)
+	if err != nil {
+		logger.Errorf("File read fail: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	length, lsn, err := db.GetEngine().Append(key, data)
+	if err == errors.ErrQuotaExceeded {
+		akhttp.WriteResponse(w, http.StatusInsufficientStorage, err.Error())
+		return
+	}
+	if err == errors.ErrValueSize {
+		akhttp.WriteResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		logger.Errorf("Append key %v fail: %v", key, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !confirmWrite(w, lsn, false) {
+		return
+	}
+	akhttp.WriteResponse(w, http.StatusOK, length)
+}
+
+// Incr handle add delta to integer value request.
+func Incr(w http.ResponseWriter, req *http.Request) {
+	if !db.GetEngine().IsMaster() {
+		akhttp.WriteResponse(w, http.StatusUnauthorized, "sorry this akita node isn't master node! ")
+		return
+	}
+	key := req.FormValue("key")
+	if key == "" {
+		akhttp.WriteResponse(w, http.StatusBadRequest, "key can not be empty! ")
+		return
+	}
+	if len(common.StringToByteSlice(key)) > 10*consts.K {
+		akhttp.WriteResponse(w, http.StatusBadRequest, errors.ErrKeySize)
+		return
+	}
+	if !allowed(w, req, key, auth.PermWrite) {
+		return
+	}
+	delta := int64(1)
+	if d := req.FormValue("delta"); d != "" {
+		var err error
+		if delta, err = strconv.ParseInt(d, 10, 64); err != nil {
+			akhttp.WriteResponse(w, http.StatusBadRequest, "delta must be an integer! ")
+			return
+		}
+	}
+	n, lsn, err := db.GetEngine().Incr(key, delta)
+	if err == errors.ErrValueNotInteger || err == errors.ErrIncrOverflow {
+		akhttp.WriteResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err == errors.ErrQuotaExceeded {
+		akhttp.WriteResponse(w, http.StatusInsufficientStorage, err.Error())
+		return
+	}
+	if err != nil {
+		logger.Errorf("Incr key %v fail: %v", key, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !confirmWrite(w, lsn, false) {
+		return
+	}
+	akhttp.WriteResponse(w, http.StatusOK, n)
+}
+
+// Digest handle request asking whether a value with the sha256 digest is stored, for a save to the namespace
+// form value, the default namespace if it is empty. The token of the request needs read permission on the namespace.
+func Digest(w http.ResponseWriter, req *http.Request) {
+	digest, err := hex.DecodeString(req.URL.Query().Get("sha256"))
+	if err != nil || len(digest) != consts.LengthDigest {
+		akhttp.WriteResponse(w, http.StatusBadRequest, errors.ErrDigestSize.Error())
+		return
+	}
+	var prefix string
+	if namespace := req.URL.Query().Get("namespace"); namespace != "" {
+		prefix = namespace + db.NamespaceSeparator
+	}
+	if !allowed(w, req, prefix, auth.PermRead) {
+		return
+	}
+	akhttp.WriteResponse(w, http.StatusOK, db.GetEngine().HasDigest(digest))
+}
+
 // Search handle get data request.
 func Search(w http.ResponseWriter, req *http.Request) {
-	key := req.URL.Query()["key"][0]
+	signed := auth.IsSigned(req)
+	if signed && !checkSignature(w, req) {
+		return
+	}
+	key := req.URL.Query().Get("key")
 	if key == "" {
 		akhttp.WriteResponse(w, http.StatusOK, "key can not be empty!  ")
 		return
 	}
+	if !signed && !allowed(w, req, key, auth.PermRead) {
+		return
+	}
+	o, err := imaging.ParseOptions(req.URL.Query())
+	if err != nil {
+		akhttp.WriteResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if o != nil {
+		searchTransformed(w, req, key, o)
+		return
+	}
 	value, err := db.GetEngine().Seek(key)
 	if err != nil {
 		logger.Errorf("Seek key %v error %v", key, err)
@@ -79,23 +294,159 @@ func Search(w http.ResponseWriter, req *http.Request) {
 	akhttp.WriteResponseWithContextType(w, http.StatusOK, "image/jpeg", value)
 }
 
+// MultiSave handle insert several data request, the i-th key form value is saved with the i-th file.
+func MultiSave(w http.ResponseWriter, req *http.Request) {
+	if !db.GetEngine().IsMaster() {
+		akhttp.WriteResponse(w, http.StatusUnauthorized, "sorry this akita node isn't master node! ")
+		return
+	}
+	if err := req.ParseMultipartForm(multiMaxSize); err != nil {
+		akhttp.WriteResponse(w, http.StatusBadRequest, "request must be a multipart form! ")
+		return
+	}
+	keys, files := req.MultipartForm.Value["key"], req.MultipartForm.File["file"]
+	if len(keys) == 0 || len(keys) != len(files) {
+		akhttp.WriteResponse(w, http.StatusBadRequest, "every key needs exactly one file! ")
+		return
+	}
+	if len(keys) > multiMaxKeys {
+		akhttp.WriteResponse(w, http.StatusBadRequest, "too many keys in one request. ")
+		return
+	}
+
+	var total int64
+	values, metas := make([][]byte, len(keys)), make([]*imaging.Metadata, len(keys))
+	for i, key := range keys {
+		if key == "" {
+			akhttp.WriteResponse(w, http.StatusBadRequest, "key can not be empty! ")
+			return
+		}
+		if len(common.StringToByteSlice(key)) > 10*consts.K {
+			akhttp.WriteResponse(w, http.StatusBadRequest, errors.ErrKeySize)
+			return
+		}
+		if !allowed(w, req, key, auth.PermWrite) {
+			return
+		}
+		if total += files[i].Size; total > multiMaxSize {
+			logger.Errorf("Upload files too large: %v", total)
+			akhttp.WriteResponse(w, http.StatusBadRequest, "files are too large to save. ")
+			return
+		}
+		src, err := files[i].Open()
+		if err != nil {
+			logger.Errorf("File open fail: %s", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		values[i], err = ioutil.ReadAll(src)
+		src.Close()
+		if err != nil {
+			logger.Errorf("File read fail: %s", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if values[i], metas[i], err = prepareUpload(key, values[i]); err != nil {
+			akhttp.WriteResponse(w, http.StatusBadRequest, "file of key "+key+": "+err.Error())
+			return
+		}
+	}
+	attrs := make([]db.Attrs, len(keys))
+	for i, meta := range metas {
+		attrs[i] = metadataAttrs(meta)
+	}
+	lsn, err := db.GetEngine().BatchInsertWithAttrs(keys, values, attrs)
+	if err == errors.ErrQuotaExceeded {
+		akhttp.WriteResponse(w, http.StatusInsufficientStorage, err.Error())
+		return
+	}
+	if err != nil {
+		logger.Errorf("File save %d keys fail: %v", len(keys), err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !confirmWrite(w, lsn, false) {
+		return
+	}
+	akhttp.WriteResponse(w, http.StatusOK, keys)
+}
+
+// MultiSearch handle get several data request, values are returned as multipart/mixed parts
+// carrying their key and status in X-Akita-Key and X-Akita-Status headers.
+func MultiSearch(w http.ResponseWriter, req *http.Request) {
+	keys := req.URL.Query()["key"]
+	if len(keys) == 0 {
+		akhttp.WriteResponse(w, http.StatusBadRequest, "key can not be empty!  ")
+		return
+	}
+	if len(keys) > multiMaxKeys {
+		akhttp.WriteResponse(w, http.StatusBadRequest, "too many keys in one request. ")
+		return
+	}
+	denied := make([]bool, len(keys))
+	for i, key := range keys {
+		ok, err := acls.Allowed(auth.TokenInfoFrom(req.Context()), key, auth.PermRead)
+		if err != nil {
+			logger.Errorf("Check acl of key %v error %v", key, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		denied[i] = !ok
+	}
+	values, errs := db.GetEngine().BatchSeek(keys)
+
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	w.WriteHeader(http.StatusOK)
+	for i, key := range keys {
+		status := http.StatusOK
+		if denied[i] {
+			status, values[i] = http.StatusForbidden, nil
+		} else if errs[i] != nil {
+			status = http.StatusInternalServerError
+		} else if values[i] == nil {
+			status = http.StatusNotFound
+		}
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", "image/jpeg")
+		header.Set("X-Akita-Key", key)
+		header.Set("X-Akita-Status", strconv.Itoa(status))
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			logger.Errorf("Failed to write http response %v", err)
+			return
+		}
+		if _, err = part.Write(values[i]); err != nil {
+			logger.Errorf("Failed to write http response %v", err)
+			return
+		}
+	}
+	mw.Close()
+}
+
 // Del handle delete data request.
 func Del(w http.ResponseWriter, req *http.Request) {
 	if !db.GetEngine().IsMaster() {
 		akhttp.WriteResponse(w, http.StatusUnauthorized, "sorry this akita node isn't master node! ")
 		return
 	}
-	key := req.URL.Query()["key"][0]
+	key := req.URL.Query().Get("key")
 	if key == "" {
 		akhttp.WriteResponse(w, http.StatusOK, "key can not be empty!  ")
 		return
 	}
-	_, delOffset, err := db.GetEngine().Delete(key)
+	if !allowed(w, req, key, auth.PermDelete) {
+		return
+	}
+	deleted, delOffset, lsn, err := db.GetEngine().Delete(key)
 	if err != nil {
 		logger.Errorf("Delete key %v fail: %v", key, err)
 		akhttp.WriteResponse(w, http.StatusInternalServerError, "delete key: "+key+" fail: "+err.Error())
 		return
 	}
+	if deleted && !confirmWrite(w, lsn, false) {
+		return
+	}
 	akhttp.WriteResponse(w, http.StatusOK, delOffset)
 }
 
@@ -105,6 +456,160 @@ func Sync(w http.ResponseWriter, req *http.Request) {
 		akhttp.WriteResponse(w, http.StatusUnauthorized, "sorry this akita node isn't master node! ")
 		return
 	}
+	syncOffset, ok := readSyncOffset(w, req)
+	if !ok {
+		return
+	}
+
+	e := db.GetEngine()
+	nodeID := syncNodeID(req, syncOffset)
+	data, more, err := e.SyncDataAfter(syncOffset.Lsn, syncOffset.LegacyOffset)
+	if err == nil || err == errors.ErrNoDataUpdate {
+		// the slave asks for the records after the ones it wrote, so it has written those up to lsn
+		e.AckSync(nodeID, remoteIP(req), syncOffset.Lsn)
+	}
+	if err == errors.ErrNoDataUpdate {
+		// a slave which caught up waits a while for the next write
+		notifier := make(chan struct{})
+		e.Register(nodeID, notifier)
+		select {
+		case <-time.After(1000 * time.Millisecond):
+			e.Unregister(nodeID, notifier)
+		case <-notifier:
+			data, more, err = e.SyncDataAfter(syncOffset.Lsn, syncOffset.LegacyOffset)
+		}
+	}
+	syncData := &pb.SyncData{}
+	switch err {
+	case nil:
+		syncData.Code = 1
+		syncData.Data = data
+		syncData.More = more
+		logger.Infof("the data length is %d", len(data))
+	case errors.ErrNoDataUpdate:
+	case errors.ErrSyncPositionLost:
+		logger.Errorf("slave %v syncs from lsn %d: %v", req.RemoteAddr, syncOffset.Lsn, err)
+		akhttp.WriteResponse(w, http.StatusGone, err.Error())
+		return
+	default:
+		logger.Errorf("get data after lsn error :%v", err)
+	}
+	protoData, _ := proto.Marshal(syncData)
+	// use protobuf format to transport data
+	akhttp.WriteBytes(w, http.StatusOK, "application/protobuf", protoData)
+}
+
+// SyncStream deal with slaves sync stream request, the records after the lsn of the slave are pushed
+// through the response as they are written, as frames of a length and a SyncData.
+func SyncStream(w http.ResponseWriter, req *http.Request) {
+	if !db.GetEngine().IsMaster() {
+		akhttp.WriteResponse(w, http.StatusUnauthorized, "sorry this akita node isn't master node! ")
+		return
+	}
+	syncOffset, ok := readSyncOffset(w, req)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		akhttp.WriteResponse(w, http.StatusInternalServerError, "streaming is not supported! ")
+		return
+	}
+	e := db.GetEngine()
+	if err := e.CheckSyncPosition(syncOffset.Lsn, syncOffset.LegacyOffset); err != nil {
+		logger.Errorf("slave %v syncs from lsn %d: %v", req.RemoteAddr, syncOffset.Lsn, err)
+		akhttp.WriteResponse(w, http.StatusGone, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", db.SyncStreamContentType)
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	nodeID := syncNodeID(req, syncOffset)
+	e.AckSync(nodeID, remoteIP(req), syncOffset.Lsn)
+	logger.Infof("slave %v streams from lsn %d", nodeID, syncOffset.Lsn)
+	err := e.StreamSyncData(nodeID, syncOffset.Lsn, syncOffset.LegacyOffset, req.Context().Done(), func(syncData *pb.SyncData) error {
+		if err := db.WriteSyncFrame(w, syncData); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+	// the slave opens the stream again and is told if it must be bootstrapped
+	logger.Infof("sync stream of slave %v closed: %v", nodeID, err)
+}
+
+// SyncSnapshot deal with slaves snapshot request, a point-in-time copy of the live records ending with a checkpoint
+// of its lsn is sent as frames of a length and a SyncData, the More of the last one is false.
+func SyncSnapshot(w http.ResponseWriter, req *http.Request) {
+	if !db.GetEngine().IsMaster() {
+		akhttp.WriteResponse(w, http.StatusUnauthorized, "sorry this akita node isn't master node! ")
+		return
+	}
+	syncOffset, ok := readSyncOffset(w, req)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		akhttp.WriteResponse(w, http.StatusInternalServerError, "streaming is not supported! ")
+		return
+	}
+	w.Header().Set("Content-Type", db.SyncStreamContentType)
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	logger.Infof("slave %v bootstraps from a snapshot", syncNodeID(req, syncOffset))
+	err := db.GetEngine().SendSnapshot(func(syncData *pb.SyncData) error {
+		if err := db.WriteSyncFrame(w, syncData); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+	if err != nil {
+		// the slave sees the snapshot end before its last frame and asks again
+		logger.Errorf("send snapshot to slave %v error: %v", syncNodeID(req, syncOffset), err)
+	}
+}
+
+// SyncAck deal with slaves acknowledging the records they wrote from a sync stream.
+func SyncAck(w http.ResponseWriter, req *http.Request) {
+	if !db.GetEngine().IsMaster() {
+		akhttp.WriteResponse(w, http.StatusUnauthorized, "sorry this akita node isn't master node! ")
+		return
+	}
+	syncOffset, ok := readSyncOffset(w, req)
+	if !ok {
+		return
+	}
+	if err := db.GetEngine().AckSync(syncNodeID(req, syncOffset), remoteIP(req), syncOffset.Lsn); err != nil {
+		logger.Errorf("slave %v acknowledges lsn %d: %v", req.RemoteAddr, syncOffset.Lsn, err)
+		akhttp.WriteResponse(w, http.StatusGone, err.Error())
+		return
+	}
+	akhttp.WriteResponse(w, http.StatusOK, syncOffset.Lsn)
+}
+
+// syncNodeID return the node id of the slave, slaves of older versions are told apart by their ip, the port
+// changes whenever they connect again.
+func syncNodeID(req *http.Request, syncOffset *pb.SyncOffset) string {
+	if syncOffset.NodeId != "" {
+		return syncOffset.NodeId
+	}
+	return remoteIP(req)
+}
+
+// remoteIP return the ip of the client of req.
+func remoteIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// readSyncOffset read the sync position of a slave from the request body.
+func readSyncOffset(w http.ResponseWriter, req *http.Request) (*pb.SyncOffset, bool) {
 	reqBody := req.Body
 	defer reqBody.Close()
 
@@ -112,65 +617,15 @@ func Sync(w http.ResponseWriter, req *http.Request) {
 	if err != nil {
 		logger.Errorf("Read http body error: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return nil, false
 	}
 	syncOffset := &pb.SyncOffset{}
 	err = proto.Unmarshal(offsetBuf, syncOffset)
 	if err != nil {
 		logger.Errorf("proto data unmarshal error: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	logger.Infof("request offset is %d", syncOffset.Offset)
-
-	complete := make(chan error)
-	dataCh := make(chan []byte)
-	go func() {
-		data, err := db.GetEngine().GetDB().GetDataByOffset(syncOffset.Offset)
-		dataCh <- data
-		complete <- err
-	}()
-	data := <-dataCh
-	err = <-complete
-
-	syncData := &pb.SyncData{}
-	if err != nil {
-		if err == errors.ErrNoDataUpdate {
-			notifier := make(chan struct{})
-			db.GetEngine().Register(req.Host, notifier)
-			select {
-			case <-time.After(1000 * time.Millisecond):
-				syncData.Code = 0
-				syncData.Data = nil
-			case <-notifier:
-				go func() {
-					data, err := db.GetEngine().GetDB().GetDataByOffset(syncOffset.Offset)
-					dataCh <- data
-					complete <- err
-				}()
-				data = <-dataCh
-				err = <-complete
-
-				logger.Infof("the data length is %d", len(data))
-				if err != nil {
-					logger.Errorf("get data by offset error :%s", err)
-					syncData.Code = 0
-					syncData.Data = nil
-				}
-				syncData.Code = 1
-				syncData.Data = data
-			}
-		} else {
-			logger.Errorf("get data by offset error :%v", err)
-			syncData.Code = 0
-			syncData.Data = nil
-		}
-	} else {
-		syncData.Code = 1
-		syncData.Data = data
-		logger.Infof("the data length is %d", len(data))
+		return nil, false
 	}
-	protoData, _ := proto.Marshal(syncData)
-	// use protobuf format to transport data
-	akhttp.WriteResponseWithContextType(w, http.StatusOK, "application/protobuf", protoData)
+	logger.Infof("request lsn is %d", syncOffset.Lsn)
+	return syncOffset, true
 }
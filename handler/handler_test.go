@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"akita/common"
+	"akita/db"
+	"bytes"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openTestEngine initialize the engine over an empty data file, as master.
+func openTestEngine(t *testing.T) *db.Engine {
+	return openTestEngineWithDedup(t, false)
+}
+
+// openTestEngineWithDedup is like openTestEngine, identical values are stored once if dedup is true.
+func openTestEngineWithDedup(t *testing.T, dedup bool) *db.Engine {
+	return initTestEngine(t, true, dedup)
+}
+
+// openUncachedTestEngine is like openTestEngine, values are always read from the data file.
+func openUncachedTestEngine(t *testing.T) *db.Engine {
+	return initTestEngine(t, false, false)
+}
+
+func initTestEngine(t *testing.T, useCache bool, dedup bool) *db.Engine {
+	fPath := filepath.Join(t.TempDir(), "akita.dat")
+	f, err := os.Create(fPath)
+	if err != nil {
+		t.Fatalf("create data file error: %s.\n", err)
+	}
+	f.Close()
+	masterAddr, err := common.GetIntranetIP()
+	if err != nil {
+		t.Fatalf("get intranet ip error: %s.\n", err)
+	}
+	db.InitializeEngine(masterAddr, nil, "0", fPath, useCache, 100, dedup)
+	e := db.GetEngine()
+	e.StartBackground(1000, int64(time.Hour/time.Millisecond))
+	t.Cleanup(func() { e.GetDB().Close() })
+	return e
+}
+
+// serve send req to h and return the recorded response.
+func serve(h http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h(w, req)
+	return w
+}
+
+// testPNG return a png image of width w and height h.
+func testPNG(t *testing.T, w, h int) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatalf("encode test image error: %s.\n", err)
+	}
+	return buf.Bytes()
+}
+
+// saveRequest return a save request to target with a multipart form of key, if not empty, and file.
+func saveRequest(t *testing.T, target string, key string, file []byte) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if key != "" {
+		if err := mw.WriteField("key", key); err != nil {
+			t.Fatalf("write key field error: %s.\n", err)
+		}
+	}
+	fw, err := mw.CreateFormFile("file", "file")
+	if err != nil {
+		t.Fatalf("create form file error: %s.\n", err)
+	}
+	fw.Write(file)
+	mw.Close()
+	req := httptest.NewRequest(http.MethodPost, target, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
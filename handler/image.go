@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"akita/common"
+	"akita/consts"
+	"akita/db"
+	akerrors "akita/errors"
+	akhttp "akita/http"
+	"akita/imaging"
+	"akita/logger"
+	"encoding/binary"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// variantsNamespace holds persisted transformed images, variant o of key k is stored as _variants/k?o,
+	// its value is the version of k it was made from followed by the image.
+	variantsNamespace = "_variants"
+	variantTTL        = 30 * 24 * time.Hour // persisted variants of deleted or rewritten keys expire
+	lengthVersion     = 8
+
+	defaultImageCacheSize = 64 * consts.M
+)
+
+// imageTransforms makes, caches and optionally persists transformed images.
+type imageTransforms struct {
+	limits  imaging.Limits
+	cache   *imaging.Cache
+	presets map[string]bool // canonical options of the variants which are persisted
+	running chan struct{}   // holds a token for every running transform
+}
+
+var transforms = newImageTransforms(imaging.DefaultLimits, defaultImageCacheSize, nil)
+
+func newImageTransforms(limits imaging.Limits, cacheSize int64, presets map[string]bool) *imageTransforms {
+	return &imageTransforms{
+		limits:  limits,
+		cache:   imaging.NewCache(cacheSize),
+		presets: presets,
+		running: make(chan struct{}, limits.MaxConcurrent),
+	}
+}
+
+// UseImageTransforms set the limits of image transforms, the size in bytes of the cache of transformed
+// images and the presets whose variants master persists as keys, so that they survive restarts and are replicated.
+// A preset is a query of transform parameters such as "w=320&format=png". Only presets are persisted,
+// otherwise every request with new parameters would store another key.
+func UseImageTransforms(limits imaging.Limits, cacheSize int64, presets []string) error {
+	persisted := make(map[string]bool)
+	for _, preset := range presets {
+		query, err := url.ParseQuery(preset)
+		if err != nil {
+			return akerrors.ErrImageOptions
+		}
+		o, err := imaging.ParseOptions(query)
+		if err != nil {
+			return err
+		}
+		if o == nil {
+			return akerrors.ErrImageOptions
+		}
+		persisted[o.String()] = true
+	}
+	transforms = newImageTransforms(limits, cacheSize, persisted)
+	return nil
+}
+
+// searchTransformed write the image of key transformed with o as it is, not json encoded as plain search results.
+// Variants are made once per version of key.
+func searchTransformed(w http.ResponseWriter, req *http.Request, key string, o *imaging.Options) {
+	e := db.GetEngine()
+	version, ok := e.Version(key)
+	if !ok {
+		akhttp.WriteResponse(w, http.StatusNotFound, "key "+key+" not found! ")
+		return
+	}
+	t := transforms
+	cacheKey := key + "@" + strconv.FormatUint(version, 10) + "?" + o.String()
+	if value, contentType, ok := t.cache.Get(cacheKey); ok {
+		akhttp.WriteBytes(w, http.StatusOK, contentType, value)
+		return
+	}
+	variantKey := variantsNamespace + db.NamespaceSeparator + key + "?" + o.String()
+	persist := t.presets[o.String()]
+	if persist {
+		variant, err := e.Seek(variantKey)
+		if err != nil {
+			logger.Errorf("Seek variant %v error %v", variantKey, err)
+		}
+		if len(variant) > lengthVersion && binary.BigEndian.Uint64(variant) == version {
+			value := variant[lengthVersion:]
+			contentType := http.DetectContentType(value)
+			t.cache.Add(cacheKey, value, contentType)
+			akhttp.WriteBytes(w, http.StatusOK, contentType, value)
+			return
+		}
+	}
+
+	select {
+	case t.running <- struct{}{}:
+		defer func() { <-t.running }()
+	case <-req.Context().Done():
+		return
+	}
+	source, version, err := e.SeekWithVersion(key)
+	if err != nil {
+		logger.Errorf("Seek key %v error %v", key, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if source == nil {
+		akhttp.WriteResponse(w, http.StatusNotFound, "key "+key+" not found! ")
+		return
+	}
+	value, contentType, err := imaging.Transform(source, o, t.limits)
+	switch err {
+	case nil:
+	case akerrors.ErrImageOptions, akerrors.ErrImageTooLarge:
+		akhttp.WriteResponse(w, http.StatusBadRequest, err.Error())
+		return
+	case akerrors.ErrImageFormat:
+		akhttp.WriteResponse(w, http.StatusUnsupportedMediaType, err.Error())
+		return
+	default:
+		logger.Errorf("Transform key %v error %v", key, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	t.cache.Add(key+"@"+strconv.FormatUint(version, 10)+"?"+o.String(), value, contentType)
+	if persist && e.IsMaster() && len(common.StringToByteSlice(variantKey)) <= 10*consts.K {
+		variant := make([]byte, lengthVersion+len(value))
+		binary.BigEndian.PutUint64(variant, version)
+		copy(variant[lengthVersion:], value)
+		if _, err := e.PutWithExpire(variantKey, variant, time.Now().Add(variantTTL).Unix()); err != nil {
+			logger.Errorf("Insert variant %v error %v", variantKey, err)
+		}
+	}
+	akhttp.WriteBytes(w, http.StatusOK, contentType, value)
+}
@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"akita/imaging"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_PersistPresets(t *testing.T) {
+	e := openTestEngine(t)
+	if err := e.Put("img/a.png", testPNG(t, 40, 20)); err != nil {
+		t.Fatalf("put error: %s.\n", err)
+	}
+	if err := UseImageTransforms(imaging.DefaultLimits, defaultImageCacheSize, []string{"w=10", "w=20&format=jpg"}); err != nil {
+		t.Fatalf("use image transforms error: %s.\n", err)
+	}
+	defer UseImageTransforms(imaging.DefaultLimits, defaultImageCacheSize, nil)
+
+	for _, c := range []struct {
+		query     string
+		persisted bool
+	}{
+		{"w=10", true},
+		{"format=jpeg&w=20", true},
+		{"w=11", false},
+	} {
+		w := serve(Search, httptest.NewRequest(http.MethodGet, "/akita/search/?key=img/a.png&"+c.query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("search %s want 200, got %d %s.\n", c.query, w.Code, w.Body)
+		}
+		o, _ := imaging.ParseOptions(httptest.NewRequest(http.MethodGet, "/?"+c.query, nil).URL.Query())
+		if persisted := e.Exists(variantsNamespace + "/img/a.png?" + o.String()); persisted != c.persisted {
+			t.Fatalf("variant %s persisted %v, want %v.\n", c.query, persisted, c.persisted)
+		}
+	}
+
+	if err := UseImageTransforms(imaging.DefaultLimits, defaultImageCacheSize, []string{"w=x"}); err == nil {
+		t.Fatalf("use image transforms with a bad preset want error.\n")
+	}
+}
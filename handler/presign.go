@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"akita/auth"
+	akhttp "akita/http"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultPresignExpiry = time.Hour
+)
+
+// signer signs urls, presigned urls are turned off if it is nil.
+var signer *auth.Signer
+
+// presignPaths are the paths signed urls are issued for by method.
+var presignPaths = map[string]string{
+	http.MethodGet:  "/akita/search/",
+	http.MethodPost: "/akita/save/",
+}
+
+type presignedURL struct {
+	Method  string `json:"method"`
+	URL     string `json:"url"`
+	Expires int64  `json:"expires"`
+}
+
+// UsePresign turn on presigned urls signed by s.
+func UsePresign(s *auth.Signer) {
+	signer = s
+}
+
+// Presign handle issue signed url request, the url grants method GET (search) or POST (save, a multipart
+// form with the file) on key for expires_in seconds.
+func Presign(w http.ResponseWriter, req *http.Request) {
+	if signer == nil {
+		akhttp.WriteResponse(w, http.StatusBadRequest, "presigned urls are not turned on! ")
+		return
+	}
+	query := req.URL.Query()
+	key := query.Get("key")
+	if key == "" {
+		akhttp.WriteResponse(w, http.StatusBadRequest, "key can not be empty! ")
+		return
+	}
+	method := query.Get("method")
+	if method == "" {
+		method = http.MethodGet
+	}
+	path, ok := presignPaths[method]
+	if !ok {
+		akhttp.WriteResponse(w, http.StatusBadRequest, "method must be GET or POST! ")
+		return
+	}
+	// the url grants what the acl grants its issuer
+	perm := auth.PermRead
+	if method == http.MethodPost {
+		perm = auth.PermWrite
+	}
+	if !allowed(w, req, key, perm) {
+		return
+	}
+	expiry := defaultPresignExpiry
+	if s := query.Get("expires_in"); s != "" {
+		seconds, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || seconds <= 0 || time.Duration(seconds)*time.Second > auth.MaxPresignExpiry {
+			akhttp.WriteResponse(w, http.StatusBadRequest, "expires_in must be between 1 and "+
+				strconv.FormatInt(int64(auth.MaxPresignExpiry/time.Second), 10)+" seconds! ")
+			return
+		}
+		expiry = time.Duration(seconds) * time.Second
+	}
+	expires := time.Now().Add(expiry)
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	akhttp.WriteResponse(w, http.StatusOK, &presignedURL{
+		Method:  method,
+		URL:     scheme + "://" + req.Host + path + "?" + signer.Sign(method, path, key, expires).Encode(),
+		Expires: expires.Unix(),
+	})
+}
+
+// checkSignature verify the signature of a signed request, write the error response and return false
+// if it is not valid.
+func checkSignature(w http.ResponseWriter, req *http.Request) bool {
+	if signer == nil {
+		akhttp.WriteResponse(w, http.StatusForbidden, "presigned urls are not turned on! ")
+		return false
+	}
+	if err := signer.Verify(req); err != nil {
+		akhttp.WriteResponse(w, http.StatusForbidden, err.Error())
+		return false
+	}
+	return true
+}
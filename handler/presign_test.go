@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"akita/auth"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// useTestSigner turn on presigned urls until the test ends and return the signer.
+func useTestSigner(t *testing.T) *auth.Signer {
+	s := auth.NewSigner([]byte("0123456789abcdef0123456789abcdef"))
+	UsePresign(s)
+	t.Cleanup(func() { UsePresign(nil) })
+	return s
+}
+
+func Test_PresignSaveSearch(t *testing.T) {
+	e := openTestEngine(t)
+	s := useTestSigner(t)
+	// the signature grants the signed key whatever the acls grant
+	useTestACLs(t, e, "owner", "photos", auth.PermRead|auth.PermWrite)
+	expires := time.Now().Add(time.Minute)
+	saveURL := "/akita/save/?" + s.Sign(http.MethodPost, "/akita/save/", "photos/a", expires).Encode()
+	searchURL := "/akita/search/?" + s.Sign(http.MethodGet, "/akita/search/", "photos/a", expires).Encode()
+
+	if w := serve(Save, saveRequest(t, saveURL, "photos/b", []byte("value"))); w.Code != http.StatusForbidden {
+		t.Fatalf("signed save of another form key want 403, got %d %s.\n", w.Code, w.Body)
+	}
+	if w := serve(Save, saveRequest(t, saveURL, "", []byte("value"))); w.Code != http.StatusOK {
+		t.Fatalf("signed save want 200, got %d %s.\n", w.Code, w.Body)
+	}
+	if value, _ := e.Seek("photos/a"); string(value) != "value" {
+		t.Fatalf("value of signed save want value, got %s.\n", value)
+	}
+	if w := serve(Search, httptest.NewRequest(http.MethodGet, searchURL, nil)); w.Code != http.StatusOK {
+		t.Fatalf("signed search want 200, got %d %s.\n", w.Code, w.Body)
+	}
+	// a url signed for save does not grant search
+	signedSave := "/akita/search/?" + s.Sign(http.MethodPost, "/akita/save/", "photos/a", expires).Encode()
+	if w := serve(Search, httptest.NewRequest(http.MethodGet, signedSave, nil)); w.Code != http.StatusForbidden {
+		t.Fatalf("search signed for save want 403, got %d %s.\n", w.Code, w.Body)
+	}
+
+	tampered := s.Sign(http.MethodGet, "/akita/search/", "photos/a", expires)
+	tampered.Set("key", "photos/b")
+	if w := serve(Search, httptest.NewRequest(http.MethodGet, "/akita/search/?"+tampered.Encode(), nil)); w.Code != http.StatusForbidden {
+		t.Fatalf("search of a key not signed want 403, got %d %s.\n", w.Code, w.Body)
+	}
+	expired := s.Sign(http.MethodGet, "/akita/search/", "photos/a", time.Now().Add(-time.Minute))
+	if w := serve(Search, httptest.NewRequest(http.MethodGet, "/akita/search/?"+expired.Encode(), nil)); w.Code != http.StatusForbidden {
+		t.Fatalf("search signed until the past want 403, got %d %s.\n", w.Code, w.Body)
+	}
+
+	UsePresign(nil)
+	if w := serve(Search, httptest.NewRequest(http.MethodGet, searchURL, nil)); w.Code != http.StatusForbidden {
+		t.Fatalf("signed search with presigned urls turned off want 403, got %d %s.\n", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"akita/db"
+	akhttp "akita/http"
+	"akita/logger"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// namespaceQuota is the usage and quota of a namespace, quota is nil if the namespace has none.
+type namespaceQuota struct {
+	Namespace string    `json:"namespace"`
+	Usage     db.Usage  `json:"usage"`
+	Quota     *db.Quota `json:"quota"`
+}
+
+// Quotas handle namespace quota requests: GET lists the usage and quota of every namespace, or of the namespace
+// form value, POST sets the quota of namespace to the max_bytes and max_objects form values, 0 removing a limit.
+func Quotas(w http.ResponseWriter, req *http.Request) {
+	e := db.GetEngine()
+	switch req.Method {
+	case http.MethodGet:
+		quotas, err := e.Quotas()
+		if err != nil {
+			logger.Errorf("List quotas fail: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		usages := e.Usages()
+		if namespace := req.FormValue("namespace"); namespace != "" {
+			akhttp.WriteResponse(w, http.StatusOK, &namespaceQuota{
+				Namespace: namespace,
+				Usage:     usages[namespace],
+				Quota:     quotas[namespace],
+			})
+			return
+		}
+		namespaces := make([]string, 0, len(usages))
+		for namespace := range usages {
+			namespaces = append(namespaces, namespace)
+		}
+		for namespace := range quotas {
+			if _, ok := usages[namespace]; !ok {
+				namespaces = append(namespaces, namespace)
+			}
+		}
+		sort.Strings(namespaces)
+		result := make([]*namespaceQuota, len(namespaces))
+		for i, namespace := range namespaces {
+			result[i] = &namespaceQuota{
+				Namespace: namespace,
+				Usage:     usages[namespace],
+				Quota:     quotas[namespace],
+			}
+		}
+		akhttp.WriteResponse(w, http.StatusOK, result)
+	case http.MethodPost:
+		if !e.IsMaster() {
+			akhttp.WriteResponse(w, http.StatusUnauthorized, "sorry this akita node isn't master node! ")
+			return
+		}
+		namespace := req.FormValue("namespace")
+		if namespace == "" {
+			akhttp.WriteResponse(w, http.StatusBadRequest, "namespace can not be empty! ")
+			return
+		}
+		var q db.Quota
+		var err error
+		if q.MaxBytes, err = formInt(req, "max_bytes"); err != nil {
+			akhttp.WriteResponse(w, http.StatusBadRequest, "max_bytes must be a non-negative integer! ")
+			return
+		}
+		if q.MaxObjects, err = formInt(req, "max_objects"); err != nil {
+			akhttp.WriteResponse(w, http.StatusBadRequest, "max_objects must be a non-negative integer! ")
+			return
+		}
+		if err := e.SetQuota(namespace, q); err != nil {
+			logger.Errorf("Set quota of namespace %v fail: %v", namespace, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		akhttp.WriteResponse(w, http.StatusOK, &namespaceQuota{
+			Namespace: namespace,
+			Usage:     e.Usage(namespace),
+			Quota:     &q,
+		})
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		akhttp.WriteResponse(w, http.StatusMethodNotAllowed, "method "+req.Method+" is not allowed! ")
+	}
+}
+
+// formInt return the non-negative integer form value name, 0 if it is empty.
+func formInt(req *http.Request, name string) (int64, error) {
+	s := req.FormValue(name)
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err == nil && n < 0 {
+		err = strconv.ErrRange
+	}
+	return n, err
+}
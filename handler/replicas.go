@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"akita/db"
+	akhttp "akita/http"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Replicas list the slaves of the master with their acknowledged position, lag and last contact.
+func Replicas(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		akhttp.WriteResponse(w, http.StatusMethodNotAllowed, "method "+req.Method+" is not allowed! ")
+		return
+	}
+	e := db.GetEngine()
+	if !e.IsMaster() {
+		akhttp.WriteResponse(w, http.StatusUnauthorized, "sorry this akita node isn't master node! ")
+		return
+	}
+	akhttp.WriteResponse(w, http.StatusOK, e.Replicas())
+}
+
+// Metrics write the replication metrics in the prometheus text format, a slave writes its lsn only.
+func Metrics(w http.ResponseWriter, req *http.Request) {
+	e := db.GetEngine()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	writeMetric(w, "akita_lsn", "gauge", "Log sequence number of the last record written.")
+	fmt.Fprintf(w, "akita_lsn %d\n", e.GetDB().LSN())
+	if !e.IsMaster() {
+		return
+	}
+
+	replicas := e.Replicas()
+	now := time.Now().Unix()
+	gauges := []struct {
+		name  string
+		help  string
+		value func(r *db.Replica) string
+	}{
+		{"akita_replica_ack_lsn", "Log sequence number of the last record the replica acknowledged.", func(r *db.Replica) string {
+			return strconv.FormatInt(r.AckLSN, 10)
+		}},
+		{"akita_replica_lag_records", "Records the replica has not acknowledged.", func(r *db.Replica) string {
+			return strconv.FormatInt(r.LagRecords, 10)
+		}},
+		{"akita_replica_lag_bytes", "Size of the records the replica has not acknowledged.", func(r *db.Replica) string {
+			return strconv.FormatInt(r.LagBytes, 10)
+		}},
+		{"akita_replica_lag_seconds", "Age of the oldest record the replica has not acknowledged.", func(r *db.Replica) string {
+			return strconv.FormatFloat(r.LagSeconds, 'f', -1, 64)
+		}},
+		{"akita_replica_last_contact_seconds", "Seconds since the last request of the replica.", func(r *db.Replica) string {
+			return strconv.FormatInt(now-r.LastContact, 10)
+		}},
+		{"akita_replica_streaming", "Whether the replica has a sync stream open.", func(r *db.Replica) string {
+			if r.Streaming {
+				return "1"
+			}
+			return "0"
+		}},
+	}
+	for _, g := range gauges {
+		writeMetric(w, g.name, "gauge", g.help)
+		for _, r := range replicas {
+			// configured slaves which never synced have no metrics
+			if r.NodeID == "" {
+				continue
+			}
+			fmt.Fprintf(w, "%s{node=%q,addr=%q} %s\n", g.name, r.NodeID, r.Addr, g.value(r))
+		}
+	}
+	writeMetric(w, "akita_replicas_missing", "gauge", "Configured slaves which did not sync since the master started.")
+	missing := 0
+	for _, r := range replicas {
+		if r.NodeID == "" {
+			missing++
+		}
+	}
+	fmt.Fprintf(w, "akita_replicas_missing %d\n", missing)
+}
+
+// writeMetric write the help and type lines of a metric.
+func writeMetric(w http.ResponseWriter, name string, kind string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// confirmWrite wait for the write quorum to acknowledge the write at lsn and tell how many replicas did in
+// the X-Akita-Replicas header. If too few did in time the error is written, as json error envelope if v2,
+// and false returned.
+func confirmWrite(w http.ResponseWriter, lsn int64, v2 bool) bool {
+	n, err := db.GetEngine().WaitQuorum(lsn)
+	w.Header().Set(akhttp.ReplicasHeader, strconv.Itoa(n))
+	if err == nil {
+		return true
+	}
+	if v2 {
+		akhttp.WriteError(w, http.StatusGatewayTimeout, akhttp.ErrCodeQuorumTimeout, err.Error())
+	} else {
+		akhttp.WriteResponse(w, http.StatusGatewayTimeout, err.Error())
+	}
+	return false
+}
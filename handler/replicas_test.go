@@ -0,0 +1,121 @@
+package handler
+
+import (
+	akhttp "akita/http"
+	"akita/pb"
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// syncRequest return a sync request of the slave node1 at lsn to path.
+func syncRequest(t *testing.T, path string, lsn int64) *http.Request {
+	body, err := proto.Marshal(&pb.SyncOffset{Lsn: lsn, NodeId: "node1"})
+	if err != nil {
+		t.Fatalf("marshal sync offset error: %s.\n", err)
+	}
+	return httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+}
+
+func Test_SyncAck(t *testing.T) {
+	e := openTestEngine(t)
+	if err := e.Put("k", []byte("v")); err != nil {
+		t.Fatalf("put error: %s.\n", err)
+	}
+	lsn := e.GetDB().LSN()
+
+	// a slave ahead of the master is told to bootstrap and is not a replica of the records it claims
+	if w := serve(Sync, syncRequest(t, "/akita/sync/", lsn+1)); w.Code != http.StatusGone {
+		t.Fatalf("sync after the last record want 410, got %d.\n", w.Code)
+	}
+	if w := serve(SyncAck, syncRequest(t, "/akita/sync/ack/", lsn+1)); w.Code != http.StatusGone {
+		t.Fatalf("ack of a record the master did not write want 410, got %d.\n", w.Code)
+	}
+	if replicas := e.Replicas(); len(replicas) != 0 {
+		t.Fatalf("replicas after rejected acks want none, got %+v.\n", replicas[0])
+	}
+
+	if w := serve(SyncAck, syncRequest(t, "/akita/sync/ack/", lsn)); w.Code != http.StatusOK {
+		t.Fatalf("ack of the last record want 200, got %d.\n", w.Code)
+	}
+	if replicas := e.Replicas(); len(replicas) != 1 || replicas[0].AckLSN != lsn {
+		t.Fatalf("replicas after ack want node1 at lsn %d, got %+v.\n", lsn, replicas)
+	}
+}
+
+func Test_SyncNodeID(t *testing.T) {
+	e := openTestEngine(t)
+	if err := e.Put("k", []byte("v")); err != nil {
+		t.Fatalf("put error: %s.\n", err)
+	}
+	lsn := e.GetDB().LSN()
+
+	// a slave of an older version sends no node id and connects from another port every time
+	for _, addr := range []string{"192.0.2.1:40001", "192.0.2.1:40002"} {
+		body, err := proto.Marshal(&pb.SyncOffset{Lsn: lsn})
+		if err != nil {
+			t.Fatalf("marshal sync offset error: %s.\n", err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/akita/sync/ack/", bytes.NewReader(body))
+		req.RemoteAddr = addr
+		if w := serve(SyncAck, req); w.Code != http.StatusOK {
+			t.Fatalf("ack from %s want 200, got %d.\n", addr, w.Code)
+		}
+	}
+	if replicas := e.Replicas(); len(replicas) != 1 || replicas[0].NodeID != "192.0.2.1" {
+		t.Fatalf("replicas of a slave without node id want one of its ip, got %+v.\n", replicas)
+	}
+}
+
+func Test_WriteQuorum(t *testing.T) {
+	e := openTestEngine(t)
+	e.UseWriteQuorum(1, 10*time.Millisecond, false)
+	put := func(key string) *httptest.ResponseRecorder {
+		return serve(KeysV2, httptest.NewRequest(http.MethodPut, KeysV2Prefix+key, strings.NewReader("value")))
+	}
+
+	// no slave acknowledges the writes
+	w := serve(Save, saveRequest(t, "/akita/save/", "a", []byte("value")))
+	if w.Code != http.StatusGatewayTimeout || w.Header().Get(akhttp.ReplicasHeader) != "0" {
+		t.Fatalf("save without replica want 504 and 0 replicas, got %d %q.\n", w.Code, w.Header().Get(akhttp.ReplicasHeader))
+	}
+	w = put("b")
+	if w.Code != http.StatusGatewayTimeout || w.Header().Get(akhttp.ReplicasHeader) != "0" {
+		t.Fatalf("v2 put without replica want 504 and 0 replicas, got %d %q.\n", w.Code, w.Header().Get(akhttp.ReplicasHeader))
+	}
+	if code := errorCode(t, w); code != akhttp.ErrCodeQuorumTimeout {
+		t.Fatalf("v2 put without replica want error code %s, got %s.\n", akhttp.ErrCodeQuorumTimeout, code)
+	}
+
+	// writes fall back to asynchronous replication
+	e.UseWriteQuorum(1, 10*time.Millisecond, true)
+	w = serve(Save, saveRequest(t, "/akita/save/", "c", []byte("value")))
+	if w.Code != http.StatusOK || w.Header().Get(akhttp.ReplicasHeader) != "0" {
+		t.Fatalf("async save without replica want 200 and 0 replicas, got %d %q.\n", w.Code, w.Header().Get(akhttp.ReplicasHeader))
+	}
+
+	// a slave acknowledges the next write
+	e.UseWriteQuorum(1, time.Second, false)
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- put("d") }()
+	// wait until the write is in the data file
+	deadline := time.Now().Add(time.Second)
+	for {
+		if value, _ := e.Seek("d"); value != nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if w := serve(SyncAck, syncRequest(t, "/akita/sync/ack/", e.GetDB().LSN())); w.Code != http.StatusOK {
+		t.Fatalf("ack of the last record want 200, got %d.\n", w.Code)
+	}
+	w = <-done
+	if w.Code != http.StatusNoContent || w.Header().Get(akhttp.ReplicasHeader) != "1" {
+		t.Fatalf("v2 put acknowledged by a replica want 204 and 1 replica, got %d %q.\n", w.Code, w.Header().Get(akhttp.ReplicasHeader))
+	}
+}
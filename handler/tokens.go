@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"akita/auth"
+	"akita/db"
+	akhttp "akita/http"
+	"akita/logger"
+	"net/http"
+)
+
+// tokens are the api tokens, authentication is turned off if it is nil.
+var tokens *auth.Tokens
+
+type createdToken struct {
+	Token string `json:"token"`
+	*auth.TokenInfo
+}
+
+// UseTokens turn on authentication by api tokens t.
+func UseTokens(t *auth.Tokens) {
+	tokens = t
+}
+
+// Tokens handle api token requests: GET lists tokens, POST creates a token with the name and scope form values
+// and returns it once, DELETE revokes the token of the id form value.
+func Tokens(w http.ResponseWriter, req *http.Request) {
+	if tokens == nil {
+		akhttp.WriteResponse(w, http.StatusBadRequest, "authentication is not turned on! ")
+		return
+	}
+	if req.Method == http.MethodGet {
+		infos, err := tokens.List()
+		if err != nil {
+			logger.Errorf("List tokens fail: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		akhttp.WriteResponse(w, http.StatusOK, infos)
+		return
+	}
+	if !db.GetEngine().IsMaster() {
+		akhttp.WriteResponse(w, http.StatusUnauthorized, "sorry this akita node isn't master node! ")
+		return
+	}
+	switch req.Method {
+	case http.MethodPost:
+		scope, err := auth.ParseScope(req.FormValue("scope"))
+		if err != nil {
+			akhttp.WriteResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		token, info, err := tokens.Create(req.FormValue("name"), scope)
+		if err != nil {
+			logger.Errorf("Create token fail: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		akhttp.WriteResponse(w, http.StatusOK, &createdToken{Token: token, TokenInfo: info})
+	case http.MethodDelete:
+		revoked, err := tokens.Revoke(req.FormValue("id"))
+		if err != nil {
+			logger.Errorf("Revoke token fail: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !revoked {
+			akhttp.WriteResponse(w, http.StatusNotFound, "no token with this id! ")
+			return
+		}
+		akhttp.WriteResponse(w, http.StatusOK, "revoke token success! ")
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		akhttp.WriteResponse(w, http.StatusMethodNotAllowed, "method "+req.Method+" is not allowed! ")
+	}
+}
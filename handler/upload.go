@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"akita/auth"
+	"akita/db"
+	akhttp "akita/http"
+	"akita/imaging"
+	"akita/logger"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// attributes of the values of uploaded images, see db.Attrs
+	contentTypeAttr = "image_content_type"
+	widthAttr       = "image_width"
+	heightAttr      = "image_height"
+)
+
+// uploadPolicy decides which uploaded images have their metadata blocks stripped before they are written.
+type uploadPolicy struct {
+	strip      bool
+	namespaces map[string]bool // namespaces to strip, every namespace if empty
+}
+
+var uploads = &uploadPolicy{}
+
+// UseMetadataStripping strip exif and other metadata blocks of jpeg and png images uploaded to namespaces,
+// or to every namespace if namespaces is empty.
+func UseMetadataStripping(namespaces []string) {
+	p := &uploadPolicy{
+		strip:      true,
+		namespaces: make(map[string]bool),
+	}
+	for _, ns := range namespaces {
+		if ns = strings.TrimSpace(ns); ns != "" {
+			p.namespaces[ns] = true
+		}
+	}
+	uploads = p
+}
+
+func (p *uploadPolicy) shouldStrip(key string) bool {
+	return p.strip && (len(p.namespaces) == 0 || p.namespaces[db.Namespace(key)])
+}
+
+// prepareUpload detect whether value is an image and strip its metadata blocks if the namespace of key asks
+// for it. It returns the value to write and the image metadata, which is nil for other values.
+func prepareUpload(key string, value []byte) ([]byte, *imaging.Metadata, error) {
+	meta := imaging.Inspect(value)
+	if meta == nil {
+		return value, nil, nil
+	}
+	if uploads.shouldStrip(key) {
+		stripped, err := imaging.StripMetadata(value)
+		if err != nil {
+			return nil, nil, err
+		}
+		value = stripped
+	}
+	return value, meta, nil
+}
+
+// metadataAttrs return the attributes meta is stored as with the value, nil if the value is no image.
+func metadataAttrs(meta *imaging.Metadata) db.Attrs {
+	if meta == nil {
+		return nil
+	}
+	return db.Attrs{
+		contentTypeAttr: meta.ContentType,
+		widthAttr:       strconv.Itoa(meta.Width),
+		heightAttr:      strconv.Itoa(meta.Height),
+	}
+}
+
+// Metadata handle get metadata of an image request, metadata is only known for images uploaded by save,
+// multi save or the v2 api.
+func Metadata(w http.ResponseWriter, req *http.Request) {
+	key := req.URL.Query().Get("key")
+	if key == "" {
+		akhttp.WriteResponse(w, http.StatusBadRequest, "key can not be empty! ")
+		return
+	}
+	if !allowed(w, req, key, auth.PermRead) {
+		return
+	}
+	attrs, err := db.GetEngine().Attrs(key)
+	if err != nil {
+		logger.Errorf("Seek metadata of key %v error %v", key, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	contentType, ok := attrs[contentTypeAttr]
+	if !ok {
+		akhttp.WriteResponse(w, http.StatusNotFound, "no metadata of key "+key+"! ")
+		return
+	}
+	meta := &imaging.Metadata{ContentType: contentType}
+	meta.Width, _ = strconv.Atoi(attrs[widthAttr])
+	meta.Height, _ = strconv.Atoi(attrs[heightAttr])
+	akhttp.WriteResponse(w, http.StatusOK, meta)
+}
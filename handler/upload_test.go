@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"akita/imaging"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_Metadata(t *testing.T) {
+	openTestEngine(t)
+	put := func(value []byte) {
+		w := serve(KeysV2, httptest.NewRequest(http.MethodPut, KeysV2Prefix+"img/a", bytes.NewReader(value)))
+		if w.Code != http.StatusNoContent {
+			t.Fatalf("put img/a want 204, got %d %s.\n", w.Code, w.Body)
+		}
+	}
+	meta := func() (int, *imaging.Metadata) {
+		w := serve(Metadata, httptest.NewRequest(http.MethodGet, "/akita/meta/?key=img/a", nil))
+		m := &imaging.Metadata{}
+		json.Unmarshal(w.Body.Bytes(), m)
+		return w.Code, m
+	}
+
+	put(testPNG(t, 40, 20))
+	if code, m := meta(); code != http.StatusOK || m.ContentType != "image/png" || m.Width != 40 || m.Height != 20 {
+		t.Fatalf("metadata of img/a want image/png 40x20, got %d %+v.\n", code, m)
+	}
+	// metadata belongs to the value, writing a value which is no image drops it
+	put([]byte("text"))
+	if code, _ := meta(); code != http.StatusNotFound {
+		t.Fatalf("metadata of overwritten img/a want 404, got %d.\n", code)
+	}
+	put(testPNG(t, 8, 8))
+	if w := serve(KeysV2, httptest.NewRequest(http.MethodDelete, KeysV2Prefix+"img/a", nil)); w.Code != http.StatusNoContent {
+		t.Fatalf("delete img/a want 204, got %d.\n", w.Code)
+	}
+	if code, _ := meta(); code != http.StatusNotFound {
+		t.Fatalf("metadata of deleted img/a want 404, got %d.\n", code)
+	}
+}
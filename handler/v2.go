@@ -0,0 +1,164 @@
+package handler
+
+import (
+	"akita/auth"
+	"akita/common"
+	"akita/consts"
+	"akita/db"
+	"akita/errors"
+	akhttp "akita/http"
+	"akita/logger"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// KeysV2Prefix is the path prefix of the v2 key api, the rest of the path is the key
+	KeysV2Prefix = "/v2/keys/"
+
+	maxKeySize   = 10 * consts.K
+	maxValueSize = 64 * consts.M
+)
+
+// KeysV2 handle /v2/keys/{key} requests, PUT save the request body as value,
+// GET and HEAD read value, DELETE delete key. Errors are written as json error envelope.
+func KeysV2(w http.ResponseWriter, req *http.Request) {
+	key := strings.TrimPrefix(req.URL.Path, KeysV2Prefix)
+	if key == "" {
+		akhttp.WriteError(w, http.StatusBadRequest, akhttp.ErrCodeKeyEmpty, "key can not be empty")
+		return
+	}
+	if len(common.StringToByteSlice(key)) > maxKeySize {
+		akhttp.WriteError(w, http.StatusBadRequest, akhttp.ErrCodeKeyTooLarge, "key size is too large")
+		return
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		if allowedV2(w, req, key, auth.PermRead) {
+			getKeyV2(w, req, key)
+		}
+	case http.MethodPut:
+		if allowedV2(w, req, key, auth.PermWrite) {
+			putKeyV2(w, req, key)
+		}
+	case http.MethodDelete:
+		if allowedV2(w, req, key, auth.PermDelete) {
+			deleteKeyV2(w, req, key)
+		}
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		akhttp.WriteError(w, http.StatusMethodNotAllowed, akhttp.ErrCodeMethodNotAllowed, "method "+req.Method+" is not allowed")
+	}
+}
+
+func getKeyV2(w http.ResponseWriter, req *http.Request, key string) {
+	value, compressed, err := db.GetEngine().SeekEncoded(key)
+	if err != nil {
+		logger.Errorf("Seek key %v error %v", key, err)
+		akhttp.WriteError(w, http.StatusInternalServerError, akhttp.ErrCodeInternal, err.Error())
+		return
+	}
+	if value == nil {
+		akhttp.WriteError(w, http.StatusNotFound, akhttp.ErrCodeNotFound, "key "+key+" not found")
+		return
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	if compressed && !acceptGzip(req) {
+		if value, err = db.GetEngine().Seek(key); err != nil {
+			logger.Errorf("Seek key %v error %v", key, err)
+			akhttp.WriteError(w, http.StatusInternalServerError, akhttp.ErrCodeInternal, err.Error())
+			return
+		}
+		compressed = false
+	}
+	if compressed {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Content-Type", "application/octet-stream")
+	} else {
+		w.Header().Set("Content-Type", http.DetectContentType(value))
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(value)))
+	w.WriteHeader(http.StatusOK)
+	if req.Method == http.MethodHead {
+		return
+	}
+	if _, err := w.Write(value); err != nil {
+		logger.Errorf("Failed to write http response %v", err)
+	}
+}
+
+// acceptGzip report whether the client of req accepts gzip encoded responses. Only the v2 api serves compressed
+// values as they are stored, /akita/search always answers with the json encoded decompressed value.
+func acceptGzip(req *http.Request) bool {
+	for _, enc := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		if strings.TrimSpace(strings.SplitN(enc, ";", 2)[0]) == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+func putKeyV2(w http.ResponseWriter, req *http.Request, key string) {
+	if !db.GetEngine().IsMaster() {
+		akhttp.WriteError(w, http.StatusForbidden, akhttp.ErrCodeNotMaster, "this akita node isn't master node")
+		return
+	}
+	if req.ContentLength > maxValueSize {
+		akhttp.WriteError(w, http.StatusRequestEntityTooLarge, akhttp.ErrCodeValueTooLarge, "value is too large to save")
+		return
+	}
+	defer req.Body.Close()
+	value, err := ioutil.ReadAll(io.LimitReader(req.Body, maxValueSize+1))
+	if err != nil {
+		logger.Errorf("Read http body error: %v", err)
+		akhttp.WriteError(w, http.StatusBadRequest, akhttp.ErrCodeBadRequest, err.Error())
+		return
+	}
+	if len(value) > maxValueSize {
+		akhttp.WriteError(w, http.StatusRequestEntityTooLarge, akhttp.ErrCodeValueTooLarge, "value is too large to save")
+		return
+	}
+	value, meta, err := prepareUpload(key, value)
+	if err != nil {
+		akhttp.WriteError(w, http.StatusBadRequest, akhttp.ErrCodeBadRequest, err.Error())
+		return
+	}
+	lsn, err := db.GetEngine().PutWithAttrs(key, value, 0, metadataAttrs(meta))
+	if err == errors.ErrQuotaExceeded {
+		akhttp.WriteError(w, http.StatusInsufficientStorage, akhttp.ErrCodeQuotaExceeded, err.Error())
+		return
+	}
+	if err != nil {
+		logger.Errorf("Save key %v fail: %v", key, err)
+		akhttp.WriteError(w, http.StatusInternalServerError, akhttp.ErrCodeInternal, err.Error())
+		return
+	}
+	if !confirmWrite(w, lsn, true) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func deleteKeyV2(w http.ResponseWriter, req *http.Request, key string) {
+	if !db.GetEngine().IsMaster() {
+		akhttp.WriteError(w, http.StatusForbidden, akhttp.ErrCodeNotMaster, "this akita node isn't master node")
+		return
+	}
+	deleted, _, lsn, err := db.GetEngine().Delete(key)
+	if err != nil {
+		logger.Errorf("Delete key %v fail: %v", key, err)
+		akhttp.WriteError(w, http.StatusInternalServerError, akhttp.ErrCodeInternal, err.Error())
+		return
+	}
+	if !deleted {
+		akhttp.WriteError(w, http.StatusNotFound, akhttp.ErrCodeNotFound, "key "+key+" not found")
+		return
+	}
+	if !confirmWrite(w, lsn, true) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
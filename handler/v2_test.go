@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"akita/auth"
+	akhttp "akita/http"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// errorCode return the code of the json error envelope of w.
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	var envelope akhttp.ErrorEnvelope
+	if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil {
+		t.Fatalf("decode error envelope %s error: %s.\n", w.Body, err)
+	}
+	if envelope.Error.Message == "" {
+		t.Fatalf("error envelope %s want message.\n", w.Body)
+	}
+	return envelope.Error.Code
+}
+
+func Test_KeysV2Errors(t *testing.T) {
+	e := openTestEngine(t)
+	useTestACLs(t, e, "owner", "photos", auth.PermRead|auth.PermWrite|auth.PermDelete)
+	cases := []struct {
+		name   string
+		method string
+		key    string
+		token  string
+		status int
+		code   string
+	}{
+		{"empty key", http.MethodGet, "", "owner", http.StatusBadRequest, akhttp.ErrCodeKeyEmpty},
+		{"key too large", http.MethodGet, strings.Repeat("k", maxKeySize+1), "owner", http.StatusBadRequest, akhttp.ErrCodeKeyTooLarge},
+		{"missing key", http.MethodGet, "photos/missing", "owner", http.StatusNotFound, akhttp.ErrCodeNotFound},
+		{"delete missing key", http.MethodDelete, "photos/missing", "owner", http.StatusNotFound, akhttp.ErrCodeNotFound},
+		{"method", http.MethodPost, "photos/a", "owner", http.StatusMethodNotAllowed, akhttp.ErrCodeMethodNotAllowed},
+		{"acl", http.MethodGet, "photos/a", "other", http.StatusForbidden, akhttp.ErrCodeForbidden},
+	}
+	for _, c := range cases {
+		req := withToken(httptest.NewRequest(c.method, KeysV2Prefix+c.key, nil), c.token, auth.ScopeWrite)
+		w := serve(KeysV2, req)
+		if w.Code != c.status {
+			t.Fatalf("%s want %d, got %d %s.\n", c.name, c.status, w.Code, w.Body)
+		}
+		if code := errorCode(t, w); code != c.code {
+			t.Fatalf("%s want error code %s, got %s.\n", c.name, c.code, code)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Fatalf("%s want json content type, got %s.\n", c.name, ct)
+		}
+	}
+}
+
+func Test_GzipPassthrough(t *testing.T) {
+	e := openUncachedTestEngine(t)
+	e.GetDB().UseCompression(nil)
+	value := strings.Repeat("compressible ", 100)
+	if err := e.Put("logs/a", []byte(value)); err != nil {
+		t.Fatalf("put error: %s.\n", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, KeysV2Prefix+"logs/a", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := serve(KeysV2, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("v2 get accepting gzip want 200 gzip encoded, got %d %q.\n", w.Code, w.Header().Get("Content-Encoding"))
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("open gzip body error: %s.\n", err)
+	}
+	if got, err := ioutil.ReadAll(zr); err != nil || string(got) != value {
+		t.Fatalf("v2 gzip body want value, got %d bytes %v.\n", len(got), err)
+	}
+
+	// search answers with the json encoded value whatever the client accepts
+	req = httptest.NewRequest(http.MethodGet, "/akita/search/?key=logs/a", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w = serve(Search, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("search accepting gzip want 200 not encoded, got %d %q.\n", w.Code, w.Header().Get("Content-Encoding"))
+	}
+	var got []byte
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || string(got) != value {
+		t.Fatalf("search body want json encoded value, got %d bytes %v.\n", len(got), err)
+	}
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"akita/auth"
+	"akita/db"
+	akerrors "akita/errors"
+	akhttp "akita/http"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	watchHeartbeat = 15 * time.Second // comment line sent to keep idle connections open
+)
+
+var eventNames = map[db.EventType]string{
+	db.EventPut:    "put",
+	db.EventDelete: "delete",
+	db.EventExpire: "expire",
+}
+
+type watchEvent struct {
+	Seq  uint64 `json:"seq"`
+	Type string `json:"type"`
+	Key  string `json:"key"`
+}
+
+// Watch stream put, delete and expire events of a key or of keys starting with a prefix as server-sent events.
+// The id of every event is its position, a client reconnecting with Last-Event-ID, or the after parameter,
+// resumes after it. If the events after the position are no longer kept a reset event is sent first,
+// the client should then read the keys again.
+func Watch(w http.ResponseWriter, req *http.Request) {
+	key, prefix := req.FormValue("key"), req.FormValue("prefix")
+	if key != "" && prefix != "" {
+		akhttp.WriteResponse(w, http.StatusBadRequest, "key and prefix can not both be set! ")
+		return
+	}
+	if key != "" {
+		if !allowed(w, req, key, auth.PermRead) {
+			return
+		}
+		prefix = key
+	}
+	after := req.Header.Get("Last-Event-ID")
+	if after == "" {
+		after = req.FormValue("after")
+	}
+	var position uint64
+	if after != "" {
+		var err error
+		if position, err = strconv.ParseUint(after, 10, 64); err != nil {
+			akhttp.WriteResponse(w, http.StatusBadRequest, "after must be an event id! ")
+			return
+		}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		akhttp.WriteResponse(w, http.StatusInternalServerError, "streaming is not supported! ")
+		return
+	}
+
+	e := db.GetEngine()
+	reset := false
+	events, stop, err := e.WatchFrom(prefix, position)
+	if err == akerrors.ErrWatchPositionLost {
+		reset = true
+		position = e.WatchPosition()
+		events, stop, err = e.WatchFrom(prefix, position)
+	}
+	if err != nil {
+		akhttp.WriteResponse(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if reset {
+		fmt.Fprintf(w, "id: %d\nevent: reset\ndata: {\"seq\":%d}\n\n", position, position)
+	}
+	flusher.Flush()
+
+	info := auth.TokenInfoFrom(req.Context())
+	heartbeat := time.NewTicker(watchHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				// the watcher fell behind, the client resumes from the last event it got
+				return
+			}
+			if key != "" && ev.Key != key {
+				continue
+			}
+			// keys of a prefix are only seen where the acl grants listing them
+			if key == "" {
+				if ok, _ := acls.Allowed(info, ev.Key, auth.PermList); !ok {
+					continue
+				}
+			}
+			data, _ := json.Marshal(&watchEvent{Seq: ev.Seq, Type: eventNames[ev.Type], Key: ev.Key})
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.Seq, eventNames[ev.Type], data)
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+		case <-req.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
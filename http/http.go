@@ -2,6 +2,8 @@ package http
 
 import (
 	"akita/logger"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"io"
 	"io/ioutil"
@@ -45,6 +47,58 @@ func WriteResponseWithContextType(w http.ResponseWriter, code int, contentType s
 	}
 }
 
+// WriteBytes write data as it is, unlike WriteResponseWithContextType which json encodes it.
+func WriteBytes(w http.ResponseWriter, code int, contentType string, data []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(code)
+	if _, err := w.Write(data); err != nil {
+		logger.Errorf("Failed to write http response %v", err)
+	}
+}
+
+// error codes of the json error envelope
+const (
+	ErrCodeBadRequest       = "bad_request"
+	ErrCodeKeyEmpty         = "key_empty"
+	ErrCodeKeyTooLarge      = "key_too_large"
+	ErrCodeValueTooLarge    = "value_too_large"
+	ErrCodeNotFound         = "not_found"
+	ErrCodeNotMaster        = "not_master"
+	ErrCodeUnauthorized     = "unauthorized"
+	ErrCodeForbidden        = "forbidden"
+	ErrCodeMethodNotAllowed = "method_not_allowed"
+	ErrCodeQuotaExceeded    = "quota_exceeded"
+	ErrCodeRateLimited      = "rate_limited"
+	ErrCodeOverloaded       = "overloaded"
+	ErrCodeQuorumTimeout    = "quorum_timeout"
+	ErrCodeInternal         = "internal_error"
+)
+
+// ReplicasHeader is the response header of writes telling how many replicas confirmed them.
+const ReplicasHeader = "X-Akita-Replicas"
+
+type (
+	// ErrorEnvelope is the json body of every error response of the v2 api
+	ErrorEnvelope struct {
+		Error ErrorBody `json:"error"`
+	}
+
+	ErrorBody struct {
+		Code    string `json:"code"`    // machine readable error code
+		Message string `json:"message"` // human readable error message
+	}
+)
+
+// WriteError write the json error envelope with code and message.
+func WriteError(w http.ResponseWriter, status int, code string, message string) {
+	WriteResponse(w, status, &ErrorEnvelope{
+		Error: ErrorBody{
+			Code:    code,
+			Message: message,
+		},
+	})
+}
+
 const (
 	ConnectTimeout        = 500 * time.Millisecond
 	KeepAlivePeriod       = 0 * time.Second // idle conn timeout only 30 second
@@ -96,6 +150,18 @@ func NewHttpClient(timeout time.Duration) *HttpClient {
 	}
 }
 
+// NewHttpClientWithTLS create a client of https urls using config, its connections are not shared with other clients.
+func NewHttpClientWithTLS(timeout time.Duration, config *tls.Config) *HttpClient {
+	transport := GetDefaultTransport().(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return &HttpClient{
+		client: http.Client{
+			Timeout:   timeout,
+			Transport: transport,
+		},
+	}
+}
+
 func (hc *HttpClient) PostForm(url string, args url.Values) (int, []byte, error) {
 	resp, err := hc.client.PostForm(url, args)
 	if err != nil {
@@ -117,6 +183,39 @@ func (hc *HttpClient) Post(url string, contentType string, body io.Reader) (int,
 	return resp.StatusCode, data, err
 }
 
+// PostWithHeader post body with the extra request header.
+func (hc *HttpClient) PostWithHeader(url string, contentType string, header http.Header, body io.Reader) (int, []byte, error) {
+	req, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := hc.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, data, err
+}
+
+// PostStream post body with the extra request header and return the response as it starts, the caller reads
+// and closes its body. The request is canceled with ctx.
+func (hc *HttpClient) PostStream(ctx context.Context, url string, contentType string, header http.Header, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Content-Type", contentType)
+	return hc.client.Do(req)
+}
+
 func (hc *HttpClient) Get(url string) ([]byte, error) {
 	resp, err := hc.client.Get(url)
 	if err != nil {
@@ -0,0 +1,66 @@
+package imaging
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Cache is a lru cache of transformed images bounded by the total size of images.
+type Cache struct {
+	sync.Mutex
+	limit int64
+	size  int64
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key         string
+	value       []byte
+	contentType string
+}
+
+// NewCache create a cache holding at most limit bytes of images.
+func NewCache(limit int64) *Cache {
+	return &Cache{
+		limit: limit,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// Get return the image of key and its content type.
+func (c *Cache) Get(key string) ([]byte, string, bool) {
+	c.Lock()
+	defer c.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, "", false
+	}
+	c.ll.MoveToFront(el)
+	entry := el.Value.(*cacheEntry)
+	return entry.value, entry.contentType, true
+}
+
+// Add cache the image of key, least recently used images are evicted to stay within the limit.
+func (c *Cache) Add(key string, value []byte, contentType string) {
+	if int64(len(value)) > c.limit {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+	c.items[key] = c.ll.PushFront(&cacheEntry{key: key, value: value, contentType: contentType})
+	c.size += int64(len(value))
+	for c.size > c.limit {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *Cache) removeElement(el *list.Element) {
+	entry := c.ll.Remove(el).(*cacheEntry)
+	delete(c.items, entry.key)
+	c.size -= int64(len(entry.value))
+}
@@ -0,0 +1,98 @@
+package imaging
+
+import (
+	akerrors "akita/errors"
+	"bytes"
+	"encoding/binary"
+	"image"
+)
+
+// Metadata of an image.
+type Metadata struct {
+	ContentType string `json:"content_type"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// Inspect return the metadata of value, or nil if value is not an image of a known format.
+func Inspect(value []byte) *Metadata {
+	config, format, err := image.DecodeConfig(bytes.NewReader(value))
+	if err != nil {
+		return nil
+	}
+	return &Metadata{
+		ContentType: ContentTypes[format],
+		Width:       config.Width,
+		Height:      config.Height,
+	}
+}
+
+// StripMetadata remove exif, xmp, iptc and comment blocks of a jpeg or png image, the pixels are not
+// decoded so the image is not changed otherwise. Color profiles are kept. Values of other formats
+// are returned as they are.
+func StripMetadata(value []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(value, []byte{0xff, 0xd8}):
+		return stripJPEG(value)
+	case bytes.HasPrefix(value, pngSignature):
+		return stripPNG(value)
+	}
+	return value, nil
+}
+
+// stripJPEG drop the APP1 (exif, xmp), APP3 to APP13 (iptc among others), APP15 and COM segments
+// before the image data. APP0 (jfif), APP2 (icc profile) and APP14 (adobe color transform) are kept.
+func stripJPEG(value []byte) ([]byte, error) {
+	out := make([]byte, 0, len(value))
+	out = append(out, value[:2]...)
+	for i := 2; ; {
+		if i+4 > len(value) || value[i] != 0xff {
+			return nil, akerrors.ErrImageFormat
+		}
+		marker := value[i+1]
+		if marker == 0xff {
+			// fill byte
+			i++
+			continue
+		}
+		if marker == 0xda {
+			// start of scan, the rest is image data
+			return append(out, value[i:]...), nil
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(value[i+2:]))
+		if end > len(value) {
+			return nil, akerrors.ErrImageFormat
+		}
+		switch {
+		case marker == 0xe1, marker >= 0xe3 && marker <= 0xed, marker == 0xef, marker == 0xfe:
+		default:
+			out = append(out, value[i:end]...)
+		}
+		i = end
+	}
+}
+
+// stripPNG drop the eXIf, tEXt, zTXt, iTXt and tIME chunks.
+func stripPNG(value []byte) ([]byte, error) {
+	out := make([]byte, 0, len(value))
+	out = append(out, pngSignature...)
+	for i := len(pngSignature); i < len(value); {
+		if i+12 > len(value) {
+			return nil, akerrors.ErrImageFormat
+		}
+		// length, type, data, crc
+		end := i + 12 + int(binary.BigEndian.Uint32(value[i:]))
+		if end > len(value) || end < i {
+			return nil, akerrors.ErrImageFormat
+		}
+		switch string(value[i+4 : i+8]) {
+		case "eXIf", "tEXt", "zTXt", "iTXt", "tIME":
+		default:
+			out = append(out, value[i:end]...)
+		}
+		i = end
+	}
+	return out, nil
+}
@@ -0,0 +1,65 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+func Test_StripJPEG(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 30))
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatalf("encode test image: %v", err)
+	}
+	exif := append([]byte("Exif\x00\x00"), []byte("GPS 52.52N 13.40E")...)
+	segment := []byte{0xff, 0xe1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(exif)+2))
+	segment = append(segment, exif...)
+	src := append(append(append([]byte{}, buf.Bytes()[:2]...), segment...), buf.Bytes()[2:]...)
+
+	meta := Inspect(src)
+	if meta == nil || meta.ContentType != "image/jpeg" || meta.Width != 40 || meta.Height != 30 {
+		t.Fatalf("inspect jpeg: %v", meta)
+	}
+	out, err := StripMetadata(src)
+	if err != nil {
+		t.Fatalf("strip jpeg: %v", err)
+	}
+	if bytes.Contains(out, []byte("GPS")) || !bytes.Equal(out, buf.Bytes()) {
+		t.Fatalf("exif segment is not stripped")
+	}
+	if _, err := StripMetadata(src[:len(segment)]); err == nil {
+		t.Fatalf("strip truncated jpeg: no error")
+	}
+}
+
+func Test_StripPNG(t *testing.T) {
+	src := testImage(t, 20, 10)
+	text := []byte("Comment\x00taken at home")
+	chunk := make([]byte, 8, 12+len(text))
+	binary.BigEndian.PutUint32(chunk, uint32(len(text)))
+	copy(chunk[4:], "tEXt")
+	chunk = append(chunk, text...)
+	chunk = append(chunk, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(chunk[len(chunk)-4:], crc32.ChecksumIEEE(chunk[4:len(chunk)-4]))
+	// after the IHDR chunk
+	withText := append(append(append([]byte{}, src[:33]...), chunk...), src[33:]...)
+
+	out, err := StripMetadata(withText)
+	if err != nil {
+		t.Fatalf("strip png: %v", err)
+	}
+	if !bytes.Equal(out, src) {
+		t.Fatalf("text chunk is not stripped")
+	}
+	if meta := Inspect(out); meta == nil || meta.ContentType != "image/png" || meta.Width != 20 {
+		t.Fatalf("inspect png: %v", meta)
+	}
+	if out, err := StripMetadata([]byte("plain text")); err != nil || string(out) != "plain text" {
+		t.Fatalf("strip text: %q, %v", out, err)
+	}
+}
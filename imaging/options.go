@@ -0,0 +1,113 @@
+package imaging
+
+import (
+	akerrors "akita/errors"
+	"image"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Options of an image transform, the source is cropped first, then resized and encoded.
+type Options struct {
+	Width   int             // width of the result, 0 keeps the aspect ratio
+	Height  int             // height of the result, 0 keeps the aspect ratio
+	Crop    image.Rectangle // area of the source to keep, empty keeps the whole source
+	Format  string          // jpeg, png or gif, empty keeps the source format
+	Quality int             // jpeg quality from 1 to 100, 0 is the default quality
+}
+
+// Limits bound the work of transforms.
+type Limits struct {
+	MaxWidth        int // maximum width of a result
+	MaxHeight       int // maximum height of a result
+	MaxSourcePixels int // maximum pixels of a source, larger sources are not decoded
+	MaxConcurrent   int // maximum transforms running at once
+}
+
+// DefaultLimits are the limits used unless configured.
+var DefaultLimits = Limits{
+	MaxWidth:        4096,
+	MaxHeight:       4096,
+	MaxSourcePixels: 50 * 1000 * 1000,
+	MaxConcurrent:   4,
+}
+
+// ParseOptions parse the transform parameters of query: w and h in pixels, crop as "x,y,width,height",
+// format and q. It returns nil if query has no transform parameter.
+func ParseOptions(query url.Values) (*Options, error) {
+	o := &Options{}
+	found := false
+	for _, name := range []string{"w", "h", "q"} {
+		s := query.Get(name)
+		if s == "" {
+			continue
+		}
+		found = true
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return nil, akerrors.ErrImageOptions
+		}
+		switch name {
+		case "w":
+			o.Width = n
+		case "h":
+			o.Height = n
+		case "q":
+			if n > 100 {
+				return nil, akerrors.ErrImageOptions
+			}
+			o.Quality = n
+		}
+	}
+	if s := query.Get("crop"); s != "" {
+		found = true
+		parts := strings.Split(s, ",")
+		if len(parts) != 4 {
+			return nil, akerrors.ErrImageOptions
+		}
+		var n [4]int
+		for i, p := range parts {
+			var err error
+			if n[i], err = strconv.Atoi(p); err != nil || n[i] < 0 {
+				return nil, akerrors.ErrImageOptions
+			}
+		}
+		if n[2] == 0 || n[3] == 0 {
+			return nil, akerrors.ErrImageOptions
+		}
+		o.Crop = image.Rect(n[0], n[1], n[0]+n[2], n[1]+n[3])
+	}
+	if s := query.Get("format"); s != "" {
+		found = true
+		switch s {
+		case "jpeg", "png", "gif":
+			o.Format = s
+		case "jpg":
+			o.Format = "jpeg"
+		default:
+			return nil, akerrors.ErrImageOptions
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+	return o, nil
+}
+
+// String return the canonical form of o, equal options have equal strings.
+func (o *Options) String() string {
+	var b strings.Builder
+	b.WriteString("w=" + strconv.Itoa(o.Width) + "&h=" + strconv.Itoa(o.Height))
+	if !o.Crop.Empty() {
+		b.WriteString("&crop=" + strconv.Itoa(o.Crop.Min.X) + "," + strconv.Itoa(o.Crop.Min.Y) + "," +
+			strconv.Itoa(o.Crop.Dx()) + "," + strconv.Itoa(o.Crop.Dy()))
+	}
+	if o.Format != "" {
+		b.WriteString("&format=" + o.Format)
+	}
+	if o.Quality != 0 {
+		b.WriteString("&q=" + strconv.Itoa(o.Quality))
+	}
+	return b.String()
+}
@@ -0,0 +1,148 @@
+package imaging
+
+import (
+	akerrors "akita/errors"
+	"bytes"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+const (
+	defaultQuality = 85
+)
+
+// ContentTypes of the formats transforms write.
+var ContentTypes = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+}
+
+// Transform crop, resize and encode the image src as o tells, within limits l.
+// Only the first frame of an animated gif is kept. It returns the result and its content type.
+func Transform(src []byte, o *Options, l Limits) ([]byte, string, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(src))
+	if err != nil {
+		return nil, "", akerrors.ErrImageFormat
+	}
+	if config.Width*config.Height > l.MaxSourcePixels {
+		return nil, "", akerrors.ErrImageTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(src))
+	if err != nil {
+		return nil, "", akerrors.ErrImageFormat
+	}
+
+	if !o.Crop.Empty() {
+		area := o.Crop.Add(img.Bounds().Min).Intersect(img.Bounds())
+		if area.Empty() {
+			return nil, "", akerrors.ErrImageOptions
+		}
+		img = subImage(img, area)
+	}
+	width, height := size(img.Bounds().Dx(), img.Bounds().Dy(), o.Width, o.Height)
+	if width > l.MaxWidth || height > l.MaxHeight {
+		return nil, "", akerrors.ErrImageTooLarge
+	}
+	if width != img.Bounds().Dx() || height != img.Bounds().Dy() {
+		img = resize(img, width, height)
+	}
+
+	if o.Format != "" {
+		format = o.Format
+	}
+	var out bytes.Buffer
+	switch format {
+	case "jpeg":
+		quality := o.Quality
+		if quality == 0 {
+			quality = defaultQuality
+		}
+		err = jpeg.Encode(&out, img, &jpeg.Options{Quality: quality})
+	case "png":
+		err = png.Encode(&out, img)
+	case "gif":
+		err = gif.Encode(&out, img, nil)
+	default:
+		return nil, "", akerrors.ErrImageFormat
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return out.Bytes(), ContentTypes[format], nil
+}
+
+// size return the size of the result for a source of sw x sh and the wanted w x h,
+// a missing side keeps the aspect ratio of the source.
+func size(sw, sh, w, h int) (int, int) {
+	switch {
+	case w == 0 && h == 0:
+		return sw, sh
+	case w == 0:
+		w = (sw*h + sh/2) / sh
+	case h == 0:
+		h = (sh*w + sw/2) / sw
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	return w, h
+}
+
+func subImage(img image.Image, r image.Rectangle) image.Image {
+	if si, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return si.SubImage(r)
+	}
+	return toRGBA(img).SubImage(r.Sub(img.Bounds().Min))
+}
+
+// toRGBA copy img into an RGBA image whose bounds start at the origin.
+func toRGBA(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, b.Min, draw.Src)
+	return rgba
+}
+
+// resize scale img to w x h, every pixel of the result is the average of the source pixels it covers.
+func resize(img image.Image, w, h int) *image.RGBA {
+	src := toRGBA(img)
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := y*sh/h, (y+1)*sh/h
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < w; x++ {
+			x0, x1 := x*sw/w, (x+1)*sw/w
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+			var sum [4]uint64
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[src.PixOffset(x0, sy):src.PixOffset(x1, sy)]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += uint64(row[i])
+					sum[1] += uint64(row[i+1])
+					sum[2] += uint64(row[i+2])
+					sum[3] += uint64(row[i+3])
+				}
+			}
+			n := uint64((x1 - x0) * (y1 - y0))
+			p := dst.Pix[dst.PixOffset(x, y):]
+			for i := range sum {
+				p[i] = uint8(sum[i] / n)
+			}
+		}
+	}
+	return dst
+}
@@ -0,0 +1,103 @@
+package imaging
+
+import (
+	akerrors "akita/errors"
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"net/url"
+	"testing"
+)
+
+func testImage(t *testing.T, w, h int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			// left half red, right half blue
+			c := color.RGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encode test image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func Test_ParseOptions(t *testing.T) {
+	o, err := ParseOptions(url.Values{"key": {"k"}})
+	if o != nil || err != nil {
+		t.Fatalf("parse without transform: %v, %v, want nil", o, err)
+	}
+	o, err = ParseOptions(url.Values{"w": {"100"}, "crop": {"1,2,30,40"}, "format": {"jpg"}, "q": {"70"}})
+	if err != nil || o.String() != "w=100&h=0&crop=1,2,30,40&format=jpeg&q=70" {
+		t.Fatalf("parse options: %v, %v", o, err)
+	}
+	for _, bad := range []url.Values{{"w": {"-1"}}, {"q": {"101"}}, {"crop": {"1,2,3"}}, {"format": {"bmp"}}} {
+		if _, err := ParseOptions(bad); err != akerrors.ErrImageOptions {
+			t.Fatalf("parse %v: %v, want ErrImageOptions", bad, err)
+		}
+	}
+}
+
+func Test_Transform(t *testing.T) {
+	src := testImage(t, 200, 100)
+
+	out, contentType, err := Transform(src, &Options{Width: 50}, DefaultLimits)
+	if err != nil || contentType != "image/png" {
+		t.Fatalf("resize: %s, %v", contentType, err)
+	}
+	img, err := png.Decode(bytes.NewReader(out))
+	if err != nil || img.Bounds().Dx() != 50 || img.Bounds().Dy() != 25 {
+		t.Fatalf("resize keeps aspect ratio: %v, %v, want 50x25", img.Bounds(), err)
+	}
+	if r, _, b, _ := img.At(10, 10).RGBA(); r>>8 != 255 || b != 0 {
+		t.Fatalf("resized left pixel is not red")
+	}
+
+	// crop the blue half and convert to jpeg
+	out, contentType, err = Transform(src, &Options{Crop: image.Rect(100, 0, 200, 100), Format: "jpeg"}, DefaultLimits)
+	if err != nil || contentType != "image/jpeg" {
+		t.Fatalf("crop: %s, %v", contentType, err)
+	}
+	img, _, err = image.Decode(bytes.NewReader(out))
+	if err != nil || img.Bounds().Dx() != 100 {
+		t.Fatalf("crop size: %v, %v, want 100x100", img.Bounds(), err)
+	}
+	if r, _, b, _ := img.At(50, 50).RGBA(); r>>8 > 10 || b>>8 < 245 {
+		t.Fatalf("cropped pixel is not blue")
+	}
+
+	limits := DefaultLimits
+	limits.MaxWidth = 100
+	if _, _, err := Transform(src, &Options{Width: 101}, limits); err != akerrors.ErrImageTooLarge {
+		t.Fatalf("resize beyond limit: %v, want ErrImageTooLarge", err)
+	}
+	limits = DefaultLimits
+	limits.MaxSourcePixels = 100
+	if _, _, err := Transform(src, &Options{Width: 10}, limits); err != akerrors.ErrImageTooLarge {
+		t.Fatalf("decode beyond limit: %v, want ErrImageTooLarge", err)
+	}
+	if _, _, err := Transform([]byte("not an image"), &Options{Width: 10}, DefaultLimits); err != akerrors.ErrImageFormat {
+		t.Fatalf("transform text: %v, want ErrImageFormat", err)
+	}
+}
+
+func Test_Cache(t *testing.T) {
+	c := NewCache(10)
+	c.Add("a", []byte("12345"), "image/png")
+	c.Add("b", []byte("12345"), "image/png")
+	c.Get("a")
+	c.Add("c", []byte("1"), "image/png")
+	if _, _, ok := c.Get("b"); ok {
+		t.Fatalf("least recently used image is not evicted")
+	}
+	if _, _, ok := c.Get("a"); !ok {
+		t.Fatalf("recently used image is evicted")
+	}
+}
@@ -1,14 +1,26 @@
 package main
 
 import (
+	"akita/auth"
+	"akita/consts"
 	"akita/db"
 	"akita/handler"
+	"akita/imaging"
 	"akita/logger"
+	"akita/memcache"
+	"akita/ratelimit"
+	"akita/resp"
+	"akita/rpc"
+	"akita/s3"
+	aktls "akita/tls"
+	"context"
+	"crypto/tls"
 	"flag"
 	"net/http"
 	"os"
 	"os/signal"
 	"strings"
+	"time"
 )
 
 var (
@@ -18,33 +30,278 @@ var (
 	dataFilePath         = flag.String("data_file", "/usr/local/akdata.dat", "akita data file path. ")
 	cacheTurnOn          = flag.Bool("cache_turn_on", true, "use lru cache.")
 	cacheLimit           = flag.Int("cache_limit", 1000, "maximum number of caches.")
+	dedupTurnOn          = flag.Bool("dedup_turn_on", false, "store identical values once, referenced by sha256 digest.")
+	compressTurnOn       = flag.Bool("compress_turn_on", false, "gzip compress values which are not compressed already.")
+	compressNamespaces   = flag.String("compress_namespaces", "", "namespaces whose values are compressed, all namespaces if empty.")
+	encryptKeyFile       = flag.String("encrypt_key_file", "", "AES-GCM key file, values are encrypted if set.")
+	compactOnStart       = flag.Bool("compact_on_start", false, "rewrite data file with live records before serving.")
+	respPort             = flag.String("resp_port", "", "redis protocol listening port, disabled if empty.")
+	memcachePort         = flag.String("memcache_port", "", "memcached text protocol listening port, disabled if empty.")
+	grpcPort             = flag.String("grpc_port", "", "grpc service listening port, disabled if empty.")
+	s3Port               = flag.String("s3_port", "", "S3 compatible api listening port, disabled if empty.")
+	s3CredentialsFile    = flag.String("s3_credentials", "", "S3 credentials file, each line is an access key id and its secret access key.")
+	authTurnOn           = flag.Bool("auth_turn_on", false, "require api tokens on the http and grpc apis.")
+	tokenFile            = flag.String("token_file", "", "static api tokens, each line is a token, its comma separated scopes and a name.")
+	syncToken            = flag.String("sync_token", "", "api token with replication scope a slave sends to the master.")
+	tlsCertFile          = flag.String("tls_cert_file", "", "certificate file of the http, grpc and s3 listeners, tls is turned off if empty.")
+	tlsKeyFile           = flag.String("tls_key_file", "", "private key file of the tls certificate.")
+	tlsCAFile            = flag.String("tls_ca_file", "", "ca file master and slaves verify each other with, mutual tls is turned off if empty.")
+	tlsReloadInterval    = flag.Int64("tls_reload_interval", 60, "interval of checking tls files for changes, in seconds.")
+	rateRequests         = flag.Float64("rate_requests", 0, "requests of a client per second, unlimited if 0.")
+	rateRequestBurst     = flag.Int("rate_request_burst", 0, "requests a client may send at once, one second of requests if 0.")
+	rateBytes            = flag.Float64("rate_bytes", 0, "request and response body bytes of a client per second, in MB, unlimited if 0.")
+	rateByteBurst        = flag.Float64("rate_byte_burst", 0, "body bytes a client may transfer at once, in MB, one second of bytes if 0.")
+	maxConcurrent        = flag.Int("max_concurrent", 0, "requests served at once, more requests are shed, unlimited if 0.")
+	queueTimeout         = flag.Int64("queue_timeout", 100, "time a request waits to be served before it is shed, in milliseconds.")
+	shedWriteBacklog     = flag.Float64("shed_write_backlog", 0, "writes are shed when this fraction of the write queue is pending, never if 0.")
+	presignSecretFile    = flag.String("presign_secret_file", "", "hex encoded hmac secret of presigned urls, presigned urls are turned off if empty.")
+	imageMaxSize         = flag.Int("image_max_size", imaging.DefaultLimits.MaxWidth, "maximum width and height of transformed images.")
+	imageMaxPixels       = flag.Int("image_max_pixels", imaging.DefaultLimits.MaxSourcePixels, "maximum pixels of images which are transformed.")
+	imageConcurrency     = flag.Int("image_concurrency", imaging.DefaultLimits.MaxConcurrent, "maximum image transforms running at once.")
+	imageCacheSize       = flag.Int64("image_cache_size", 64, "size of the cache of transformed images, in MB.")
+	stripTurnOn          = flag.Bool("strip_metadata_turn_on", false, "strip exif and other metadata blocks of uploaded jpeg and png images.")
+	stripNamespaces      = flag.String("strip_metadata_namespaces", "", "namespaces whose images are stripped, all namespaces if empty.")
+	imagePersist         = flag.Bool("image_persist", false, "store transformed images of the presets as keys of the _variants namespace.")
+	imagePersistPresets  = flag.String("image_persist_presets", "", "transform parameters of the images which are stored, separated by ';', e.g. 'w=320;w=1024&format=jpeg'.")
 	dataFileSyncInterval = flag.Int64("dfs_interval", 1000, "data fille synchronization interval, in milliseconds.")
-	dbSyncInterval       = flag.Int64("dbs_interval", 500, "db master-slaves synchronization interval, in milliseconds.")
+	dbSyncInterval       = flag.Int64("dbs_interval", 500, "db master-slaves synchronization interval, and the first wait before a broken sync stream is opened again, in milliseconds.")
+	syncBatchSize        = flag.Float64("sync_batch_size", 4, "size of the records a slave gets at once, in MB.")
+	writeQuorum          = flag.Int("write_quorum", 0, "replicas which must acknowledge a write before it is answered, none if 0.")
+	writeQuorumTimeout   = flag.Int64("write_quorum_timeout", 1000, "time a write waits for the write quorum, in milliseconds.")
+	writeQuorumAsync     = flag.Bool("write_quorum_async", true, "answer writes the write quorum did not acknowledge in time as successful instead of failing them.")
+	nodeID               = flag.String("node_id", "", "id of a slave the master keeps track of it by, the host name if empty.")
+	syncStreamTurnOn     = flag.Bool("sync_stream_turn_on", true, "slaves keep a sync stream open instead of polling the master every dbs_interval.")
+)
+
+var (
+	// tokens authenticate requests, authentication is turned off if it is nil.
+	tokens *auth.Tokens
+	// acls restrict namespaces to the principals they are granted to, they are turned on with authentication.
+	acls *auth.ACLs
+	// limiter limits the rate of clients and sheds load, limiting is turned off if it is nil.
+	limiter *ratelimit.Limiter
+	// certs are the tls certificates of listeners and replication, tls is turned off if it is nil.
+	certs *aktls.Certificates
 )
 
 func main() {
 
-	http.HandleFunc("/akita/save/", handler.Save)
-	http.HandleFunc("/akita/search/", handler.Search)
-	http.HandleFunc("/akita/del/", handler.Del)
-	http.HandleFunc("/akita/sync/", handler.Sync)
+	http.HandleFunc("/akita/save/", tokens.RequireOrSigned(auth.ScopeWrite, limiter.LimitWrite(handler.Save)))
+	http.HandleFunc("/akita/search/", tokens.RequireOrSigned(auth.ScopeRead, limiter.Limit(handler.Search)))
+	http.HandleFunc("/akita/seek/", tokens.RequireOrSigned(auth.ScopeRead, limiter.Limit(handler.Search)))
+	http.HandleFunc("/akita/del/", tokens.Require(auth.ScopeWrite, limiter.LimitWrite(handler.Del)))
+	http.HandleFunc("/akita/msave/", tokens.Require(auth.ScopeWrite, limiter.LimitWrite(handler.MultiSave)))
+	http.HandleFunc("/akita/msearch/", tokens.Require(auth.ScopeRead, limiter.Limit(handler.MultiSearch)))
+	http.HandleFunc("/akita/append/", tokens.Require(auth.ScopeWrite, limiter.LimitWrite(handler.Append)))
+	http.HandleFunc("/akita/incr/", tokens.Require(auth.ScopeWrite, limiter.LimitWrite(handler.Incr)))
+	// replication is not limited, slaves park their sync requests on the master
+	http.HandleFunc("/akita/sync/", certs.RequirePeer(tokens.Require(auth.ScopeReplication, handler.Sync)))
+	http.HandleFunc("/akita/sync/stream/", certs.RequirePeer(tokens.Require(auth.ScopeReplication, handler.SyncStream)))
+	http.HandleFunc("/akita/sync/snapshot/", certs.RequirePeer(tokens.Require(auth.ScopeReplication, handler.SyncSnapshot)))
+	http.HandleFunc("/akita/sync/ack/", certs.RequirePeer(tokens.Require(auth.ScopeReplication, handler.SyncAck)))
+	http.HandleFunc("/akita/digest/", tokens.Require(auth.ScopeRead, limiter.Limit(handler.Digest)))
+	http.HandleFunc("/akita/watch/", tokens.Require(auth.ScopeRead, limiter.LimitStream(handler.Watch)))
+	http.HandleFunc("/akita/meta/", tokens.Require(auth.ScopeRead, limiter.Limit(handler.Metadata)))
+	http.HandleFunc("/akita/presign/", tokens.RequireFunc(presignScope, limiter.Limit(handler.Presign)))
+	http.HandleFunc("/akita/tokens/", tokens.Require(auth.ScopeAdmin, handler.Tokens))
+	http.HandleFunc("/akita/quotas/", tokens.Require(auth.ScopeAdmin, handler.Quotas))
+	http.HandleFunc("/akita/acls/", tokens.Require(auth.ScopeAdmin, handler.ACLs))
+	http.HandleFunc("/akita/acls/audit/", tokens.Require(auth.ScopeAdmin, handler.ACLAudit))
+	http.HandleFunc("/akita/replicas/", tokens.Require(auth.ScopeAdmin, handler.Replicas))
+	http.HandleFunc("/akita/metrics/", tokens.Require(auth.ScopeAdmin, handler.Metrics))
+	http.HandleFunc(handler.KeysV2Prefix, tokens.RequireFunc(auth.ScopeByMethod, limiter.LimitFunc(isWrite, handler.KeysV2)))
 
-	server := &http.Server{Addr: ":" + *port, Handler: nil}
-	db.GetEngine().Start(server, *dataFileSyncInterval, *dbSyncInterval) // start akita listening
+	var respServer *resp.Server
+	if *respPort != "" {
+		respServer = resp.NewServer(":"+*respPort, db.GetEngine(), tokens, limiter)
+		go func() {
+			if err := respServer.ListenAndServe(); err != nil && err != resp.ErrServerClosed {
+				logger.Fatalf("start resp server error %v", err)
+			}
+		}()
+	}
+
+	var memcacheServer *memcache.Server
+	if *memcachePort != "" {
+		memcacheServer = memcache.NewServer(":"+*memcachePort, db.GetEngine(), tokens, limiter)
+		go func() {
+			if err := memcacheServer.ListenAndServe(); err != nil && err != memcache.ErrServerClosed {
+				logger.Fatalf("start memcache server error %v", err)
+			}
+		}()
+	}
+
+	var rpcServer *rpc.Server
+	if *grpcPort != "" {
+		rpcServer = rpc.NewServer(":"+*grpcPort, db.GetEngine(), tokens, limiter, serverTLSConfig())
+		go func() {
+			if err := rpcServer.ListenAndServe(); err != nil && err != rpc.ErrServerClosed {
+				logger.Fatalf("start grpc server error %v", err)
+			}
+		}()
+	}
+
+	var s3Server *http.Server
+	if *s3Port != "" {
+		creds, err := s3.LoadCredentials(*s3CredentialsFile)
+		if err != nil {
+			logger.Fatalf("load s3 credentials error %v", err)
+		}
+		s3handler := s3.NewHandler(db.GetEngine(), creds)
+		if acls != nil {
+			s3handler.UseACLs(acls)
+		}
+		s3Server = &http.Server{Addr: ":" + *s3Port, Handler: limiter.LimitFunc(isWrite, s3handler.ServeHTTP), TLSConfig: serverTLSConfig()}
+		go func() {
+			if err := listenAndServe(s3Server); err != nil && err != http.ErrServerClosed {
+				logger.Fatalf("start s3 server error %v", err)
+			}
+		}()
+	}
+
+	server := &http.Server{Addr: ":" + *port, Handler: nil, TLSConfig: serverTLSConfig()}
+	stopReload := make(chan struct{})
+	if certs != nil {
+		go certs.ReloadEvery(time.Duration(*tlsReloadInterval)*time.Second, stopReload)
+	}
+	go db.GetEngine().Start(server, *dataFileSyncInterval, *dbSyncInterval) // start akita listening
 
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt, os.Kill)
 	select {
 	case <-interrupt:
+		if respServer != nil {
+			respServer.Close()
+		}
+		if memcacheServer != nil {
+			memcacheServer.Close()
+		}
+		if rpcServer != nil {
+			rpcServer.Close()
+		}
+		if s3Server != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			s3Server.Shutdown(ctx)
+			cancel()
+		}
+		close(stopReload)
 		db.GetEngine().Close(server) // recycle resources
 		signal.Stop(interrupt)
 	}
 }
 
 func init() {
-	db.InitializeEngine(*master, strings.Split(*slaves, ","), *port, *dataFilePath, *cacheTurnOn, *cacheLimit)
+	flag.Parse()
+	db.InitializeEngine(*master, strings.Split(*slaves, ","), *port, *dataFilePath, *cacheTurnOn, *cacheLimit, *dedupTurnOn)
+	if *compressTurnOn {
+		db.GetEngine().GetDB().UseCompression(strings.Split(*compressNamespaces, ","))
+	}
+	if *encryptKeyFile != "" {
+		if err := db.GetEngine().GetDB().UseEncryption(*encryptKeyFile); err != nil {
+			logger.Fatalf("load encryption key file error: %v", err)
+		}
+	}
+	var presets []string
+	if *imagePersist {
+		for _, preset := range strings.Split(*imagePersistPresets, ";") {
+			if preset != "" {
+				presets = append(presets, preset)
+			}
+		}
+		if len(presets) == 0 {
+			logger.Fatalf("-image_persist needs -image_persist_presets")
+		}
+	}
+	if err := handler.UseImageTransforms(imaging.Limits{
+		MaxWidth:        *imageMaxSize,
+		MaxHeight:       *imageMaxSize,
+		MaxSourcePixels: *imageMaxPixels,
+		MaxConcurrent:   *imageConcurrency,
+	}, *imageCacheSize*consts.M, presets); err != nil {
+		logger.Fatalf("image persist presets error: %v", err)
+	}
+	if *authTurnOn {
+		var err error
+		if tokens, err = auth.NewTokens(db.GetEngine(), *tokenFile); err != nil {
+			logger.Fatalf("load token file error: %v", err)
+		}
+		handler.UseTokens(tokens)
+		acls = auth.NewACLs(db.GetEngine())
+		handler.UseACLs(acls)
+	}
+	db.GetEngine().UseSyncToken(*syncToken)
+	db.GetEngine().UseSyncBatchSize(int64(*syncBatchSize * consts.M))
+	db.GetEngine().UseSyncStream(*syncStreamTurnOn)
+	db.GetEngine().UseWriteQuorum(*writeQuorum, time.Duration(*writeQuorumTimeout)*time.Millisecond, *writeQuorumAsync)
+	if *nodeID != "" {
+		db.GetEngine().UseNodeID(*nodeID)
+	}
+	if *tlsCertFile != "" {
+		var err error
+		if certs, err = aktls.Load(*tlsCertFile, *tlsKeyFile, *tlsCAFile); err != nil {
+			logger.Fatalf("load tls certificates error: %v", err)
+		}
+		db.GetEngine().UseTLS(certs.ClientConfig(*master))
+	}
+	if *rateRequests > 0 || *rateBytes > 0 || *maxConcurrent > 0 || *shedWriteBacklog > 0 {
+		limiter = ratelimit.NewLimiter(ratelimit.Config{
+			RequestsPerSecond: *rateRequests,
+			RequestBurst:      *rateRequestBurst,
+			BytesPerSecond:    *rateBytes * consts.M,
+			ByteBurst:         int64(*rateByteBurst * consts.M),
+			MaxConcurrent:     *maxConcurrent,
+			QueueTimeout:      time.Duration(*queueTimeout) * time.Millisecond,
+			MaxWriteBacklog:   *shedWriteBacklog,
+			WriteBacklog:      db.GetEngine().GetDB().WriteQueueLoad,
+			ByToken:           *authTurnOn,
+		})
+	}
+	if *presignSecretFile != "" {
+		s, err := auth.LoadSigner(*presignSecretFile)
+		if err != nil {
+			logger.Fatalf("load presign secret file error: %v", err)
+		}
+		handler.UsePresign(s)
+	}
+	if *stripTurnOn {
+		handler.UseMetadataStripping(strings.Split(*stripNamespaces, ","))
+	}
 	err := db.GetEngine().GetDB().Reload()
 	if err != nil {
 		logger.Fatalf("reload data base error: %v", err)
 	}
+	if *compactOnStart {
+		if err := db.GetEngine().GetDB().Compact(); err != nil {
+			logger.Fatalf("compact data file error: %v", err)
+		}
+	}
+}
+
+// serverTLSConfig return the tls config of listeners, nil if tls is turned off.
+func serverTLSConfig() *tls.Config {
+	if certs == nil {
+		return nil
+	}
+	return certs.ServerConfig()
+}
+
+// listenAndServe serve s over tls if its tls config is set.
+func listenAndServe(s *http.Server) error {
+	if s.TLSConfig != nil {
+		return s.ListenAndServeTLS("", "")
+	}
+	return s.ListenAndServe()
+}
+
+// isWrite return whether a request of a key api is a write, any method but GET and HEAD.
+func isWrite(req *http.Request) bool {
+	return auth.ScopeByMethod(req) == auth.ScopeWrite
+}
+
+// presignScope return the scope needed to issue a presigned url, the scope of the method it grants.
+func presignScope(req *http.Request) auth.Scope {
+	if req.URL.Query().Get("method") == http.MethodPost {
+		return auth.ScopeWrite
+	}
+	return auth.ScopeRead
 }
@@ -0,0 +1,444 @@
+package memcache
+
+import (
+	"akita/auth"
+	"akita/db"
+	akerrors "akita/errors"
+	"akita/logger"
+	"bufio"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxKeySize  = 250      // memcached limit of key length
+	maxItemSize = 64 << 20 // maximum size of a value
+	maxLineSize = 2048     // maximum length of a command line
+
+	// exptime up to 30 days is relative to now, larger exptime is an unix time
+	relativeExptimeLimit = 60 * 60 * 24 * 30
+
+	// flagsAttr is the attribute of a value holding its client flags
+	flagsAttr = "memcache_flags"
+)
+
+type client struct {
+	r     *bufio.Reader
+	w     *bufio.Writer
+	s     *Server
+	addr  string
+	conn  *countingConn
+	info  *auth.TokenInfo // token the client authenticated with
+	token string
+}
+
+// serveCommand read and answer one command, return true if the connection should be closed.
+func (c *client) serveCommand() (bool, error) {
+	line, err := c.readLine()
+	if err != nil {
+		if err == errLineTooLong {
+			c.reply("CLIENT_ERROR line too long")
+			c.w.Flush()
+		}
+		return true, err
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		c.reply("ERROR")
+		return false, nil
+	}
+	if c.s.tokens != nil && c.info == nil {
+		switch fields[0] {
+		case "set":
+			return false, c.authenticate(fields)
+		case "version":
+			c.reply("VERSION akita")
+		case "quit":
+			return true, nil
+		default:
+			c.reply("CLIENT_ERROR unauthenticated")
+		}
+		return false, nil
+	}
+	switch fields[0] {
+	case "get", "gets", "set", "add", "replace", "cas", "delete", "touch":
+		done, ok, err := c.admit(fields)
+		if !ok {
+			return false, err
+		}
+		defer done()
+	}
+	switch fields[0] {
+	case "get":
+		c.get(fields[1:], false)
+	case "gets":
+		c.get(fields[1:], true)
+	case "set", "add", "replace", "cas":
+		return false, c.store(fields)
+	case "delete":
+		c.delete(fields[1:])
+	case "touch":
+		c.touch(fields[1:])
+	case "version":
+		c.reply("VERSION akita")
+	case "quit":
+		return true, nil
+	default:
+		c.reply("ERROR")
+	}
+	return false, nil
+}
+
+type lineError string
+
+func (e lineError) Error() string {
+	return string(e)
+}
+
+const errLineTooLong = lineError("memcache: line too long")
+
+func (c *client) readLine() (string, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull || len(line) > maxLineSize {
+		return "", errLineTooLong
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// admit admit the command of fields within the limits of the server, its done function is called when the command
+// is answered. If the command is refused the error is replied and the data block of a storage command is skipped.
+func (c *client) admit(fields []string) (done func(), ok bool, err error) {
+	write := fields[0] != "get" && fields[0] != "gets"
+	transferred := c.conn.transferred(c.r, c.w)
+	admitted, _, err := c.s.limiter.Admit(c.s.limiter.ClientID(c.token, c.addr), write, 0)
+	if err == nil {
+		return func() { admitted(c.conn.transferred(c.r, c.w) - transferred) }, true, nil
+	}
+	c.reply("SERVER_ERROR " + err.Error())
+	if len(fields) < 5 || fields[0] == "delete" || fields[0] == "touch" {
+		return nil, false, nil
+	}
+	// skip the data block so the connection stays in sync
+	if size, err := strconv.Atoi(fields[4]); err == nil && size >= 0 {
+		if _, err := io.CopyN(ioutil.Discard, c.r, int64(size)+2); err != nil {
+			return nil, false, err
+		}
+	}
+	return nil, false, nil
+}
+
+func (c *client) reply(s string) {
+	c.w.WriteString(s)
+	c.w.WriteString("\r\n")
+}
+
+func validKey(key string) bool {
+	if len(key) == 0 || len(key) > maxKeySize {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// authorized check that the client authenticated with a token which has scope and perm on keys, reply the error
+// and return false if not.
+func (c *client) authorized(scope auth.Scope, perm auth.Permission, keys ...string) bool {
+	if c.s.tokens == nil {
+		return true
+	}
+	if c.info.Scope&scope != scope {
+		c.reply("CLIENT_ERROR " + akerrors.ErrTokenScope.Error())
+		return false
+	}
+	for _, key := range keys {
+		ok, err := c.s.acls.Allowed(c.info, key, perm)
+		if err != nil {
+			c.reply("SERVER_ERROR " + err.Error())
+			return false
+		}
+		if !ok {
+			c.reply("CLIENT_ERROR " + akerrors.ErrAccessDenied.Error())
+			return false
+		}
+	}
+	return true
+}
+
+// authenticate answer the set which authenticates a client, its data is "<username> <token>", the username
+// is not used.
+func (c *client) authenticate(fields []string) error {
+	size := 0
+	if len(fields) >= 5 {
+		size, _ = strconv.Atoi(fields[4])
+	}
+	if size <= 0 || size > maxLineSize {
+		c.reply("CLIENT_ERROR authentication failure")
+		return lineError("memcache: bad authentication data")
+	}
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return err
+	}
+	if data[size] != '\r' || data[size+1] != '\n' {
+		c.reply("CLIENT_ERROR bad data chunk")
+		return lineError("memcache: bad data chunk")
+	}
+	credentials := strings.Fields(string(data[:size]))
+	if len(credentials) == 0 {
+		c.reply("CLIENT_ERROR authentication failure")
+		return nil
+	}
+	info, err := c.s.tokens.Lookup(credentials[len(credentials)-1])
+	if err != nil {
+		c.reply("CLIENT_ERROR authentication failure")
+		return nil
+	}
+	c.info = info
+	c.token = credentials[len(credentials)-1]
+	c.reply("STORED")
+	return nil
+}
+
+// expireAt turn memcached exptime to unix time in seconds, 0 means never expire.
+func expireAt(exptime int64) int64 {
+	switch {
+	case exptime == 0:
+		return 0
+	case exptime < 0:
+		// already expired
+		return -1
+	case exptime <= relativeExptimeLimit:
+		return time.Now().Unix() + exptime
+	default:
+		return exptime
+	}
+}
+
+// get answer get <key>* and gets <key>*, values written by other apis have flags 0.
+func (c *client) get(keys []string, withCas bool) {
+	if len(keys) == 0 {
+		c.reply("ERROR")
+		return
+	}
+	for _, key := range keys {
+		if !validKey(key) {
+			c.reply("CLIENT_ERROR bad command line format")
+			return
+		}
+	}
+	if !c.authorized(auth.ScopeRead, auth.PermRead, keys...) {
+		return
+	}
+	if withCas {
+		for _, key := range keys {
+			value, version, err := c.s.engine.SeekWithVersion(key)
+			if err != nil {
+				c.reply("SERVER_ERROR " + err.Error())
+				return
+			}
+			if value != nil {
+				c.value(key, value, " "+strconv.FormatUint(version, 10))
+			}
+		}
+		c.reply("END")
+		return
+	}
+	values, errs := c.s.engine.BatchSeek(keys)
+	for i, key := range keys {
+		if errs[i] != nil {
+			c.reply("SERVER_ERROR " + errs[i].Error())
+			return
+		}
+		if values[i] != nil {
+			c.value(key, values[i], "")
+		}
+	}
+	c.reply("END")
+}
+
+// value write a value of get with its client flags, cas is empty or the cas unique prefixed with a space.
+func (c *client) value(key string, value []byte, cas string) {
+	flags := "0"
+	attrs, err := c.s.engine.Attrs(key)
+	if err != nil {
+		logger.Errorf("memcache get flags of key %s error: %v", key, err)
+	}
+	if f, ok := attrs[flagsAttr]; ok {
+		flags = f
+	}
+	c.reply("VALUE " + key + " " + flags + " " + strconv.Itoa(len(value)) + cas)
+	c.w.Write(value)
+	c.w.WriteString("\r\n")
+}
+
+// store answer <command> <key> <flags> <exptime> <bytes> [<cas unique>] [noreply] followed by the data block.
+// A malformed data block leaves the connection unusable and closes it.
+func (c *client) store(fields []string) error {
+	cmd := fields[0]
+	n := 5
+	if cmd == "cas" {
+		n = 6
+	}
+	noreply := len(fields) == n+1 && fields[n] == "noreply"
+	if len(fields) != n && !noreply {
+		c.reply("ERROR")
+		return nil
+	}
+	key := fields[1]
+	flags, flagsErr := strconv.ParseUint(fields[2], 10, 32)
+	exptime, exptimeErr := strconv.ParseInt(fields[3], 10, 64)
+	size, sizeErr := strconv.Atoi(fields[4])
+	var cas uint64
+	var casErr error
+	if cmd == "cas" {
+		cas, casErr = strconv.ParseUint(fields[5], 10, 64)
+	}
+	if !validKey(key) || flagsErr != nil || exptimeErr != nil || sizeErr != nil || casErr != nil || size < 0 {
+		c.reply("CLIENT_ERROR bad command line format")
+		return nil
+	}
+	if size > maxItemSize {
+		c.reply("SERVER_ERROR object too large for cache")
+		// skip the data block so the connection stays in sync
+		if _, err := io.CopyN(ioutil.Discard, c.r, int64(size)+2); err != nil {
+			return err
+		}
+		return nil
+	}
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return err
+	}
+	if data[size] != '\r' || data[size+1] != '\n' {
+		c.reply("CLIENT_ERROR bad data chunk")
+		return lineError("memcache: bad data chunk")
+	}
+	value := data[:size]
+
+	if !c.authorized(auth.ScopeWrite, auth.PermWrite, key) {
+		return nil
+	}
+	if !c.s.engine.IsMaster() {
+		c.replyUnlessNoreply(noreply, "SERVER_ERROR this akita node isn't master node")
+		return nil
+	}
+
+	var attrs db.Attrs
+	if flags != 0 {
+		attrs = db.Attrs{flagsAttr: strconv.FormatUint(flags, 10)}
+	}
+	found := true
+	stored, lsn, err := c.s.engine.PutIf(key, value, expireAt(exptime), attrs, func(version uint64, exists bool) bool {
+		found = exists
+		switch cmd {
+		case "add":
+			return !exists
+		case "replace":
+			return exists
+		case "cas":
+			return exists && version == cas
+		}
+		return true
+	})
+	if stored {
+		err = c.confirmWrite(lsn)
+	}
+	switch {
+	case err != nil:
+		logger.Errorf("memcache %s key %s error: %v", cmd, key, err)
+		c.replyUnlessNoreply(noreply, "SERVER_ERROR "+err.Error())
+	case stored:
+		c.replyUnlessNoreply(noreply, "STORED")
+	case cmd == "cas" && !found:
+		c.replyUnlessNoreply(noreply, "NOT_FOUND")
+	case cmd == "cas":
+		c.replyUnlessNoreply(noreply, "EXISTS")
+	default:
+		c.replyUnlessNoreply(noreply, "NOT_STORED")
+	}
+	return nil
+}
+
+// confirmWrite wait for the write quorum to acknowledge the write at lsn, it fails with ErrQuorumTimeout
+// if too few replicas did in time.
+func (c *client) confirmWrite(lsn int64) error {
+	_, err := c.s.engine.WaitQuorum(lsn)
+	return err
+}
+
+func (c *client) replyUnlessNoreply(noreply bool, s string) {
+	if !noreply {
+		c.reply(s)
+	}
+}
+
+// delete answer delete <key> [noreply].
+func (c *client) delete(args []string) {
+	noreply := len(args) == 2 && args[1] == "noreply"
+	if (len(args) != 1 && !noreply) || !validKey(args[0]) {
+		c.reply("CLIENT_ERROR bad command line format")
+		return
+	}
+	if !c.authorized(auth.ScopeWrite, auth.PermDelete, args[0]) {
+		return
+	}
+	if !c.s.engine.IsMaster() {
+		c.replyUnlessNoreply(noreply, "SERVER_ERROR this akita node isn't master node")
+		return
+	}
+	deleted, _, lsn, err := c.s.engine.Delete(args[0])
+	if deleted {
+		err = c.confirmWrite(lsn)
+	}
+	switch {
+	case err != nil:
+		c.replyUnlessNoreply(noreply, "SERVER_ERROR "+err.Error())
+	case deleted:
+		c.replyUnlessNoreply(noreply, "DELETED")
+	default:
+		c.replyUnlessNoreply(noreply, "NOT_FOUND")
+	}
+}
+
+// touch answer touch <key> <exptime> [noreply].
+func (c *client) touch(args []string) {
+	noreply := len(args) == 3 && args[2] == "noreply"
+	if (len(args) != 2 && !noreply) || !validKey(args[0]) {
+		c.reply("CLIENT_ERROR bad command line format")
+		return
+	}
+	exptime, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		c.reply("CLIENT_ERROR bad command line format")
+		return
+	}
+	if !c.authorized(auth.ScopeWrite, auth.PermWrite, args[0]) {
+		return
+	}
+	if !c.s.engine.IsMaster() {
+		c.replyUnlessNoreply(noreply, "SERVER_ERROR this akita node isn't master node")
+		return
+	}
+	touched, lsn, err := c.s.engine.Expire(args[0], expireAt(exptime))
+	if touched {
+		err = c.confirmWrite(lsn)
+	}
+	switch {
+	case err != nil:
+		c.replyUnlessNoreply(noreply, "SERVER_ERROR "+err.Error())
+	case touched:
+		c.replyUnlessNoreply(noreply, "TOUCHED")
+	default:
+		c.replyUnlessNoreply(noreply, "NOT_FOUND")
+	}
+}
@@ -0,0 +1,167 @@
+package memcache
+
+import (
+	"akita/auth"
+	"akita/db"
+	"akita/logger"
+	"akita/ratelimit"
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+)
+
+// Server serves the memcached text protocol backed by engine.
+type Server struct {
+	sync.Mutex
+	addr     string
+	engine   *db.Engine
+	tokens   *auth.Tokens // nil if authentication is turned off
+	acls     *auth.ACLs
+	limiter  *ratelimit.Limiter // nil if limiting is turned off
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// ErrServerClosed is returned by ListenAndServe after Close.
+var ErrServerClosed = errors.New("memcache: server closed")
+
+// NewServer create a memcached server listening on addr. Unless tokens is nil clients must authenticate
+// first as memcached does in text protocol, with a set whose data is "<username> <token>".
+// Commands are limited by limiter unless it is nil.
+func NewServer(addr string, engine *db.Engine, tokens *auth.Tokens, limiter *ratelimit.Limiter) *Server {
+	s := &Server{
+		addr:    addr,
+		engine:  engine,
+		tokens:  tokens,
+		limiter: limiter,
+		conns:   make(map[net.Conn]struct{}),
+	}
+	if tokens != nil {
+		s.acls = auth.NewACLs(engine)
+	}
+	return s
+}
+
+// ListenAndServe listen on the server addr and serve connections until Close.
+func (s *Server) ListenAndServe() error {
+	l, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve serve connections accepted by l until Close.
+func (s *Server) Serve(l net.Listener) error {
+	s.Lock()
+	if s.closed {
+		s.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listener = l
+	s.Unlock()
+	logger.Infof("memcache server listening on %s", l.Addr())
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.Lock()
+			closed := s.closed
+			s.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return err
+		}
+		s.Lock()
+		if s.closed {
+			s.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// Close stop listening and close every connection.
+func (s *Server) Close() error {
+	s.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.Lock()
+		delete(s.conns, conn)
+		s.Unlock()
+		conn.Close()
+		s.wg.Done()
+	}()
+
+	counted := &countingConn{Conn: conn}
+	c := &client{
+		r:    bufio.NewReader(counted),
+		w:    bufio.NewWriter(counted),
+		s:    s,
+		addr: conn.RemoteAddr().String(),
+		conn: counted,
+	}
+	for {
+		quit, err := c.serveCommand()
+		if err != nil {
+			return
+		}
+		// pipelined commands are answered together
+		if c.r.Buffered() == 0 || quit {
+			if err := c.w.Flush(); err != nil {
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// countingConn counts the bytes read from and written to a connection.
+type countingConn struct {
+	net.Conn
+	read, written int64
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.read += int64(n)
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written += int64(n)
+	return n, err
+}
+
+// transferred return the bytes r consumed and w produced on the connection, whether or not they are buffered.
+func (c *countingConn) transferred(r *bufio.Reader, w *bufio.Writer) int64 {
+	return c.read - int64(r.Buffered()) + c.written + int64(w.Buffered())
+}
@@ -0,0 +1,316 @@
+package memcache
+
+import (
+	"akita/auth"
+	"akita/common"
+	"akita/db"
+	"akita/ratelimit"
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// startTestServer start a memcached server with an engine which is master if master is true.
+func startTestServer(t *testing.T, master bool) string {
+	return startTestServerWithTokens(t, master, "")
+}
+
+// startTestServerWithTokens start a memcached server requiring the static tokens of the token file content
+// unless it is empty.
+func startTestServerWithTokens(t *testing.T, master bool, tokenFile string) string {
+	return startLimitedTestServer(t, master, tokenFile, nil)
+}
+
+// startLimitedTestServer is like startTestServerWithTokens, commands are limited by limiter unless it is nil.
+func startLimitedTestServer(t *testing.T, master bool, tokenFile string, limiter *ratelimit.Limiter) string {
+	fPath := filepath.Join(t.TempDir(), "akita.dat")
+	f, err := os.Create(fPath)
+	if err != nil {
+		t.Fatalf("create data file error: %s.\n", err)
+	}
+	f.Close()
+	masterAddr := "203.0.113.1"
+	if master {
+		if masterAddr, err = common.GetIntranetIP(); err != nil {
+			t.Fatalf("get intranet ip error: %s.\n", err)
+		}
+	}
+	db.InitializeEngine(masterAddr, nil, "0", fPath, true, 100, false)
+	e := db.GetEngine()
+	e.StartBackground(1000, int64(time.Hour/time.Millisecond))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error: %s.\n", err)
+	}
+	var tokens *auth.Tokens
+	if tokenFile != "" {
+		path := filepath.Join(t.TempDir(), "tokens")
+		if err := ioutil.WriteFile(path, []byte(tokenFile), 0600); err != nil {
+			t.Fatalf("write token file error: %s.\n", err)
+		}
+		if tokens, err = auth.NewTokens(e, path); err != nil {
+			t.Fatalf("new tokens error: %s.\n", err)
+		}
+	}
+	s := NewServer(l.Addr().String(), e, tokens, limiter)
+	go s.Serve(l)
+	t.Cleanup(func() {
+		s.Close()
+		e.GetDB().Close()
+	})
+	return l.Addr().String()
+}
+
+func Test_Commands(t *testing.T) {
+	mc := memcache.New(startTestServer(t, true))
+
+	if err := mc.Set(&memcache.Item{Key: "k1", Value: []byte("v1")}); err != nil {
+		t.Fatalf("set error: %s.\n", err)
+	}
+	if item, err := mc.Get("k1"); err != nil || string(item.Value) != "v1" {
+		t.Fatalf("get k1 want v1, got %v %v.\n", item, err)
+	}
+	if err := mc.Add(&memcache.Item{Key: "k1", Value: []byte("v2")}); err != memcache.ErrNotStored {
+		t.Fatalf("add existing key want ErrNotStored, got %v.\n", err)
+	}
+	if err := mc.Replace(&memcache.Item{Key: "k2", Value: []byte("v2")}); err != memcache.ErrNotStored {
+		t.Fatalf("replace missing key want ErrNotStored, got %v.\n", err)
+	}
+	if err := mc.Add(&memcache.Item{Key: "k2", Value: []byte("v2")}); err != nil {
+		t.Fatalf("add error: %s.\n", err)
+	}
+	if err := mc.Replace(&memcache.Item{Key: "k2", Value: []byte("v3")}); err != nil {
+		t.Fatalf("replace error: %s.\n", err)
+	}
+
+	items, err := mc.GetMulti([]string{"k1", "k2", "k3"})
+	if err != nil || len(items) != 2 || string(items["k2"].Value) != "v3" {
+		t.Fatalf("get multi want k1 and k2, got %v %v.\n", items, err)
+	}
+
+	if err := mc.Delete("k1"); err != nil {
+		t.Fatalf("delete error: %s.\n", err)
+	}
+	if err := mc.Delete("k1"); err != memcache.ErrCacheMiss {
+		t.Fatalf("delete missing key want ErrCacheMiss, got %v.\n", err)
+	}
+	if _, err := mc.Get("k1"); err != memcache.ErrCacheMiss {
+		t.Fatalf("get deleted key want ErrCacheMiss, got %v.\n", err)
+	}
+}
+
+func Test_Flags(t *testing.T) {
+	mc := memcache.New(startTestServer(t, true))
+
+	if err := mc.Set(&memcache.Item{Key: "k", Value: []byte("v1"), Flags: 0xdeadbeef}); err != nil {
+		t.Fatalf("set error: %s.\n", err)
+	}
+	if item, err := mc.Get("k"); err != nil || item.Flags != 0xdeadbeef {
+		t.Fatalf("get flags want 0xdeadbeef, got %v %v.\n", item, err)
+	}
+	if err := mc.Touch("k", 60); err != nil {
+		t.Fatalf("touch error: %s.\n", err)
+	}
+	if items, err := mc.GetMulti([]string{"k"}); err != nil || items["k"].Flags != 0xdeadbeef {
+		t.Fatalf("flags after touch want 0xdeadbeef, got %v %v.\n", items, err)
+	}
+	// appending to and incrementing a value through another api keep its flags
+	if _, _, err := db.GetEngine().Append("k", []byte("a")); err != nil {
+		t.Fatalf("append error: %s.\n", err)
+	}
+	if item, err := mc.Get("k"); err != nil || item.Flags != 0xdeadbeef || string(item.Value) != "v1a" {
+		t.Fatalf("get after append want v1a with flags 0xdeadbeef, got %v %v.\n", item, err)
+	}
+	if err := mc.Set(&memcache.Item{Key: "n", Value: []byte("1"), Flags: 7}); err != nil {
+		t.Fatalf("set error: %s.\n", err)
+	}
+	if _, _, err := db.GetEngine().Incr("n", 1); err != nil {
+		t.Fatalf("incr error: %s.\n", err)
+	}
+	if item, err := mc.Get("n"); err != nil || item.Flags != 7 || string(item.Value) != "2" {
+		t.Fatalf("get after incr want 2 with flags 7, got %v %v.\n", item, err)
+	}
+	// a value written by another api has no flags
+	if err := db.GetEngine().Put("k", []byte("v2")); err != nil {
+		t.Fatalf("put error: %s.\n", err)
+	}
+	if item, err := mc.Get("k"); err != nil || item.Flags != 0 {
+		t.Fatalf("get flags after put want 0, got %v %v.\n", item, err)
+	}
+}
+
+func Test_Cas(t *testing.T) {
+	mc := memcache.New(startTestServer(t, true))
+
+	if err := mc.Set(&memcache.Item{Key: "k", Value: []byte("v1")}); err != nil {
+		t.Fatalf("set error: %s.\n", err)
+	}
+	item, err := mc.Get("k")
+	if err != nil {
+		t.Fatalf("gets error: %s.\n", err)
+	}
+	// a write between gets and cas makes cas fail
+	if err := mc.Set(&memcache.Item{Key: "k", Value: []byte("v2")}); err != nil {
+		t.Fatalf("set error: %s.\n", err)
+	}
+	item.Value = []byte("v3")
+	if err := mc.CompareAndSwap(item); err != memcache.ErrCASConflict {
+		t.Fatalf("cas with stale unique want ErrCASConflict, got %v.\n", err)
+	}
+
+	if item, err = mc.Get("k"); err != nil {
+		t.Fatalf("gets error: %s.\n", err)
+	}
+	item.Value = []byte("v3")
+	if err := mc.CompareAndSwap(item); err != nil {
+		t.Fatalf("cas error: %s.\n", err)
+	}
+	if item, err := mc.Get("k"); err != nil || string(item.Value) != "v3" {
+		t.Fatalf("get k want v3, got %v %v.\n", item, err)
+	}
+
+	mc.Delete("k")
+	if err := mc.CompareAndSwap(item); err != memcache.ErrCacheMiss {
+		t.Fatalf("cas on missing key want ErrCacheMiss, got %v.\n", err)
+	}
+}
+
+func Test_Expiration(t *testing.T) {
+	mc := memcache.New(startTestServer(t, true))
+
+	if err := mc.Set(&memcache.Item{Key: "k1", Value: []byte("v1"), Expiration: 1}); err != nil {
+		t.Fatalf("set error: %s.\n", err)
+	}
+	// an absolute unix time in the past expires the item immediately
+	if err := mc.Set(&memcache.Item{Key: "k2", Value: []byte("v2"), Expiration: int32(time.Now().Unix() - 10)}); err != nil {
+		t.Fatalf("set error: %s.\n", err)
+	}
+	if _, err := mc.Get("k2"); err != memcache.ErrCacheMiss {
+		t.Fatalf("get expired key want ErrCacheMiss, got %v.\n", err)
+	}
+
+	if err := mc.Set(&memcache.Item{Key: "k3", Value: []byte("v3"), Expiration: 1}); err != nil {
+		t.Fatalf("set error: %s.\n", err)
+	}
+	if err := mc.Touch("k3", 0); err != nil {
+		t.Fatalf("touch error: %s.\n", err)
+	}
+	if err := mc.Touch("k4", 10); err != memcache.ErrCacheMiss {
+		t.Fatalf("touch missing key want ErrCacheMiss, got %v.\n", err)
+	}
+
+	time.Sleep(2100 * time.Millisecond)
+	if _, err := mc.Get("k1"); err != memcache.ErrCacheMiss {
+		t.Fatalf("get expired key want ErrCacheMiss, got %v.\n", err)
+	}
+	if item, err := mc.Get("k3"); err != nil || string(item.Value) != "v3" {
+		t.Fatalf("get touched key want v3, got %v %v.\n", item, err)
+	}
+}
+
+func Test_ReadOnlySlave(t *testing.T) {
+	addr := startTestServer(t, false)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial error: %s.\n", err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	conn.Write([]byte("set k 0 0 1\r\nv\r\nget k\r\n"))
+	line, _ := r.ReadString('\n')
+	if !strings.HasPrefix(line, "SERVER_ERROR") {
+		t.Fatalf("set on slave want SERVER_ERROR, got %q.\n", line)
+	}
+	if line, _ = r.ReadString('\n'); line != "END\r\n" {
+		t.Fatalf("get on slave want END, got %q.\n", line)
+	}
+}
+
+func Test_Auth(t *testing.T) {
+	addr := startTestServerWithTokens(t, true, "rw-token read,write app\n")
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial error: %s.\n", err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for _, c := range []struct {
+		command string
+		reply   string
+	}{
+		{"get k\r\n", "CLIENT_ERROR unauthenticated\r\n"},
+		{"set auth 0 0 11\r\napp wrong-1\r\n", "CLIENT_ERROR authentication failure\r\n"},
+		{"set auth 0 0 12\r\napp rw-token\r\n", "STORED\r\n"},
+		{"set k 0 0 1\r\nv\r\n", "STORED\r\n"},
+		{"set _tokens/x 0 0 2\r\n{}\r\n", "CLIENT_ERROR the acl of the namespace does not grant this request. \r\n"},
+		{"get k\r\n", "VALUE k 0 1\r\n"},
+	} {
+		if _, err := conn.Write([]byte(c.command)); err != nil {
+			t.Fatalf("write error: %s.\n", err)
+		}
+		if line, err := r.ReadString('\n'); err != nil || line != c.reply {
+			t.Fatalf("reply to %q want %q, got %q %v.\n", c.command, c.reply, line, err)
+		}
+	}
+}
+
+func Test_Limit(t *testing.T) {
+	var backlog int32
+	limiter := ratelimit.NewLimiter(ratelimit.Config{
+		RequestsPerSecond: 1,
+		RequestBurst:      3,
+		MaxWriteBacklog:   0.5,
+		WriteBacklog:      func() float64 { return float64(atomic.LoadInt32(&backlog)) },
+	})
+	conn, err := net.Dial("tcp", startLimitedTestServer(t, true, "", limiter))
+	if err != nil {
+		t.Fatalf("dial error: %s.\n", err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for _, c := range []struct {
+		backlog int32
+		command string
+		reply   string
+	}{
+		{0, "get k\r\n", "END\r\n"},
+		// the data block of a refused set is skipped
+		{1, "set k 0 0 1\r\nv\r\n", "SERVER_ERROR server is overloaded, retry later. \r\n"},
+		{1, "get k\r\n", "END\r\n"},
+		{0, "set k 0 0 1\r\nv\r\n", "STORED\r\n"},
+		{0, "get k\r\n", "SERVER_ERROR too many requests, retry later. \r\n"},
+		{0, "version\r\n", "VERSION akita\r\n"},
+	} {
+		atomic.StoreInt32(&backlog, c.backlog)
+		if _, err := conn.Write([]byte(c.command)); err != nil {
+			t.Fatalf("write error: %s.\n", err)
+		}
+		if line, err := r.ReadString('\n'); err != nil || line != c.reply {
+			t.Fatalf("reply to %q want %q, got %q %v.\n", c.command, c.reply, line, err)
+		}
+	}
+}
+
+func Test_WriteQuorum(t *testing.T) {
+	mc := memcache.New(startTestServer(t, true))
+
+	db.GetEngine().UseWriteQuorum(1, 10*time.Millisecond, false)
+	err := mc.Set(&memcache.Item{Key: "k", Value: []byte("v")})
+	if err == nil || !strings.Contains(err.Error(), "too few replicas") {
+		t.Fatalf("set without write quorum want quorum timeout, got %v.\n", err)
+	}
+	// the value is stored on the master anyway
+	if item, err := mc.Get("k"); err != nil || string(item.Value) != "v" {
+		t.Fatalf("get k: %v, %v.\n", item, err)
+	}
+}
@@ -0,0 +1,378 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion7
+
+// AkitaClient is the client API for Akita service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AkitaClient interface {
+	// Put store a value, the first request carries the key and expiration, every request a chunk of the value.
+	Put(ctx context.Context, opts ...grpc.CallOption) (Akita_PutClient, error)
+	// Get stream the value of key in chunks, the first response carries the value size.
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (Akita_GetClient, error)
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+	// Scan iterate keys matching a glob pattern, a returned cursor of 0 ends the iteration.
+	Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (*ScanResponse, error)
+	// BatchWrite store several values with one data file write.
+	BatchWrite(ctx context.Context, in *BatchWriteRequest, opts ...grpc.CallOption) (*BatchWriteResponse, error)
+	// Watch stream changes of keys starting with prefix, after the event at position after if it is set.
+	// A watch whose position is no longer kept fails with OUT_OF_RANGE, a watcher falling behind with ABORTED.
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (Akita_WatchClient, error)
+}
+
+type akitaClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAkitaClient(cc grpc.ClientConnInterface) AkitaClient {
+	return &akitaClient{cc}
+}
+
+func (c *akitaClient) Put(ctx context.Context, opts ...grpc.CallOption) (Akita_PutClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Akita_serviceDesc.Streams[0], "/pb.Akita/Put", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &akitaPutClient{stream}
+	return x, nil
+}
+
+type Akita_PutClient interface {
+	Send(*PutRequest) error
+	CloseAndRecv() (*PutResponse, error)
+	grpc.ClientStream
+}
+
+type akitaPutClient struct {
+	grpc.ClientStream
+}
+
+func (x *akitaPutClient) Send(m *PutRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *akitaPutClient) CloseAndRecv() (*PutResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(PutResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *akitaClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (Akita_GetClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Akita_serviceDesc.Streams[1], "/pb.Akita/Get", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &akitaGetClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Akita_GetClient interface {
+	Recv() (*GetResponse, error)
+	grpc.ClientStream
+}
+
+type akitaGetClient struct {
+	grpc.ClientStream
+}
+
+func (x *akitaGetClient) Recv() (*GetResponse, error) {
+	m := new(GetResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *akitaClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error) {
+	out := new(DeleteResponse)
+	err := c.cc.Invoke(ctx, "/pb.Akita/Delete", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *akitaClient) Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (*ScanResponse, error) {
+	out := new(ScanResponse)
+	err := c.cc.Invoke(ctx, "/pb.Akita/Scan", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *akitaClient) BatchWrite(ctx context.Context, in *BatchWriteRequest, opts ...grpc.CallOption) (*BatchWriteResponse, error) {
+	out := new(BatchWriteResponse)
+	err := c.cc.Invoke(ctx, "/pb.Akita/BatchWrite", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *akitaClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (Akita_WatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Akita_serviceDesc.Streams[2], "/pb.Akita/Watch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &akitaWatchClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Akita_WatchClient interface {
+	Recv() (*WatchEvent, error)
+	grpc.ClientStream
+}
+
+type akitaWatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *akitaWatchClient) Recv() (*WatchEvent, error) {
+	m := new(WatchEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// AkitaServer is the server API for Akita service.
+// All implementations must embed UnimplementedAkitaServer
+// for forward compatibility
+type AkitaServer interface {
+	// Put store a value, the first request carries the key and expiration, every request a chunk of the value.
+	Put(Akita_PutServer) error
+	// Get stream the value of key in chunks, the first response carries the value size.
+	Get(*GetRequest, Akita_GetServer) error
+	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	// Scan iterate keys matching a glob pattern, a returned cursor of 0 ends the iteration.
+	Scan(context.Context, *ScanRequest) (*ScanResponse, error)
+	// BatchWrite store several values with one data file write.
+	BatchWrite(context.Context, *BatchWriteRequest) (*BatchWriteResponse, error)
+	// Watch stream changes of keys starting with prefix, after the event at position after if it is set.
+	// A watch whose position is no longer kept fails with OUT_OF_RANGE, a watcher falling behind with ABORTED.
+	Watch(*WatchRequest, Akita_WatchServer) error
+	mustEmbedUnimplementedAkitaServer()
+}
+
+// UnimplementedAkitaServer must be embedded to have forward compatible implementations.
+type UnimplementedAkitaServer struct {
+}
+
+func (UnimplementedAkitaServer) Put(Akita_PutServer) error {
+	return status.Errorf(codes.Unimplemented, "method Put not implemented")
+}
+func (UnimplementedAkitaServer) Get(*GetRequest, Akita_GetServer) error {
+	return status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedAkitaServer) Delete(context.Context, *DeleteRequest) (*DeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedAkitaServer) Scan(context.Context, *ScanRequest) (*ScanResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Scan not implemented")
+}
+func (UnimplementedAkitaServer) BatchWrite(context.Context, *BatchWriteRequest) (*BatchWriteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchWrite not implemented")
+}
+func (UnimplementedAkitaServer) Watch(*WatchRequest, Akita_WatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedAkitaServer) mustEmbedUnimplementedAkitaServer() {}
+
+// UnsafeAkitaServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AkitaServer will
+// result in compilation errors.
+type UnsafeAkitaServer interface {
+	mustEmbedUnimplementedAkitaServer()
+}
+
+func RegisterAkitaServer(s grpc.ServiceRegistrar, srv AkitaServer) {
+	s.RegisterService(&_Akita_serviceDesc, srv)
+}
+
+func _Akita_Put_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(AkitaServer).Put(&akitaPutServer{stream})
+}
+
+type Akita_PutServer interface {
+	SendAndClose(*PutResponse) error
+	Recv() (*PutRequest, error)
+	grpc.ServerStream
+}
+
+type akitaPutServer struct {
+	grpc.ServerStream
+}
+
+func (x *akitaPutServer) SendAndClose(m *PutResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *akitaPutServer) Recv() (*PutRequest, error) {
+	m := new(PutRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Akita_Get_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(GetRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AkitaServer).Get(m, &akitaGetServer{stream})
+}
+
+type Akita_GetServer interface {
+	Send(*GetResponse) error
+	grpc.ServerStream
+}
+
+type akitaGetServer struct {
+	grpc.ServerStream
+}
+
+func (x *akitaGetServer) Send(m *GetResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Akita_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AkitaServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Akita/Delete",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AkitaServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Akita_Scan_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ScanRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AkitaServer).Scan(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Akita/Scan",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AkitaServer).Scan(ctx, req.(*ScanRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Akita_BatchWrite_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchWriteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AkitaServer).BatchWrite(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.Akita/BatchWrite",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AkitaServer).BatchWrite(ctx, req.(*BatchWriteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Akita_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AkitaServer).Watch(m, &akitaWatchServer{stream})
+}
+
+type Akita_WatchServer interface {
+	Send(*WatchEvent) error
+	grpc.ServerStream
+}
+
+type akitaWatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *akitaWatchServer) Send(m *WatchEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+var _Akita_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "pb.Akita",
+	HandlerType: (*AkitaServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Delete",
+			Handler:    _Akita_Delete_Handler,
+		},
+		{
+			MethodName: "Scan",
+			Handler:    _Akita_Scan_Handler,
+		},
+		{
+			MethodName: "BatchWrite",
+			Handler:    _Akita_BatchWrite_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Put",
+			Handler:       _Akita_Put_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Get",
+			Handler:       _Akita_Get_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Watch",
+			Handler:       _Akita_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "akita.proto",
+}
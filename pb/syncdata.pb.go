@@ -32,6 +32,7 @@ type SyncData struct {
 
 	Code int32  `protobuf:"varint,8,opt,name=Code,proto3" json:"Code,omitempty"`
 	Data []byte `protobuf:"bytes,7,opt,name=Data,proto3" json:"Data,omitempty"`
+	More bool   `protobuf:"varint,9,opt,name=more,proto3" json:"more,omitempty"` // more records follow Data, the slave asks again right away
 }
 
 func (x *SyncData) Reset() {
@@ -80,12 +81,23 @@ func (x *SyncData) GetData() []byte {
 	return nil
 }
 
+func (x *SyncData) GetMore() bool {
+	if x != nil {
+		return x.More
+	}
+	return false
+}
+
+// SyncOffset is the position a slave syncs from, it asks for the records after lsn.
+// The position is also what the slave acknowledges to have written.
 type SyncOffset struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Offset int64 `protobuf:"varint,2,opt,name=offset,proto3" json:"offset,omitempty"`
+	Lsn          int64  `protobuf:"varint,3,opt,name=lsn,proto3" json:"lsn,omitempty"`
+	NodeId       string `protobuf:"bytes,4,opt,name=node_id,json=nodeId,proto3" json:"node_id,omitempty"`                    // id of the slave, the master keeps track of its slaves by it
+	LegacyOffset int64  `protobuf:"varint,5,opt,name=legacy_offset,json=legacyOffset,proto3" json:"legacy_offset,omitempty"` // bytes of the records without lsns a slave at lsn 0 has written
 }
 
 func (x *SyncOffset) Reset() {
@@ -120,9 +132,23 @@ func (*SyncOffset) Descriptor() ([]byte, []int) {
 	return file_syncdata_proto_rawDescGZIP(), []int{1}
 }
 
-func (x *SyncOffset) GetOffset() int64 {
+func (x *SyncOffset) GetLsn() int64 {
+	if x != nil {
+		return x.Lsn
+	}
+	return 0
+}
+
+func (x *SyncOffset) GetNodeId() string {
+	if x != nil {
+		return x.NodeId
+	}
+	return ""
+}
+
+func (x *SyncOffset) GetLegacyOffset() int64 {
 	if x != nil {
-		return x.Offset
+		return x.LegacyOffset
 	}
 	return 0
 }
@@ -131,13 +157,18 @@ var File_syncdata_proto protoreflect.FileDescriptor
 
 var file_syncdata_proto_rawDesc = []byte{
 	0x0a, 0x0e, 0x73, 0x79, 0x6e, 0x63, 0x64, 0x61, 0x74, 0x61, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
-	0x12, 0x02, 0x70, 0x62, 0x22, 0x32, 0x0a, 0x08, 0x53, 0x79, 0x6e, 0x63, 0x44, 0x61, 0x74, 0x61,
+	0x12, 0x02, 0x70, 0x62, 0x22, 0x46, 0x0a, 0x08, 0x53, 0x79, 0x6e, 0x63, 0x44, 0x61, 0x74, 0x61,
 	0x12, 0x12, 0x0a, 0x04, 0x43, 0x6f, 0x64, 0x65, 0x18, 0x08, 0x20, 0x01, 0x28, 0x05, 0x52, 0x04,
 	0x43, 0x6f, 0x64, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x44, 0x61, 0x74, 0x61, 0x18, 0x07, 0x20, 0x01,
-	0x28, 0x0c, 0x52, 0x04, 0x44, 0x61, 0x74, 0x61, 0x22, 0x24, 0x0a, 0x0a, 0x53, 0x79, 0x6e, 0x63,
-	0x4f, 0x66, 0x66, 0x73, 0x65, 0x74, 0x12, 0x16, 0x0a, 0x06, 0x6f, 0x66, 0x66, 0x73, 0x65, 0x74,
-	0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x6f, 0x66, 0x66, 0x73, 0x65, 0x74, 0x42, 0x06,
-	0x5a, 0x04, 0x2e, 0x3b, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x28, 0x0c, 0x52, 0x04, 0x44, 0x61, 0x74, 0x61, 0x12, 0x12, 0x0a, 0x04, 0x6d, 0x6f, 0x72, 0x65,
+	0x18, 0x09, 0x20, 0x01, 0x28, 0x08, 0x52, 0x04, 0x6d, 0x6f, 0x72, 0x65, 0x22, 0x62, 0x0a, 0x0a,
+	0x53, 0x79, 0x6e, 0x63, 0x4f, 0x66, 0x66, 0x73, 0x65, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x6c, 0x73,
+	0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x03, 0x6c, 0x73, 0x6e, 0x12, 0x17, 0x0a, 0x07,
+	0x6e, 0x6f, 0x64, 0x65, 0x5f, 0x69, 0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x6e,
+	0x6f, 0x64, 0x65, 0x49, 0x64, 0x12, 0x23, 0x0a, 0x0d, 0x6c, 0x65, 0x67, 0x61, 0x63, 0x79, 0x5f,
+	0x6f, 0x66, 0x66, 0x73, 0x65, 0x74, 0x18, 0x05, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0c, 0x6c, 0x65,
+	0x67, 0x61, 0x63, 0x79, 0x4f, 0x66, 0x66, 0x73, 0x65, 0x74, 0x4a, 0x04, 0x08, 0x02, 0x10, 0x03,
+	0x42, 0x06, 0x5a, 0x04, 0x2e, 0x3b, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
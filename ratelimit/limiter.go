@@ -0,0 +1,355 @@
+package ratelimit
+
+import (
+	"akita/auth"
+	akerrors "akita/errors"
+	akhttp "akita/http"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// clients whose buckets are full are forgotten on the sweep after this interval
+	sweepInterval = time.Minute
+)
+
+// Config of a limiter, a zero limit is unlimited.
+type Config struct {
+	RequestsPerSecond float64       // requests of a client per second
+	RequestBurst      int           // requests a client may send at once
+	BytesPerSecond    float64       // request and response body bytes of a client per second
+	ByteBurst         int64         // body bytes a client may transfer at once
+	MaxConcurrent     int           // requests served at once
+	QueueTimeout      time.Duration // time a request waits for one of MaxConcurrent before it is shed
+	MaxWriteBacklog   float64       // writes are shed when WriteBacklog is at least this fraction
+	WriteBacklog      func() float64
+	ByToken           bool // identify clients by api token, by ip if the request has no token
+}
+
+// Limiter limits the rate of requests and bytes of every client with token buckets,
+// and sheds requests when the server is overloaded.
+type Limiter struct {
+	sync.Mutex
+	config    Config
+	clients   map[string]*client
+	slots     chan struct{} // a request holds a slot while it is served, nil if unlimited
+	lastSweep time.Time
+}
+
+// client are the buckets of a client identity.
+type client struct {
+	requests bucket
+	bytes    bucket
+}
+
+// NewLimiter create a limiter of config, a zero burst is the amount of one second.
+func NewLimiter(config Config) *Limiter {
+	if config.RequestBurst <= 0 {
+		config.RequestBurst = int(math.Max(1, math.Ceil(config.RequestsPerSecond)))
+	}
+	if config.ByteBurst <= 0 {
+		config.ByteBurst = int64(math.Ceil(config.BytesPerSecond))
+	}
+	l := &Limiter{
+		config:    config,
+		clients:   make(map[string]*client),
+		lastSweep: time.Now(),
+	}
+	if config.MaxConcurrent > 0 {
+		l.slots = make(chan struct{}, config.MaxConcurrent)
+	}
+	return l
+}
+
+// Limit return a handler serving h within the limits, the request is a read.
+// If l is nil limiting is turned off and h is returned.
+func (l *Limiter) Limit(h http.HandlerFunc) http.HandlerFunc {
+	return l.LimitFunc(func(*http.Request) bool { return false }, h)
+}
+
+// LimitWrite is like Limit, the request is a write which is shed when the write queue is backlogged.
+func (l *Limiter) LimitWrite(h http.HandlerFunc) http.HandlerFunc {
+	return l.LimitFunc(func(*http.Request) bool { return true }, h)
+}
+
+// LimitFunc is like Limit, isWrite decides whether the request is a write.
+func (l *Limiter) LimitFunc(isWrite func(*http.Request) bool, h http.HandlerFunc) http.HandlerFunc {
+	if l == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, req *http.Request) {
+		id := l.identify(req)
+		reserved := req.ContentLength
+		if reserved < 0 {
+			reserved = 0
+		}
+		done, wait, err := l.Admit(id, isWrite(req), reserved)
+		switch err {
+		case nil:
+		case akerrors.ErrRateLimited:
+			writeRateLimited(w, wait)
+			return
+		default:
+			writeOverloaded(w)
+			return
+		}
+		body := &countingReader{ReadCloser: req.Body}
+		req.Body = body
+		cw := &countingWriter{ResponseWriter: w}
+		h(cw, req)
+		// bodies of unknown length and responses are charged after they are transferred
+		extra := body.n - reserved
+		if extra < 0 {
+			extra = 0
+		}
+		done(extra + cw.n)
+	}
+}
+
+// LimitStream is like Limit for requests served for a long time, such as watch streams.
+// Only the request rate is limited, the request holds no concurrency slot.
+func (l *Limiter) LimitStream(h http.HandlerFunc) http.HandlerFunc {
+	if l == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, req *http.Request) {
+		if wait := l.take(l.identify(req), 0); wait > 0 {
+			writeRateLimited(w, wait)
+			return
+		}
+		h(w, req)
+	}
+}
+
+// Admit admit a request of client id which is not served over http, such as a grpc call or a redis command,
+// carrying n bytes. A write is shed when the write queue is backlogged. An admitted request holds a concurrency slot
+// until done is called with the bytes it transferred besides n. Otherwise err is ErrOverloaded, or ErrRateLimited
+// and wait is how long the client should wait. If l is nil every request is admitted.
+func (l *Limiter) Admit(id string, write bool, n int64) (done func(transferred int64), wait time.Duration, err error) {
+	if l == nil {
+		return func(int64) {}, 0, nil
+	}
+	if write && l.writeBacklogged() {
+		return nil, 0, akerrors.ErrOverloaded
+	}
+	if !l.acquire() {
+		return nil, 0, akerrors.ErrOverloaded
+	}
+	if wait := l.take(id, n); wait > 0 {
+		l.release()
+		return nil, wait, akerrors.ErrRateLimited
+	}
+	return func(transferred int64) {
+		l.charge(id, transferred)
+		l.release()
+	}, 0, nil
+}
+
+// AdmitStream is like Admit for requests served for a long time, such as watch streams.
+// Only the request rate is limited, the request holds no concurrency slot.
+func (l *Limiter) AdmitStream(id string) (time.Duration, error) {
+	if l == nil {
+		return 0, nil
+	}
+	if wait := l.take(id, 0); wait > 0 {
+		return wait, akerrors.ErrRateLimited
+	}
+	return 0, nil
+}
+
+// ClientID return the identity of a client sending token from addr, a digest of the token or the ip of addr.
+func (l *Limiter) ClientID(token string, addr string) string {
+	if l != nil && l.config.ByToken && token != "" {
+		sum := sha256.Sum256([]byte(token))
+		return "token:" + hex.EncodeToString(sum[:])
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return "ip:" + host
+}
+
+// identify return the client identity of req.
+func (l *Limiter) identify(req *http.Request) string {
+	return l.ClientID(auth.RequestToken(req), req.RemoteAddr)
+}
+
+// take take a request and n bytes from the buckets of client id,
+// return how long the client should wait if they are not available.
+func (l *Limiter) take(id string, n int64) time.Duration {
+	now := time.Now()
+	l.Lock()
+	defer l.Unlock()
+	l.sweep(now)
+	c := l.client(id)
+	requests, bytes := float64(l.config.RequestBurst), float64(l.config.ByteBurst)
+	wait := c.requests.wait(now, l.config.RequestsPerSecond, requests, 1)
+	if w := c.bytes.wait(now, l.config.BytesPerSecond, bytes, float64(n)); w > wait {
+		wait = w
+	}
+	if wait > 0 {
+		return wait
+	}
+	c.requests.take(1, l.config.RequestsPerSecond)
+	c.bytes.take(float64(n), l.config.BytesPerSecond)
+	return 0
+}
+
+// charge take n bytes from the byte bucket of client id, it may go into debt.
+func (l *Limiter) charge(id string, n int64) {
+	if n == 0 || l.config.BytesPerSecond <= 0 {
+		return
+	}
+	now := time.Now()
+	l.Lock()
+	defer l.Unlock()
+	c := l.client(id)
+	c.bytes.refill(now, l.config.BytesPerSecond, float64(l.config.ByteBurst))
+	c.bytes.take(float64(n), l.config.BytesPerSecond)
+}
+
+// client return the buckets of id, a new client starts with full buckets.
+func (l *Limiter) client(id string) *client {
+	c, ok := l.clients[id]
+	if !ok {
+		now := time.Now()
+		c = &client{
+			requests: bucket{tokens: float64(l.config.RequestBurst), last: now},
+			bytes:    bucket{tokens: float64(l.config.ByteBurst), last: now},
+		}
+		l.clients[id] = c
+	}
+	return c
+}
+
+// sweep forget clients whose buckets are full, they are the same as new clients.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for id, c := range l.clients {
+		full := c.requests.refill(now, l.config.RequestsPerSecond, float64(l.config.RequestBurst)) &&
+			c.bytes.refill(now, l.config.BytesPerSecond, float64(l.config.ByteBurst))
+		if full {
+			delete(l.clients, id)
+		}
+	}
+}
+
+// acquire take a concurrency slot, waiting at most the queue timeout. It returns false if the request is shed.
+func (l *Limiter) acquire() bool {
+	if l.slots == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if l.config.QueueTimeout <= 0 {
+		return false
+	}
+	timer := time.NewTimer(l.config.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (l *Limiter) release() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+func (l *Limiter) writeBacklogged() bool {
+	return l.config.WriteBacklog != nil && l.config.MaxWriteBacklog > 0 && l.config.WriteBacklog() >= l.config.MaxWriteBacklog
+}
+
+// bucket is a token bucket, tokens are negative while a client is in debt.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill add the tokens earned since the last refill, return whether the bucket is full.
+func (b *bucket) refill(now time.Time, rate float64, burst float64) bool {
+	if rate <= 0 {
+		return true
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	return b.tokens >= burst
+}
+
+// wait refill the bucket, return how long until n tokens can be taken. More than burst tokens
+// can be taken from a full bucket, leaving it in debt.
+func (b *bucket) wait(now time.Time, rate float64, burst float64, n float64) time.Duration {
+	if rate <= 0 {
+		return 0
+	}
+	b.refill(now, rate, burst)
+	need := math.Min(n, burst)
+	if b.tokens >= need {
+		return 0
+	}
+	return time.Duration((need - b.tokens) / rate * float64(time.Second))
+}
+
+func (b *bucket) take(n float64, rate float64) {
+	if rate > 0 {
+		b.tokens -= n
+	}
+}
+
+func writeRateLimited(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	akhttp.WriteError(w, http.StatusTooManyRequests, akhttp.ErrCodeRateLimited, akerrors.ErrRateLimited.Error())
+}
+
+func writeOverloaded(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "1")
+	akhttp.WriteError(w, http.StatusServiceUnavailable, akhttp.ErrCodeOverloaded, akerrors.ErrOverloaded.Error())
+}
+
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// countingWriter counts the bytes of a response body.
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *countingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,120 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func serve(h http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h(w, req)
+	return w
+}
+
+func request(remoteAddr string, token string, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/akita/save/", strings.NewReader(body))
+	req.RemoteAddr = remoteAddr
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+func ok(w http.ResponseWriter, req *http.Request) {
+	w.Write([]byte("ok"))
+}
+
+func Test_RequestRate(t *testing.T) {
+	l := NewLimiter(Config{RequestsPerSecond: 1, RequestBurst: 2, ByToken: true})
+	h := l.Limit(ok)
+
+	for i := 0; i < 2; i++ {
+		if w := serve(h, request("10.0.0.1:1000", "", "")); w.Code != http.StatusOK {
+			t.Fatalf("request %d within burst: status %d", i, w.Code)
+		}
+	}
+	w := serve(h, request("10.0.0.1:1001", "", ""))
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over burst: status %d, want 429", w.Code)
+	}
+	if w.Header().Get("Retry-After") != "1" {
+		t.Fatalf("Retry-After %q, want 1", w.Header().Get("Retry-After"))
+	}
+
+	// other clients have their own buckets
+	if w := serve(h, request("10.0.0.2:1000", "", "")); w.Code != http.StatusOK {
+		t.Fatalf("request of another ip: status %d", w.Code)
+	}
+	if w := serve(h, request("10.0.0.1:1000", "secret", "")); w.Code != http.StatusOK {
+		t.Fatalf("request with token from a limited ip: status %d", w.Code)
+	}
+}
+
+func Test_ByteRate(t *testing.T) {
+	l := NewLimiter(Config{BytesPerSecond: 100, ByteBurst: 100})
+	h := l.Limit(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(make([]byte, 150))
+	})
+
+	// a transfer larger than the burst is served from a full bucket, leaving it in debt
+	if w := serve(h, request("10.0.0.1:1000", "", "0123456789")); w.Code != http.StatusOK {
+		t.Fatalf("first request: status %d", w.Code)
+	}
+	w := serve(h, request("10.0.0.1:1000", "", ""))
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request in debt: status %d, want 429", w.Code)
+	}
+	// 60 bytes of debt take 0.6 seconds to pay
+	if w.Header().Get("Retry-After") != "1" {
+		t.Fatalf("Retry-After %q, want 1", w.Header().Get("Retry-After"))
+	}
+}
+
+func Test_Shedding(t *testing.T) {
+	backlog := 0.0
+	l := NewLimiter(Config{
+		MaxConcurrent:   1,
+		QueueTimeout:    10 * time.Millisecond,
+		MaxWriteBacklog: 0.8,
+		WriteBacklog:    func() float64 { return backlog },
+	})
+	entered, release := make(chan struct{}), make(chan struct{})
+	blocking := l.Limit(func(w http.ResponseWriter, req *http.Request) {
+		close(entered)
+		<-release
+	})
+	done := make(chan struct{})
+	go func() {
+		serve(blocking, request("10.0.0.1:1000", "", ""))
+		close(done)
+	}()
+	<-entered
+
+	w := serve(l.Limit(ok), request("10.0.0.2:1000", "", ""))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("request over concurrency: status %d, want 503 with Retry-After", w.Code)
+	}
+	close(release)
+	<-done
+	if w := serve(l.Limit(ok), request("10.0.0.2:1000", "", "")); w.Code != http.StatusOK {
+		t.Fatalf("request after slot is released: status %d", w.Code)
+	}
+
+	backlog = 0.9
+	if w := serve(l.LimitWrite(ok), request("10.0.0.2:1000", "", "")); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("write with write queue backlog: status %d, want 503", w.Code)
+	}
+	if w := serve(l.Limit(ok), request("10.0.0.2:1000", "", "")); w.Code != http.StatusOK {
+		t.Fatalf("read with write queue backlog: status %d", w.Code)
+	}
+}
+
+func Test_NilLimiter(t *testing.T) {
+	var l *Limiter
+	if w := serve(l.Limit(ok), request("10.0.0.1:1000", "", "")); w.Code != http.StatusOK {
+		t.Fatalf("nil limiter: status %d", w.Code)
+	}
+}
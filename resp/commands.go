@@ -0,0 +1,472 @@
+package resp
+
+import (
+	"akita/auth"
+	"akita/db"
+	akerrors "akita/errors"
+	"akita/logger"
+	"bufio"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	errSyntax    = "ERR syntax error"
+	errNotInt    = "ERR value is not an integer or out of range"
+	errReadOnly  = "READONLY You can't write against a read only replica."
+	errNoAuth    = "NOAUTH Authentication required."
+	errWrongPass = "WRONGPASS invalid username-password pair or user is disabled."
+	errNoReplica = "NOREPLICAS "
+)
+
+type client struct {
+	r     *bufio.Reader
+	w     *writer
+	s     *Server
+	addr  string
+	sent  *countingWriter
+	info  *auth.TokenInfo // token the client authenticated with
+	token string
+	lsn   int64 // lsn of the last write of the client, WAIT waits for replicas to acknowledge it
+}
+
+type command struct {
+	arity  int             // number of arguments including command name, -n means at least n
+	write  bool            // write commands are refused by slaves
+	noAuth bool            // the command is served before the client authenticates
+	scope  auth.Scope      // scope the token of the client needs
+	perm   auth.Permission // permission needed on the keys of the command, 0 if it has none
+	// keys are the arguments from firstKey to lastKey, lastKey -1 is the last argument
+	firstKey, lastKey int
+	exec              func(c *client, args [][]byte)
+}
+
+var commands map[string]*command
+
+func init() {
+	commands = map[string]*command{
+		"ping":    {arity: -1, exec: (*client).ping},
+		"echo":    {arity: 2, exec: (*client).echo},
+		"quit":    {arity: 1, noAuth: true, exec: (*client).ok},
+		"auth":    {arity: -2, noAuth: true, exec: (*client).auth},
+		"select":  {arity: 2, exec: (*client).selectDB},
+		"hello":   {arity: -1, noAuth: true, exec: (*client).hello},
+		"client":  {arity: -2, exec: (*client).ok},
+		"command": {arity: -1, exec: (*client).command},
+		"get":     {arity: 2, scope: auth.ScopeRead, perm: auth.PermRead, firstKey: 1, lastKey: 1, exec: (*client).get},
+		"set":     {arity: -3, write: true, scope: auth.ScopeWrite, perm: auth.PermWrite, firstKey: 1, lastKey: 1, exec: (*client).set},
+		"del":     {arity: -2, write: true, scope: auth.ScopeWrite, perm: auth.PermDelete, firstKey: 1, lastKey: -1, exec: (*client).del},
+		"exists":  {arity: -2, scope: auth.ScopeRead, perm: auth.PermRead, firstKey: 1, lastKey: -1, exec: (*client).exists},
+		"expire":  {arity: 3, write: true, scope: auth.ScopeWrite, perm: auth.PermWrite, firstKey: 1, lastKey: 1, exec: (*client).expire},
+		"ttl":     {arity: 2, scope: auth.ScopeRead, perm: auth.PermRead, firstKey: 1, lastKey: 1, exec: (*client).ttl},
+		"mget":    {arity: -2, scope: auth.ScopeRead, perm: auth.PermRead, firstKey: 1, lastKey: -1, exec: (*client).mget},
+		"scan":    {arity: -2, scope: auth.ScopeRead, exec: (*client).scan},
+		"wait":    {arity: 3, exec: (*client).wait},
+	}
+}
+
+// execute run command of args, return true if the connection should be closed.
+func (c *client) execute(args [][]byte) bool {
+	name := strings.ToLower(string(args[0]))
+	cmd, ok := commands[name]
+	if !ok {
+		c.w.writeError("ERR unknown command '" + string(args[0]) + "'")
+		return false
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		c.w.writeError("ERR wrong number of arguments for '" + name + "' command")
+		return false
+	}
+	if c.s.tokens != nil && !cmd.noAuth && !c.authorized(cmd, args) {
+		return false
+	}
+	if cmd.write && !c.s.engine.IsMaster() {
+		c.w.writeError(errReadOnly)
+		return false
+	}
+	// commands setting up the connection are not limited
+	if cmd.noAuth {
+		cmd.exec(c, args)
+		return name == "quit"
+	}
+	var received int64
+	for _, arg := range args {
+		received += int64(len(arg))
+	}
+	done, _, err := c.s.limiter.Admit(c.s.limiter.ClientID(c.token, c.addr), cmd.write, received)
+	if err != nil {
+		c.w.writeError("ERR " + err.Error())
+		return false
+	}
+	// the reply may be flushed while it is written
+	sent := c.sent.n + int64(c.w.w.Buffered())
+	cmd.exec(c, args)
+	done(c.sent.n + int64(c.w.w.Buffered()) - sent)
+	return false
+}
+
+// authorized check that the client authenticated with a token which has the scope of cmd and the permission
+// of cmd on its keys, write the error reply and return false if not.
+func (c *client) authorized(cmd *command, args [][]byte) bool {
+	if c.info == nil {
+		c.w.writeError(errNoAuth)
+		return false
+	}
+	if c.info.Scope&cmd.scope != cmd.scope {
+		c.w.writeError("NOPERM " + akerrors.ErrTokenScope.Error())
+		return false
+	}
+	if cmd.perm == 0 {
+		return true
+	}
+	lastKey := cmd.lastKey
+	if lastKey < 0 {
+		lastKey = len(args) - 1
+	}
+	for _, key := range args[cmd.firstKey : lastKey+1] {
+		ok, err := c.s.acls.Allowed(c.info, string(key), cmd.perm)
+		if err != nil {
+			c.w.writeError("ERR " + err.Error())
+			return false
+		}
+		if !ok {
+			c.w.writeError("NOPERM " + akerrors.ErrAccessDenied.Error())
+			return false
+		}
+	}
+	return true
+}
+
+// authenticate make the client act with token, the user name of AUTH and HELLO is not used.
+// Write the error reply and return false if token is not valid.
+func (c *client) authenticate(token []byte) bool {
+	if c.s.tokens == nil {
+		c.w.writeError("ERR AUTH called without any password configured for the default user.")
+		return false
+	}
+	info, err := c.s.tokens.Lookup(string(token))
+	if err == akerrors.ErrTokenInvalid {
+		c.w.writeError(errWrongPass)
+		return false
+	}
+	if err != nil {
+		c.w.writeError("ERR " + err.Error())
+		return false
+	}
+	c.info = info
+	c.token = string(token)
+	return true
+}
+
+// auth AUTH [username] token.
+func (c *client) auth(args [][]byte) {
+	if len(args) > 3 {
+		c.w.writeError(errSyntax)
+		return
+	}
+	if c.authenticate(args[len(args)-1]) {
+		c.w.writeSimple("OK")
+	}
+}
+
+func (c *client) ok(args [][]byte) {
+	c.w.writeSimple("OK")
+}
+
+func (c *client) ping(args [][]byte) {
+	if len(args) > 1 {
+		c.w.writeBulk(args[1])
+		return
+	}
+	c.w.writeSimple("PONG")
+}
+
+func (c *client) echo(args [][]byte) {
+	c.w.writeBulk(args[1])
+}
+
+func (c *client) selectDB(args [][]byte) {
+	if string(args[1]) != "0" {
+		c.w.writeError("ERR DB index is out of range")
+		return
+	}
+	c.w.writeSimple("OK")
+}
+
+// hello switch protocol version, HELLO [protover [AUTH username password] [SETNAME clientname]].
+// The password is an api token, the client name is not kept.
+func (c *client) hello(args [][]byte) {
+	proto := c.w.proto
+	if len(args) > 1 {
+		v, err := strconv.Atoi(string(args[1]))
+		if err != nil {
+			c.w.writeError("ERR Protocol version is not an integer or out of range")
+			return
+		}
+		if v != 2 && v != 3 {
+			c.w.writeError("NOPROTO unsupported protocol version")
+			return
+		}
+		proto = v
+	}
+	var token []byte
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToLower(string(args[i])); {
+		case opt == "auth" && i+2 < len(args):
+			token = args[i+2]
+			i += 2
+		case opt == "setname" && i+1 < len(args):
+			i++
+		default:
+			c.w.writeError(errSyntax)
+			return
+		}
+	}
+	if token != nil && !c.authenticate(token) {
+		return
+	}
+	if c.s.tokens != nil && c.info == nil {
+		c.w.writeError("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used")
+		return
+	}
+	c.w.proto = proto
+	role := "replica"
+	if c.s.engine.IsMaster() {
+		role = "master"
+	}
+	c.w.writeMap(5)
+	c.w.writeBulk([]byte("server"))
+	c.w.writeBulk([]byte("akita"))
+	c.w.writeBulk([]byte("proto"))
+	c.w.writeInt(int64(proto))
+	c.w.writeBulk([]byte("id"))
+	c.w.writeInt(0)
+	c.w.writeBulk([]byte("mode"))
+	c.w.writeBulk([]byte("standalone"))
+	c.w.writeBulk([]byte("role"))
+	c.w.writeBulk([]byte(role))
+}
+
+func (c *client) command(args [][]byte) {
+	c.w.writeArray(0)
+}
+
+func (c *client) get(args [][]byte) {
+	value, err := c.s.engine.Seek(string(args[1]))
+	if err != nil {
+		c.w.writeError("ERR " + err.Error())
+		return
+	}
+	if value == nil {
+		c.w.writeNull()
+		return
+	}
+	c.w.writeBulk(value)
+}
+
+// set SET key value [EX seconds | PX milliseconds], expiration is kept in whole seconds.
+func (c *client) set(args [][]byte) {
+	var expireAt int64
+	for i := 3; i < len(args); i++ {
+		opt := strings.ToLower(string(args[i]))
+		if (opt != "ex" && opt != "px") || i+1 >= len(args) || expireAt != 0 {
+			c.w.writeError(errSyntax)
+			return
+		}
+		n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+		if err != nil {
+			c.w.writeError(errNotInt)
+			return
+		}
+		if n <= 0 {
+			c.w.writeError("ERR invalid expire time in 'set' command")
+			return
+		}
+		unit := time.Second
+		if opt == "px" {
+			unit = time.Millisecond
+		}
+		if n > int64(math.MaxInt64/unit) {
+			c.w.writeError("ERR invalid expire time in 'set' command")
+			return
+		}
+		ttl := time.Duration(n) * unit
+		// round up, a key never lives shorter than asked
+		expireAt = time.Now().Add(ttl + time.Second - 1).Unix()
+		i++
+	}
+	lsn, err := c.s.engine.PutWithExpire(string(args[1]), args[2], expireAt)
+	if err != nil {
+		logger.Errorf("resp set key %s error: %v", args[1], err)
+		c.w.writeError("ERR " + err.Error())
+		return
+	}
+	if !c.confirmWrite(lsn) {
+		return
+	}
+	c.w.writeSimple("OK")
+}
+
+func (c *client) del(args [][]byte) {
+	var n, last int64
+	for _, key := range args[1:] {
+		deleted, _, lsn, err := c.s.engine.Delete(string(key))
+		if err != nil {
+			c.w.writeError("ERR " + err.Error())
+			return
+		}
+		if deleted {
+			n++
+			last = lsn
+		}
+	}
+	if n > 0 && !c.confirmWrite(last) {
+		return
+	}
+	c.w.writeInt(n)
+}
+
+func (c *client) exists(args [][]byte) {
+	var n int64
+	for _, key := range args[1:] {
+		if c.s.engine.Exists(string(key)) {
+			n++
+		}
+	}
+	c.w.writeInt(n)
+}
+
+func (c *client) expire(args [][]byte) {
+	seconds, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		c.w.writeError(errNotInt)
+		return
+	}
+	now := time.Now().Unix()
+	if seconds > math.MaxInt64-now {
+		c.w.writeError("ERR invalid expire time in 'expire' command")
+		return
+	}
+	expireAt := now + seconds
+	if seconds <= 0 {
+		// expire in the past, key is deleted right now
+		expireAt = -1
+	}
+	ok, lsn, err := c.s.engine.Expire(string(args[1]), expireAt)
+	if err != nil {
+		c.w.writeError("ERR " + err.Error())
+		return
+	}
+	if ok {
+		if c.confirmWrite(lsn) {
+			c.w.writeInt(1)
+		}
+		return
+	}
+	c.w.writeInt(0)
+}
+
+// confirmWrite wait for the write quorum to acknowledge the write at lsn, write the error reply and return false
+// if too few replicas did in time.
+func (c *client) confirmWrite(lsn int64) bool {
+	c.lsn = lsn
+	if _, err := c.s.engine.WaitQuorum(lsn); err != nil {
+		c.w.writeError(errNoReplica + err.Error())
+		return false
+	}
+	return true
+}
+
+// wait WAIT numreplicas timeout, wait until numreplicas replicas acknowledged the writes of the client,
+// for at most timeout milliseconds or forever if it is 0, and reply how many did.
+func (c *client) wait(args [][]byte) {
+	n, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		c.w.writeError(errNotInt)
+		return
+	}
+	ms, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		c.w.writeError("ERR timeout is not an integer or out of range")
+		return
+	}
+	if ms < 0 {
+		c.w.writeError("ERR timeout is negative")
+		return
+	}
+	timeout := time.Duration(ms) * time.Millisecond
+	if ms == 0 || ms > int64(math.MaxInt64/time.Millisecond) {
+		timeout = math.MaxInt64
+	}
+	c.w.writeInt(int64(c.s.engine.WaitReplicas(c.lsn, n, timeout)))
+}
+
+func (c *client) ttl(args [][]byte) {
+	c.w.writeInt(c.s.engine.TTL(string(args[1])))
+}
+
+func (c *client) mget(args [][]byte) {
+	keys := make([]string, len(args)-1)
+	for i, key := range args[1:] {
+		keys[i] = string(key)
+	}
+	values, errs := c.s.engine.BatchSeek(keys)
+	c.w.writeArray(len(values))
+	for i, value := range values {
+		if value == nil || errs[i] != nil {
+			c.w.writeNull()
+			continue
+		}
+		c.w.writeBulk(value)
+	}
+}
+
+// scan SCAN cursor [MATCH pattern] [COUNT count].
+func (c *client) scan(args [][]byte) {
+	cursor, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		c.w.writeError("ERR invalid cursor")
+		return
+	}
+	pattern, count := "", 10
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			c.w.writeError(errSyntax)
+			return
+		}
+		switch strings.ToLower(string(args[i])) {
+		case "match":
+			pattern = string(args[i+1])
+		case "count":
+			if count, err = strconv.Atoi(string(args[i+1])); err != nil || count < 1 {
+				c.w.writeError(errNotInt)
+				return
+			}
+			// count is a hint, a larger one is served in several calls
+			if count > db.MaxScanCount {
+				count = db.MaxScanCount
+			}
+		default:
+			c.w.writeError(errSyntax)
+			return
+		}
+	}
+	next, keys := c.s.engine.Scan(cursor, pattern, count)
+	if c.s.acls != nil {
+		listed := keys[:0]
+		for _, key := range keys {
+			if ok, _ := c.s.acls.Allowed(c.info, key, auth.PermList); ok {
+				listed = append(listed, key)
+			}
+		}
+		keys = listed
+	}
+	c.w.writeArray(2)
+	c.w.writeBulk([]byte(strconv.FormatUint(next, 10)))
+	c.w.writeArray(len(keys))
+	for _, key := range keys {
+		c.w.writeBulk([]byte(key))
+	}
+}
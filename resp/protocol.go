@@ -0,0 +1,153 @@
+package resp
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxBulkSize  = 64 << 20 // maximum size of a bulk string argument
+	maxArraySize = 1 << 20  // maximum number of arguments of a command
+
+	// limits of commands of clients which did not authenticate yet, enough for AUTH and HELLO
+	unauthBulkSize  = 16 << 10
+	unauthArraySize = 10
+)
+
+type protocolError string
+
+func (e protocolError) Error() string {
+	return "resp protocol error: " + string(e)
+}
+
+// readCommand read a command as array of bulk strings, or an inline command. Until the client authenticated
+// commands are limited to a few small arguments.
+func (c *client) readCommand() ([][]byte, error) {
+	unauth := c.s.tokens != nil && c.info == nil
+	arraySize, bulkSize := maxArraySize, maxBulkSize
+	if unauth {
+		arraySize, bulkSize = unauthArraySize, unauthBulkSize
+	}
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, nil
+	}
+	if line[0] != '*' {
+		fields := strings.Fields(string(line))
+		args := make([][]byte, len(fields))
+		for i, f := range fields {
+			args[i] = []byte(f)
+		}
+		return args, nil
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if unauth && err == nil && n > arraySize {
+		return nil, protocolError("unauthenticated multibulk length")
+	}
+	if err != nil || n < -1 || n > arraySize {
+		return nil, protocolError("invalid multibulk length")
+	}
+	if n <= 0 {
+		// a null or empty array is no command
+		return nil, nil
+	}
+	args := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, protocolError("expected '$', got '" + string(line) + "'")
+		}
+		size, err := strconv.Atoi(string(line[1:]))
+		if unauth && err == nil && size > bulkSize {
+			return nil, protocolError("unauthenticated bulk length")
+		}
+		if err != nil || size < 0 || size > bulkSize {
+			return nil, protocolError("invalid bulk length")
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, buf[:size])
+	}
+	return args, nil
+}
+
+func (c *client) readLine() ([]byte, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, protocolError("too big inline request")
+	}
+	if err != nil {
+		return nil, err
+	}
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line, nil
+}
+
+// writer write replies in RESP2, or RESP3 after HELLO 3.
+type writer struct {
+	w     *bufio.Writer
+	proto int
+}
+
+func (w *writer) flush() error {
+	return w.w.Flush()
+}
+
+func (w *writer) writeLine(prefix byte, s string) {
+	w.w.WriteByte(prefix)
+	w.w.WriteString(s)
+	w.w.WriteString("\r\n")
+}
+
+func (w *writer) writeSimple(s string) {
+	w.writeLine('+', s)
+}
+
+func (w *writer) writeError(s string) {
+	w.writeLine('-', s)
+}
+
+func (w *writer) writeInt(n int64) {
+	w.writeLine(':', strconv.FormatInt(n, 10))
+}
+
+func (w *writer) writeBulk(b []byte) {
+	w.writeLine('$', strconv.Itoa(len(b)))
+	w.w.Write(b)
+	w.w.WriteString("\r\n")
+}
+
+func (w *writer) writeNull() {
+	if w.proto == 3 {
+		w.w.WriteString("_\r\n")
+		return
+	}
+	w.w.WriteString("$-1\r\n")
+}
+
+func (w *writer) writeArray(n int) {
+	w.writeLine('*', strconv.Itoa(n))
+}
+
+// writeMap write header of a map of n pairs, an array of 2n elements in RESP2.
+func (w *writer) writeMap(n int) {
+	if w.proto == 3 {
+		w.writeLine('%', strconv.Itoa(n))
+		return
+	}
+	w.writeArray(2 * n)
+}
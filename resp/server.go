@@ -0,0 +1,164 @@
+package resp
+
+import (
+	"akita/auth"
+	"akita/db"
+	"akita/logger"
+	"akita/ratelimit"
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// Server serves the redis RESP2/RESP3 protocol backed by engine.
+type Server struct {
+	sync.Mutex
+	addr     string
+	engine   *db.Engine
+	tokens   *auth.Tokens // nil if authentication is turned off
+	acls     *auth.ACLs
+	limiter  *ratelimit.Limiter // nil if limiting is turned off
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// ErrServerClosed is returned by ListenAndServe after Close.
+var ErrServerClosed = errors.New("resp: server closed")
+
+// NewServer create a RESP server listening on addr, clients must authenticate with a token of tokens
+// by AUTH or HELLO unless tokens is nil. Commands are limited by limiter unless it is nil.
+func NewServer(addr string, engine *db.Engine, tokens *auth.Tokens, limiter *ratelimit.Limiter) *Server {
+	s := &Server{
+		addr:    addr,
+		engine:  engine,
+		tokens:  tokens,
+		limiter: limiter,
+		conns:   make(map[net.Conn]struct{}),
+	}
+	if tokens != nil {
+		s.acls = auth.NewACLs(engine)
+	}
+	return s
+}
+
+// ListenAndServe listen on the server addr and serve connections until Close.
+func (s *Server) ListenAndServe() error {
+	l, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve serve connections accepted by l until Close.
+func (s *Server) Serve(l net.Listener) error {
+	s.Lock()
+	if s.closed {
+		s.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listener = l
+	s.Unlock()
+	logger.Infof("resp server listening on %s", l.Addr())
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.Lock()
+			closed := s.closed
+			s.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return err
+		}
+		s.Lock()
+		if s.closed {
+			s.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// Close stop listening and close every connection.
+func (s *Server) Close() error {
+	s.Lock()
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.Lock()
+		delete(s.conns, conn)
+		s.Unlock()
+		conn.Close()
+		s.wg.Done()
+	}()
+
+	sent := &countingWriter{w: conn}
+	c := &client{
+		r:    bufio.NewReader(conn),
+		w:    &writer{w: bufio.NewWriter(sent), proto: 2},
+		s:    s,
+		addr: conn.RemoteAddr().String(),
+		sent: sent,
+	}
+	for {
+		args, err := c.readCommand()
+		if err != nil {
+			if pe, ok := err.(protocolError); ok {
+				c.w.writeError("ERR Protocol error: " + string(pe))
+				c.w.flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		quit := c.execute(args)
+		// pipelined commands are answered together
+		if c.r.Buffered() == 0 || quit {
+			if err := c.w.flush(); err != nil {
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// countingWriter counts the bytes written to a connection.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,379 @@
+package resp
+
+import (
+	"akita/auth"
+	"akita/common"
+	"akita/db"
+	"akita/ratelimit"
+	"bufio"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// startTestServer start a RESP server with an engine which is master if master is true.
+func startTestServer(t *testing.T, master bool) string {
+	return startTestServerWithTokens(t, master, "")
+}
+
+// startTestServerWithTokens start a RESP server requiring the static tokens of the token file content
+// unless it is empty.
+func startTestServerWithTokens(t *testing.T, master bool, tokenFile string) string {
+	return startLimitedTestServer(t, master, tokenFile, nil)
+}
+
+// startLimitedTestServer is like startTestServerWithTokens, commands are limited by limiter unless it is nil.
+func startLimitedTestServer(t *testing.T, master bool, tokenFile string, limiter *ratelimit.Limiter) string {
+	fPath := filepath.Join(t.TempDir(), "akita.dat")
+	f, err := os.Create(fPath)
+	if err != nil {
+		t.Fatalf("create data file error: %s.\n", err)
+	}
+	f.Close()
+	masterAddr := "203.0.113.1"
+	if master {
+		if masterAddr, err = common.GetIntranetIP(); err != nil {
+			t.Fatalf("get intranet ip error: %s.\n", err)
+		}
+	}
+	db.InitializeEngine(masterAddr, nil, "0", fPath, true, 100, false)
+	e := db.GetEngine()
+	e.StartBackground(1000, int64(time.Hour/time.Millisecond))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error: %s.\n", err)
+	}
+	var tokens *auth.Tokens
+	if tokenFile != "" {
+		path := filepath.Join(t.TempDir(), "tokens")
+		if err := ioutil.WriteFile(path, []byte(tokenFile), 0600); err != nil {
+			t.Fatalf("write token file error: %s.\n", err)
+		}
+		if tokens, err = auth.NewTokens(e, path); err != nil {
+			t.Fatalf("new tokens error: %s.\n", err)
+		}
+	}
+	s := NewServer(l.Addr().String(), e, tokens, limiter)
+	go s.Serve(l)
+	t.Cleanup(func() {
+		s.Close()
+		e.GetDB().Close()
+	})
+	return l.Addr().String()
+}
+
+func dialTestServer(t *testing.T, addr string) redis.Conn {
+	conn, err := redis.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial resp server error: %s.\n", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func Test_Commands(t *testing.T) {
+	conn := dialTestServer(t, startTestServer(t, true))
+
+	if ok, err := redis.String(conn.Do("SET", "k1", "v1")); err != nil || ok != "OK" {
+		t.Fatalf("set: %v, %v", ok, err)
+	}
+	if v, err := redis.String(conn.Do("GET", "k1")); err != nil || v != "v1" {
+		t.Fatalf("get: %v, %v", v, err)
+	}
+	if _, err := conn.Do("GET", "missing"); err != nil {
+		t.Fatalf("get missing: %v", err)
+	}
+	if v, err := conn.Do("GET", "missing"); v != nil {
+		t.Fatalf("get missing: %v, %v", v, err)
+	}
+	if n, err := redis.Int(conn.Do("EXISTS", "k1", "k1", "missing")); err != nil || n != 2 {
+		t.Fatalf("exists: %v, %v", n, err)
+	}
+	if n, err := redis.Int(conn.Do("TTL", "k1")); err != nil || n != -1 {
+		t.Fatalf("ttl of persistent key: %v, %v", n, err)
+	}
+	if n, err := redis.Int(conn.Do("TTL", "missing")); err != nil || n != -2 {
+		t.Fatalf("ttl of missing key: %v, %v", n, err)
+	}
+	if n, err := redis.Int(conn.Do("EXPIRE", "k1", 100)); err != nil || n != 1 {
+		t.Fatalf("expire: %v, %v", n, err)
+	}
+	if n, err := redis.Int(conn.Do("TTL", "k1")); err != nil || n < 99 || n > 100 {
+		t.Fatalf("ttl: %v, %v", n, err)
+	}
+	// an expire time which overflows is refused, the key is kept
+	if _, err := conn.Do("EXPIRE", "k1", int64(math.MaxInt64)); err == nil || !strings.Contains(err.Error(), "invalid expire time") {
+		t.Fatalf("expire overflowing: %v", err)
+	}
+	if _, err := conn.Do("SET", "k5", "v5", "EX", int64(math.MaxInt64)); err == nil || !strings.Contains(err.Error(), "invalid expire time") {
+		t.Fatalf("set ex overflowing: %v", err)
+	}
+	if n, err := redis.Int(conn.Do("TTL", "k1")); err != nil || n < 99 || n > 100 {
+		t.Fatalf("ttl after overflowing expire: %v, %v", n, err)
+	}
+	if v, err := redis.String(conn.Do("GET", "k1")); err != nil || v != "v1" {
+		t.Fatalf("get after expire: %v, %v", v, err)
+	}
+
+	if _, err := conn.Do("SET", "k2", "v2", "PX", 1); err != nil {
+		t.Fatalf("set px: %v", err)
+	}
+	if _, err := conn.Do("SET", "k3", "v3", "EX", 100); err != nil {
+		t.Fatalf("set ex: %v", err)
+	}
+	if _, err := conn.Do("SET", "k4", "v4", "NX"); err == nil {
+		t.Fatalf("set with unsupported option should fail")
+	}
+	time.Sleep(1100 * time.Millisecond)
+	values, err := redis.Values(conn.Do("MGET", "k1", "k2", "k3"))
+	if err != nil || len(values) != 3 || values[1] != nil || string(values[2].([]byte)) != "v3" {
+		t.Fatalf("mget: %v, %v", values, err)
+	}
+
+	if n, err := redis.Int(conn.Do("DEL", "k1", "missing")); err != nil || n != 1 {
+		t.Fatalf("del: %v, %v", n, err)
+	}
+	if n, err := redis.Int(conn.Do("EXISTS", "k1")); err != nil || n != 0 {
+		t.Fatalf("exists after del: %v, %v", n, err)
+	}
+}
+
+func Test_Scan(t *testing.T) {
+	conn := dialTestServer(t, startTestServer(t, true))
+	for _, key := range []string{"img/1", "img/2", "img/3", "log/1", "log/2"} {
+		if _, err := conn.Do("SET", key, key); err != nil {
+			t.Fatalf("set: %v", err)
+		}
+	}
+	var keys []string
+	cursor := 0
+	for {
+		reply, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", "img/*", "COUNT", 2))
+		if err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		if cursor, err = redis.Int(reply[0], nil); err != nil {
+			t.Fatalf("scan cursor: %v", err)
+		}
+		page, err := redis.Strings(reply[1], nil)
+		if err != nil {
+			t.Fatalf("scan keys: %v", err)
+		}
+		keys = append(keys, page...)
+		if cursor == 0 {
+			break
+		}
+	}
+	if strings.Join(keys, ",") != "img/1,img/2,img/3" {
+		t.Fatalf("scan keys: %v", keys)
+	}
+}
+
+func Test_ScanCount(t *testing.T) {
+	conn := dialTestServer(t, startTestServer(t, true))
+	if _, err := conn.Do("SET", "k1", "v1"); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	for _, count := range []string{"9223372036854775807", "1000000000"} {
+		reply, err := redis.Values(conn.Do("SCAN", 0, "COUNT", count))
+		if err != nil {
+			t.Fatalf("scan count %s: %v", count, err)
+		}
+		if keys, err := redis.Strings(reply[1], nil); err != nil || len(keys) != 1 {
+			t.Fatalf("scan count %s keys: %v, %v", count, keys, err)
+		}
+	}
+}
+
+func Test_NegativeMultibulk(t *testing.T) {
+	conn, err := net.Dial("tcp", startTestServer(t, true))
+	if err != nil {
+		t.Fatalf("dial resp server error: %s.\n", err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	// a null array is skipped
+	if _, err := conn.Write([]byte("*-1\r\nPING\r\n")); err != nil {
+		t.Fatalf("write error: %s.\n", err)
+	}
+	if line, err := r.ReadString('\n'); err != nil || line != "+PONG\r\n" {
+		t.Fatalf("reply after null array: %q, %v", line, err)
+	}
+	if _, err := conn.Write([]byte("*-5\r\n")); err != nil {
+		t.Fatalf("write error: %s.\n", err)
+	}
+	if line, err := r.ReadString('\n'); err != nil || !strings.HasPrefix(line, "-ERR Protocol error") {
+		t.Fatalf("reply to negative multibulk length: %q, %v", line, err)
+	}
+}
+
+func Test_ReadOnlySlave(t *testing.T) {
+	conn := dialTestServer(t, startTestServer(t, false))
+	if _, err := conn.Do("SET", "k1", "v1"); err == nil || !strings.HasPrefix(err.Error(), "READONLY") {
+		t.Fatalf("set on slave: %v", err)
+	}
+	if _, err := conn.Do("DEL", "k1"); err == nil || !strings.HasPrefix(err.Error(), "READONLY") {
+		t.Fatalf("del on slave: %v", err)
+	}
+	if v, err := conn.Do("GET", "k1"); err != nil || v != nil {
+		t.Fatalf("get on slave: %v, %v", v, err)
+	}
+}
+
+func Test_Hello3(t *testing.T) {
+	conn, err := net.Dial("tcp", startTestServer(t, true))
+	if err != nil {
+		t.Fatalf("dial resp server error: %s.\n", err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	if _, err := conn.Write([]byte("HELLO 3\r\nGET missing\r\n")); err != nil {
+		t.Fatalf("write error: %s.\n", err)
+	}
+	if line, err := r.ReadString('\n'); err != nil || line != "%5\r\n" {
+		t.Fatalf("hello 3 reply: %q, %v", line, err)
+	}
+	// role is the last field of hello reply
+	for line := ""; line != "master\r\n"; {
+		if line, err = r.ReadString('\n'); err != nil {
+			t.Fatalf("read hello reply error: %s.\n", err)
+		}
+	}
+	if line, err := r.ReadString('\n'); err != nil || line != "_\r\n" {
+		t.Fatalf("resp3 null reply: %q, %v", line, err)
+	}
+}
+
+func Test_Auth(t *testing.T) {
+	addr := startTestServerWithTokens(t, true, "rw-token read,write app\nadmin-token read,admin root\n")
+	conn := dialTestServer(t, addr)
+
+	if _, err := conn.Do("GET", "k1"); err == nil || !strings.HasPrefix(err.Error(), "NOAUTH") {
+		t.Fatalf("get before auth: %v", err)
+	}
+	if _, err := conn.Do("AUTH", "wrong"); err == nil || !strings.HasPrefix(err.Error(), "WRONGPASS") {
+		t.Fatalf("auth with a wrong token: %v", err)
+	}
+	if ok, err := redis.String(conn.Do("AUTH", "rw-token")); err != nil || ok != "OK" {
+		t.Fatalf("auth: %v, %v", ok, err)
+	}
+	if ok, err := redis.String(conn.Do("SET", "k1", "v1")); err != nil || ok != "OK" {
+		t.Fatalf("set after auth: %v, %v", ok, err)
+	}
+	// keys of akita itself, such as tokens, are only written by admins
+	if _, err := conn.Do("SET", "_tokens/x", "{}"); err == nil || !strings.HasPrefix(err.Error(), "NOPERM") {
+		t.Fatalf("set of a reserved key: %v", err)
+	}
+
+	admin := dialTestServer(t, addr)
+	if _, err := admin.Do("HELLO", "2"); err == nil || !strings.HasPrefix(err.Error(), "NOAUTH") {
+		t.Fatalf("hello before auth: %v", err)
+	}
+	if _, err := admin.Do("HELLO", "2", "AUTH", "default", "admin-token"); err != nil {
+		t.Fatalf("hello with auth: %v", err)
+	}
+	if _, err := admin.Do("SET", "k2", "v2"); err == nil || !strings.HasPrefix(err.Error(), "NOPERM") {
+		t.Fatalf("set with a token without write scope: %v", err)
+	}
+	if v, err := redis.String(admin.Do("GET", "_tokens/x")); err != redis.ErrNil {
+		t.Fatalf("get of a reserved key by admin: %v, %v", v, err)
+	}
+}
+
+func Test_UnauthenticatedLimits(t *testing.T) {
+	addr := startTestServerWithTokens(t, true, "rw-token read,write app\n")
+	send := func(req string) string {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("dial resp server error: %s.\n", err)
+		}
+		defer conn.Close()
+		if _, err := conn.Write([]byte(req)); err != nil {
+			t.Fatalf("write error: %s.\n", err)
+		}
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		return line
+	}
+
+	if line := send("*11\r\n"); !strings.HasPrefix(line, "-ERR Protocol error: unauthenticated multibulk length") {
+		t.Fatalf("reply to many arguments before auth: %q", line)
+	}
+	if line := send("*2\r\n$4\r\nAUTH\r\n$67108864\r\n"); !strings.HasPrefix(line, "-ERR Protocol error: unauthenticated bulk length") {
+		t.Fatalf("reply to a large argument before auth: %q", line)
+	}
+
+	// the full limits apply after auth
+	conn := dialTestServer(t, addr)
+	if _, err := conn.Do("AUTH", "rw-token"); err != nil {
+		t.Fatalf("auth: %v", err)
+	}
+	value := strings.Repeat("v", 1<<20)
+	if ok, err := redis.String(conn.Do("SET", "k1", value)); err != nil || ok != "OK" {
+		t.Fatalf("set of a large value after auth: %v, %v", ok, err)
+	}
+	args := []interface{}{"k1"}
+	for i := 0; i < 20; i++ {
+		args = append(args, "missing")
+	}
+	if n, err := redis.Int(conn.Do("EXISTS", args...)); err != nil || n != 1 {
+		t.Fatalf("exists of many keys after auth: %v, %v", n, err)
+	}
+}
+
+func Test_Limit(t *testing.T) {
+	var backlog int32
+	limiter := ratelimit.NewLimiter(ratelimit.Config{
+		RequestsPerSecond: 1,
+		RequestBurst:      3,
+		MaxWriteBacklog:   0.5,
+		WriteBacklog:      func() float64 { return float64(atomic.LoadInt32(&backlog)) },
+	})
+	conn := dialTestServer(t, startLimitedTestServer(t, true, "", limiter))
+
+	if _, err := conn.Do("GET", "k"); err != nil {
+		t.Fatalf("get within burst error: %s.\n", err)
+	}
+	atomic.StoreInt32(&backlog, 1)
+	if _, err := conn.Do("SET", "k", "v"); err == nil || !strings.Contains(err.Error(), "overloaded") {
+		t.Fatalf("set with backlogged writes want overloaded, got %v.\n", err)
+	}
+	if _, err := conn.Do("GET", "k"); err != nil {
+		t.Fatalf("get with backlogged writes error: %s.\n", err)
+	}
+	atomic.StoreInt32(&backlog, 0)
+	if _, err := conn.Do("SET", "k", "v"); err != nil {
+		t.Fatalf("set within burst error: %s.\n", err)
+	}
+	if _, err := conn.Do("GET", "k"); err == nil || !strings.Contains(err.Error(), "too many requests") {
+		t.Fatalf("get over burst want too many requests, got %v.\n", err)
+	}
+	// connection commands are not limited
+	if _, err := conn.Do("HELLO", "2"); err != nil {
+		t.Fatalf("hello over burst error: %s.\n", err)
+	}
+}
+
+func Test_WriteQuorum(t *testing.T) {
+	conn := dialTestServer(t, startTestServer(t, true))
+
+	db.GetEngine().UseWriteQuorum(1, 10*time.Millisecond, false)
+	if _, err := conn.Do("SET", "k", "v"); err == nil || !strings.HasPrefix(err.Error(), "NOREPLICAS") {
+		t.Fatalf("set without write quorum want NOREPLICAS, got %v.\n", err)
+	}
+	// a master without slaves has no replica to wait for
+	if n, err := redis.Int(conn.Do("WAIT", "1", "10")); err != nil || n != 0 {
+		t.Fatalf("wait: %v, %v.\n", n, err)
+	}
+	if _, err := conn.Do("WAIT", "1", "-1"); err == nil {
+		t.Fatalf("wait with negative timeout want error.\n")
+	}
+}
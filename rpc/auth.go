@@ -0,0 +1,83 @@
+package rpc
+
+import (
+	"akita/auth"
+	akerrors "akita/errors"
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// methodScopes are the scopes the methods of the service need.
+var methodScopes = map[string]auth.Scope{
+	"/pb.Akita/Put":        auth.ScopeWrite,
+	"/pb.Akita/Get":        auth.ScopeRead,
+	"/pb.Akita/Delete":     auth.ScopeWrite,
+	"/pb.Akita/Scan":       auth.ScopeRead,
+	"/pb.Akita/BatchWrite": auth.ScopeWrite,
+	"/pb.Akita/Watch":      auth.ScopeRead,
+}
+
+// authorize check the token in the authorization metadata of ctx, "Bearer <token>", has the scope of method,
+// the returned context carries the token info for acl checks.
+func authorize(tokens *auth.Tokens, ctx context.Context, method string) (context.Context, error) {
+	scope, ok := methodScopes[method]
+	if !ok {
+		return nil, status.Error(codes.PermissionDenied, "unknown method")
+	}
+	info, err := tokens.Authorize(requestToken(ctx), scope)
+	switch err {
+	case nil:
+		return auth.WithTokenInfo(ctx, info), nil
+	case akerrors.ErrTokenMissing, akerrors.ErrTokenInvalid:
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	case akerrors.ErrTokenScope:
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	default:
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+}
+
+// requestToken return the token in the authorization metadata of ctx, "Bearer <token>".
+func requestToken(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			return strings.TrimSpace(strings.TrimPrefix(values[0], "Bearer "))
+		}
+	}
+	return ""
+}
+
+func unaryAuth(tokens *auth.Tokens) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authorize(tokens, ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func streamAuth(tokens *auth.Tokens) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authorize(tokens, ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authorizedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// authorizedStream is a server stream whose context carries the token info.
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
+}
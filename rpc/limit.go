@@ -0,0 +1,111 @@
+package rpc
+
+import (
+	"akita/ratelimit"
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// writeMethods are the methods which are shed when the write queue is backlogged.
+var writeMethods = map[string]bool{
+	"/pb.Akita/Put":        true,
+	"/pb.Akita/Delete":     true,
+	"/pb.Akita/BatchWrite": true,
+}
+
+// streamMethods are served for a long time, only their call rate is limited.
+var streamMethods = map[string]bool{
+	"/pb.Akita/Watch": true,
+}
+
+// clientID return the limiter identity of the client calling with ctx.
+func clientID(limiter *ratelimit.Limiter, ctx context.Context) string {
+	var addr string
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+	return limiter.ClientID(requestToken(ctx), addr)
+}
+
+// limitError convert an error of the limiter to a grpc status, a rate limited call
+// carries how long the client should wait in the retry-after trailer.
+func limitError(ctx context.Context, wait time.Duration, err error) error {
+	if wait > 0 {
+		grpc.SetTrailer(ctx, metadata.Pairs("retry-after", strconv.Itoa(int(math.Ceil(wait.Seconds())))))
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return status.Error(codes.Unavailable, err.Error())
+}
+
+func unaryLimit(limiter *ratelimit.Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		done, wait, err := limiter.Admit(clientID(limiter, ctx), writeMethods[info.FullMethod], messageSize(req))
+		if err != nil {
+			return nil, limitError(ctx, wait, err)
+		}
+		resp, err := handler(ctx, req)
+		done(messageSize(resp))
+		return resp, err
+	}
+}
+
+func streamLimit(limiter *ratelimit.Limiter) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := ss.Context()
+		id := clientID(limiter, ctx)
+		if streamMethods[info.FullMethod] {
+			if wait, err := limiter.AdmitStream(id); err != nil {
+				return limitError(ctx, wait, err)
+			}
+			return handler(srv, ss)
+		}
+		done, wait, err := limiter.Admit(id, writeMethods[info.FullMethod], 0)
+		if err != nil {
+			return limitError(ctx, wait, err)
+		}
+		// messages are charged after they are transferred
+		cs := &countingStream{ServerStream: ss}
+		err = handler(srv, cs)
+		done(cs.n)
+		return err
+	}
+}
+
+// messageSize return the encoded size of a message, 0 if it is not a protocol buffer.
+func messageSize(m interface{}) int64 {
+	if msg, ok := m.(proto.Message); ok {
+		return int64(proto.Size(msg))
+	}
+	return 0
+}
+
+// countingStream counts the bytes of the messages sent and received on a stream.
+type countingStream struct {
+	grpc.ServerStream
+	n int64
+}
+
+func (s *countingStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.n += messageSize(m)
+	}
+	return err
+}
+
+func (s *countingStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.n += messageSize(m)
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+package rpc
+
+import (
+	"akita/auth"
+	"akita/consts"
+	"akita/db"
+	"akita/logger"
+	"akita/pb"
+	"akita/ratelimit"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// Server serves the akita grpc service backed by engine.
+type Server struct {
+	sync.Mutex
+	addr   string
+	engine *db.Engine
+	server *grpc.Server
+	closed bool
+}
+
+// ErrServerClosed is returned by ListenAndServe after Close.
+var ErrServerClosed = errors.New("rpc: server closed")
+
+// NewServer create a grpc server listening on addr, calls must carry a token of tokens unless tokens is nil,
+// keys are then restricted by the access control lists stored in engine. Calls are limited by limiter unless it is nil.
+// Connections are served over tls with config unless it is nil.
+func NewServer(addr string, engine *db.Engine, tokens *auth.Tokens, limiter *ratelimit.Limiter, config *tls.Config) *Server {
+	// a batch write carries all of its values in one message
+	opts := []grpc.ServerOption{grpc.MaxRecvMsgSize(maxBatchSize + consts.M)}
+	if config != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(config)))
+	}
+	svc := &service{engine: engine}
+	var unary []grpc.UnaryServerInterceptor
+	var stream []grpc.StreamServerInterceptor
+	if tokens != nil {
+		unary = append(unary, unaryAuth(tokens))
+		stream = append(stream, streamAuth(tokens))
+		svc.acls = auth.NewACLs(engine)
+	}
+	if limiter != nil {
+		unary = append(unary, unaryLimit(limiter))
+		stream = append(stream, streamLimit(limiter))
+	}
+	opts = append(opts, grpc.ChainUnaryInterceptor(unary...), grpc.ChainStreamInterceptor(stream...))
+	s := &Server{
+		addr:   addr,
+		engine: engine,
+		server: grpc.NewServer(opts...),
+	}
+	pb.RegisterAkitaServer(s.server, svc)
+	return s
+}
+
+// ListenAndServe listen on the server addr and serve connections until Close.
+func (s *Server) ListenAndServe() error {
+	l, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve serve connections accepted by l until Close.
+func (s *Server) Serve(l net.Listener) error {
+	s.Lock()
+	if s.closed {
+		s.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.Unlock()
+	logger.Infof("grpc server listening on %s", l.Addr())
+	err := s.server.Serve(l)
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return ErrServerClosed
+	}
+	return err
+}
+
+// Close stop listening and close every connection, watch streams never end by themselves
+// so they are cancelled rather than waited for.
+func (s *Server) Close() error {
+	s.Lock()
+	s.closed = true
+	s.Unlock()
+	s.server.Stop()
+	return nil
+}
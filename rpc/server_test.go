@@ -0,0 +1,334 @@
+package rpc
+
+import (
+	"akita/auth"
+	"akita/common"
+	"akita/db"
+	"akita/pb"
+	"akita/ratelimit"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// startTestServer start a grpc server with an engine which is master if master is true.
+func startTestServer(t *testing.T, master bool) pb.AkitaClient {
+	return startTestServerWithTokens(t, master, "")
+}
+
+// startTestServerWithTokens start a grpc server requiring the static tokens of the token file content
+// unless it is empty.
+func startTestServerWithTokens(t *testing.T, master bool, tokenFile string) pb.AkitaClient {
+	return startLimitedTestServer(t, master, tokenFile, nil)
+}
+
+// startLimitedTestServer is like startTestServerWithTokens, calls are limited by limiter unless it is nil.
+func startLimitedTestServer(t *testing.T, master bool, tokenFile string, limiter *ratelimit.Limiter) pb.AkitaClient {
+	fPath := filepath.Join(t.TempDir(), "akita.dat")
+	f, err := os.Create(fPath)
+	if err != nil {
+		t.Fatalf("create data file error: %s.\n", err)
+	}
+	f.Close()
+	masterAddr := "203.0.113.1"
+	if master {
+		if masterAddr, err = common.GetIntranetIP(); err != nil {
+			t.Fatalf("get intranet ip error: %s.\n", err)
+		}
+	}
+	db.InitializeEngine(masterAddr, nil, "0", fPath, true, 100, false)
+	e := db.GetEngine()
+	e.StartBackground(1000, int64(time.Hour/time.Millisecond))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error: %s.\n", err)
+	}
+	var tokens *auth.Tokens
+	if tokenFile != "" {
+		path := filepath.Join(t.TempDir(), "tokens")
+		if err := ioutil.WriteFile(path, []byte(tokenFile), 0600); err != nil {
+			t.Fatalf("write token file error: %s.\n", err)
+		}
+		if tokens, err = auth.NewTokens(e, path); err != nil {
+			t.Fatalf("new tokens error: %s.\n", err)
+		}
+	}
+	s := NewServer(l.Addr().String(), e, tokens, limiter, nil)
+	go s.Serve(l)
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("dial grpc server error: %s.\n", err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		s.Close()
+		e.GetDB().Close()
+	})
+	return pb.NewAkitaClient(conn)
+}
+
+func put(t *testing.T, c pb.AkitaClient, key string, value []byte, chunkSize int) {
+	stream, err := c.Put(context.Background())
+	if err != nil {
+		t.Fatalf("put error: %s.\n", err)
+	}
+	req := &pb.PutRequest{Key: key}
+	for len(value) > chunkSize {
+		req.Chunk = value[:chunkSize]
+		if err := stream.Send(req); err != nil {
+			t.Fatalf("send chunk error: %s.\n", err)
+		}
+		value = value[chunkSize:]
+		req = &pb.PutRequest{}
+	}
+	req.Chunk = value
+	if err := stream.Send(req); err != nil {
+		t.Fatalf("send chunk error: %s.\n", err)
+	}
+	if _, err := stream.CloseAndRecv(); err != nil {
+		t.Fatalf("put key %s error: %s.\n", key, err)
+	}
+}
+
+func get(c pb.AkitaClient, key string) ([]byte, error) {
+	stream, err := c.Get(context.Background(), &pb.GetRequest{Key: key})
+	if err != nil {
+		return nil, err
+	}
+	var value []byte
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return value, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		value = append(value, resp.Chunk...)
+	}
+}
+
+func Test_PutGet(t *testing.T) {
+	c := startTestServer(t, true)
+
+	large := bytes.Repeat([]byte("0123456789"), 20000)
+	put(t, c, "large", large, 4096)
+	if value, err := get(c, "large"); err != nil || !bytes.Equal(value, large) {
+		t.Fatalf("get large value want %d bytes, got %d %v.\n", len(large), len(value), err)
+	}
+	put(t, c, "empty", nil, 1)
+	if value, err := get(c, "empty"); err != nil || len(value) != 0 {
+		t.Fatalf("get empty value want no bytes, got %q %v.\n", value, err)
+	}
+
+	if _, err := get(c, "missing"); status.Code(err) != codes.NotFound {
+		t.Fatalf("get missing key want NotFound, got %v.\n", err)
+	}
+	resp, err := c.Delete(context.Background(), &pb.DeleteRequest{Key: "large"})
+	if err != nil || !resp.Deleted {
+		t.Fatalf("delete want deleted, got %v %v.\n", resp, err)
+	}
+	if _, err := get(c, "large"); status.Code(err) != codes.NotFound {
+		t.Fatalf("get deleted key want NotFound, got %v.\n", err)
+	}
+}
+
+func Test_BatchWriteAndScan(t *testing.T) {
+	c := startTestServer(t, true)
+
+	req := &pb.BatchWriteRequest{Items: []*pb.KeyValue{
+		{Key: "a/1", Value: []byte("1")},
+		{Key: "a/2", Value: []byte("2")},
+		{Key: "b/1", Value: []byte("3")},
+	}}
+	if _, err := c.BatchWrite(context.Background(), req); err != nil {
+		t.Fatalf("batch write error: %s.\n", err)
+	}
+	if value, err := get(c, "a/2"); err != nil || string(value) != "2" {
+		t.Fatalf("get a/2 want 2, got %q %v.\n", value, err)
+	}
+	resp, err := c.Scan(context.Background(), &pb.ScanRequest{Pattern: "a/*", Count: 10})
+	if err != nil || resp.Cursor != 0 || len(resp.Keys) != 2 || resp.Keys[0] != "a/1" || resp.Keys[1] != "a/2" {
+		t.Fatalf("scan a/* want a/1 a/2, got %v %v.\n", resp, err)
+	}
+	resp, err = c.Scan(context.Background(), &pb.ScanRequest{Pattern: "b/*"})
+	if err != nil || len(resp.Keys) != 1 || resp.Keys[0] != "b/1" {
+		t.Fatalf("scan b/* without count want b/1, got %v %v.\n", resp, err)
+	}
+	for _, count := range []int32{-1, db.MaxScanCount + 1} {
+		if _, err := c.Scan(context.Background(), &pb.ScanRequest{Pattern: "*", Count: count}); status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("scan count %d want InvalidArgument, got %v.\n", count, err)
+		}
+	}
+}
+
+func Test_Watch(t *testing.T) {
+	c := startTestServer(t, true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := c.Watch(ctx, &pb.WatchRequest{Prefix: "img/"})
+	if err != nil {
+		t.Fatalf("watch error: %s.\n", err)
+	}
+	if _, err := stream.Header(); err != nil {
+		t.Fatalf("watch header error: %s.\n", err)
+	}
+	put(t, c, "doc/1", []byte("x"), 10)
+	put(t, c, "img/1", []byte("x"), 10)
+	c.Delete(context.Background(), &pb.DeleteRequest{Key: "img/1"})
+
+	want := []*pb.WatchEvent{
+		{Type: pb.WatchEvent_PUT, Key: "img/1"},
+		{Type: pb.WatchEvent_DELETE, Key: "img/1"},
+	}
+	var seqs []uint64
+	for _, w := range want {
+		ev, err := stream.Recv()
+		if err != nil || ev.Type != w.Type || ev.Key != w.Key {
+			t.Fatalf("watch want %v, got %v %v.\n", w, ev, err)
+		}
+		seqs = append(seqs, ev.Seq)
+	}
+
+	// resume after the put
+	resumed, err := c.Watch(ctx, &pb.WatchRequest{Prefix: "img/", After: seqs[0]})
+	if err != nil {
+		t.Fatalf("watch error: %s.\n", err)
+	}
+	if ev, err := resumed.Recv(); err != nil || ev.Seq != seqs[1] || ev.Type != pb.WatchEvent_DELETE {
+		t.Fatalf("resumed watch want delete of img/1, got %v %v.\n", ev, err)
+	}
+	lost, err := c.Watch(ctx, &pb.WatchRequest{Prefix: "img/", After: 1})
+	if err != nil {
+		t.Fatalf("watch error: %s.\n", err)
+	}
+	if _, err := lost.Recv(); status.Code(err) != codes.OutOfRange {
+		t.Fatalf("watch from lost position want OutOfRange, got %v.\n", err)
+	}
+}
+
+func Test_ReadOnlySlave(t *testing.T) {
+	c := startTestServer(t, false)
+
+	_, err := c.Delete(context.Background(), &pb.DeleteRequest{Key: "k"})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("delete on slave want FailedPrecondition, got %v.\n", err)
+	}
+	if _, err := get(c, "k"); status.Code(err) != codes.NotFound {
+		t.Fatalf("get on slave want NotFound, got %v.\n", err)
+	}
+}
+
+func Test_Auth(t *testing.T) {
+	c := startTestServerWithTokens(t, true, "secret-reader read\n")
+
+	if _, err := get(c, "k"); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("get without token want Unauthenticated, got %v.\n", err)
+	}
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer secret-reader")
+	if _, err := c.Scan(ctx, &pb.ScanRequest{Count: 10}); err != nil {
+		t.Fatalf("scan with read token error: %s.\n", err)
+	}
+	if _, err := c.Delete(ctx, &pb.DeleteRequest{Key: "k"}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("delete with read token want PermissionDenied, got %v.\n", err)
+	}
+}
+
+func Test_ACL(t *testing.T) {
+	c := startTestServerWithTokens(t, true, "secret-uploader read,write uploader\n")
+	err := auth.NewACLs(db.GetEngine()).Grant("ops", &auth.Grant{
+		Principal:   "uploader",
+		Namespace:   "photos",
+		Prefix:      "photos/2020/",
+		Permissions: auth.PermRead | auth.PermWrite,
+	})
+	if err != nil {
+		t.Fatalf("grant error: %s.\n", err)
+	}
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer secret-uploader")
+	items := []*pb.KeyValue{{Key: "photos/2020/a", Value: []byte("a")}}
+	if _, err := c.BatchWrite(ctx, &pb.BatchWriteRequest{Items: items}); err != nil {
+		t.Fatalf("batch write granted key error: %s.\n", err)
+	}
+	items = append(items, &pb.KeyValue{Key: "photos/2021/b", Value: []byte("b")})
+	if _, err := c.BatchWrite(ctx, &pb.BatchWriteRequest{Items: items}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("batch write key not granted want PermissionDenied, got %v.\n", err)
+	}
+	if _, err := c.Delete(ctx, &pb.DeleteRequest{Key: "photos/2020/a"}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("delete without delete grant want PermissionDenied, got %v.\n", err)
+	}
+	// list is not granted, so scan hides the keys of photos
+	resp, err := c.Scan(ctx, &pb.ScanRequest{Pattern: "photos/*", Count: 10})
+	if err != nil || len(resp.Keys) != 0 {
+		t.Fatalf("scan got %v, %v.\n", resp, err)
+	}
+}
+
+func Test_Limit(t *testing.T) {
+	var backlog int32
+	limiter := ratelimit.NewLimiter(ratelimit.Config{
+		RequestsPerSecond: 1,
+		RequestBurst:      3,
+		MaxWriteBacklog:   0.5,
+		WriteBacklog:      func() float64 { return float64(atomic.LoadInt32(&backlog)) },
+	})
+	c := startLimitedTestServer(t, true, "", limiter)
+	if _, err := c.Scan(context.Background(), &pb.ScanRequest{Count: 10}); err != nil {
+		t.Fatalf("scan within burst error: %s.\n", err)
+	}
+	atomic.StoreInt32(&backlog, 1)
+	if _, err := c.Delete(context.Background(), &pb.DeleteRequest{Key: "k"}); status.Code(err) != codes.Unavailable {
+		t.Fatalf("delete with backlogged writes want Unavailable, got %v.\n", err)
+	}
+	if _, err := get(c, "k"); status.Code(err) != codes.NotFound {
+		t.Fatalf("get with backlogged writes want NotFound, got %v.\n", err)
+	}
+	atomic.StoreInt32(&backlog, 0)
+	if _, err := c.Delete(context.Background(), &pb.DeleteRequest{Key: "k"}); err != nil {
+		t.Fatalf("delete within burst error: %s.\n", err)
+	}
+	var trailer metadata.MD
+	_, err := c.Scan(context.Background(), &pb.ScanRequest{Count: 10}, grpc.Trailer(&trailer))
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("scan over burst want ResourceExhausted, got %v.\n", err)
+	}
+	if values := trailer.Get("retry-after"); len(values) != 1 || values[0] != "1" {
+		t.Fatalf("retry-after trailer %v, want 1.\n", values)
+	}
+}
+
+func Test_WriteQuorum(t *testing.T) {
+	c := startTestServer(t, true)
+	items := []*pb.KeyValue{{Key: "k", Value: []byte("v")}}
+
+	// a master without slaves answers a write no replica confirmed
+	db.GetEngine().UseWriteQuorum(1, 10*time.Millisecond, true)
+	var header metadata.MD
+	if _, err := c.BatchWrite(context.Background(), &pb.BatchWriteRequest{Items: items}, grpc.Header(&header)); err != nil {
+		t.Fatalf("batch write falling back to async replication error: %s.\n", err)
+	}
+	if values := header.Get("x-akita-replicas"); len(values) != 1 || values[0] != "0" {
+		t.Fatalf("x-akita-replicas header %v, want 0.\n", values)
+	}
+	db.GetEngine().UseWriteQuorum(1, 10*time.Millisecond, false)
+	if _, err := c.Delete(context.Background(), &pb.DeleteRequest{Key: "k"}); status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("delete without write quorum want DeadlineExceeded, got %v.\n", err)
+	}
+	if _, err := get(c, "k"); status.Code(err) != codes.NotFound {
+		t.Fatalf("get key deleted on the master want NotFound, got %v.\n", err)
+	}
+}
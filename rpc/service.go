@@ -0,0 +1,279 @@
+package rpc
+
+import (
+	"akita/auth"
+	"akita/consts"
+	"akita/db"
+	akerrors "akita/errors"
+	"akita/logger"
+	"akita/pb"
+	"bytes"
+	"context"
+	"io"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	maxKeySize       = 10 * consts.K
+	maxValueSize     = 64 * consts.M
+	maxBatchKeys     = 256
+	maxBatchSize     = 64 * consts.M
+	getChunkSize     = 64 * consts.K // size of the value chunks streamed by Get
+	defaultScanCount = 10            // count of a Scan which sets none
+	notMasterInfo    = "this akita node isn't master node"
+	replicasMetadata = "x-akita-replicas" // header of writes telling how many replicas confirmed them
+)
+
+// service implement pb.AkitaServer with engine.
+type service struct {
+	pb.UnimplementedAkitaServer
+	engine *db.Engine
+	acls   *auth.ACLs // nil if authentication is turned off
+}
+
+func checkKey(key string) error {
+	if key == "" {
+		return status.Error(codes.InvalidArgument, "key can not be empty")
+	}
+	if len(key) > maxKeySize {
+		return status.Error(codes.InvalidArgument, "key size is too large")
+	}
+	return nil
+}
+
+// checkACL check the token of ctx has perm on key.
+func (s *service) checkACL(ctx context.Context, key string, perm auth.Permission) error {
+	ok, err := s.acls.Allowed(auth.TokenInfoFrom(ctx), key, perm)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if !ok {
+		return status.Error(codes.PermissionDenied, "key "+key+": "+akerrors.ErrAccessDenied.Error())
+	}
+	return nil
+}
+
+// listable report whether the token of ctx may list key, errors count as denied.
+func (s *service) listable(ctx context.Context, key string) bool {
+	ok, err := s.acls.Allowed(auth.TokenInfoFrom(ctx), key, auth.PermList)
+	return err == nil && ok
+}
+
+// confirmWrite wait for the write quorum to acknowledge the write at lsn and tell how many replicas did in the
+// x-akita-replicas header of ctx, it fails with DeadlineExceeded if too few did in time.
+func (s *service) confirmWrite(ctx context.Context, lsn int64) error {
+	n, err := s.engine.WaitQuorum(lsn)
+	grpc.SetHeader(ctx, metadata.Pairs(replicasMetadata, strconv.Itoa(n)))
+	if err != nil {
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	}
+	return nil
+}
+
+func (s *service) checkMaster() error {
+	if !s.engine.IsMaster() {
+		return status.Error(codes.FailedPrecondition, notMasterInfo)
+	}
+	return nil
+}
+
+// Put receive the chunks of a value and store it once the client closes the stream.
+func (s *service) Put(stream pb.Akita_PutServer) error {
+	if err := s.checkMaster(); err != nil {
+		return err
+	}
+	first, err := stream.Recv()
+	if err == io.EOF {
+		return status.Error(codes.InvalidArgument, "put request is empty")
+	}
+	if err != nil {
+		return err
+	}
+	key, expireAt := first.Key, first.ExpireAt
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	if err := s.checkACL(stream.Context(), key, auth.PermWrite); err != nil {
+		return err
+	}
+	var value bytes.Buffer
+	for req := first; ; {
+		if value.Len()+len(req.Chunk) > maxValueSize {
+			return status.Error(codes.ResourceExhausted, "value size is too large")
+		}
+		value.Write(req.Chunk)
+		if req, err = stream.Recv(); err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	lsn, err := s.engine.PutWithExpire(key, value.Bytes(), expireAt)
+	if err == akerrors.ErrQuotaExceeded {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if err != nil {
+		logger.Errorf("Insert key %v error %v", key, err)
+		return status.Error(codes.Internal, err.Error())
+	}
+	if err := s.confirmWrite(stream.Context(), lsn); err != nil {
+		return err
+	}
+	return stream.SendAndClose(&pb.PutResponse{Size: int64(value.Len())})
+}
+
+// Get stream the value of key in chunks, the first response carries the value size.
+func (s *service) Get(req *pb.GetRequest, stream pb.Akita_GetServer) error {
+	if err := checkKey(req.Key); err != nil {
+		return err
+	}
+	if err := s.checkACL(stream.Context(), req.Key, auth.PermRead); err != nil {
+		return err
+	}
+	value, err := s.engine.Seek(req.Key)
+	if err != nil {
+		logger.Errorf("Seek key %v error %v", req.Key, err)
+		return status.Error(codes.Internal, err.Error())
+	}
+	if value == nil && !s.engine.Exists(req.Key) {
+		return status.Error(codes.NotFound, "key "+req.Key+" not found")
+	}
+	resp := &pb.GetResponse{Size: int64(len(value))}
+	for {
+		n := len(value)
+		if n > getChunkSize {
+			n = getChunkSize
+		}
+		resp.Chunk = value[:n]
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+		value = value[n:]
+		if len(value) == 0 {
+			return nil
+		}
+		resp = &pb.GetResponse{}
+	}
+}
+
+func (s *service) Delete(ctx context.Context, req *pb.DeleteRequest) (*pb.DeleteResponse, error) {
+	if err := s.checkMaster(); err != nil {
+		return nil, err
+	}
+	if err := checkKey(req.Key); err != nil {
+		return nil, err
+	}
+	if err := s.checkACL(ctx, req.Key, auth.PermDelete); err != nil {
+		return nil, err
+	}
+	deleted, _, lsn, err := s.engine.Delete(req.Key)
+	if err != nil {
+		logger.Errorf("Delete key %v error %v", req.Key, err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if deleted {
+		if err := s.confirmWrite(ctx, lsn); err != nil {
+			return nil, err
+		}
+	}
+	return &pb.DeleteResponse{Deleted: deleted}, nil
+}
+
+func (s *service) Scan(ctx context.Context, req *pb.ScanRequest) (*pb.ScanResponse, error) {
+	count := int(req.Count)
+	if count == 0 {
+		count = defaultScanCount
+	}
+	if count < 0 || count > db.MaxScanCount {
+		return nil, status.Errorf(codes.InvalidArgument, "count must be between 1 and %d", db.MaxScanCount)
+	}
+	cursor, keys := s.engine.Scan(req.Cursor, req.Pattern, count)
+	if s.acls != nil {
+		listed := keys[:0]
+		for _, key := range keys {
+			if s.listable(ctx, key) {
+				listed = append(listed, key)
+			}
+		}
+		keys = listed
+	}
+	return &pb.ScanResponse{Cursor: cursor, Keys: keys}, nil
+}
+
+func (s *service) BatchWrite(ctx context.Context, req *pb.BatchWriteRequest) (*pb.BatchWriteResponse, error) {
+	if err := s.checkMaster(); err != nil {
+		return nil, err
+	}
+	if len(req.Items) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "batch is empty")
+	}
+	if len(req.Items) > maxBatchKeys {
+		return nil, status.Error(codes.InvalidArgument, "too many keys in batch")
+	}
+	keys, values := make([]string, len(req.Items)), make([][]byte, len(req.Items))
+	size := 0
+	for i, item := range req.Items {
+		if err := checkKey(item.Key); err != nil {
+			return nil, err
+		}
+		if err := s.checkACL(ctx, item.Key, auth.PermWrite); err != nil {
+			return nil, err
+		}
+		keys[i], values[i] = item.Key, item.Value
+		size += len(item.Value)
+	}
+	if size > maxBatchSize {
+		return nil, status.Error(codes.ResourceExhausted, "batch size is too large")
+	}
+	lsn, err := s.engine.BatchInsert(keys, values)
+	if err == akerrors.ErrQuotaExceeded {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if err != nil {
+		logger.Errorf("Batch insert %d keys error %v", len(keys), err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := s.confirmWrite(ctx, lsn); err != nil {
+		return nil, err
+	}
+	return &pb.BatchWriteResponse{}, nil
+}
+
+// Watch stream the changes of keys starting with the prefix until the client goes away,
+// a client resumes with the seq of the last event it got. Changes of keys the token may not list are skipped.
+func (s *service) Watch(req *pb.WatchRequest, stream pb.Akita_WatchServer) error {
+	events, stop, err := s.engine.WatchFrom(req.Prefix, req.After)
+	if err == akerrors.ErrWatchPositionLost {
+		return status.Error(codes.OutOfRange, err.Error())
+	}
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	defer stop()
+	// headers tell the client the watch is registered, so no event after this is missed
+	if err := stream.SendHeader(nil); err != nil {
+		return err
+	}
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return status.Error(codes.Aborted, "watcher fell behind, events were dropped")
+			}
+			if s.acls != nil && !s.listable(stream.Context(), ev.Key) {
+				continue
+			}
+			if err := stream.Send(&pb.WatchEvent{Type: pb.WatchEvent_Type(ev.Type), Key: ev.Key, Seq: ev.Seq}); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
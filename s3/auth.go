@@ -0,0 +1,182 @@
+package s3
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	signAlgorithm   = "AWS4-HMAC-SHA256"
+	amzDateFormat   = "20060102T150405Z"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+	streamPayload   = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	maxRequestSkew  = 15 * time.Minute
+)
+
+// Credentials maps access key ids to secret access keys.
+type Credentials map[string]string
+
+// LoadCredentials load credentials file, each line is "<access key id> <secret access key>".
+// Lines starting with # are ignored.
+func LoadCredentials(path string) (Credentials, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	creds := make(Credentials)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		fields := strings.Fields(s)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("credentials file %s line %d: want access key id and secret access key", path, line)
+		}
+		creds[fields[0]] = fields[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(creds) == 0 {
+		return nil, fmt.Errorf("credentials file %s has no credentials", path)
+	}
+	return creds, nil
+}
+
+// authenticate verify the AWS signature version 4 of the Authorization header of req,
+// and return the access key id and the signed sha256 of the payload, which the body must be checked against once read.
+func (creds Credentials) authenticate(req *http.Request) (string, string, error) {
+	auth := req.Header.Get("Authorization")
+	if auth == "" {
+		return "", "", errMissingAuth
+	}
+	if !strings.HasPrefix(auth, signAlgorithm+" ") {
+		return "", "", errAuthMalformed
+	}
+	var credential, signedHeaders, signature string
+	for _, part := range strings.Split(strings.TrimPrefix(auth, signAlgorithm+" "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return "", "", errAuthMalformed
+		}
+		switch kv[0] {
+		case "Credential":
+			credential = kv[1]
+		case "SignedHeaders":
+			signedHeaders = kv[1]
+		case "Signature":
+			signature = kv[1]
+		}
+	}
+	// credential is <access key id>/<date>/<region>/s3/aws4_request
+	scope := strings.Split(credential, "/")
+	if len(scope) != 5 || scope[3] != "s3" || scope[4] != "aws4_request" || signedHeaders == "" || signature == "" {
+		return "", "", errAuthMalformed
+	}
+	secret, ok := creds[scope[0]]
+	if !ok {
+		return "", "", errInvalidAccessKeyID
+	}
+
+	amzDate := req.Header.Get("X-Amz-Date")
+	t, err := time.Parse(amzDateFormat, amzDate)
+	if err != nil || scope[1] != amzDate[:8] {
+		return "", "", errAuthMalformed
+	}
+	if skew := time.Since(t); skew > maxRequestSkew || skew < -maxRequestSkew {
+		return "", "", errRequestTimeSkewed
+	}
+	payloadHash := req.Header.Get("X-Amz-Content-Sha256")
+	switch {
+	case payloadHash == "":
+		return "", "", errAuthMalformed
+	case payloadHash == streamPayload:
+		return "", "", errStreamingUnsupported
+	}
+
+	canonical := canonicalRequest(req, strings.Split(signedHeaders, ";"), payloadHash)
+	hash := sha256.Sum256([]byte(canonical))
+	stringToSign := signAlgorithm + "\n" + amzDate + "\n" + strings.Join(scope[1:], "/") + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secret), scope[1])
+	for _, s := range scope[2:] {
+		key = hmacSHA256(key, s)
+	}
+	want := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	if !hmac.Equal([]byte(want), []byte(signature)) {
+		return "", "", errSignatureMismatch
+	}
+	if payloadHash == unsignedPayload {
+		return scope[0], "", nil
+	}
+	return scope[0], payloadHash, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalRequest build the canonical request of signature version 4, S3 paths are encoded once.
+func canonicalRequest(req *http.Request, signedHeaders []string, payloadHash string) string {
+	query := req.URL.Query()
+	params := make([]string, 0, len(query))
+	for k, vs := range query {
+		for _, v := range vs {
+			params = append(params, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	sort.Strings(params)
+
+	var headers strings.Builder
+	for _, name := range signedHeaders {
+		var value string
+		switch name {
+		case "host":
+			value = req.Host
+		case "content-length":
+			value = strconv.FormatInt(req.ContentLength, 10)
+		default:
+			value = strings.Join(req.Header[http.CanonicalHeaderKey(name)], ",")
+		}
+		headers.WriteString(name + ":" + strings.Join(strings.Fields(value), " ") + "\n")
+	}
+
+	return strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		strings.Join(params, "&"),
+		headers.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+}
+
+// uriEncode percent-encode every byte of s except unreserved characters, and '/' unless encodeSlash.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !encodeSlash {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
@@ -0,0 +1,87 @@
+package s3
+
+import (
+	akerrors "akita/errors"
+	"akita/logger"
+	"encoding/xml"
+	"net/http"
+)
+
+// apiError is an S3 error, written as the S3 xml error document.
+type apiError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.code + ": " + e.message
+}
+
+var (
+	errAccessDenied         = &apiError{http.StatusForbidden, "AccessDenied", "Access Denied"}
+	errNotMaster            = &apiError{http.StatusForbidden, "AccessDenied", "this akita node isn't master node"}
+	errMissingAuth          = &apiError{http.StatusForbidden, "AccessDenied", "request is not signed with AWS signature version 4"}
+	errAuthMalformed        = &apiError{http.StatusBadRequest, "AuthorizationHeaderMalformed", "the authorization header is malformed"}
+	errInvalidAccessKeyID   = &apiError{http.StatusForbidden, "InvalidAccessKeyId", "the access key id does not exist"}
+	errSignatureMismatch    = &apiError{http.StatusForbidden, "SignatureDoesNotMatch", "the request signature does not match"}
+	errRequestTimeSkewed    = &apiError{http.StatusForbidden, "RequestTimeTooSkewed", "the difference between the request time and the server time is too large"}
+	errContentSHA256        = &apiError{http.StatusBadRequest, "XAmzContentSHA256Mismatch", "the provided x-amz-content-sha256 does not match the content"}
+	errBadDigest            = &apiError{http.StatusBadRequest, "BadDigest", "the Content-MD5 does not match the content"}
+	errInvalidDigest        = &apiError{http.StatusBadRequest, "InvalidDigest", "the Content-MD5 is not valid"}
+	errEntityTooLarge       = &apiError{http.StatusBadRequest, "EntityTooLarge", "the object is too large"}
+	errInvalidBucketName    = &apiError{http.StatusBadRequest, "InvalidBucketName", "the bucket name is not valid"}
+	errKeyTooLong           = &apiError{http.StatusBadRequest, "KeyTooLongError", "the object key is too long"}
+	errInvalidArgument      = &apiError{http.StatusBadRequest, "InvalidArgument", "invalid argument"}
+	errMalformedXML         = &apiError{http.StatusBadRequest, "MalformedXML", "the xml is not well-formed"}
+	errNoSuchKey            = &apiError{http.StatusNotFound, "NoSuchKey", "the specified key does not exist"}
+	errNoSuchUpload         = &apiError{http.StatusNotFound, "NoSuchUpload", "the specified multipart upload does not exist"}
+	errInvalidPart          = &apiError{http.StatusBadRequest, "InvalidPart", "one or more of the specified parts could not be found"}
+	errInvalidPartOrder     = &apiError{http.StatusBadRequest, "InvalidPartOrder", "the list of parts was not in ascending order"}
+	errMethodNotAllowed     = &apiError{http.StatusMethodNotAllowed, "MethodNotAllowed", "the method is not allowed against this resource"}
+	errNotImplemented       = &apiError{http.StatusNotImplemented, "NotImplemented", "the requested functionality is not implemented"}
+	errQuotaExceeded        = &apiError{http.StatusInsufficientStorage, "QuotaExceeded", "the quota of the bucket is exceeded"}
+	errQuorumTimeout        = &apiError{http.StatusGatewayTimeout, "QuorumTimeout", "the object is stored on the master but too few replicas confirmed it in time"}
+	errStreamingUnsupported = &apiError{http.StatusNotImplemented, "NotImplemented", "aws-chunked payload signing is not supported, sign the whole payload or use UNSIGNED-PAYLOAD"}
+)
+
+type errorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource"`
+	RequestID string   `xml:"RequestId"`
+}
+
+// writeError write err as S3 error document, errors which are not S3 errors are internal errors.
+func writeError(w http.ResponseWriter, req *http.Request, err error) {
+	switch err {
+	case akerrors.ErrQuotaExceeded:
+		err = errQuotaExceeded
+	case akerrors.ErrQuorumTimeout:
+		err = errQuorumTimeout
+	}
+	ae, ok := err.(*apiError)
+	if !ok {
+		logger.Errorf("s3 %s %s error: %v", req.Method, req.URL.Path, err)
+		ae = &apiError{http.StatusInternalServerError, "InternalError", err.Error()}
+	}
+	writeXML(w, ae.status, &errorResponse{
+		Code:     ae.code,
+		Message:  ae.message,
+		Resource: req.URL.Path,
+	})
+}
+
+func writeXML(w http.ResponseWriter, status int, v interface{}) {
+	body, err := xml.Marshal(v)
+	if err != nil {
+		logger.Errorf("marshal s3 response error: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	w.Write(body)
+}
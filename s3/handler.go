@@ -0,0 +1,227 @@
+package s3
+
+import (
+	"akita/auth"
+	"akita/consts"
+	"akita/db"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	maxKeySize    = 1 * consts.K  // S3 limit of object key length
+	maxObjectSize = 64 * consts.M // maximum size of an object or a part put at once
+	etagAttr      = "s3_etag"     // attribute of the etag of an object, see db.Attrs
+	sizeAttr      = "s3_size"     // attribute of the size of an object
+)
+
+// Handler serves a subset of the S3 api over the engine, path style only: /<bucket>/<object key>.
+// A bucket is the namespace of its objects, object key k of bucket b is stored as akita key b/k.
+type Handler struct {
+	engine *db.Engine
+	creds  Credentials
+	acls   *auth.ACLs
+}
+
+// NewHandler create a S3 handler, requests must be signed with one of creds.
+func NewHandler(engine *db.Engine, creds Credentials) *Handler {
+	return &Handler{
+		engine: engine,
+		creds:  creds,
+	}
+}
+
+// UseACLs restrict buckets by access control lists a, the principal of a request is its access key id.
+func (h *Handler) UseACLs(a *auth.ACLs) {
+	h.acls = a
+}
+
+// request is an authenticated S3 request.
+type request struct {
+	*http.Request
+	accessKeyID string
+	bucket      string
+	key         string // object key in bucket
+	payloadHash string // signed sha256 of body, empty if unsigned
+}
+
+// akitaKey return the akita key of the object.
+func (r *request) akitaKey() string {
+	return r.bucket + db.NamespaceSeparator + r.key
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	accessKeyID, payloadHash, err := h.creds.authenticate(req)
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+	path := strings.TrimPrefix(req.URL.Path, "/")
+	if path == "" {
+		writeError(w, req, errNotImplemented)
+		return
+	}
+	r := &request{Request: req, accessKeyID: accessKeyID, payloadHash: payloadHash}
+	if i := strings.IndexByte(path, '/'); i >= 0 {
+		r.bucket, r.key = path[:i], path[i+1:]
+	} else {
+		r.bucket = path
+	}
+	if !validBucket(r.bucket) {
+		writeError(w, req, errInvalidBucketName)
+		return
+	}
+	if len(r.key) > maxKeySize {
+		writeError(w, req, errKeyTooLong)
+		return
+	}
+	if err = h.allow(r); err != nil {
+		writeError(w, req, err)
+		return
+	}
+	if r.key == "" {
+		err = h.serveBucket(w, r)
+	} else {
+		err = h.serveObject(w, r)
+	}
+	if err != nil {
+		writeError(w, req, err)
+	}
+}
+
+// allow check the access key of r has the permission its method needs on the object, or on the list prefix
+// of a bucket request.
+func (h *Handler) allow(r *request) error {
+	if h.acls == nil {
+		return nil
+	}
+	key, perm := r.akitaKey(), auth.PermRead
+	switch {
+	case r.key == "":
+		key, perm = r.bucket+db.NamespaceSeparator+r.URL.Query().Get("prefix"), auth.PermList
+	case r.Method == http.MethodPut || r.Method == http.MethodPost:
+		perm = auth.PermWrite
+	case r.Method == http.MethodDelete && r.URL.Query().Get("uploadId") != "":
+		perm = auth.PermWrite
+	case r.Method == http.MethodDelete:
+		perm = auth.PermDelete
+	}
+	ok, err := h.acls.Allowed(&auth.TokenInfo{Name: r.accessKeyID}, key, perm)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errAccessDenied
+	}
+	return nil
+}
+
+func (h *Handler) serveBucket(w http.ResponseWriter, r *request) error {
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodGet && query.Get("list-type") == "2":
+		return h.listObjectsV2(w, r)
+	case r.Method == http.MethodGet:
+		return errNotImplemented
+	default:
+		return errMethodNotAllowed
+	}
+}
+
+func (h *Handler) serveObject(w http.ResponseWriter, r *request) error {
+	query := r.URL.Query()
+	_, uploads := query["uploads"]
+	uploadID := query.Get("uploadId")
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if uploadID != "" {
+			return errNotImplemented
+		}
+		return h.getObject(w, r)
+	case http.MethodPut:
+		if r.Header.Get("X-Amz-Copy-Source") != "" {
+			return errNotImplemented
+		}
+		if uploadID != "" {
+			return h.uploadPart(w, r, uploadID, query.Get("partNumber"))
+		}
+		return h.putObject(w, r)
+	case http.MethodPost:
+		if uploads {
+			return h.createMultipartUpload(w, r)
+		}
+		if uploadID != "" {
+			return h.completeMultipartUpload(w, r, uploadID)
+		}
+		return errNotImplemented
+	case http.MethodDelete:
+		if uploadID != "" {
+			return h.abortMultipartUpload(w, r, uploadID)
+		}
+		return h.deleteObject(w, r)
+	default:
+		return errMethodNotAllowed
+	}
+}
+
+// validBucket report whether name is a valid S3 bucket name, 3 to 63 lowercase letters, digits, dots or
+// hyphens, starting and ending with a letter or digit. Names of internal namespaces are never valid.
+func validBucket(name string) bool {
+	if len(name) < 3 || len(name) > 63 {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case 'a' <= c && c <= 'z' || '0' <= c && c <= '9':
+		case (c == '.' || c == '-') && i != 0 && i != len(name)-1:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// readBody read the request body, at most limit bytes, and check it against the signed
+// payload hash and Content-MD5.
+func (r *request) readBody(limit int64) ([]byte, error) {
+	if r.ContentLength > limit {
+		return nil, errEntityTooLarge
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, errEntityTooLarge
+	}
+	if r.payloadHash != "" {
+		sum := sha256.Sum256(body)
+		if hex.EncodeToString(sum[:]) != r.payloadHash {
+			return nil, errContentSHA256
+		}
+	}
+	if contentMD5 := r.Header.Get("Content-MD5"); contentMD5 != "" {
+		want, err := base64.StdEncoding.DecodeString(contentMD5)
+		if err != nil || len(want) != md5.Size {
+			return nil, errInvalidDigest
+		}
+		if sum := md5.Sum(body); !bytes.Equal(sum[:], want) {
+			return nil, errBadDigest
+		}
+	}
+	return body, nil
+}
+
+// etag return the quoted md5 of value, as S3 does for objects not uploaded in parts.
+func etag(value []byte) string {
+	sum := md5.Sum(value)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
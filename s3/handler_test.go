@@ -0,0 +1,271 @@
+package s3
+
+import (
+	"akita/common"
+	"akita/db"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+const (
+	testAccessKeyID     = "AKIDAKITA"
+	testSecretAccessKey = "secret"
+)
+
+// startTestServer start a S3 server with a master engine and return a client of it signed with secret.
+func startTestServer(t *testing.T, secret string) *s3.S3 {
+	fPath := filepath.Join(t.TempDir(), "akita.dat")
+	f, err := os.Create(fPath)
+	if err != nil {
+		t.Fatalf("create data file error: %s.\n", err)
+	}
+	f.Close()
+	masterAddr, err := common.GetIntranetIP()
+	if err != nil {
+		t.Fatalf("get intranet ip error: %s.\n", err)
+	}
+	db.InitializeEngine(masterAddr, nil, "0", fPath, true, 100, false)
+	e := db.GetEngine()
+	e.StartBackground(1000, int64(time.Hour/time.Millisecond))
+
+	server := httptest.NewServer(NewHandler(e, Credentials{testAccessKeyID: testSecretAccessKey}))
+	t.Cleanup(func() {
+		server.Close()
+		e.GetDB().Close()
+	})
+	sess := session.Must(session.NewSession(&aws.Config{
+		Endpoint:         aws.String(server.URL),
+		Region:           aws.String("us-east-1"),
+		Credentials:      credentials.NewStaticCredentials(testAccessKeyID, secret, ""),
+		S3ForcePathStyle: aws.Bool(true),
+		DisableSSL:       aws.Bool(true),
+	}))
+	return s3.New(sess)
+}
+
+func errCode(err error) string {
+	if ae, ok := err.(awserr.Error); ok {
+		return ae.Code()
+	}
+	return ""
+}
+
+func Test_Object(t *testing.T) {
+	c := startTestServer(t, testSecretAccessKey)
+
+	_, err := c.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String("photos"),
+		Key:    aws.String("2021/a b+c.txt"),
+		Body:   bytes.NewReader([]byte("0123456789")),
+	})
+	if err != nil {
+		t.Fatalf("put object error: %s.\n", err)
+	}
+	head, err := c.HeadObject(&s3.HeadObjectInput{Bucket: aws.String("photos"), Key: aws.String("2021/a b+c.txt")})
+	if err != nil || *head.ContentLength != 10 || *head.ETag != `"781e5e245d69b566979b86e28d23f2c7"` {
+		t.Fatalf("head object want 10 bytes, got %v %v.\n", head, err)
+	}
+	got, err := c.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String("photos"),
+		Key:    aws.String("2021/a b+c.txt"),
+		Range:  aws.String("bytes=2-4"),
+	})
+	if err != nil {
+		t.Fatalf("get object error: %s.\n", err)
+	}
+	body, _ := ioutil.ReadAll(got.Body)
+	if string(body) != "234" || *got.ContentRange != "bytes 2-4/10" {
+		t.Fatalf("get range want 234, got %q %v.\n", body, *got.ContentRange)
+	}
+	// objects are stored under the bucket namespace
+	if value, _ := db.GetEngine().Seek("photos/2021/a b+c.txt"); string(value) != "0123456789" {
+		t.Fatalf("akita key photos/2021/a b+c.txt want object, got %q.\n", value)
+	}
+
+	if _, err := c.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String("photos"), Key: aws.String("2021/a b+c.txt")}); err != nil {
+		t.Fatalf("delete object error: %s.\n", err)
+	}
+	_, err = c.GetObject(&s3.GetObjectInput{Bucket: aws.String("photos"), Key: aws.String("2021/a b+c.txt")})
+	if errCode(err) != s3.ErrCodeNoSuchKey {
+		t.Fatalf("get deleted object want NoSuchKey, got %v.\n", err)
+	}
+}
+
+func Test_ListObjectsV2(t *testing.T) {
+	c := startTestServer(t, testSecretAccessKey)
+
+	for _, key := range []string{"a/1", "a/2", "b/1", "c", "d"} {
+		_, err := c.PutObject(&s3.PutObjectInput{Bucket: aws.String("bkt"), Key: aws.String(key), Body: bytes.NewReader([]byte(key))})
+		if err != nil {
+			t.Fatalf("put object error: %s.\n", err)
+		}
+	}
+	db.GetEngine().Put("other/x", []byte("x"))
+
+	var keys []string
+	input := &s3.ListObjectsV2Input{Bucket: aws.String("bkt"), Delimiter: aws.String("/"), MaxKeys: aws.Int64(2)}
+	err := c.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, cp := range page.CommonPrefixes {
+			keys = append(keys, *cp.Prefix)
+		}
+		for _, o := range page.Contents {
+			keys = append(keys, *o.Key)
+		}
+		return true
+	})
+	if err != nil || len(keys) != 4 || keys[0] != "a/" || keys[1] != "b/" || keys[2] != "c" || keys[3] != "d" {
+		t.Fatalf("list with delimiter want a/ b/ c d, got %v %v.\n", keys, err)
+	}
+
+	out, err := c.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: aws.String("bkt"), Prefix: aws.String("a/")})
+	if err != nil || len(out.Contents) != 2 || *out.Contents[1].Key != "a/2" || *out.Contents[1].Size != 3 || *out.IsTruncated {
+		t.Fatalf("list prefix a/ want a/1 a/2, got %v %v.\n", out, err)
+	}
+}
+
+func Test_MultipartUpload(t *testing.T) {
+	c := startTestServer(t, testSecretAccessKey)
+
+	data := bytes.Repeat([]byte("akita"), 3*1024*1024)
+	uploader := s3manager.NewUploaderWithClient(c, func(u *s3manager.Uploader) {
+		u.PartSize = 5 * 1024 * 1024
+	})
+	_, err := uploader.Upload(&s3manager.UploadInput{Bucket: aws.String("big"), Key: aws.String("file"), Body: bytes.NewReader(data)})
+	if err != nil {
+		t.Fatalf("multipart upload error: %s.\n", err)
+	}
+	got, err := c.GetObject(&s3.GetObjectInput{Bucket: aws.String("big"), Key: aws.String("file")})
+	if err != nil {
+		t.Fatalf("get object error: %s.\n", err)
+	}
+	body, _ := ioutil.ReadAll(got.Body)
+	if !bytes.Equal(body, data) {
+		t.Fatalf("get uploaded object want %d bytes, got %d.\n", len(data), len(body))
+	}
+	head, err := c.HeadObject(&s3.HeadObjectInput{Bucket: aws.String("big"), Key: aws.String("file")})
+	if err != nil || !strings.HasSuffix(*head.ETag, `-3"`) {
+		t.Fatalf("head uploaded object want etag of 3 parts, got %v %v.\n", head, err)
+	}
+	out, err := c.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: aws.String("big")})
+	if err != nil || len(out.Contents) != 1 || *out.Contents[0].ETag != *head.ETag || *out.Contents[0].Size != int64(len(data)) {
+		t.Fatalf("list uploaded object want etag %s size %d, got %v %v.\n", *head.ETag, len(data), out, err)
+	}
+	if keys, _ := db.GetEngine().List(uploadsNamespace+"/", "", 10); len(keys) != 0 {
+		t.Fatalf("parts are not removed after complete: %v.\n", keys)
+	}
+
+	created, err := c.CreateMultipartUpload(&s3.CreateMultipartUploadInput{Bucket: aws.String("big"), Key: aws.String("aborted")})
+	if err != nil {
+		t.Fatalf("create multipart upload error: %s.\n", err)
+	}
+	_, err = c.AbortMultipartUpload(&s3.AbortMultipartUploadInput{Bucket: aws.String("big"), Key: aws.String("aborted"), UploadId: created.UploadId})
+	if err != nil {
+		t.Fatalf("abort multipart upload error: %s.\n", err)
+	}
+	_, err = c.UploadPart(&s3.UploadPartInput{
+		Bucket:     aws.String("big"),
+		Key:        aws.String("aborted"),
+		UploadId:   created.UploadId,
+		PartNumber: aws.Int64(1),
+		Body:       bytes.NewReader([]byte("x")),
+	})
+	if errCode(err) != s3.ErrCodeNoSuchUpload {
+		t.Fatalf("upload part of aborted upload want NoSuchUpload, got %v.\n", err)
+	}
+}
+
+func Test_MultipartQuota(t *testing.T) {
+	c := startTestServer(t, testSecretAccessKey)
+	if err := db.GetEngine().SetQuota("small", db.Quota{MaxObjects: 1}); err != nil {
+		t.Fatalf("set quota error: %s.\n", err)
+	}
+	created, err := c.CreateMultipartUpload(&s3.CreateMultipartUploadInput{Bucket: aws.String("small"), Key: aws.String("file")})
+	if err != nil {
+		t.Fatalf("create multipart upload error: %s.\n", err)
+	}
+	uploadPart := func(n int64, size int) error {
+		req, _ := c.UploadPartRequest(&s3.UploadPartInput{
+			Bucket:     aws.String("small"),
+			Key:        aws.String("file"),
+			UploadId:   created.UploadId,
+			PartNumber: aws.Int64(n),
+			Body:       bytes.NewReader(make([]byte, size)),
+		})
+		// a quota error is a server error, which is retried by default
+		req.Retryer = client.DefaultRetryer{}
+		return req.Send()
+	}
+
+	if err := uploadPart(1, 100); err != nil {
+		t.Fatalf("upload part within quota error: %s.\n", err)
+	}
+	if _, err := c.PutObject(&s3.PutObjectInput{Bucket: aws.String("small"), Key: aws.String("k"), Body: bytes.NewReader([]byte("v"))}); err != nil {
+		t.Fatalf("put object error: %s.\n", err)
+	}
+	// parts are charged to the bucket although they are stored in the uploads namespace
+	if err := uploadPart(2, 100); errCode(err) != "QuotaExceeded" {
+		t.Fatalf("upload part to a bucket over quota want QuotaExceeded, got %v.\n", err)
+	}
+	if value, _ := db.GetEngine().Seek(partKey(*created.UploadId, 2)); value != nil {
+		t.Fatalf("part over quota is stored: %d bytes.\n", len(value))
+	}
+}
+
+func Test_Authentication(t *testing.T) {
+	c := startTestServer(t, "wrong secret")
+
+	_, err := c.PutObject(&s3.PutObjectInput{Bucket: aws.String("bkt"), Key: aws.String("k"), Body: bytes.NewReader([]byte("v"))})
+	if errCode(err) != "SignatureDoesNotMatch" {
+		t.Fatalf("put with wrong secret want SignatureDoesNotMatch, got %v.\n", err)
+	}
+
+	resp, err := http.Get(c.Endpoint + "/bkt/k")
+	if err != nil {
+		t.Fatalf("get error: %s.\n", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("unsigned get want 403, got %d.\n", resp.StatusCode)
+	}
+}
+
+func Test_WriteQuorum(t *testing.T) {
+	c := startTestServer(t, testSecretAccessKey)
+	put := func() (*http.Response, error) {
+		req, _ := c.PutObjectRequest(&s3.PutObjectInput{Bucket: aws.String("bkt"), Key: aws.String("k"), Body: bytes.NewReader([]byte("v"))})
+		// a write quorum timeout is a server error, which is retried by default
+		req.Retryer = client.DefaultRetryer{}
+		err := req.Send()
+		return req.HTTPResponse, err
+	}
+
+	// a master without slaves answers a write no replica confirmed
+	db.GetEngine().UseWriteQuorum(1, 10*time.Millisecond, true)
+	resp, err := put()
+	if err != nil || resp.Header.Get("X-Akita-Replicas") != "0" {
+		t.Fatalf("put falling back to async replication want 0 replicas, got %v %v.\n", resp.Header, err)
+	}
+	db.GetEngine().UseWriteQuorum(1, 10*time.Millisecond, false)
+	if _, err := put(); errCode(err) != "QuorumTimeout" {
+		t.Fatalf("put without write quorum want QuorumTimeout, got %v.\n", err)
+	}
+	// the object is stored on the master anyway
+	if _, err := c.HeadObject(&s3.HeadObjectInput{Bucket: aws.String("bkt"), Key: aws.String("k")}); err != nil {
+		t.Fatalf("head object error: %s.\n", err)
+	}
+}
@@ -0,0 +1,127 @@
+package s3
+
+import (
+	"akita/db"
+	"encoding/base64"
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxListKeys = 1000
+	// skipPrefix follows every key of a common prefix, it is never a byte of an utf-8 key
+	skipPrefix = "\xff"
+)
+
+type listBucketResult struct {
+	XMLName               xml.Name       `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	KeyCount              int            `xml:"KeyCount"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	Contents              []object       `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+type object struct {
+	Key          string `xml:"Key"`
+	ETag         string `xml:"ETag"`
+	Size         int    `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// listObjectsV2 list objects of the bucket in key order, keys sharing a prefix up to the delimiter
+// are rolled up into one common prefix.
+func (h *Handler) listObjectsV2(w http.ResponseWriter, r *request) error {
+	query := r.URL.Query()
+	result := &listBucketResult{
+		Name:              r.bucket,
+		Prefix:            query.Get("prefix"),
+		Delimiter:         query.Get("delimiter"),
+		StartAfter:        query.Get("start-after"),
+		ContinuationToken: query.Get("continuation-token"),
+		MaxKeys:           maxListKeys,
+	}
+	if s := query.Get("max-keys"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return errInvalidArgument
+		}
+		if n < maxListKeys {
+			result.MaxKeys = n
+		}
+	}
+	after := result.StartAfter
+	if result.ContinuationToken != "" {
+		token, err := base64.RawURLEncoding.DecodeString(result.ContinuationToken)
+		if err != nil {
+			return errInvalidArgument
+		}
+		after = string(token)
+	}
+
+	bucketPrefix := r.bucket + db.NamespaceSeparator
+	var keys []string
+	for result.KeyCount < result.MaxKeys {
+		batch, more := h.engine.List(bucketPrefix+result.Prefix, bucketPrefix+after, result.MaxKeys-result.KeyCount)
+		for _, key := range batch {
+			name := strings.TrimPrefix(key, bucketPrefix)
+			if cp := rollUp(name, result.Prefix, result.Delimiter); cp != "" {
+				result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{cp})
+				result.KeyCount++
+				// keys after the common prefix are listed by the next batch
+				after = cp + skipPrefix
+				more = true
+				break
+			}
+			keys = append(keys, key)
+			result.Contents = append(result.Contents, object{Key: name, StorageClass: "STANDARD"})
+			result.KeyCount++
+			after = name
+		}
+		if !more {
+			break
+		}
+	}
+	if result.KeyCount == result.MaxKeys {
+		if rest, _ := h.engine.List(bucketPrefix+result.Prefix, bucketPrefix+after, 1); len(rest) > 0 {
+			result.IsTruncated = true
+			result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(after))
+		}
+	}
+
+	for i, key := range keys {
+		objectETag, size, err := h.objectInfo(key)
+		if err != nil {
+			return err
+		}
+		result.Contents[i].ETag = objectETag
+		result.Contents[i].Size = size
+	}
+	writeXML(w, http.StatusOK, result)
+	return nil
+}
+
+// rollUp return the common prefix of name, up to and including the first delimiter after prefix,
+// or "" if name is not rolled up.
+func rollUp(name string, prefix string, delimiter string) string {
+	if delimiter == "" {
+		return ""
+	}
+	i := strings.Index(name[len(prefix):], delimiter)
+	if i < 0 {
+		return ""
+	}
+	return name[:len(prefix)+i+len(delimiter)]
+}
@@ -0,0 +1,205 @@
+package s3
+
+import (
+	"akita/consts"
+	"akita/db"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// uploadsNamespace holds the parts of multipart uploads, it is not a valid bucket name.
+	// Upload id u of object key k is stored as _s3_uploads/u with value k, part n as _s3_uploads/u/0000n.
+	uploadsNamespace = "_s3_uploads"
+	uploadTTL        = 7 * 24 * time.Hour // abandoned uploads expire
+	maxPartNumber    = 10000
+	maxUploadSize    = consts.MaxValueSize // an object is saved as one value
+	maxCompleteSize  = 1 * consts.M        // size of the complete multipart upload document
+)
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+type completeMultipartUpload struct {
+	Parts []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+func uploadKey(uploadID string) string {
+	return uploadsNamespace + db.NamespaceSeparator + uploadID
+}
+
+func partKey(uploadID string, partNumber int) string {
+	return fmt.Sprintf("%s/%05d", uploadKey(uploadID), partNumber)
+}
+
+// checkUpload return an error unless uploadID is an upload of the object of r.
+func (h *Handler) checkUpload(r *request, uploadID string) error {
+	if !h.engine.IsMaster() {
+		return errNotMaster
+	}
+	object, err := h.engine.Seek(uploadKey(uploadID))
+	if err != nil {
+		return err
+	}
+	if string(object) != r.akitaKey() {
+		return errNoSuchUpload
+	}
+	return nil
+}
+
+func (h *Handler) createMultipartUpload(w http.ResponseWriter, r *request) error {
+	if !h.engine.IsMaster() {
+		return errNotMaster
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	uploadID := hex.EncodeToString(id)
+	expireAt := time.Now().Add(uploadTTL).Unix()
+	if _, err := h.engine.PutWithExpire(uploadKey(uploadID), []byte(r.akitaKey()), expireAt); err != nil {
+		return err
+	}
+	writeXML(w, http.StatusOK, &initiateMultipartUploadResult{
+		Bucket:   r.bucket,
+		Key:      r.key,
+		UploadID: uploadID,
+	})
+	return nil
+}
+
+func (h *Handler) uploadPart(w http.ResponseWriter, r *request, uploadID string, partNumber string) error {
+	n, err := strconv.Atoi(partNumber)
+	if err != nil || n < 1 || n > maxPartNumber {
+		return errInvalidArgument
+	}
+	if err := h.checkUpload(r, uploadID); err != nil {
+		return err
+	}
+	value, err := r.readBody(maxObjectSize)
+	if err != nil {
+		return err
+	}
+	// parts are stored in the uploads namespace, they are charged to the bucket the object is saved to
+	if err := h.engine.CheckQuota(r.bucket, 1, int64(len(value))); err != nil {
+		return err
+	}
+	expireAt := time.Now().Add(uploadTTL).Unix()
+	if _, err := h.engine.PutWithExpire(partKey(uploadID, n), value, expireAt); err != nil {
+		return err
+	}
+	w.Header().Set("ETag", etag(value))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// completeMultipartUpload join the listed parts into the object and remove the upload.
+func (h *Handler) completeMultipartUpload(w http.ResponseWriter, r *request, uploadID string) error {
+	if err := h.checkUpload(r, uploadID); err != nil {
+		return err
+	}
+	body, err := r.readBody(maxCompleteSize)
+	if err != nil {
+		return err
+	}
+	complete := &completeMultipartUpload{}
+	if err := xml.Unmarshal(body, complete); err != nil || len(complete.Parts) == 0 {
+		return errMalformedXML
+	}
+	keys := make([]string, len(complete.Parts))
+	for i, part := range complete.Parts {
+		if i > 0 && part.PartNumber <= complete.Parts[i-1].PartNumber {
+			return errInvalidPartOrder
+		}
+		keys[i] = partKey(uploadID, part.PartNumber)
+	}
+	// parts are read one at a time, so an upload too large to save is refused before it is held in memory
+	var value []byte
+	sums := make([]byte, 0, len(keys)*md5.Size)
+	for i, part := range complete.Parts {
+		data, err := h.engine.Seek(keys[i])
+		if err != nil {
+			return err
+		}
+		sum := md5.Sum(data)
+		if data == nil && !h.engine.Exists(keys[i]) || strings.Trim(part.ETag, `"`) != hex.EncodeToString(sum[:]) {
+			return errInvalidPart
+		}
+		if len(value)+len(data) > maxUploadSize {
+			return errEntityTooLarge
+		}
+		value = append(value, data...)
+		sums = append(sums, sum[:]...)
+	}
+	// as S3, the etag of a multipart object is the md5 of the md5s of its parts and the count of parts
+	sum := md5.Sum(sums)
+	objectETag := fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(sum[:]), len(keys))
+	lsn, err := h.engine.PutWithAttrs(r.akitaKey(), value, 0, objectAttrs(value, objectETag))
+	if err != nil {
+		return err
+	}
+	// the upload is kept, so it can be completed again, until the object is confirmed
+	if err := h.confirmWrite(w, lsn); err != nil {
+		return err
+	}
+	if err := h.removeUpload(uploadID); err != nil {
+		return err
+	}
+	writeXML(w, http.StatusOK, &completeMultipartUploadResult{
+		Location: "/" + r.bucket + "/" + r.key,
+		Bucket:   r.bucket,
+		Key:      r.key,
+		ETag:     objectETag,
+	})
+	return nil
+}
+
+func (h *Handler) abortMultipartUpload(w http.ResponseWriter, r *request, uploadID string) error {
+	if err := h.checkUpload(r, uploadID); err != nil {
+		return err
+	}
+	if err := h.removeUpload(uploadID); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// removeUpload delete every part of the upload, including parts which were not completed, and the upload.
+func (h *Handler) removeUpload(uploadID string) error {
+	for {
+		keys, _ := h.engine.List(uploadKey(uploadID)+db.NamespaceSeparator, "", maxListKeys)
+		if len(keys) == 0 {
+			break
+		}
+		for _, key := range keys {
+			if _, _, _, err := h.engine.Delete(key); err != nil {
+				return err
+			}
+		}
+	}
+	_, _, _, err := h.engine.Delete(uploadKey(uploadID))
+	return err
+}
@@ -0,0 +1,105 @@
+package s3
+
+import (
+	"akita/db"
+	akhttp "akita/http"
+	"bytes"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+)
+
+func (h *Handler) putObject(w http.ResponseWriter, r *request) error {
+	if !h.engine.IsMaster() {
+		return errNotMaster
+	}
+	value, err := r.readBody(maxObjectSize)
+	if err != nil {
+		return err
+	}
+	objectETag := etag(value)
+	lsn, err := h.engine.PutWithAttrs(r.akitaKey(), value, 0, objectAttrs(value, objectETag))
+	if err != nil {
+		return err
+	}
+	if err := h.confirmWrite(w, lsn); err != nil {
+		return err
+	}
+	w.Header().Set("ETag", objectETag)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// getObject serve GET and HEAD of an object, including Range and conditional requests.
+func (h *Handler) getObject(w http.ResponseWriter, r *request) error {
+	value, err := h.engine.Seek(r.akitaKey())
+	if err != nil {
+		return err
+	}
+	if value == nil && !h.engine.Exists(r.akitaKey()) {
+		return errNoSuchKey
+	}
+	attrs, err := h.engine.Attrs(r.akitaKey())
+	if err != nil {
+		return err
+	}
+	if objectETag, ok := attrs[etagAttr]; ok {
+		w.Header().Set("ETag", objectETag)
+	} else {
+		w.Header().Set("ETag", etag(value))
+	}
+	// modification time is not stored
+	http.ServeContent(w, r.Request, path.Base(r.key), time.Time{}, bytes.NewReader(value))
+	return nil
+}
+
+// deleteObject delete an object, deleting a missing object succeeds as in S3.
+func (h *Handler) deleteObject(w http.ResponseWriter, r *request) error {
+	if !h.engine.IsMaster() {
+		return errNotMaster
+	}
+	deleted, _, lsn, err := h.engine.Delete(r.akitaKey())
+	if err != nil {
+		return err
+	}
+	if deleted {
+		if err := h.confirmWrite(w, lsn); err != nil {
+			return err
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// confirmWrite wait for the write quorum to acknowledge the write at lsn and tell how many replicas did
+// in the X-Akita-Replicas header, it fails with ErrQuorumTimeout if too few did in time.
+func (h *Handler) confirmWrite(w http.ResponseWriter, lsn int64) error {
+	n, err := h.engine.WaitQuorum(lsn)
+	w.Header().Set(akhttp.ReplicasHeader, strconv.Itoa(n))
+	return err
+}
+
+// objectAttrs return the attributes an object is saved with, so that listing does not read values.
+func objectAttrs(value []byte, objectETag string) db.Attrs {
+	return db.Attrs{etagAttr: objectETag, sizeAttr: strconv.Itoa(len(value))}
+}
+
+// objectInfo return the etag and size of the object of key, which are read from its value if the
+// object was not saved by the S3 api.
+func (h *Handler) objectInfo(key string) (string, int, error) {
+	attrs, err := h.engine.Attrs(key)
+	if err != nil {
+		return "", 0, err
+	}
+	if objectETag, ok := attrs[etagAttr]; ok {
+		if size, err := strconv.Atoi(attrs[sizeAttr]); err == nil {
+			return objectETag, size, nil
+		}
+	}
+	value, err := h.engine.Seek(key)
+	if err != nil {
+		return "", 0, err
+	}
+	return etag(value), len(value), nil
+}
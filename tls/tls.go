@@ -0,0 +1,211 @@
+package tls
+
+import (
+	akerrors "akita/errors"
+	akhttp "akita/http"
+	"akita/logger"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Certificates holds the certificate and key of a node and the ca its peers are verified with.
+// Files are read again by Reload, connections made afterwards use them without restart.
+type Certificates struct {
+	sync.RWMutex
+	certFile string
+	keyFile  string
+	caFile   string // mutual tls is turned off if empty
+	cert     *tls.Certificate
+	roots    *x509.CertPool
+	modTimes []time.Time // modification times of files when they were loaded
+}
+
+// Load load the certificate and key files, and the ca file unless it is empty.
+func Load(certFile string, keyFile string, caFile string) (*Certificates, error) {
+	c := &Certificates{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+	}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload read the files again, the loaded certificates are kept if any file is not valid.
+func (c *Certificates) Reload() error {
+	modTimes, err := c.stat()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	var roots *x509.CertPool
+	if c.caFile != "" {
+		pem, err := ioutil.ReadFile(c.caFile)
+		if err != nil {
+			return err
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("ca file %s has no pem certificate", c.caFile)
+		}
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.cert = &cert
+	c.roots = roots
+	c.modTimes = modTimes
+	return nil
+}
+
+// ReloadEvery reload the files every interval when any of them has changed, until stop is closed.
+func (c *Certificates) ReloadEvery(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !c.changed() {
+				continue
+			}
+			if err := c.Reload(); err != nil {
+				logger.Errorf("reload tls certificates error %v", err)
+				continue
+			}
+			logger.Infof("tls certificates reloaded from %s", c.certFile)
+		case <-stop:
+			return
+		}
+	}
+}
+
+// MutualTLS return whether peers are verified with a ca.
+func (c *Certificates) MutualTLS() bool {
+	return c != nil && c.caFile != ""
+}
+
+// ServerConfig return the tls config of a listener, clients presenting a certificate are verified with the ca.
+func (c *Certificates) ServerConfig() *tls.Config {
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return c.certificate(), nil },
+	}
+	if c.MutualTLS() {
+		// the ca can be reloaded, so certificates are verified against the current one rather than ClientCAs
+		config.ClientAuth = tls.RequestClientCert
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return nil
+			}
+			return c.verify(cs.PeerCertificates, "", x509.ExtKeyUsageClientAuth)
+		}
+	}
+	return config
+}
+
+// ClientConfig return the tls config of connections to the peer host, the certificate is presented to it
+// and it is verified with the ca, or system roots if the ca is not set. The peer certificate must be issued
+// to host, its name or ip address.
+func (c *Certificates) ClientConfig(host string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: host,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return c.certificate(), nil
+		},
+		// verified by VerifyConnection with the current ca
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if host == "" {
+				return akerrors.ErrPeerName
+			}
+			return c.verify(cs.PeerCertificates, host, x509.ExtKeyUsageServerAuth)
+		},
+	}
+}
+
+// RequirePeer return a handler serving h only to clients which presented a certificate verified with the ca.
+// If mutual tls is turned off h is returned.
+func (c *Certificates) RequirePeer(h http.HandlerFunc) http.HandlerFunc {
+	if !c.MutualTLS() {
+		return h
+	}
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.TLS == nil || len(req.TLS.PeerCertificates) == 0 {
+			akhttp.WriteError(w, http.StatusForbidden, akhttp.ErrCodeForbidden, akerrors.ErrPeerCertificate.Error())
+			return
+		}
+		h(w, req)
+	}
+}
+
+func (c *Certificates) certificate() *tls.Certificate {
+	c.RLock()
+	defer c.RUnlock()
+	return c.cert
+}
+
+// verify verify the peer certificate chain for usage, and name, a host name or ip address, unless it is empty.
+func (c *Certificates) verify(certs []*x509.Certificate, name string, usage x509.ExtKeyUsage) error {
+	if len(certs) == 0 {
+		return akerrors.ErrPeerCertificate
+	}
+	c.RLock()
+	roots := c.roots
+	c.RUnlock()
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       name,
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(opts)
+	return err
+}
+
+// stat return the modification times of files.
+func (c *Certificates) stat() ([]time.Time, error) {
+	files := []string{c.certFile, c.keyFile}
+	if c.caFile != "" {
+		files = append(files, c.caFile)
+	}
+	modTimes := make([]time.Time, 0, len(files))
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		modTimes = append(modTimes, fi.ModTime())
+	}
+	return modTimes, nil
+}
+
+// changed return whether any file was modified since it was loaded.
+func (c *Certificates) changed() bool {
+	modTimes, err := c.stat()
+	if err != nil {
+		logger.Errorf("stat tls certificate files error %v", err)
+		return false
+	}
+	c.RLock()
+	defer c.RUnlock()
+	for i := range modTimes {
+		if !modTimes[i].Equal(c.modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
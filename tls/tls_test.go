@@ -0,0 +1,219 @@
+package tls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA signs the certificates of tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "akita test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create ca certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue write a certificate of 127.0.0.1 named cn and its key to dir, return their paths.
+func (ca *testCA) issue(t *testing.T, dir string, cn string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	certFile, keyFile := filepath.Join(dir, "node.crt"), filepath.Join(dir, "node.key")
+	writeFile(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	writeFile(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}))
+	return certFile, keyFile
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+// startTestServer serve requests of peers verified by server certs over tls.
+func startTestServer(t *testing.T, certs *Certificates) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &http.Server{
+		Handler: certs.RequirePeer(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte(req.TLS.PeerCertificates[0].Subject.CommonName))
+		}),
+		TLSConfig: certs.ServerConfig(),
+		ErrorLog:  log.New(ioutil.Discard, "", 0),
+	}
+	go s.ServeTLS(l, "", "")
+	t.Cleanup(func() { s.Close() })
+	return "https://" + l.Addr().String()
+}
+
+func get(config *tls.Config, url string) (int, string, error) {
+	c := &http.Client{Transport: &http.Transport{TLSClientConfig: config, DisableKeepAlives: true}}
+	resp, err := c.Get(url)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(body), err
+}
+
+func Test_MutualTLS(t *testing.T) {
+	ca := newTestCA(t)
+	serverDir, slaveDir := t.TempDir(), t.TempDir()
+	caFile := filepath.Join(serverDir, "ca.crt")
+	writeFile(t, caFile, ca.pem)
+
+	certFile, keyFile := ca.issue(t, serverDir, "master")
+	master, err := Load(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatalf("load master certificates: %v", err)
+	}
+	url := startTestServer(t, master)
+
+	certFile, keyFile = ca.issue(t, slaveDir, "slave")
+	slave, err := Load(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatalf("load slave certificates: %v", err)
+	}
+	if code, body, err := get(slave.ClientConfig("127.0.0.1"), url); err != nil || code != http.StatusOK || body != "slave" {
+		t.Fatalf("get with slave certificate: %d %q %v", code, body, err)
+	}
+
+	// the master certificate is issued to 127.0.0.1, other hosts are refused whatever the url
+	for _, host := range []string{"127.0.0.2", "master.example", ""} {
+		if _, _, err := get(slave.ClientConfig(host), url); err == nil {
+			t.Fatalf("get verifying the master as %q succeeded", host)
+		}
+	}
+
+	// a client without certificate is refused by RequirePeer
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	if code, _, err := get(&tls.Config{RootCAs: roots}, url); err != nil || code != http.StatusForbidden {
+		t.Fatalf("get without certificate: %d %v, want 403", code, err)
+	}
+
+	// certificates of another ca are refused in the handshake
+	other := newTestCA(t)
+	otherDir := t.TempDir()
+	certFile, keyFile = other.issue(t, otherDir, "intruder")
+	writeFile(t, filepath.Join(otherDir, "ca.crt"), ca.pem)
+	intruder, err := Load(certFile, keyFile, filepath.Join(otherDir, "ca.crt"))
+	if err != nil {
+		t.Fatalf("load intruder certificates: %v", err)
+	}
+	if _, _, err := get(intruder.ClientConfig("127.0.0.1"), url); err == nil {
+		t.Fatalf("get with certificate of another ca succeeded")
+	}
+}
+
+func Test_Reload(t *testing.T) {
+	ca := newTestCA(t)
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	writeFile(t, caFile, ca.pem)
+	certFile, keyFile := ca.issue(t, dir, "before")
+	certs, err := Load(certFile, keyFile, "")
+	if err != nil {
+		t.Fatalf("load certificates: %v", err)
+	}
+	url := startTestServer(t, certs)
+	client, err := Load(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatalf("load client certificates: %v", err)
+	}
+
+	serverName := func() string {
+		conn, err := tls.Dial("tcp", url[len("https://"):], client.ClientConfig("127.0.0.1"))
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}
+	if name := serverName(); name != "before" {
+		t.Fatalf("server certificate %q, want before", name)
+	}
+
+	if certs.changed() {
+		t.Fatalf("files changed before they were written")
+	}
+	ca.issue(t, dir, "after")
+	// modification times may not change within the file system time granularity
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	stop := make(chan struct{})
+	defer close(stop)
+	go certs.ReloadEvery(10*time.Millisecond, stop)
+	deadline := time.Now().Add(2 * time.Second)
+	for serverName() != "after" {
+		if time.Now().After(deadline) {
+			t.Fatalf("server certificate was not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// a broken certificate file keeps the loaded certificate
+	writeFile(t, certFile, []byte("broken"))
+	if err := certs.Reload(); err == nil {
+		t.Fatalf("reload broken certificate succeeded")
+	}
+	if name := serverName(); name != "after" {
+		t.Fatalf("server certificate %q after failed reload, want after", name)
+	}
+}